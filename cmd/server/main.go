@@ -5,6 +5,7 @@ import (
 	"log"
 	"notes-backend/internal/config"
 	"notes-backend/internal/database"
+	"notes-backend/internal/mailer"
 	"notes-backend/internal/routes"
 	"notes-backend/pkg/logger"
 	"os"
@@ -44,13 +45,24 @@ func main() {
 
 	log.Println("数据库自动迁移完成")
 
+	// 初始化全文搜索索引
+	if err := database.SetupFullTextSearch(cfg.Search); err != nil {
+		log.Fatalf("Failed to setup full-text search: %v", err)
+	}
+
 	// 创建上传目录
 	if err := createUploadDirs(cfg.File.UploadPath); err != nil {
 		log.Fatalf("Failed to create upload directories: %v", err)
 	}
 
+	// 初始化邮件发送
+	mail, err := mailer.New(cfg.Mail)
+	if err != nil {
+		log.Fatalf("Failed to initialize mailer: %v", err)
+	}
+
 	// 初始化路由
-	router := routes.Setup(db, cfg)
+	router := routes.Setup(db, cfg, mail)
 
 	// 启动服务器
 	addr := fmt.Sprintf(":%d", cfg.Server.Port)
@@ -10,13 +10,154 @@ import (
 )
 
 type Config struct {
-	Server   ServerConfig   `yaml:"server"`
-	Database DatabaseConfig `yaml:"database"`
-	JWT      JWTConfig      `yaml:"jwt"`
-	File     FileConfig     `yaml:"file"`
-	Backup   BackupConfig   `yaml:"backup"`
-	Log      LogConfig      `yaml:"log"`
-	Frontend FrontendConfig `yaml:"frontend"`
+	Server    ServerConfig    `yaml:"server"`
+	Database  DatabaseConfig  `yaml:"database"`
+	JWT       JWTConfig       `yaml:"jwt"`
+	File      FileConfig      `yaml:"file"`
+	Backup    BackupConfig    `yaml:"backup"`
+	Log       LogConfig       `yaml:"log"`
+	Frontend  FrontendConfig  `yaml:"frontend"`
+	Revision  RevisionConfig  `yaml:"revision"`
+	Search    SearchConfig    `yaml:"search"`
+	Trash     TrashConfig     `yaml:"trash"`
+	Import    ImportConfig    `yaml:"import"`
+	Render    RenderConfig    `yaml:"render"`
+	Share     ShareConfig     `yaml:"share"`
+	Analytics AnalyticsConfig `yaml:"analytics"`
+	Live      LiveConfig      `yaml:"live"`
+	Auth      AuthConfig      `yaml:"auth"`
+	Mail      MailConfig      `yaml:"mail"`
+	OIDC      OIDCConfig      `yaml:"oidc"`
+}
+
+type OIDCConfig struct {
+	// 登录完成后跳转的前端页面，附带一次性登录码 login_code 或错误信息 error，为空时使用 <frontend.base_url>/oidc/callback
+	FrontendCallbackURL string `yaml:"frontend_callback_url"`
+	// 身份提供方，键为 URL 中使用的名称，如 /api/auth/oidc/<name>/start
+	Providers map[string]OIDCProviderConfig `yaml:"providers"`
+}
+
+type OIDCProviderConfig struct {
+	DisplayName  string   `yaml:"display_name"`
+	Issuer       string   `yaml:"issuer"`
+	ClientID     string   `yaml:"client_id"`
+	ClientSecret string   `yaml:"client_secret"`
+	Scopes       []string `yaml:"scopes"`
+	// 在身份提供方注册的回调地址，为空时根据请求地址生成 /api/auth/oidc/<name>/callback
+	RedirectURL string          `yaml:"redirect_url"`
+	Claims      OIDCClaimConfig `yaml:"claims"`
+	// 没有对应账号时自动创建用户，否则只能登录已关联或邮箱相同的账号
+	AutoProvision bool `yaml:"auto_provision"`
+	// 允许登录的邮箱域名，为空时不限制
+	AllowedDomains []string `yaml:"allowed_domains"`
+	// 身份提供方不返回 email_verified 时，是否仍信任其邮箱（用于关联已有账号）
+	TrustEmail bool `yaml:"trust_email"`
+}
+
+// OIDCClaimConfig ID Token 或 UserInfo 中各字段对应的 claim 名称
+type OIDCClaimConfig struct {
+	Email         string `yaml:"email"`
+	EmailVerified string `yaml:"email_verified"`
+	Username      string `yaml:"username"`
+	Name          string `yaml:"name"`
+}
+
+type AuthConfig struct {
+	// 为 true 时未验证邮箱的账号不能登录，注册后也不签发令牌
+	RequireEmailVerification bool `yaml:"require_email_verification"`
+	// 邮箱验证链接有效期
+	VerifyTokenHours int `yaml:"verify_token_hours"`
+	// 重置密码链接有效期
+	ResetTokenMinutes int `yaml:"reset_token_minutes"`
+	// 身份验证器应用中显示的发行方名称
+	TOTPIssuer string `yaml:"totp_issuer"`
+	// 开启两步验证的用户登录时，密码验证通过后签发的挑战令牌有效期
+	ChallengeMinutes int `yaml:"challenge_minutes"`
+	// 同一用户在 two_factor_window_minutes 内最多允许的验证码错误次数
+	TwoFactorMaxAttempts   int `yaml:"two_factor_max_attempts"`
+	TwoFactorWindowMinutes int `yaml:"two_factor_window_minutes"`
+}
+
+type MailConfig struct {
+	// 发送方式：smtp 通过 SMTP 发送，file 写入 dir 目录下的 .eml 文件，log 只输出到日志
+	Driver string `yaml:"driver"`
+	// 发件人，如 "Notes <noreply@example.com>"
+	From string     `yaml:"from"`
+	Dir  string     `yaml:"dir"`
+	SMTP SMTPConfig `yaml:"smtp"`
+}
+
+type SMTPConfig struct {
+	Host     string `yaml:"host"`
+	Port     int    `yaml:"port"`
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+	// 加密方式：starttls（默认）、tls（隐式 TLS，通常为 465 端口）或 none
+	Encryption     string `yaml:"encryption"`
+	TimeoutSeconds int    `yaml:"timeout_seconds"`
+}
+
+type LiveConfig struct {
+	// 协同编辑中的文档保存到数据库的间隔
+	SaveIntervalSeconds int `yaml:"save_interval_seconds"`
+	// 协同编辑期间记录历史版本的最小间隔，最后一个连接断开时总会记录一次
+	RevisionIntervalMinutes int `yaml:"revision_interval_minutes"`
+	// 每篇笔记在内存中保留的最近操作数，落后更多的客户端需要重新同步
+	HistorySize int `yaml:"history_size"`
+}
+
+type AnalyticsConfig struct {
+	// 按天统计使用的时区，如 Asia/Shanghai，为空时使用服务器本地时区
+	Timezone string `yaml:"timezone"`
+	// 将访问记录汇总为按天统计的间隔
+	RollupIntervalMinutes int `yaml:"rollup_interval_minutes"`
+	// 原始访问记录保留天数，超过后汇总并删除，负数表示永久保留
+	RawRetentionDays int `yaml:"raw_retention_days"`
+	// 访问者 IP 的保存方式：full 完整保存，truncate 截断为 /24（IPv6 为 /48），hash 保存带密钥的哈希
+	IPMode string `yaml:"ip_mode"`
+	// 计算 IP 哈希和访客标识的密钥，为空时使用 JWT 密钥
+	IPHashKey string `yaml:"ip_hash_key"`
+	// 识别为机器人的 User-Agent 关键字（不区分大小写），为空时使用内置列表
+	BotUserAgents []string `yaml:"bot_user_agents"`
+}
+
+type ShareConfig struct {
+	// 分享页面模板目录，为空时使用内置模板
+	TemplateDir string `yaml:"template_dir"`
+	// 显示在分享页面标题和 og:site_name 中的站点名称
+	SiteName string `yaml:"site_name"`
+	// 输入密码后签发的访问令牌有效期
+	AccessTokenMinutes int `yaml:"access_token_minutes"`
+	// 同一 IP 对同一分享码在 unlock_window_minutes 内最多允许的密码错误次数
+	UnlockMaxAttempts   int `yaml:"unlock_max_attempts"`
+	UnlockWindowMinutes int `yaml:"unlock_window_minutes"`
+}
+
+type RenderConfig struct {
+	// 渲染结果缓存的笔记版本数，负数表示不缓存
+	CacheSize int `yaml:"cache_size"`
+}
+
+type ImportConfig struct {
+	MaxArchiveSize    int64 `yaml:"max_archive_size"`
+	JobRetentionHours int   `yaml:"job_retention_hours"`
+}
+
+type TrashConfig struct {
+	RetentionDays        int `yaml:"retention_days"`
+	PurgeIntervalMinutes int `yaml:"purge_interval_minutes"`
+}
+
+type SearchConfig struct {
+	// PostgreSQL 文本搜索配置名（regconfig），如 simple、english，使用 zhparser 时为其创建的配置名
+	TextSearchConfig string `yaml:"text_search_config"`
+	// 分词方式：default 直接交给 PostgreSQL，zhparser 使用 zhparser 扩展，bigram 在应用层对中日韩文本做二元切分
+	Tokenizer string `yaml:"tokenizer"`
+}
+
+type RevisionConfig struct {
+	DefaultKeepCount int `yaml:"default_keep_count"`
+	DefaultKeepDays  int `yaml:"default_keep_days"`
 }
 
 type FrontendConfig struct {
@@ -39,8 +180,13 @@ type DatabaseConfig struct {
 }
 
 type JWTConfig struct {
-	Secret      string `yaml:"secret"`
-	ExpireHours int    `yaml:"expire_hours"`
+	Secret string `yaml:"secret"`
+	// 访问令牌有效期，过期后使用刷新令牌换取新的访问令牌
+	AccessTokenMinutes int `yaml:"access_token_minutes"`
+	// 刷新令牌有效期，每次刷新后重新计算
+	RefreshTokenDays int `yaml:"refresh_token_days"`
+	// 刷新令牌轮换后的宽限时间，期间再次使用上一个令牌视为并发刷新而不是盗用
+	RefreshReuseGraceSeconds int `yaml:"refresh_reuse_grace_seconds"`
 }
 
 type FileConfig struct {
@@ -80,6 +226,10 @@ func Load() (*Config, error) {
 
 	cfg.setDefaults()
 
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+
 	if err := cfg.configureDatabaseByMode(); err != nil {
 		return nil, fmt.Errorf("database configuration failed: %w", err)
 	}
@@ -230,6 +380,41 @@ func (c *Config) overrideFromEnv() {
 	if val := os.Getenv("FRONTEND_BASE_URL"); val != "" {
 		c.Frontend.BaseURL = val
 	}
+	if val := os.Getenv("SHARE_TEMPLATE_DIR"); val != "" {
+		c.Share.TemplateDir = val
+	}
+	if val := os.Getenv("ANALYTICS_IP_MODE"); val != "" {
+		c.Analytics.IPMode = val
+	}
+	if val := os.Getenv("ANALYTICS_IP_HASH_KEY"); val != "" {
+		c.Analytics.IPHashKey = val
+	}
+	if val := os.Getenv("SEARCH_TOKENIZER"); val != "" {
+		c.Search.Tokenizer = val
+	}
+	if val := os.Getenv("SEARCH_TEXT_CONFIG"); val != "" {
+		c.Search.TextSearchConfig = val
+	}
+	if val := os.Getenv("MAIL_DRIVER"); val != "" {
+		c.Mail.Driver = val
+	}
+	if val := os.Getenv("MAIL_FROM"); val != "" {
+		c.Mail.From = val
+	}
+	if val := os.Getenv("SMTP_HOST"); val != "" {
+		c.Mail.SMTP.Host = val
+	}
+	if val := os.Getenv("SMTP_PORT"); val != "" {
+		if port, err := strconv.Atoi(val); err == nil {
+			c.Mail.SMTP.Port = port
+		}
+	}
+	if val := os.Getenv("SMTP_USERNAME"); val != "" {
+		c.Mail.SMTP.Username = val
+	}
+	if val := os.Getenv("SMTP_PASSWORD"); val != "" {
+		c.Mail.SMTP.Password = val
+	}
 }
 
 func (c *Config) setDefaults() {
@@ -240,8 +425,14 @@ func (c *Config) setDefaults() {
 		c.Server.Mode = "debug"
 	}
 
-	if c.JWT.ExpireHours == 0 {
-		c.JWT.ExpireHours = 24
+	if c.JWT.AccessTokenMinutes == 0 {
+		c.JWT.AccessTokenMinutes = 15
+	}
+	if c.JWT.RefreshTokenDays == 0 {
+		c.JWT.RefreshTokenDays = 30
+	}
+	if c.JWT.RefreshReuseGraceSeconds == 0 {
+		c.JWT.RefreshReuseGraceSeconds = 30
 	}
 
 	if c.File.UploadPath == "" {
@@ -272,6 +463,143 @@ func (c *Config) setDefaults() {
 	if c.Frontend.BaseURL == "" {
 		c.Frontend.BaseURL = "https://huage.api.withgo.cn"
 	}
+	if c.Search.Tokenizer == "" {
+		c.Search.Tokenizer = "default"
+	}
+	if c.Search.TextSearchConfig == "" {
+		if c.Search.Tokenizer == "zhparser" {
+			c.Search.TextSearchConfig = "chinese"
+		} else {
+			c.Search.TextSearchConfig = "simple"
+		}
+	}
+	if c.Share.AccessTokenMinutes == 0 {
+		c.Share.AccessTokenMinutes = 60
+	}
+	if c.Share.UnlockMaxAttempts == 0 {
+		c.Share.UnlockMaxAttempts = 5
+	}
+	if c.Share.UnlockWindowMinutes == 0 {
+		c.Share.UnlockWindowMinutes = 15
+	}
+	if c.Analytics.RollupIntervalMinutes <= 0 {
+		c.Analytics.RollupIntervalMinutes = 60
+	}
+	if c.Analytics.RawRetentionDays == 0 {
+		c.Analytics.RawRetentionDays = 30
+	}
+	if c.Analytics.IPMode == "" {
+		c.Analytics.IPMode = "full"
+	}
+	if c.Analytics.IPHashKey == "" {
+		c.Analytics.IPHashKey = c.JWT.Secret
+	}
+	if c.Live.SaveIntervalSeconds == 0 {
+		c.Live.SaveIntervalSeconds = 5
+	}
+	if c.Live.RevisionIntervalMinutes == 0 {
+		c.Live.RevisionIntervalMinutes = 10
+	}
+	if c.Live.HistorySize == 0 {
+		c.Live.HistorySize = 1000
+	}
+	if c.Render.CacheSize == 0 {
+		c.Render.CacheSize = 1000
+	}
+	if c.Import.MaxArchiveSize == 0 {
+		c.Import.MaxArchiveSize = 200 * 1024 * 1024
+	}
+	if c.Import.JobRetentionHours == 0 {
+		c.Import.JobRetentionHours = 24
+	}
+	if c.Trash.RetentionDays == 0 {
+		c.Trash.RetentionDays = 30
+	}
+	if c.Trash.PurgeIntervalMinutes == 0 {
+		c.Trash.PurgeIntervalMinutes = 60
+	}
+	if c.Revision.DefaultKeepCount == 0 && c.Revision.DefaultKeepDays == 0 {
+		c.Revision.DefaultKeepCount = 100
+	}
+	if c.Auth.VerifyTokenHours == 0 {
+		c.Auth.VerifyTokenHours = 48
+	}
+	if c.Auth.ResetTokenMinutes == 0 {
+		c.Auth.ResetTokenMinutes = 30
+	}
+	if c.Auth.TOTPIssuer == "" {
+		c.Auth.TOTPIssuer = "Notes"
+	}
+	if c.Auth.ChallengeMinutes == 0 {
+		c.Auth.ChallengeMinutes = 5
+	}
+	if c.Auth.TwoFactorMaxAttempts == 0 {
+		c.Auth.TwoFactorMaxAttempts = 5
+	}
+	if c.Auth.TwoFactorWindowMinutes == 0 {
+		c.Auth.TwoFactorWindowMinutes = 15
+	}
+	if c.OIDC.FrontendCallbackURL == "" {
+		c.OIDC.FrontendCallbackURL = strings.TrimRight(c.Frontend.BaseURL, "/") + "/oidc/callback"
+	}
+	for name, provider := range c.OIDC.Providers {
+		if provider.DisplayName == "" {
+			provider.DisplayName = name
+		}
+		if len(provider.Scopes) == 0 {
+			provider.Scopes = []string{"openid", "email", "profile"}
+		}
+		if provider.Claims.Email == "" {
+			provider.Claims.Email = "email"
+		}
+		if provider.Claims.EmailVerified == "" {
+			provider.Claims.EmailVerified = "email_verified"
+		}
+		if provider.Claims.Username == "" {
+			provider.Claims.Username = "preferred_username"
+		}
+		if provider.Claims.Name == "" {
+			provider.Claims.Name = "name"
+		}
+		c.OIDC.Providers[name] = provider
+	}
+	if c.Mail.Driver == "" {
+		if c.Mail.SMTP.Host != "" {
+			c.Mail.Driver = "smtp"
+		} else {
+			c.Mail.Driver = "log"
+		}
+	}
+	if c.Mail.From == "" {
+		c.Mail.From = "noreply@localhost"
+	}
+	if c.Mail.Dir == "" {
+		c.Mail.Dir = "./mail"
+	}
+	if c.Mail.SMTP.Port == 0 {
+		c.Mail.SMTP.Port = 587
+	}
+	if c.Mail.SMTP.Encryption == "" {
+		c.Mail.SMTP.Encryption = "starttls"
+	}
+	if c.Mail.SMTP.TimeoutSeconds == 0 {
+		c.Mail.SMTP.TimeoutSeconds = 10
+	}
+}
+
+// validate 检查不安全或无法识别的配置，发现问题时拒绝启动
+func (c *Config) validate() error {
+	// log 方式不发送邮件，release 模式下用户收不到验证和重置密码邮件
+	if c.Server.Mode == "release" && c.Mail.Driver == "log" {
+		return fmt.Errorf("mail.driver \"log\" does not deliver verification or password reset emails; configure smtp in release mode")
+	}
+
+	switch c.Analytics.IPMode {
+	case "full", "truncate", "hash":
+	default:
+		return fmt.Errorf("invalid analytics.ip_mode %q: must be full, truncate or hash", c.Analytics.IPMode)
+	}
+	return nil
 }
 
 func (c *Config) GetDSN() string {
@@ -106,6 +106,10 @@ func AutoMigrate() error {
 
 	fmt.Println("开始数据库迁移...")
 
+	// 邮箱验证上线前注册的用户视为已验证
+	backfillEmailVerified := DB.Migrator().HasTable(&models.User{}) &&
+		!DB.Migrator().HasColumn(&models.User{}, "EmailVerifiedAt")
+
 	err := DB.AutoMigrate(
 		&models.User{},
 		&models.Category{},
@@ -116,12 +120,31 @@ func AutoMigrate() error {
 		&models.NoteVisit{},
 		&models.UserStorage{},
 		&models.SystemConfig{},
+		&models.NoteRevision{},
+		&models.RevisionPolicy{},
+		&models.NoteLink{},
+		&models.NoteTemplate{},
+		&models.VisitRollup{},
+		&models.NotePermission{},
+		&models.Session{},
+		&models.RotatedRefreshToken{},
+		&models.RevokedToken{},
+		&models.UserToken{},
+		&models.UserTwoFactor{},
+		&models.RecoveryCode{},
+		&models.UserIdentity{},
 	)
 
 	if err != nil {
 		return fmt.Errorf("failed to auto migrate: %w", err)
 	}
 
+	if backfillEmailVerified {
+		if err := DB.Exec("UPDATE users SET email_verified_at = created_at WHERE email_verified_at IS NULL").Error; err != nil {
+			return fmt.Errorf("failed to backfill email verification: %w", err)
+		}
+	}
+
 	fmt.Println("数据库迁移完成")
 
 	if err := insertDefaultConfigs(); err != nil {
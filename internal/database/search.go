@@ -0,0 +1,94 @@
+package database
+
+import (
+	"fmt"
+	"notes-backend/internal/config"
+	"notes-backend/internal/models"
+	"regexp"
+
+	"gorm.io/gorm"
+)
+
+const searchIndexSignatureKey = "search_index_signature"
+
+var textSearchConfigPattern = regexp.MustCompile(`^[a-z_][a-z0-9_]*$`)
+
+// SetupFullTextSearch 创建笔记全文搜索所需的 tsvector 列和 GIN 索引。
+// 分词配置变化时会清空已有索引，由 SearchService 在后台重建
+func SetupFullTextSearch(cfg config.SearchConfig) error {
+	if DB == nil {
+		return fmt.Errorf("database connection not initialized")
+	}
+
+	if !textSearchConfigPattern.MatchString(cfg.TextSearchConfig) {
+		return fmt.Errorf("invalid text search config name: %s", cfg.TextSearchConfig)
+	}
+
+	if cfg.Tokenizer == "zhparser" {
+		if err := setupZhparser(cfg.TextSearchConfig); err != nil {
+			return fmt.Errorf("failed to setup zhparser: %w", err)
+		}
+	}
+
+	statements := []string{
+		"ALTER TABLE notes ADD COLUMN IF NOT EXISTS search_vector tsvector",
+		"CREATE INDEX IF NOT EXISTS idx_notes_search_vector ON notes USING GIN (search_vector)",
+	}
+	for _, stmt := range statements {
+		if err := DB.Exec(stmt).Error; err != nil {
+			return err
+		}
+	}
+
+	signature := fmt.Sprintf("%s:%s", cfg.Tokenizer, cfg.TextSearchConfig)
+
+	var existing models.SystemConfig
+	err := DB.Where("key = ?", searchIndexSignatureKey).First(&existing).Error
+	if err != nil && err != gorm.ErrRecordNotFound {
+		return err
+	}
+
+	if err == nil && existing.Value == signature {
+		return nil
+	}
+
+	if err == nil {
+		fmt.Printf("全文搜索配置变更 (%s -> %s)，重建索引\n", existing.Value, signature)
+		if err := DB.Exec("UPDATE notes SET search_vector = NULL").Error; err != nil {
+			return err
+		}
+	}
+
+	return DB.Save(&models.SystemConfig{
+		Key:         searchIndexSignatureKey,
+		Value:       signature,
+		Description: "全文搜索索引使用的分词配置",
+		IsActive:    true,
+	}).Error
+}
+
+func setupZhparser(configName string) error {
+	if err := DB.Exec("CREATE EXTENSION IF NOT EXISTS zhparser").Error; err != nil {
+		return err
+	}
+
+	var exists bool
+	if err := DB.Raw("SELECT EXISTS(SELECT 1 FROM pg_ts_config WHERE cfgname = ?)", configName).Scan(&exists).Error; err != nil {
+		return err
+	}
+	if exists {
+		return nil
+	}
+
+	statements := []string{
+		fmt.Sprintf("CREATE TEXT SEARCH CONFIGURATION %s (PARSER = zhparser)", configName),
+		fmt.Sprintf("ALTER TEXT SEARCH CONFIGURATION %s ADD MAPPING FOR n,v,a,i,e,l,j WITH simple", configName),
+	}
+	for _, stmt := range statements {
+		if err := DB.Exec(stmt).Error; err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -2,6 +2,7 @@
 package handlers
 
 import (
+	"fmt"
 	"net/http"
 	"notes-backend/internal/services"
 	"notes-backend/internal/utils"
@@ -11,12 +12,14 @@ import (
 )
 
 type AdminHandler struct {
-	fileService *services.FileService
+	fileService      *services.FileService
+	analyticsService *services.AnalyticsService
 }
 
-func NewAdminHandler(fileService *services.FileService) *AdminHandler {
+func NewAdminHandler(fileService *services.FileService, analyticsService *services.AnalyticsService) *AdminHandler {
 	return &AdminHandler{
-		fileService: fileService,
+		fileService:      fileService,
+		analyticsService: analyticsService,
 	}
 }
 
@@ -83,4 +86,16 @@ func (h *AdminHandler) RecalculateUserStorage(c *gin.Context) {
 	}
 
 	utils.SuccessWithMessage(c, "存储统计重新计算成功", nil)
-}
\ No newline at end of file
+}
+
+// 立即执行访问记录保留策略：汇总尚未汇总的日期并删除过期的原始访问记录
+func (h *AdminHandler) RunAnalyticsRetention(c *gin.Context) {
+	report, err := h.analyticsService.ApplyRetention()
+	if err != nil {
+		fmt.Printf("Visit retention failed: %v\n", err)
+		utils.ErrorWithData(c, http.StatusInternalServerError, "执行访问记录保留策略失败", report)
+		return
+	}
+
+	utils.SuccessWithMessage(c, "访问记录保留策略执行完成", report)
+}
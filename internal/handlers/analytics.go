@@ -0,0 +1,67 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"notes-backend/internal/models"
+	"notes-backend/internal/services"
+	"notes-backend/internal/utils"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"gorm.io/gorm"
+)
+
+type AnalyticsHandler struct {
+	analyticsService *services.AnalyticsService
+	validator        *validator.Validate
+}
+
+func NewAnalyticsHandler(analyticsService *services.AnalyticsService) *AnalyticsHandler {
+	return &AnalyticsHandler{
+		analyticsService: analyticsService,
+		validator:        validator.New(),
+	}
+}
+
+// GetNoteAnalytics 获取笔记的访问统计，interval 为 hour、day 或 week，from/to 为 RFC3339 时间
+func (h *AnalyticsHandler) GetNoteAnalytics(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+
+	noteID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		utils.Error(c, http.StatusBadRequest, "无效的笔记ID")
+		return
+	}
+
+	var req models.AnalyticsRequest
+	if err := c.ShouldBindQuery(&req); err != nil {
+		utils.Error(c, http.StatusBadRequest, "请求参数错误")
+		return
+	}
+
+	if req.Interval == "" {
+		req.Interval = "day"
+	}
+
+	if err := h.validator.Struct(&req); err != nil {
+		utils.ValidationError(c, err.Error())
+		return
+	}
+
+	analytics, err := h.analyticsService.GetNoteAnalytics(uint(noteID), userID.(uint), &req)
+	if err != nil {
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			utils.NotFound(c, "笔记不存在")
+		case errors.Is(err, services.ErrAnalyticsRange):
+			utils.Error(c, http.StatusBadRequest, err.Error())
+		default:
+			utils.InternalError(c)
+		}
+		return
+	}
+
+	utils.Success(c, analytics)
+}
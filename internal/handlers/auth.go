@@ -1,6 +1,8 @@
 package handlers
 
 import (
+	"errors"
+	"fmt"
 	"net/http"
 	"notes-backend/internal/config"
 	"notes-backend/internal/models"
@@ -12,16 +14,18 @@ import (
 )
 
 type AuthHandler struct {
-	authService *services.AuthService
-	config      *config.Config
-	validator   *validator.Validate
+	authService    *services.AuthService
+	sessionService *services.SessionService
+	config         *config.Config
+	validator      *validator.Validate
 }
 
-func NewAuthHandler(authService *services.AuthService, cfg *config.Config) *AuthHandler {
+func NewAuthHandler(authService *services.AuthService, sessionService *services.SessionService, cfg *config.Config) *AuthHandler {
 	return &AuthHandler{
-		authService: authService,
-		config:      cfg,
-		validator:   validator.New(),
+		authService:    authService,
+		sessionService: sessionService,
+		config:         cfg,
+		validator:      validator.New(),
 	}
 }
 
@@ -45,18 +49,22 @@ func (h *AuthHandler) Register(c *gin.Context) {
 		return
 	}
 
-	// 生成 JWT Token
-	token, err := utils.GenerateToken(
-		int(user.ID), user.Username, user.Email, user.Role,
-		h.config.JWT.Secret, h.config.JWT.ExpireHours)
+	// 要求邮箱验证时，验证完成后再登录
+	if h.config.Auth.RequireEmailVerification {
+		utils.SuccessWithMessage(c, "注册成功，请查收验证邮件", gin.H{"user": user})
+		return
+	}
+
+	// 创建会话并签发令牌
+	tokens, err := h.sessionService.CreateSession(user, newClientInfo(c))
 	if err != nil {
 		utils.InternalError(c)
 		return
 	}
 
 	utils.SuccessWithMessage(c, "注册成功", models.UserResponse{
-		User:  user,
-		Token: token,
+		User:      user,
+		TokenPair: *tokens,
 	})
 }
 
@@ -74,27 +82,176 @@ func (h *AuthHandler) Login(c *gin.Context) {
 	}
 
 	// 用户登录
-	user, err := h.authService.Login(&req)
+	user, challenge, err := h.authService.Login(&req)
 	if err != nil {
+		if errors.Is(err, services.ErrEmailNotVerified) {
+			utils.Forbidden(c, err.Error())
+			return
+		}
 		utils.Error(c, http.StatusBadRequest, err.Error())
 		return
 	}
 
-	// 生成 JWT Token
-	token, err := utils.GenerateToken(
-		int(user.ID), user.Username, user.Email, user.Role,
-		h.config.JWT.Secret, h.config.JWT.ExpireHours)
+	// 开启了两步验证，使用挑战令牌和验证码调用 /api/auth/2fa/verify 完成登录
+	if challenge != nil {
+		utils.SuccessWithMessage(c, "请输入两步验证码", challenge)
+		return
+	}
+
+	// 创建会话并签发令牌
+	tokens, err := h.sessionService.CreateSession(user, newClientInfo(c))
 	if err != nil {
 		utils.InternalError(c)
 		return
 	}
 
 	utils.SuccessWithMessage(c, "登录成功", models.UserResponse{
-		User:  user,
-		Token: token,
+		User:      user,
+		TokenPair: *tokens,
+	})
+}
+
+// Refresh 使用刷新令牌换取新的访问令牌，刷新令牌同时轮换，旧令牌不能再次使用
+func (h *AuthHandler) Refresh(c *gin.Context) {
+	var req models.RefreshTokenRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.Error(c, http.StatusBadRequest, "请求参数错误")
+		return
+	}
+
+	if err := h.validator.Struct(&req); err != nil {
+		utils.ValidationError(c, err.Error())
+		return
+	}
+
+	user, tokens, err := h.sessionService.Refresh(req.RefreshToken, newClientInfo(c))
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrRefreshInProgress):
+			utils.Error(c, http.StatusConflict, err.Error())
+		case errors.Is(err, services.ErrRefreshTokenInvalid), errors.Is(err, services.ErrRefreshTokenReused):
+			utils.Unauthorized(c, err.Error())
+		default:
+			fmt.Printf("Refresh token error: %v\n", err)
+			utils.InternalError(c)
+		}
+		return
+	}
+
+	utils.Success(c, models.UserResponse{
+		User:      user,
+		TokenPair: *tokens,
 	})
 }
 
+// VerifyEmail 使用验证邮件中的令牌完成邮箱验证
+func (h *AuthHandler) VerifyEmail(c *gin.Context) {
+	var req models.VerifyEmailRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.Error(c, http.StatusBadRequest, "请求参数错误")
+		return
+	}
+
+	if err := h.validator.Struct(&req); err != nil {
+		utils.ValidationError(c, err.Error())
+		return
+	}
+
+	user, err := h.authService.VerifyEmail(req.Token)
+	if err != nil {
+		if errors.Is(err, services.ErrUserTokenInvalid) {
+			utils.Error(c, http.StatusBadRequest, err.Error())
+			return
+		}
+		fmt.Printf("VerifyEmail error: %v\n", err)
+		utils.InternalError(c)
+		return
+	}
+
+	utils.SuccessWithMessage(c, "邮箱验证成功", gin.H{"user": user})
+}
+
+// ResendVerification 重新发送验证邮件
+func (h *AuthHandler) ResendVerification(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+
+	user, err := h.authService.GetUserByID(userID.(uint))
+	if err != nil {
+		utils.InternalError(c)
+		return
+	}
+
+	if err := h.authService.SendVerificationEmail(user); err != nil {
+		switch {
+		case errors.Is(err, services.ErrEmailAlreadyVerified):
+			utils.Error(c, http.StatusBadRequest, err.Error())
+		case errors.Is(err, services.ErrMailCooldown):
+			utils.Error(c, http.StatusTooManyRequests, err.Error())
+		default:
+			fmt.Printf("ResendVerification error: %v\n", err)
+			utils.InternalError(c)
+		}
+		return
+	}
+
+	utils.SuccessWithMessage(c, "验证邮件已发送", nil)
+}
+
+// ForgotPassword 发送重置密码邮件，无论邮箱是否注册都返回相同结果
+func (h *AuthHandler) ForgotPassword(c *gin.Context) {
+	var req models.ForgotPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.Error(c, http.StatusBadRequest, "请求参数错误")
+		return
+	}
+
+	if err := h.validator.Struct(&req); err != nil {
+		utils.ValidationError(c, err.Error())
+		return
+	}
+
+	if err := h.authService.ForgotPassword(req.Email); err != nil {
+		fmt.Printf("ForgotPassword error: %v\n", err)
+		utils.InternalError(c)
+		return
+	}
+
+	utils.SuccessWithMessage(c, "如果该邮箱已注册，重置密码邮件已发送", nil)
+}
+
+// ResetPassword 使用重置邮件中的令牌设置新密码，并退出该用户的所有设备
+func (h *AuthHandler) ResetPassword(c *gin.Context) {
+	var req models.ResetPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.Error(c, http.StatusBadRequest, "请求参数错误")
+		return
+	}
+
+	if err := h.validator.Struct(&req); err != nil {
+		utils.ValidationError(c, err.Error())
+		return
+	}
+
+	user, err := h.authService.ResetPassword(req.Token, req.Password)
+	if err != nil {
+		if errors.Is(err, services.ErrUserTokenInvalid) {
+			utils.Error(c, http.StatusBadRequest, err.Error())
+			return
+		}
+		fmt.Printf("ResetPassword error: %v\n", err)
+		utils.InternalError(c)
+		return
+	}
+
+	if _, err := h.sessionService.RevokeSessions(user.ID, ""); err != nil {
+		fmt.Printf("ResetPassword error: %v\n", err)
+		utils.InternalError(c)
+		return
+	}
+
+	utils.SuccessWithMessage(c, "密码已重置，请重新登录", nil)
+}
+
 func (h *AuthHandler) GetMe(c *gin.Context) {
 	userID, exists := c.Get("user_id")
 	if !exists {
@@ -117,11 +274,13 @@ func (h *AuthHandler) GetMe(c *gin.Context) {
 	}
 
 	response := gin.H{
-		"id":       user.ID,
-		"username": user.Username,
-		"email":    user.Email,
-		"avatar":   user.Avatar,
-		"role":     user.Role,
+		"id":                 user.ID,
+		"username":           user.Username,
+		"email":              user.Email,
+		"avatar":             user.Avatar,
+		"role":               user.Role,
+		"email_verified":     user.EmailVerifiedAt != nil,
+		"two_factor_enabled": user.TwoFactorEnabled,
 		"storage": gin.H{
 			"used_space":     storage.UsedSpace,
 			"max_space":      h.config.File.MaxUserStorage,
@@ -136,7 +295,95 @@ func (h *AuthHandler) GetMe(c *gin.Context) {
 	utils.Success(c, response)
 }
 
+// Logout 吊销当前会话和访问令牌，之后刷新令牌也不能再使用
 func (h *AuthHandler) Logout(c *gin.Context) {
-	// JWT 是无状态的，客户端删除 token 即可
+	userID, _ := c.Get("user_id")
+	sessionID := c.GetString("session_id")
+	tokenID := c.GetString("token_id")
+	expiresAt := c.GetTime("token_expires_at")
+
+	if err := h.sessionService.Logout(userID.(uint), sessionID, tokenID, expiresAt); err != nil {
+		fmt.Printf("Logout error: %v\n", err)
+		utils.InternalError(c)
+		return
+	}
+
 	utils.SuccessWithMessage(c, "退出成功", nil)
 }
+
+// GetSessions 当前用户已登录的设备
+func (h *AuthHandler) GetSessions(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+
+	sessions, err := h.sessionService.GetSessions(userID.(uint), c.GetString("session_id"))
+	if err != nil {
+		fmt.Printf("GetSessions error: %v\n", err)
+		utils.InternalError(c)
+		return
+	}
+
+	utils.Success(c, sessions)
+}
+
+// DeleteSession 退出指定设备，可以是当前设备
+func (h *AuthHandler) DeleteSession(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+
+	if err := h.sessionService.RevokeSession(userID.(uint), c.Param("id")); err != nil {
+		if errors.Is(err, services.ErrSessionNotFound) {
+			utils.NotFound(c, err.Error())
+			return
+		}
+		fmt.Printf("DeleteSession error: %v\n", err)
+		utils.InternalError(c)
+		return
+	}
+
+	utils.SuccessWithMessage(c, "已退出该设备", nil)
+}
+
+// DeleteSessions 退出所有设备，except=current 时保留当前设备
+func (h *AuthHandler) DeleteSessions(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+
+	except := c.Query("except")
+	if except != "" && except != "current" {
+		utils.Error(c, http.StatusBadRequest, "except 参数只支持 current")
+		return
+	}
+
+	keepSessionID := ""
+	if except == "current" {
+		keepSessionID = c.GetString("session_id")
+		if keepSessionID == "" {
+			utils.Error(c, http.StatusBadRequest, "当前令牌不属于任何会话，请重新登录")
+			return
+		}
+	}
+
+	count, err := h.sessionService.RevokeSessions(userID.(uint), keepSessionID)
+	if err != nil {
+		fmt.Printf("DeleteSessions error: %v\n", err)
+		utils.InternalError(c)
+		return
+	}
+
+	if keepSessionID == "" {
+		// 同时吊销本次请求使用的访问令牌
+		err := h.sessionService.Logout(userID.(uint), "", c.GetString("token_id"), c.GetTime("token_expires_at"))
+		if err != nil {
+			fmt.Printf("DeleteSessions error: %v\n", err)
+			utils.InternalError(c)
+			return
+		}
+	}
+
+	utils.SuccessWithMessage(c, fmt.Sprintf("已退出 %d 个设备", count), gin.H{"revoked": count})
+}
+
+func newClientInfo(c *gin.Context) *models.ClientInfo {
+	return &models.ClientInfo{
+		IP:        c.ClientIP(),
+		UserAgent: c.GetHeader("User-Agent"),
+	}
+}
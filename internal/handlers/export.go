@@ -0,0 +1,41 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"notes-backend/internal/services"
+	"notes-backend/internal/utils"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type ExportHandler struct {
+	exportService *services.ExportService
+}
+
+func NewExportHandler(exportService *services.ExportService) *ExportHandler {
+	return &ExportHandler{exportService: exportService}
+}
+
+// Export 导出当前用户的全部笔记，ZIP 以流的方式直接写入响应
+func (h *ExportHandler) Export(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+
+	format := c.DefaultQuery("format", "markdown")
+	if format != "markdown" {
+		utils.Error(c, http.StatusBadRequest, "不支持的导出格式")
+		return
+	}
+
+	filename := fmt.Sprintf("notes-export-%s.zip", time.Now().Format("20060102-150405"))
+	c.Header("Content-Type", "application/zip")
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	c.Status(http.StatusOK)
+
+	// 响应头已发送，出错时只能中断连接，客户端收到的是不完整的响应而不是看似完整的压缩包
+	if err := h.exportService.ExportMarkdown(userID.(uint), c.Writer); err != nil {
+		fmt.Printf("Export failed for user %d: %v\n", userID, err)
+		panic(http.ErrAbortHandler)
+	}
+}
@@ -2,6 +2,8 @@ package handlers
 
 import (
 	"fmt"
+	"io"
+	"mime"
 	"mime/multipart"
 	"net/http"
 	"notes-backend/internal/config"
@@ -172,14 +174,7 @@ func (h *FileHandler) ServeFile(c *gin.Context) {
 		return
 	}
 
-	// 设置响应头
-	c.Header("Content-Disposition", fmt.Sprintf("inline; filename=\"%s\"", attachment.OriginalFilename))
-	if attachment.MimeType != nil {
-		c.Header("Content-Type", *attachment.MimeType)
-	}
-
-	// 发送文件
-	c.File(attachment.FilePath)
+	serveStoredFile(c, attachment.FilePath, attachment.OriginalFilename, false)
 }
 
 func (h *FileHandler) DownloadFile(c *gin.Context) {
@@ -209,12 +204,54 @@ func (h *FileHandler) DownloadFile(c *gin.Context) {
 		return
 	}
 
-	// 设置下载响应头
-	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", attachment.OriginalFilename))
-	if attachment.MimeType != nil {
-		c.Header("Content-Type", *attachment.MimeType)
+	serveStoredFile(c, attachment.FilePath, attachment.OriginalFilename, true)
+}
+
+// inlineContentTypes 允许浏览器直接内联显示的类型，仅限位图图片；
+// SVG 等可携带脚本的类型一律作为附件下载
+var inlineContentTypes = map[string]bool{
+	"image/png":  true,
+	"image/jpeg": true,
+	"image/gif":  true,
+	"image/webp": true,
+	"image/bmp":  true,
+	"image/avif": true,
+}
+
+// serveStoredFile 发送用户上传的文件。Content-Type 由扩展名或文件内容推断，
+// 不信任上传时客户端提供的值；只有图片内联显示，其余类型强制下载
+func serveStoredFile(c *gin.Context, path, filename string, download bool) {
+	contentType := detectContentType(path, filename)
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+
+	disposition := "attachment"
+	if !download && inlineContentTypes[mediaType] {
+		disposition = "inline"
+	}
+	header := mime.FormatMediaType(disposition, map[string]string{"filename": filename})
+	if header == "" {
+		header = disposition
 	}
 
-	// 发送文件
-	c.File(attachment.FilePath)
-}
\ No newline at end of file
+	c.Header("Content-Type", contentType)
+	c.Header("Content-Disposition", header)
+	c.Header("X-Content-Type-Options", "nosniff")
+	c.File(path)
+}
+
+// detectContentType 优先按扩展名判断类型，无法识别时读取文件头嗅探
+func detectContentType(path, filename string) string {
+	if contentType := mime.TypeByExtension(strings.ToLower(filepath.Ext(filename))); contentType != "" {
+		return contentType
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return "application/octet-stream"
+	}
+	defer file.Close()
+
+	buf := make([]byte, 512)
+	n, _ := io.ReadFull(file, buf)
+	return http.DetectContentType(buf[:n])
+}
@@ -0,0 +1,135 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"notes-backend/internal/config"
+	"notes-backend/internal/models"
+	"notes-backend/internal/services"
+	"notes-backend/internal/utils"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+)
+
+type ImportHandler struct {
+	importService *services.ImportService
+	config        *config.Config
+	validator     *validator.Validate
+}
+
+func NewImportHandler(importService *services.ImportService, cfg *config.Config) *ImportHandler {
+	return &ImportHandler{
+		importService: importService,
+		config:        cfg,
+		validator:     validator.New(),
+	}
+}
+
+// Import 上传待导入的文件并创建后台任务，返回 202 和任务信息，客户端通过任务 ID 轮询进度
+func (h *ImportHandler) Import(c *gin.Context) {
+	h.startImport(c, "")
+}
+
+// ImportENEX 导入 Evernote 导出的 .enex 文件，笔记本名称默认取文件名
+func (h *ImportHandler) ImportENEX(c *gin.Context) {
+	h.startImport(c, "enex")
+}
+
+// startImport format 非空时忽略请求中的 format 参数
+func (h *ImportHandler) startImport(c *gin.Context, format string) {
+	userID, _ := c.Get("user_id")
+
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, h.config.Import.MaxArchiveSize+1024*1024)
+
+	var req models.ImportRequest
+	if err := c.ShouldBind(&req); err != nil {
+		utils.Error(c, http.StatusBadRequest, "请求参数错误或文件过大")
+		return
+	}
+	if format != "" {
+		req.Format = format
+	}
+	if req.Format == "" {
+		req.Format = "markdown"
+	}
+	if req.OnConflict == "" {
+		req.OnConflict = models.ImportConflictSkip
+	}
+	if req.ContentType == "" || req.Format != "enex" {
+		req.ContentType = "markdown"
+	}
+	req.Notebook = strings.TrimSpace(req.Notebook)
+
+	if err := h.validator.Struct(&req); err != nil {
+		utils.ValidationError(c, err.Error())
+		return
+	}
+
+	file, header, err := c.Request.FormFile("file")
+	if err != nil {
+		utils.Error(c, http.StatusBadRequest, "未找到上传文件或文件过大")
+		return
+	}
+	defer file.Close()
+
+	if header.Size > h.config.Import.MaxArchiveSize {
+		utils.Error(c, http.StatusRequestEntityTooLarge, fmt.Sprintf("导入文件大小不能超过 %d MB", h.config.Import.MaxArchiveSize/(1024*1024)))
+		return
+	}
+
+	if req.Format == "enex" && req.Notebook == "" {
+		name := filepath.Base(header.Filename)
+		req.Notebook = strings.TrimSpace(strings.TrimSuffix(name, filepath.Ext(name)))
+	}
+
+	// 上传文件复制到临时文件，由后台任务处理完成后删除
+	tmp, err := os.CreateTemp("", "notes-import-*")
+	if err != nil {
+		utils.InternalError(c)
+		return
+	}
+	if _, err := io.Copy(tmp, file); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		utils.Error(c, http.StatusBadRequest, "读取上传文件失败")
+		return
+	}
+	tmp.Close()
+
+	job, err := h.importService.StartImport(userID.(uint), &req, tmp.Name())
+	if err != nil {
+		os.Remove(tmp.Name())
+		if errors.Is(err, services.ErrImportInProgress) {
+			utils.Error(c, http.StatusConflict, err.Error())
+			return
+		}
+		utils.InternalError(c)
+		return
+	}
+
+	utils.Accepted(c, "导入任务已创建", job)
+}
+
+func (h *ImportHandler) GetJobs(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+
+	utils.Success(c, h.importService.GetJobs(userID.(uint)))
+}
+
+func (h *ImportHandler) GetJob(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+
+	job, err := h.importService.GetJob(c.Param("id"), userID.(uint))
+	if err != nil {
+		utils.NotFound(c, err.Error())
+		return
+	}
+
+	utils.Success(c, job)
+}
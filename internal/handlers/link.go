@@ -0,0 +1,67 @@
+package handlers
+
+import (
+	"net/http"
+	"notes-backend/internal/services"
+	"notes-backend/internal/utils"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+type LinkHandler struct {
+	linkService *services.LinkService
+}
+
+func NewLinkHandler(linkService *services.LinkService) *LinkHandler {
+	return &LinkHandler{linkService: linkService}
+}
+
+// GetLinks 获取笔记的出链，标题匹配到多篇笔记时 status 为 ambiguous 并返回候选笔记
+func (h *LinkHandler) GetLinks(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+
+	noteID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		utils.Error(c, http.StatusBadRequest, "无效的笔记ID")
+		return
+	}
+
+	links, err := h.linkService.GetLinks(uint(noteID), userID.(uint))
+	if err != nil {
+		utils.NotFound(c, err.Error())
+		return
+	}
+
+	utils.Success(c, links)
+}
+
+func (h *LinkHandler) GetBacklinks(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+
+	noteID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		utils.Error(c, http.StatusBadRequest, "无效的笔记ID")
+		return
+	}
+
+	backlinks, err := h.linkService.GetBacklinks(uint(noteID), userID.(uint))
+	if err != nil {
+		utils.NotFound(c, err.Error())
+		return
+	}
+
+	utils.Success(c, backlinks)
+}
+
+func (h *LinkHandler) GetGraph(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+
+	graph, err := h.linkService.GetGraph(userID.(uint))
+	if err != nil {
+		utils.InternalError(c)
+		return
+	}
+
+	utils.Success(c, graph)
+}
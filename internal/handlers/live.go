@@ -0,0 +1,160 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"notes-backend/internal/middleware"
+	"notes-backend/internal/models"
+	"notes-backend/internal/services"
+	"notes-backend/internal/utils"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	liveWriteWait      = 10 * time.Second
+	livePongWait       = 60 * time.Second
+	livePingPeriod     = 50 * time.Second
+	liveMaxMessageSize = 1 << 20
+)
+
+type LiveHandler struct {
+	hub         services.LiveHub
+	noteService *services.NoteService
+	upgrader    websocket.Upgrader
+}
+
+func NewLiveHandler(hub services.LiveHub, noteService *services.NoteService) *LiveHandler {
+	return &LiveHandler{
+		hub:         hub,
+		noteService: noteService,
+		upgrader: websocket.Upgrader{
+			ReadBufferSize:  4096,
+			WriteBufferSize: 4096,
+			CheckOrigin:     checkLiveOrigin,
+		},
+	}
+}
+
+// checkLiveOrigin 允许同源、跨域白名单中的前端和不带 Origin 的非浏览器客户端
+func checkLiveOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" || middleware.IsAllowedOrigin(origin) {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && u.Host == r.Host
+}
+
+// Live 协同编辑 WebSocket 连接。所有有权限的用户都可以加入并同步光标，只有所有者和 editor 可以提交修改
+func (h *LiveHandler) Live(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+	user, _ := c.Get("user")
+
+	noteID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		utils.Error(c, http.StatusBadRequest, "无效的笔记ID")
+		return
+	}
+
+	note, err := h.noteService.GetNoteByID(uint(noteID), userID.(uint))
+	if err != nil {
+		utils.NotFound(c, "笔记不存在")
+		return
+	}
+
+	conn, err := h.upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		// Upgrade 失败时已经写入了错误响应
+		fmt.Printf("Live websocket upgrade failed: %v\n", err)
+		return
+	}
+
+	client := services.NewLiveClient(userID.(uint), user.(*models.User).Username, note.AccessRole)
+	if err := h.hub.Join(uint(noteID), client); err != nil {
+		fmt.Printf("Live join failed: note_id=%d, error=%v\n", noteID, err)
+		conn.WriteControl(websocket.CloseMessage,
+			websocket.FormatCloseMessage(websocket.CloseInternalServerErr, "无法加入协同编辑"),
+			time.Now().Add(liveWriteWait))
+		conn.Close()
+		return
+	}
+
+	go h.writePump(conn, client)
+	h.readPump(conn, uint(noteID), client)
+}
+
+// readPump 读取客户端发送的操作和光标，连接断开时离开会话
+func (h *LiveHandler) readPump(conn *websocket.Conn, noteID uint, client *services.LiveClient) {
+	defer func() {
+		h.hub.Leave(noteID, client)
+		conn.Close()
+	}()
+
+	conn.SetReadLimit(liveMaxMessageSize)
+	conn.SetReadDeadline(time.Now().Add(livePongWait))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(livePongWait))
+	})
+
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseNormalClosure) {
+				fmt.Printf("Live connection closed: note_id=%d, error=%v\n", noteID, err)
+			}
+			return
+		}
+
+		var msg models.LiveMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			continue
+		}
+
+		switch msg.Type {
+		case models.LiveMessageOperation:
+			var op utils.TextOperation
+			if err := json.Unmarshal(msg.Operation, &op); err != nil {
+				continue
+			}
+			h.hub.Submit(noteID, client, msg.Revision, op)
+		case models.LiveMessageCursor:
+			if msg.Cursor != nil {
+				h.hub.MoveCursor(noteID, client, *msg.Cursor)
+			}
+		}
+	}
+}
+
+// writePump 发送消息和心跳，消息通道被服务端关闭时断开连接
+func (h *LiveHandler) writePump(conn *websocket.Conn, client *services.LiveClient) {
+	ticker := time.NewTicker(livePingPeriod)
+	defer func() {
+		ticker.Stop()
+		conn.Close()
+	}()
+
+	for {
+		select {
+		case data, ok := <-client.Messages():
+			conn.SetWriteDeadline(time.Now().Add(liveWriteWait))
+			if !ok {
+				conn.WriteMessage(websocket.CloseMessage, []byte{})
+				return
+			}
+			if err := conn.WriteMessage(websocket.TextMessage, data); err != nil {
+				return
+			}
+		case <-ticker.C:
+			conn.SetWriteDeadline(time.Now().Add(liveWriteWait))
+			if err := conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		}
+	}
+}
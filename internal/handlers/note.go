@@ -3,12 +3,15 @@ package handlers
 
 import (
 	"crypto/md5"
+	"errors"
 	"fmt"
 	"net/http"
 	"notes-backend/internal/models"
+	"notes-backend/internal/search"
 	"notes-backend/internal/services"
 	"notes-backend/internal/utils"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -55,6 +58,11 @@ func (h *NoteHandler) GetNotes(c *gin.Context) {
 
 	notes, pagination, err := h.noteService.GetNotes(userID.(uint), &req)
 	if err != nil {
+		var queryErr *search.Error
+		if errors.As(err, &queryErr) {
+			utils.ErrorWithData(c, http.StatusBadRequest, queryErr.Error(), queryErr)
+			return
+		}
 		utils.InternalError(c)
 		return
 	}
@@ -82,24 +90,40 @@ func (h *NoteHandler) GetNote(c *gin.Context) {
 		return
 	}
 
-	// 记录浏览量（避免作者自己查看时计数）
-	go h.recordView(uint(noteID), userID.(uint), c)
+	// 记录浏览量（避免作者自己查看时计数），请求信息需在返回前读取
+	go h.recordView(uint(noteID), userID.(uint), newViewerInfo(c))
 
+	setNoteETag(c, note)
 	utils.Success(c, note)
 }
 
 // recordView 记录浏览量（异步处理，避免影响响应速度）
-func (h *NoteHandler) recordView(noteID, viewerID uint, c *gin.Context) {
-	clientIP := c.ClientIP()
-	userAgent := c.GetHeader("User-Agent")
-	
+func (h *NoteHandler) recordView(noteID, viewerID uint, viewerInfo *models.ViewerInfo) {
 	// 创建唯一标识符（基于用户ID、IP、笔记ID和时间窗口）
 	timeWindow := time.Now().Format("2006-01-02-15") // 1小时时间窗口
-	identifier := fmt.Sprintf("%d-%s-%d-%s", viewerID, clientIP, noteID, timeWindow)
+	identifier := fmt.Sprintf("%d-%s-%d-%s", viewerID, viewerInfo.IP, noteID, timeWindow)
 	hash := fmt.Sprintf("%x", md5.Sum([]byte(identifier)))
 
 	// 记录浏览量
-	h.noteService.RecordView(noteID, viewerID, clientIP, userAgent, hash)
+	h.noteService.RecordView(noteID, viewerID, viewerInfo, hash)
+}
+
+// newViewerInfo 读取访问者信息，供记录浏览量和识别机器人访问
+func newViewerInfo(c *gin.Context) *models.ViewerInfo {
+	purpose := ""
+	for _, header := range []string{"Sec-Purpose", "Purpose", "X-Purpose", "X-Moz"} {
+		if purpose = c.GetHeader(header); purpose != "" {
+			break
+		}
+	}
+
+	return &models.ViewerInfo{
+		IP:        c.ClientIP(),
+		UserAgent: c.GetHeader("User-Agent"),
+		Referer:   c.GetHeader("Referer"),
+		Method:    c.Request.Method,
+		Purpose:   purpose,
+	}
 }
 
 func (h *NoteHandler) CreateNote(c *gin.Context) {
@@ -128,9 +152,34 @@ func (h *NoteHandler) CreateNote(c *gin.Context) {
 		return
 	}
 
+	setNoteETag(c, note)
 	utils.SuccessWithMessage(c, "创建成功", note)
 }
 
+// BulkUpdate 批量操作笔记，返回每条笔记的处理结果
+func (h *NoteHandler) BulkUpdate(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+
+	var req models.BulkNoteRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.Error(c, http.StatusBadRequest, "请求参数错误")
+		return
+	}
+
+	if err := h.validator.Struct(&req); err != nil {
+		utils.ValidationError(c, err.Error())
+		return
+	}
+
+	response, err := h.noteService.BulkUpdate(userID.(uint), &req)
+	if err != nil {
+		utils.Error(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	utils.Success(c, response)
+}
+
 func (h *NoteHandler) UpdateNote(c *gin.Context) {
 	userID, _ := c.Get("user_id")
 	noteIDStr := c.Param("id")
@@ -153,12 +202,27 @@ func (h *NoteHandler) UpdateNote(c *gin.Context) {
 		return
 	}
 
-	note, err := h.noteService.UpdateNote(uint(noteID), userID.(uint), &req)
+	expectedVersion, err := parseIfMatch(c)
+	if err != nil {
+		utils.Error(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	note, err := h.noteService.UpdateNote(uint(noteID), userID.(uint), &req, expectedVersion)
 	if err != nil {
+		if errors.Is(err, services.ErrVersionConflict) {
+			respondVersionConflict(c, h.noteService, uint(noteID), userID.(uint))
+			return
+		}
+		if errors.Is(err, services.ErrNoteForbidden) {
+			utils.Forbidden(c, err.Error())
+			return
+		}
 		utils.Error(c, http.StatusBadRequest, err.Error())
 		return
 	}
 
+	setNoteETag(c, note)
 	utils.SuccessWithMessage(c, "更新成功", note)
 }
 
@@ -177,10 +241,20 @@ func (h *NoteHandler) DeleteNote(c *gin.Context) {
 		return
 	}
 
+	expectedVersion, err := parseIfMatch(c)
+	if err != nil {
+		utils.Error(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
 	fmt.Printf("Attempting to delete note: note_id=%d, user_id=%v\n", noteID, userID)
 
-	err = h.noteService.DeleteNote(uint(noteID), userID.(uint))
+	err = h.noteService.DeleteNote(uint(noteID), userID.(uint), expectedVersion)
 	if err != nil {
+		if errors.Is(err, services.ErrVersionConflict) {
+			respondVersionConflict(c, h.noteService, uint(noteID), userID.(uint))
+			return
+		}
 		fmt.Printf("Delete note failed: %v\n", err)
 		utils.Error(c, http.StatusBadRequest, err.Error())
 		return
@@ -200,4 +274,38 @@ func (h *NoteHandler) GetUserStats(c *gin.Context) {
 	}
 
 	utils.Success(c, stats)
-}
\ No newline at end of file
+}
+
+// respondVersionConflict 返回 412 以及服务端当前的笔记内容
+func respondVersionConflict(c *gin.Context, noteService *services.NoteService, noteID, userID uint) {
+	current, err := noteService.GetNoteByID(noteID, userID)
+	if err != nil {
+		utils.NotFound(c, "笔记不存在")
+		return
+	}
+
+	setNoteETag(c, current)
+	utils.PreconditionFailed(c, services.ErrVersionConflict.Error(), current)
+}
+
+func setNoteETag(c *gin.Context, note *models.Note) {
+	c.Header("ETag", fmt.Sprintf("\"%d\"", note.Version))
+}
+
+// parseIfMatch 解析 If-Match 请求头中的版本号，未提供或为 * 时返回 nil
+func parseIfMatch(c *gin.Context) (*int, error) {
+	value := strings.TrimSpace(c.GetHeader("If-Match"))
+	if value == "" || value == "*" {
+		return nil, nil
+	}
+
+	value = strings.TrimPrefix(value, "W/")
+	value = strings.Trim(value, "\"")
+
+	version, err := strconv.Atoi(value)
+	if err != nil {
+		return nil, fmt.Errorf("无效的 If-Match 请求头")
+	}
+
+	return &version, nil
+}
@@ -0,0 +1,202 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"notes-backend/internal/config"
+	"notes-backend/internal/models"
+	"notes-backend/internal/services"
+	"notes-backend/internal/utils"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+)
+
+// oidcStateCookie 保存发起登录时的 state，回调时与查询参数比对，防止登录 CSRF
+const oidcStateCookie = "oidc_state"
+
+type OIDCHandler struct {
+	oidcService      *services.OIDCService
+	twoFactorService *services.TwoFactorService
+	sessionService   *services.SessionService
+	config           *config.Config
+	validator        *validator.Validate
+}
+
+func NewOIDCHandler(oidcService *services.OIDCService, twoFactorService *services.TwoFactorService, sessionService *services.SessionService, cfg *config.Config) *OIDCHandler {
+	return &OIDCHandler{
+		oidcService:      oidcService,
+		twoFactorService: twoFactorService,
+		sessionService:   sessionService,
+		config:           cfg,
+		validator:        validator.New(),
+	}
+}
+
+// GetProviders 登录页可用的身份提供方
+func (h *OIDCHandler) GetProviders(c *gin.Context) {
+	utils.Success(c, h.oidcService.Providers())
+}
+
+// Start 跳转到身份提供方登录。redirect 参数为登录完成后前端要打开的页面，只接受站内路径
+func (h *OIDCHandler) Start(c *gin.Context) {
+	provider := c.Param("provider")
+
+	returnTo := c.Query("redirect")
+	if !strings.HasPrefix(returnTo, "/") || strings.HasPrefix(returnTo, "//") || strings.HasPrefix(returnTo, "/\\") {
+		returnTo = ""
+	}
+
+	authURL, state, err := h.oidcService.Start(c.Request.Context(), provider, callbackURL(c, provider), returnTo)
+	if err != nil {
+		if errors.Is(err, services.ErrOIDCProviderNotFound) {
+			utils.NotFound(c, err.Error())
+			return
+		}
+		if errors.Is(err, services.ErrOIDCLoginFailed) {
+			utils.Error(c, http.StatusBadGateway, "无法连接身份提供方")
+			return
+		}
+		fmt.Printf("OIDC start error: %v\n", err)
+		utils.InternalError(c)
+		return
+	}
+
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(oidcStateCookie, state, 600, "/api/auth/oidc", "", isSecureRequest(c), true)
+	c.Redirect(http.StatusFound, authURL)
+}
+
+// Callback 身份提供方回调。登录成功后跳转到前端并附带一次性登录码 login_code，失败时附带 error
+func (h *OIDCHandler) Callback(c *gin.Context) {
+	provider := c.Param("provider")
+	state := c.Query("state")
+
+	cookieState, _ := c.Cookie(oidcStateCookie)
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(oidcStateCookie, "", -1, "/api/auth/oidc", "", isSecureRequest(c), true)
+
+	if errParam := c.Query("error"); errParam != "" {
+		fmt.Printf("OIDC provider returned error: provider=%s, error=%s, description=%s\n",
+			provider, errParam, c.Query("error_description"))
+		h.redirectToFrontend(c, url.Values{"error": {services.ErrOIDCLoginFailed.Error()}}, "")
+		return
+	}
+
+	if state == "" || cookieState != state {
+		h.redirectToFrontend(c, url.Values{"error": {services.ErrOIDCState.Error()}}, "")
+		return
+	}
+
+	user, returnTo, err := h.oidcService.Callback(c.Request.Context(), provider, state, c.Query("code"))
+	if err != nil {
+		message := err.Error()
+		switch {
+		case errors.Is(err, services.ErrOIDCState),
+			errors.Is(err, services.ErrOIDCProviderNotFound),
+			errors.Is(err, services.ErrOIDCLoginFailed),
+			errors.Is(err, services.ErrOIDCEmailMissing),
+			errors.Is(err, services.ErrOIDCDomainNotAllowed),
+			errors.Is(err, services.ErrOIDCNoAccount),
+			errors.Is(err, services.ErrOIDCEmailUnverified):
+		default:
+			fmt.Printf("OIDC callback error: provider=%s, error=%v\n", provider, err)
+			message = services.ErrOIDCLoginFailed.Error()
+		}
+		h.redirectToFrontend(c, url.Values{"error": {message}}, returnTo)
+		return
+	}
+
+	code, err := h.oidcService.IssueLoginCode(user)
+	if err != nil {
+		fmt.Printf("OIDC callback error: provider=%s, error=%v\n", provider, err)
+		h.redirectToFrontend(c, url.Values{"error": {services.ErrOIDCLoginFailed.Error()}}, returnTo)
+		return
+	}
+
+	h.redirectToFrontend(c, url.Values{"login_code": {code}}, returnTo)
+}
+
+// Exchange 使用一次性登录码换取令牌，返回结果与密码登录相同；开启两步验证的用户返回挑战令牌
+func (h *OIDCHandler) Exchange(c *gin.Context) {
+	var req models.OIDCExchangeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.Error(c, http.StatusBadRequest, "请求参数错误")
+		return
+	}
+
+	if err := h.validator.Struct(&req); err != nil {
+		utils.ValidationError(c, err.Error())
+		return
+	}
+
+	user, err := h.oidcService.ExchangeLoginCode(req.Code)
+	if err != nil {
+		if errors.Is(err, services.ErrOIDCLoginCode) {
+			utils.Unauthorized(c, err.Error())
+			return
+		}
+		if errors.Is(err, services.ErrEmailNotVerified) {
+			utils.Forbidden(c, err.Error())
+			return
+		}
+		fmt.Printf("OIDC exchange error: %v\n", err)
+		utils.InternalError(c)
+		return
+	}
+
+	if user.TwoFactorEnabled {
+		challenge, err := h.twoFactorService.NewChallenge(user)
+		if err != nil {
+			utils.InternalError(c)
+			return
+		}
+		utils.SuccessWithMessage(c, "请输入两步验证码", challenge)
+		return
+	}
+
+	tokens, err := h.sessionService.CreateSession(user, newClientInfo(c))
+	if err != nil {
+		utils.InternalError(c)
+		return
+	}
+
+	utils.SuccessWithMessage(c, "登录成功", models.UserResponse{
+		User:      user,
+		TokenPair: *tokens,
+	})
+}
+
+func (h *OIDCHandler) redirectToFrontend(c *gin.Context, params url.Values, returnTo string) {
+	if returnTo != "" {
+		params.Set("redirect", returnTo)
+	}
+
+	target := h.config.OIDC.FrontendCallbackURL
+	if strings.Contains(target, "?") {
+		target += "&" + params.Encode()
+	} else {
+		target += "?" + params.Encode()
+	}
+	c.Redirect(http.StatusFound, target)
+}
+
+// callbackURL 根据当前请求生成回调地址，经过反向代理时使用 X-Forwarded-Proto 和 X-Forwarded-Host
+func callbackURL(c *gin.Context, provider string) string {
+	scheme := "http"
+	if isSecureRequest(c) {
+		scheme = "https"
+	}
+	host := c.Request.Host
+	if forwarded := c.GetHeader("X-Forwarded-Host"); forwarded != "" {
+		host = forwarded
+	}
+	return scheme + "://" + host + "/api/auth/oidc/" + url.PathEscape(provider) + "/callback"
+}
+
+func isSecureRequest(c *gin.Context) bool {
+	return c.Request.TLS != nil || c.GetHeader("X-Forwarded-Proto") == "https"
+}
@@ -0,0 +1,223 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"notes-backend/internal/models"
+	"notes-backend/internal/services"
+	"notes-backend/internal/utils"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"gorm.io/gorm"
+)
+
+type PermissionHandler struct {
+	permissionService *services.PermissionService
+	validator         *validator.Validate
+}
+
+func NewPermissionHandler(permissionService *services.PermissionService) *PermissionHandler {
+	return &PermissionHandler{
+		permissionService: permissionService,
+		validator:         validator.New(),
+	}
+}
+
+func (h *PermissionHandler) GetNotePermissions(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+
+	noteID, ok := parseIDParam(c, "id", "无效的笔记ID")
+	if !ok {
+		return
+	}
+
+	permissions, err := h.permissionService.GetNotePermissions(noteID, userID.(uint))
+	if err != nil {
+		respondPermissionError(c, err, "笔记不存在")
+		return
+	}
+
+	utils.Success(c, permissions)
+}
+
+// GrantNotePermission 按用户名或邮箱授权其他用户访问笔记，重复授权时更新角色
+func (h *PermissionHandler) GrantNotePermission(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+
+	noteID, ok := parseIDParam(c, "id", "无效的笔记ID")
+	if !ok {
+		return
+	}
+
+	req, ok := h.bindPermissionRequest(c)
+	if !ok {
+		return
+	}
+
+	permission, err := h.permissionService.GrantNote(noteID, userID.(uint), req)
+	if err != nil {
+		respondPermissionError(c, err, "笔记不存在")
+		return
+	}
+
+	utils.SuccessWithMessage(c, "授权成功", permission)
+}
+
+func (h *PermissionHandler) RevokeNotePermission(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+
+	noteID, ok := parseIDParam(c, "id", "无效的笔记ID")
+	if !ok {
+		return
+	}
+	permissionID, ok := parseIDParam(c, "permissionId", "无效的授权ID")
+	if !ok {
+		return
+	}
+
+	if err := h.permissionService.RevokeNote(noteID, permissionID, userID.(uint)); err != nil {
+		respondPermissionError(c, err, "笔记不存在")
+		return
+	}
+
+	utils.SuccessWithMessage(c, "已取消授权", nil)
+}
+
+func (h *PermissionHandler) GetCategoryPermissions(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+
+	categoryID, ok := parseIDParam(c, "id", "无效的分类ID")
+	if !ok {
+		return
+	}
+
+	permissions, err := h.permissionService.GetCategoryPermissions(categoryID, userID.(uint))
+	if err != nil {
+		respondPermissionError(c, err, "分类不存在")
+		return
+	}
+
+	utils.Success(c, permissions)
+}
+
+// GrantCategoryPermission 授权其他用户访问分类及其所有子分类下的笔记
+func (h *PermissionHandler) GrantCategoryPermission(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+
+	categoryID, ok := parseIDParam(c, "id", "无效的分类ID")
+	if !ok {
+		return
+	}
+
+	req, ok := h.bindPermissionRequest(c)
+	if !ok {
+		return
+	}
+
+	permission, err := h.permissionService.GrantCategory(categoryID, userID.(uint), req)
+	if err != nil {
+		respondPermissionError(c, err, "分类不存在")
+		return
+	}
+
+	utils.SuccessWithMessage(c, "授权成功", permission)
+}
+
+func (h *PermissionHandler) RevokeCategoryPermission(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+
+	categoryID, ok := parseIDParam(c, "id", "无效的分类ID")
+	if !ok {
+		return
+	}
+	permissionID, ok := parseIDParam(c, "permissionId", "无效的授权ID")
+	if !ok {
+		return
+	}
+
+	if err := h.permissionService.RevokeCategory(categoryID, permissionID, userID.(uint)); err != nil {
+		respondPermissionError(c, err, "分类不存在")
+		return
+	}
+
+	utils.SuccessWithMessage(c, "已取消授权", nil)
+}
+
+// GetSharedWithMe 其他用户共享给当前用户的笔记
+func (h *PermissionHandler) GetSharedWithMe(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+
+	var req models.SharedWithMeRequest
+	if err := c.ShouldBindQuery(&req); err != nil {
+		utils.Error(c, http.StatusBadRequest, "请求参数错误")
+		return
+	}
+
+	if req.Page <= 0 {
+		req.Page = 1
+	}
+	if req.Limit <= 0 {
+		req.Limit = 20
+	}
+	if req.Limit > 100 {
+		req.Limit = 100
+	}
+
+	if err := h.validator.Struct(&req); err != nil {
+		utils.ValidationError(c, err.Error())
+		return
+	}
+
+	notes, pagination, err := h.permissionService.GetSharedWithMe(userID.(uint), &req)
+	if err != nil {
+		fmt.Printf("Get shared notes error: %v\n", err)
+		utils.InternalError(c)
+		return
+	}
+
+	utils.Success(c, gin.H{
+		"notes":      notes,
+		"pagination": pagination,
+	})
+}
+
+func (h *PermissionHandler) bindPermissionRequest(c *gin.Context) (*models.NotePermissionRequest, bool) {
+	var req models.NotePermissionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.Error(c, http.StatusBadRequest, "请求参数错误")
+		return nil, false
+	}
+
+	if err := h.validator.Struct(&req); err != nil {
+		utils.ValidationError(c, err.Error())
+		return nil, false
+	}
+
+	return &req, true
+}
+
+func parseIDParam(c *gin.Context, name, message string) (uint, bool) {
+	id, err := strconv.ParseUint(c.Param(name), 10, 32)
+	if err != nil {
+		utils.Error(c, http.StatusBadRequest, message)
+		return 0, false
+	}
+	return uint(id), true
+}
+
+func respondPermissionError(c *gin.Context, err error, notFound string) {
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		utils.NotFound(c, notFound)
+	case errors.Is(err, services.ErrGranteeNotFound), errors.Is(err, services.ErrPermissionNotFound):
+		utils.NotFound(c, err.Error())
+	case errors.Is(err, services.ErrGrantSelf):
+		utils.Error(c, http.StatusBadRequest, err.Error())
+	default:
+		fmt.Printf("Note permission error: %v\n", err)
+		utils.InternalError(c)
+	}
+}
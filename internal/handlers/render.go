@@ -0,0 +1,44 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"notes-backend/internal/services"
+	"notes-backend/internal/utils"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type RenderHandler struct {
+	renderService *services.RenderService
+}
+
+func NewRenderHandler(renderService *services.RenderService) *RenderHandler {
+	return &RenderHandler{renderService: renderService}
+}
+
+// GetRenderedNote 获取笔记渲染后的 HTML，ETag 与笔记接口一致为版本号
+func (h *RenderHandler) GetRenderedNote(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+
+	noteID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		utils.Error(c, http.StatusBadRequest, "无效的笔记ID")
+		return
+	}
+
+	rendered, err := h.renderService.GetRenderedNote(uint(noteID), userID.(uint))
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			utils.NotFound(c, "笔记不存在")
+		} else {
+			utils.InternalError(c)
+		}
+		return
+	}
+
+	c.Header("ETag", fmt.Sprintf("\"%d\"", rendered.Version))
+	utils.Success(c, rendered)
+}
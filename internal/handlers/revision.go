@@ -0,0 +1,169 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"notes-backend/internal/models"
+	"notes-backend/internal/services"
+	"notes-backend/internal/utils"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+)
+
+type RevisionHandler struct {
+	revisionService *services.RevisionService
+	noteService     *services.NoteService
+	validator       *validator.Validate
+}
+
+func NewRevisionHandler(revisionService *services.RevisionService, noteService *services.NoteService) *RevisionHandler {
+	return &RevisionHandler{
+		revisionService: revisionService,
+		noteService:     noteService,
+		validator:       validator.New(),
+	}
+}
+
+func (h *RevisionHandler) GetRevisions(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+
+	noteID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		utils.Error(c, http.StatusBadRequest, "无效的笔记ID")
+		return
+	}
+
+	revisions, err := h.revisionService.GetRevisions(uint(noteID), userID.(uint))
+	if err != nil {
+		utils.NotFound(c, err.Error())
+		return
+	}
+
+	utils.Success(c, revisions)
+}
+
+func (h *RevisionHandler) GetRevision(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+
+	noteID, revision, ok := parseRevisionParams(c)
+	if !ok {
+		return
+	}
+
+	rev, err := h.revisionService.GetRevision(noteID, userID.(uint), revision)
+	if err != nil {
+		utils.NotFound(c, err.Error())
+		return
+	}
+
+	utils.Success(c, rev)
+}
+
+// GetRevisionDiff 返回行级统一格式差异，against 指定对比版本（默认上一个版本，0 表示空文档）
+func (h *RevisionHandler) GetRevisionDiff(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+
+	noteID, revision, ok := parseRevisionParams(c)
+	if !ok {
+		return
+	}
+
+	var against *int
+	if againstStr := c.Query("against"); againstStr != "" {
+		value, err := strconv.Atoi(againstStr)
+		if err != nil || value < 0 {
+			utils.Error(c, http.StatusBadRequest, "无效的对比版本")
+			return
+		}
+		against = &value
+	}
+
+	diff, err := h.revisionService.DiffRevisions(noteID, userID.(uint), revision, against)
+	if err != nil {
+		utils.NotFound(c, err.Error())
+		return
+	}
+
+	utils.Success(c, diff)
+}
+
+func (h *RevisionHandler) RestoreRevision(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+
+	noteID, revision, ok := parseRevisionParams(c)
+	if !ok {
+		return
+	}
+
+	expectedVersion, err := parseIfMatch(c)
+	if err != nil {
+		utils.Error(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	note, err := h.noteService.RestoreRevision(noteID, userID.(uint), revision, expectedVersion)
+	if err != nil {
+		if errors.Is(err, services.ErrVersionConflict) {
+			respondVersionConflict(c, h.noteService, noteID, userID.(uint))
+			return
+		}
+		utils.Error(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	setNoteETag(c, note)
+	utils.SuccessWithMessage(c, "恢复成功", note)
+}
+
+func (h *RevisionHandler) GetPolicy(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+
+	policy, err := h.revisionService.GetPolicy(userID.(uint))
+	if err != nil {
+		utils.InternalError(c)
+		return
+	}
+
+	utils.Success(c, policy)
+}
+
+func (h *RevisionHandler) UpdatePolicy(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+
+	var req models.RevisionPolicyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.Error(c, http.StatusBadRequest, "请求参数错误")
+		return
+	}
+
+	if err := h.validator.Struct(&req); err != nil {
+		utils.ValidationError(c, err.Error())
+		return
+	}
+
+	policy, err := h.revisionService.UpdatePolicy(userID.(uint), &req)
+	if err != nil {
+		utils.InternalError(c)
+		return
+	}
+
+	utils.SuccessWithMessage(c, "更新成功", policy)
+}
+
+func parseRevisionParams(c *gin.Context) (uint, int, bool) {
+	noteID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		utils.Error(c, http.StatusBadRequest, "无效的笔记ID")
+		return 0, 0, false
+	}
+
+	revision, err := strconv.Atoi(c.Param("rev"))
+	if err != nil || revision <= 0 {
+		utils.Error(c, http.StatusBadRequest, "无效的版本号")
+		return 0, 0, false
+	}
+
+	return uint(noteID), revision, true
+}
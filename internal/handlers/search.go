@@ -0,0 +1,67 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"notes-backend/internal/models"
+	"notes-backend/internal/search"
+	"notes-backend/internal/services"
+	"notes-backend/internal/utils"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+)
+
+type SearchHandler struct {
+	searchService *services.SearchService
+	validator     *validator.Validate
+}
+
+func NewSearchHandler(searchService *services.SearchService) *SearchHandler {
+	return &SearchHandler{
+		searchService: searchService,
+		validator:     validator.New(),
+	}
+}
+
+func (h *SearchHandler) Search(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+
+	var req models.SearchRequest
+	if err := c.ShouldBindQuery(&req); err != nil {
+		utils.Error(c, http.StatusBadRequest, "请求参数错误")
+		return
+	}
+
+	// 设置默认值
+	if req.Page <= 0 {
+		req.Page = 1
+	}
+	if req.Limit <= 0 {
+		req.Limit = 20
+	}
+	if req.Limit > 100 {
+		req.Limit = 100
+	}
+
+	if err := h.validator.Struct(&req); err != nil {
+		utils.ValidationError(c, err.Error())
+		return
+	}
+
+	results, pagination, err := h.searchService.Search(userID.(uint), &req)
+	if err != nil {
+		var queryErr *search.Error
+		if errors.As(err, &queryErr) {
+			utils.ErrorWithData(c, http.StatusBadRequest, queryErr.Error(), queryErr)
+			return
+		}
+		utils.InternalError(c)
+		return
+	}
+
+	utils.Success(c, gin.H{
+		"results":    results,
+		"pagination": pagination,
+	})
+}
@@ -1,8 +1,7 @@
 package handlers
 
 import (
-	"crypto/rand"
-	"encoding/hex"
+	"errors"
 	"fmt"
 	"net/http"
 	"notes-backend/internal/config"
@@ -14,256 +13,366 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"github.com/go-playground/validator/v10"
-	"gorm.io/gorm"
 )
 
 type ShareHandler struct {
-	db          *gorm.DB
-	noteService *services.NoteService
-	validator   *validator.Validate
-	config      *config.Config
+	noteService   *services.NoteService
+	shareService  *services.ShareService
+	renderService *services.RenderService
+	validator     *validator.Validate
+	config        *config.Config
 }
 
-func NewShareHandler(db *gorm.DB, noteService *services.NoteService, cfg *config.Config) *ShareHandler {
+func NewShareHandler(noteService *services.NoteService, shareService *services.ShareService, renderService *services.RenderService, cfg *config.Config) *ShareHandler {
 	return &ShareHandler{
-		db:          db,
-		noteService: noteService,
-		validator:   validator.New(),
-		config:      cfg,
+		noteService:   noteService,
+		shareService:  shareService,
+		renderService: renderService,
+		validator:     validator.New(),
+		config:        cfg,
 	}
 }
 
-func (h *ShareHandler) CreateShareLink(c *gin.Context) {
-	userID, _ := c.Get("user_id")
-	noteIDStr := c.Param("id")
+func (h *ShareHandler) GetPublicNote(c *gin.Context) {
+	shareCode := c.Param("code")
 
-	noteID, err := strconv.ParseUint(noteIDStr, 10, 32)
-	if err != nil {
-		utils.Error(c, http.StatusBadRequest, "无效的笔记ID")
+	fmt.Printf("GetPublicNote called with code: %s\n", shareCode)
+
+	// 查找分享链接并检查是否过期
+	shareLink, ok := h.getShareLink(c, shareCode)
+	if !ok {
 		return
 	}
 
-	var req models.ShareLinkCreateRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
-		req = models.ShareLinkCreateRequest{}
+	// 设置了密码的分享需要先通过 unlock 接口获取访问令牌
+	if err := h.shareService.VerifyAccess(shareLink, shareAccessToken(c, shareCode)); err != nil {
+		utils.Error(c, http.StatusUnauthorized, err.Error())
+		return
 	}
 
-	// 检查笔记是否存在且为公开笔记
-	var note models.Note
-	if err := h.db.Where("id = ? AND user_id = ? AND is_public = ?", noteID, userID, true).First(&note).Error; err != nil {
-		if err == gorm.ErrRecordNotFound {
-			utils.Error(c, http.StatusNotFound, "笔记不存在或非公开笔记")
-		} else {
-			utils.InternalError(c)
-		}
+	// 获取笔记详情
+	viewerInfo := newViewerInfo(c)
+	viewerInfo.ShareLinkID = &shareLink.ID
+
+	note, err := h.noteService.GetPublicNoteByID(shareLink.NoteID, viewerInfo)
+	if err != nil {
+		fmt.Printf("GetPublicNoteByID error: %v\n", err)
+		utils.NotFound(c, "笔记不存在")
 		return
 	}
 
-	// 检查是否已有分享链接
-	var existingShare models.ShareLink
-	if err := h.db.Where("note_id = ? AND is_active = ?", noteID, true).First(&existingShare).Error; err == nil {
-		// 更新现有分享链接
-		updates := models.ShareLink{
-			Password:   req.Password,
-			ExpireTime: req.ExpireTime,
-		}
-
-		if err := h.db.Model(&existingShare).Updates(updates).Error; err != nil {
+	if renderHTML, _ := strconv.ParseBool(c.Query("rendered_html")); renderHTML {
+		rendered, err := h.renderService.Render(note)
+		if err != nil {
 			utils.InternalError(c)
 			return
 		}
+		note.RenderedHTML = rendered
+	}
+	// HTML 笔记的原始内容可能包含脚本，公开访问时只返回过滤后的内容
+	if note.ContentType == "html" {
+		note.Content = h.renderService.Sanitize(note.Content)
+	}
 
-		response := models.ShareLinkResponse{
-			ShareCode:  existingShare.ShareCode,
-			// 修复：使用新的路径 /shared/
-			ShareURL:   fmt.Sprintf("%s/shared/%s", h.config.Frontend.BaseURL, existingShare.ShareCode),
-			Password:   req.Password,
-			ExpireTime: req.ExpireTime,
-		}
+	fmt.Printf("Returning note: %+v\n", note)
 
-		utils.SuccessWithMessage(c, "分享链接更新成功", response)
+	utils.Success(c, note)
+}
+
+// GetNoteShares 获取笔记的全部分享链接
+func (h *ShareHandler) GetNoteShares(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+
+	noteID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		utils.Error(c, http.StatusBadRequest, "无效的笔记ID")
 		return
 	}
 
-	// 创建新的分享链接
-	shareCode, err := generateRandomString(32)
+	shares, err := h.shareService.GetNoteShares(uint(noteID), userID.(uint))
 	if err != nil {
-		utils.InternalError(c)
+		respondShareError(c, err)
 		return
 	}
 
-	shareLink := models.ShareLink{
-		NoteID:     uint(noteID),
-		ShareCode:  shareCode,
-		Password:   req.Password,
-		ExpireTime: req.ExpireTime,
-		VisitCount: 0,
-		IsActive:   true,
-	}
+	utils.Success(c, shares)
+}
+
+// GetUserShares 获取当前用户所有可访问的分享链接
+func (h *ShareHandler) GetUserShares(c *gin.Context) {
+	userID, _ := c.Get("user_id")
 
-	if err := h.db.Create(&shareLink).Error; err != nil {
+	shares, err := h.shareService.GetUserShares(userID.(uint))
+	if err != nil {
 		utils.InternalError(c)
 		return
 	}
 
-	response := models.ShareLinkResponse{
-		ShareCode:  shareCode,
-		// 修复：使用新的路径 /shared/，移除多余的 notes
-		ShareURL:   fmt.Sprintf("%s/shared/%s", h.config.Frontend.BaseURL, shareCode),
-		Password:   req.Password,
-		ExpireTime: req.ExpireTime,
+	utils.Success(c, shares)
+}
+
+func (h *ShareHandler) GetShare(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+
+	noteID, shareID, ok := parseShareIDs(c)
+	if !ok {
+		return
+	}
+
+	share, err := h.shareService.GetShare(noteID, shareID, userID.(uint))
+	if err != nil {
+		respondShareError(c, err)
+		return
 	}
 
-	utils.SuccessWithMessage(c, "分享链接创建成功", response)
+	utils.Success(c, share)
 }
 
-func (h *ShareHandler) GetShareInfo(c *gin.Context) {
+// CreateShare 新建分享链接，同一笔记可以有多个链接
+func (h *ShareHandler) CreateShare(c *gin.Context) {
 	userID, _ := c.Get("user_id")
-	noteIDStr := c.Param("id")
 
-	noteID, err := strconv.ParseUint(noteIDStr, 10, 32)
+	noteID, err := strconv.ParseUint(c.Param("id"), 10, 32)
 	if err != nil {
 		utils.Error(c, http.StatusBadRequest, "无效的笔记ID")
 		return
 	}
 
-	// 检查笔记是否存在
-	var note models.Note
-	if err := h.db.Where("id = ? AND user_id = ?", noteID, userID).First(&note).Error; err != nil {
-		if err == gorm.ErrRecordNotFound {
-			utils.NotFound(c, "笔记不存在")
-		} else {
-			utils.InternalError(c)
-		}
+	var req models.ShareCreateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.Error(c, http.StatusBadRequest, "请求参数错误")
 		return
 	}
 
-	// 获取分享链接
-	var shareLink models.ShareLink
-	if err := h.db.Where("note_id = ? AND is_active = ?", noteID, true).First(&shareLink).Error; err != nil {
-		if err == gorm.ErrRecordNotFound {
-			utils.NotFound(c, "分享链接不存在")
-		} else {
-			utils.InternalError(c)
-		}
+	if err := h.validator.Struct(&req); err != nil {
+		utils.ValidationError(c, err.Error())
 		return
 	}
 
-	response := models.ShareLinkResponse{
-		ShareCode:  shareLink.ShareCode,
-		// 修复：使用新的路径 /shared/
-		ShareURL:   fmt.Sprintf("%s/shared/%s", h.config.Frontend.BaseURL, shareLink.ShareCode),
-		Password:   shareLink.Password,
-		ExpireTime: shareLink.ExpireTime,
+	share, err := h.shareService.CreateShare(uint(noteID), userID.(uint), &req)
+	if err != nil {
+		respondShareError(c, err)
+		return
 	}
 
-	utils.Success(c, response)
+	utils.SuccessWithMessage(c, "分享链接创建成功", share)
 }
 
-func (h *ShareHandler) DeleteShareLink(c *gin.Context) {
+// UpdateShare 部分更新分享链接，未出现的字段保持不变
+func (h *ShareHandler) UpdateShare(c *gin.Context) {
 	userID, _ := c.Get("user_id")
-	noteIDStr := c.Param("id")
 
-	noteID, err := strconv.ParseUint(noteIDStr, 10, 32)
-	if err != nil {
-		utils.Error(c, http.StatusBadRequest, "无效的笔记ID")
+	noteID, shareID, ok := parseShareIDs(c)
+	if !ok {
 		return
 	}
 
-	// 检查笔记是否存在
-	var note models.Note
-	if err := h.db.Where("id = ? AND user_id = ?", noteID, userID).First(&note).Error; err != nil {
-		if err == gorm.ErrRecordNotFound {
-			utils.NotFound(c, "笔记不存在")
-		} else {
-			utils.InternalError(c)
-		}
+	var req models.ShareUpdateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.Error(c, http.StatusBadRequest, "请求参数错误")
 		return
 	}
 
-	// 删除分享链接（软删除）
-	result := h.db.Model(&models.ShareLink{}).Where("note_id = ? AND is_active = ?", noteID, true).Update("is_active", false)
-	if result.Error != nil {
-		utils.InternalError(c)
+	if err := h.validator.Struct(&req); err != nil {
+		utils.ValidationError(c, err.Error())
 		return
 	}
 
-	if result.RowsAffected == 0 {
-		utils.NotFound(c, "分享链接不存在")
+	share, err := h.shareService.UpdateShare(noteID, shareID, userID.(uint), &req)
+	if err != nil {
+		respondShareError(c, err)
+		return
+	}
+
+	utils.SuccessWithMessage(c, "分享链接更新成功", share)
+}
+
+func (h *ShareHandler) DeleteShare(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+
+	noteID, shareID, ok := parseShareIDs(c)
+	if !ok {
+		return
+	}
+
+	if err := h.shareService.DeleteShare(noteID, shareID, userID.(uint)); err != nil {
+		respondShareError(c, err)
 		return
 	}
 
 	utils.SuccessWithMessage(c, "分享链接删除成功", nil)
 }
 
-func (h *ShareHandler) GetPublicNote(c *gin.Context) {
-	shareCode := c.Param("code")
-	
-	fmt.Printf("GetPublicNote called with code: %s\n", shareCode)
-	fmt.Printf("Request path: %s\n", c.Request.URL.Path)
-	fmt.Printf("Request method: %s\n", c.Request.Method)
-
-	// 查找分享链接
-	var shareLink models.ShareLink
-	if err := h.db.Preload("Note").Preload("Note.Category").Preload("Note.Tags").
-		Where("share_code = ? AND is_active = ?", shareCode, true).First(&shareLink).Error; err != nil {
-		if err == gorm.ErrRecordNotFound {
-			fmt.Printf("Share link not found for code: %s\n", shareCode)
-			utils.NotFound(c, "分享链接不存在或已失效")
-		} else {
-			fmt.Printf("Database error: %v\n", err)
-			utils.InternalError(c)
-		}
+// CreateShareLink 旧版单链接接口，更新笔记最早的分享链接，没有时新建
+func (h *ShareHandler) CreateShareLink(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+
+	noteID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		utils.Error(c, http.StatusBadRequest, "无效的笔记ID")
 		return
 	}
 
-	fmt.Printf("Found share link: %+v\n", shareLink)
+	var req models.ShareLinkCreateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		req = models.ShareLinkCreateRequest{}
+	}
 
-	// 检查是否过期
-	if shareLink.ExpireTime != nil && time.Now().After(*shareLink.ExpireTime) {
-		fmt.Printf("Share link expired: %v\n", *shareLink.ExpireTime)
-		utils.Error(c, http.StatusGone, "分享链接已过期")
+	if err := h.validator.Struct(&req); err != nil {
+		utils.ValidationError(c, err.Error())
 		return
 	}
 
-	// 检查密码
-	password := c.Query("password")
-	if shareLink.Password != nil && *shareLink.Password != "" {
-		fmt.Printf("Password required. Provided: %v, Expected: %v\n", password, *shareLink.Password)
-		if password != *shareLink.Password {
-			utils.Error(c, http.StatusUnauthorized, "访问密码错误")
-			return
-		}
+	share, created, err := h.shareService.SaveLegacyShare(uint(noteID), userID.(uint), &req)
+	if err != nil {
+		respondShareError(c, err)
+		return
 	}
 
-	// 获取笔记详情
-	viewerInfo := &models.ViewerInfo{
-		IP:        c.ClientIP(),
-		UserAgent: c.GetHeader("User-Agent"),
-		Referer:   c.GetHeader("Referer"),
+	message := "分享链接更新成功"
+	if created {
+		message = "分享链接创建成功"
 	}
+	utils.SuccessWithMessage(c, message, legacyShareResponse(share))
+}
 
-	note, err := h.noteService.GetPublicNoteByID(shareLink.NoteID, viewerInfo)
+// GetShareInfo 旧版单链接接口，返回笔记最早的分享链接
+func (h *ShareHandler) GetShareInfo(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+
+	noteID, err := strconv.ParseUint(c.Param("id"), 10, 32)
 	if err != nil {
-		fmt.Printf("GetPublicNoteByID error: %v\n", err)
-		utils.NotFound(c, "笔记不存在")
+		utils.Error(c, http.StatusBadRequest, "无效的笔记ID")
 		return
 	}
 
-	fmt.Printf("Returning note: %+v\n", note)
+	share, err := h.shareService.GetLegacyShare(uint(noteID), userID.(uint))
+	if err != nil {
+		respondShareError(c, err)
+		return
+	}
 
-	// 异步更新访问计数
-	go func() {
-		h.db.Model(&shareLink).Update("visit_count", gorm.Expr("visit_count + 1"))
-	}()
+	utils.Success(c, legacyShareResponse(share))
+}
 
-	utils.Success(c, note)
+// DeleteShareLink 旧版单链接接口，删除笔记的全部分享链接
+func (h *ShareHandler) DeleteShareLink(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+
+	noteID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		utils.Error(c, http.StatusBadRequest, "无效的笔记ID")
+		return
+	}
+
+	if err := h.shareService.DeleteNoteShares(uint(noteID), userID.(uint)); err != nil {
+		respondShareError(c, err)
+		return
+	}
+
+	utils.SuccessWithMessage(c, "分享链接删除成功", nil)
+}
+
+func legacyShareResponse(share *models.ShareLinkInfo) models.ShareLinkResponse {
+	return models.ShareLinkResponse{
+		ShareCode:   share.ShareCode,
+		ShareURL:    share.ShareURL,
+		HasPassword: share.HasPassword,
+		ExpireTime:  share.ExpireTime,
+	}
+}
+
+func parseShareIDs(c *gin.Context) (uint, uint, bool) {
+	noteID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		utils.Error(c, http.StatusBadRequest, "无效的笔记ID")
+		return 0, 0, false
+	}
+
+	shareID, err := strconv.ParseUint(c.Param("shareId"), 10, 32)
+	if err != nil {
+		utils.Error(c, http.StatusBadRequest, "无效的分享ID")
+		return 0, 0, false
+	}
+
+	return uint(noteID), uint(shareID), true
 }
 
-func generateRandomString(length int) (string, error) {
-	bytes := make([]byte, length/2)
-	if _, err := rand.Read(bytes); err != nil {
-		return "", err
+func respondShareError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, services.ErrShareNotFound), errors.Is(err, services.ErrNoteNotShareable):
+		utils.NotFound(c, err.Error())
+	case errors.Is(err, services.ErrInvalidMaxViews):
+		utils.Error(c, http.StatusBadRequest, err.Error())
+	default:
+		fmt.Printf("Share link error: %v\n", err)
+		utils.InternalError(c)
 	}
-	return hex.EncodeToString(bytes), nil
-}
\ No newline at end of file
+}
+
+// UnlockPublicNote 校验分享密码，签发只对该分享码有效的短期访问令牌，同时写入 Cookie
+func (h *ShareHandler) UnlockPublicNote(c *gin.Context) {
+	shareCode := c.Param("code")
+
+	var req models.ShareUnlockRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.Error(c, http.StatusBadRequest, "请求参数错误")
+		return
+	}
+
+	shareLink, ok := h.getShareLink(c, shareCode)
+	if !ok {
+		return
+	}
+
+	token, expiresAt, err := h.shareService.Unlock(shareLink, req.Password, c.ClientIP())
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrShareThrottled):
+			utils.Error(c, http.StatusTooManyRequests, err.Error())
+		case errors.Is(err, services.ErrSharePassword):
+			utils.Error(c, http.StatusUnauthorized, err.Error())
+		default:
+			utils.InternalError(c)
+		}
+		return
+	}
+
+	setShareCookie(c, shareCode, token, expiresAt)
+	utils.Success(c, models.ShareUnlockResponse{Token: token, ExpiresAt: expiresAt})
+}
+
+func (h *ShareHandler) getShareLink(c *gin.Context, shareCode string) (*models.ShareLink, bool) {
+	shareLink, err := h.shareService.GetShareLink(shareCode)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrShareNotFound):
+			utils.NotFound(c, err.Error())
+		case errors.Is(err, services.ErrShareExpired), errors.Is(err, services.ErrShareViewLimit):
+			utils.Error(c, http.StatusGone, err.Error())
+		default:
+			fmt.Printf("Database error: %v\n", err)
+			utils.InternalError(c)
+		}
+		return nil, false
+	}
+	return shareLink, true
+}
+
+// shareAccessToken 从 X-Share-Token 请求头或分享码对应的 Cookie 中读取访问令牌
+func shareAccessToken(c *gin.Context, shareCode string) string {
+	if token := c.GetHeader("X-Share-Token"); token != "" {
+		return token
+	}
+	token, _ := c.Cookie(shareCookieName(shareCode))
+	return token
+}
+
+func setShareCookie(c *gin.Context, shareCode, token string, expiresAt time.Time) {
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(shareCookieName(shareCode), token, int(time.Until(expiresAt).Seconds()), "/", "", isSecureRequest(c), true)
+}
+
+func shareCookieName(shareCode string) string {
+	return "share_" + shareCode
+}
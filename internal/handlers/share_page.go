@@ -0,0 +1,231 @@
+package handlers
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"notes-backend/internal/config"
+	"notes-backend/internal/models"
+	"notes-backend/internal/services"
+	"notes-backend/internal/web"
+	"os"
+	"regexp"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+const shareExcerptLength = 160
+
+// 正文中的附件地址需要登录，分享页面改写为按分享码访问的地址
+var attachmentURLPattern = regexp.MustCompile(`(src|href)="/api/files/(\d+)(?:/download)?"`)
+
+// SharePageHandler 服务端渲染的分享页面 /shared/:code，供浏览器直接访问和社交平台抓取预览
+type SharePageHandler struct {
+	shareService  *services.ShareService
+	noteService   *services.NoteService
+	renderService *services.RenderService
+	config        *config.Config
+	templates     *template.Template
+}
+
+type sharePageData struct {
+	SiteName    string
+	Title       string
+	Description string
+	URL         string
+	Image       string
+	NoIndex     bool
+
+	Note    *models.Note
+	Content template.HTML
+	Error   string
+	Message string
+}
+
+func NewSharePageHandler(shareService *services.ShareService, noteService *services.NoteService, renderService *services.RenderService, cfg *config.Config) *SharePageHandler {
+	templates, err := web.LoadTemplates(cfg.Share.TemplateDir)
+	if err != nil {
+		fmt.Printf("Failed to load share templates from %s, using built-in templates: %v\n", cfg.Share.TemplateDir, err)
+		templates = template.Must(web.LoadTemplates(""))
+	}
+
+	return &SharePageHandler{
+		shareService:  shareService,
+		noteService:   noteService,
+		renderService: renderService,
+		config:        cfg,
+		templates:     templates,
+	}
+}
+
+// ShowSharedNote 渲染分享页面，设置了密码时显示密码表单
+func (h *SharePageHandler) ShowSharedNote(c *gin.Context) {
+	shareLink, ok := h.getShareLink(c)
+	if !ok {
+		return
+	}
+
+	if err := h.shareService.VerifyAccess(shareLink, shareAccessToken(c, shareLink.ShareCode)); err != nil {
+		h.renderPassword(c, http.StatusOK, "")
+		return
+	}
+
+	h.renderNote(c, shareLink)
+}
+
+// UnlockSharedNote 处理密码表单提交，验证通过后写入访问令牌 Cookie 并跳转回分享页面
+func (h *SharePageHandler) UnlockSharedNote(c *gin.Context) {
+	shareLink, ok := h.getShareLink(c)
+	if !ok {
+		return
+	}
+
+	token, expiresAt, err := h.shareService.Unlock(shareLink, c.PostForm("password"), c.ClientIP())
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrShareThrottled):
+			h.renderPassword(c, http.StatusTooManyRequests, err.Error())
+		case errors.Is(err, services.ErrSharePassword):
+			h.renderPassword(c, http.StatusUnauthorized, err.Error())
+		default:
+			h.renderError(c, http.StatusInternalServerError, "服务器内部错误", "请稍后再试。")
+		}
+		return
+	}
+
+	setShareCookie(c, shareLink.ShareCode, token, expiresAt)
+	c.Redirect(http.StatusSeeOther, "/shared/"+shareLink.ShareCode)
+}
+
+// ServeSharedFile 提供分享笔记中的附件，设置了密码的分享需要有效的访问令牌
+func (h *SharePageHandler) ServeSharedFile(c *gin.Context) {
+	shareLink, err := h.shareService.GetShareLink(c.Param("code"))
+	if err != nil || h.shareService.VerifyAccess(shareLink, shareAccessToken(c, shareLink.ShareCode)) != nil {
+		c.Status(http.StatusNotFound)
+		return
+	}
+
+	attachmentID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.Status(http.StatusNotFound)
+		return
+	}
+
+	attachment, err := h.shareService.GetSharedAttachment(shareLink, uint(attachmentID))
+	if err != nil {
+		c.Status(http.StatusNotFound)
+		return
+	}
+
+	if _, err := os.Stat(attachment.FilePath); os.IsNotExist(err) {
+		c.Status(http.StatusNotFound)
+		return
+	}
+
+	serveStoredFile(c, attachment.FilePath, attachment.OriginalFilename, false)
+}
+
+// getShareLink 查找分享链接，失败时已输出 404/410 页面
+func (h *SharePageHandler) getShareLink(c *gin.Context) (*models.ShareLink, bool) {
+	shareLink, err := h.shareService.GetShareLink(c.Param("code"))
+	if err == nil {
+		return shareLink, true
+	}
+
+	switch {
+	case errors.Is(err, services.ErrShareNotFound):
+		h.renderError(c, http.StatusNotFound, "分享不存在", "该分享链接不存在或已被取消。")
+	case errors.Is(err, services.ErrShareExpired):
+		h.renderError(c, http.StatusGone, "分享已过期", "该分享链接已过期，请联系分享者重新分享。")
+	case errors.Is(err, services.ErrShareViewLimit):
+		h.renderError(c, http.StatusGone, "分享已失效", "该分享链接的访问次数已用完，请联系分享者重新分享。")
+	default:
+		fmt.Printf("Failed to load share link: %v\n", err)
+		h.renderError(c, http.StatusInternalServerError, "服务器内部错误", "请稍后再试。")
+	}
+	return nil, false
+}
+
+func (h *SharePageHandler) renderNote(c *gin.Context, shareLink *models.ShareLink) {
+	viewerInfo := newViewerInfo(c)
+	viewerInfo.ShareLinkID = &shareLink.ID
+
+	note, err := h.noteService.GetPublicNoteByID(shareLink.NoteID, viewerInfo)
+	if err != nil {
+		h.renderError(c, http.StatusNotFound, "分享不存在", "该笔记已被删除或不再公开。")
+		return
+	}
+
+	rendered, err := h.renderService.Render(note)
+	if err != nil {
+		h.renderError(c, http.StatusInternalServerError, "服务器内部错误", "笔记渲染失败，请稍后再试。")
+		return
+	}
+	rendered = attachmentURLPattern.ReplaceAllString(rendered, fmt.Sprintf(`$1="/shared/%s/files/$2"`, shareLink.ShareCode))
+
+	data := h.pageData(note.Title)
+	data.URL = h.shareService.ShareURL(shareLink.ShareCode)
+	data.Description = h.renderService.Excerpt(rendered, shareExcerptLength)
+	data.Note = note
+	// 内容已经过白名单过滤
+	data.Content = template.HTML(rendered)
+	if image := firstImage(note.Attachments); image != nil {
+		data.Image = fmt.Sprintf("%s/files/%d", data.URL, image.ID)
+	}
+	if shareLink.ExpireTime != nil || h.shareService.RequiresPassword(shareLink) {
+		data.NoIndex = true
+	}
+
+	h.render(c, http.StatusOK, "note.html", data)
+}
+
+// renderPassword 密码页面不展示笔记的任何内容
+func (h *SharePageHandler) renderPassword(c *gin.Context, status int, message string) {
+	data := h.pageData("受密码保护的笔记")
+	data.URL = h.shareService.ShareURL(c.Param("code"))
+	data.Description = "此分享需要访问密码"
+	data.NoIndex = true
+	data.Error = message
+
+	c.Header("Cache-Control", "no-store")
+	h.render(c, status, "password.html", data)
+}
+
+func (h *SharePageHandler) renderError(c *gin.Context, status int, title, message string) {
+	data := h.pageData(title)
+	data.NoIndex = true
+	data.Message = message
+
+	h.render(c, status, "error.html", data)
+}
+
+func (h *SharePageHandler) render(c *gin.Context, status int, name string, data *sharePageData) {
+	var buf bytes.Buffer
+	if err := h.templates.ExecuteTemplate(&buf, name, data); err != nil {
+		fmt.Printf("Failed to render share page %s: %v\n", name, err)
+		c.String(http.StatusInternalServerError, "服务器内部错误")
+		return
+	}
+	c.Data(status, "text/html; charset=utf-8", buf.Bytes())
+}
+
+func (h *SharePageHandler) pageData(title string) *sharePageData {
+	return &sharePageData{
+		SiteName: h.config.Share.SiteName,
+		Title:    title,
+	}
+}
+
+// firstImage 按上传顺序返回第一张图片附件
+func firstImage(attachments []models.Attachment) *models.Attachment {
+	var first *models.Attachment
+	for i := range attachments {
+		if attachments[i].IsImage && (first == nil || attachments[i].ID < first.ID) {
+			first = &attachments[i]
+		}
+	}
+	return first
+}
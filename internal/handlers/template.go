@@ -0,0 +1,173 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"notes-backend/internal/models"
+	"notes-backend/internal/services"
+	"notes-backend/internal/utils"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"gorm.io/gorm"
+)
+
+type TemplateHandler struct {
+	templateService *services.TemplateService
+	validator       *validator.Validate
+}
+
+func NewTemplateHandler(templateService *services.TemplateService) *TemplateHandler {
+	return &TemplateHandler{
+		templateService: templateService,
+		validator:       validator.New(),
+	}
+}
+
+func (h *TemplateHandler) GetTemplates(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+
+	templates, err := h.templateService.GetTemplates(userID.(uint))
+	if err != nil {
+		utils.InternalError(c)
+		return
+	}
+
+	utils.Success(c, templates)
+}
+
+func (h *TemplateHandler) GetTemplate(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+
+	templateID, ok := parseTemplateID(c)
+	if !ok {
+		return
+	}
+
+	template, err := h.templateService.GetTemplate(templateID, userID.(uint))
+	if err != nil {
+		utils.NotFound(c, "模板不存在")
+		return
+	}
+
+	utils.Success(c, template)
+}
+
+func (h *TemplateHandler) CreateTemplate(c *gin.Context) {
+	user, _ := c.Get("user")
+
+	var req models.NoteTemplateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.Error(c, http.StatusBadRequest, "请求参数错误")
+		return
+	}
+
+	if err := h.validator.Struct(&req); err != nil {
+		utils.ValidationError(c, err.Error())
+		return
+	}
+
+	template, err := h.templateService.CreateTemplate(user.(*models.User), &req)
+	if err != nil {
+		respondTemplateError(c, err)
+		return
+	}
+
+	utils.SuccessWithMessage(c, "创建成功", template)
+}
+
+func (h *TemplateHandler) UpdateTemplate(c *gin.Context) {
+	user, _ := c.Get("user")
+
+	templateID, ok := parseTemplateID(c)
+	if !ok {
+		return
+	}
+
+	var req models.NoteTemplateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.Error(c, http.StatusBadRequest, "请求参数错误")
+		return
+	}
+
+	if err := h.validator.Struct(&req); err != nil {
+		utils.ValidationError(c, err.Error())
+		return
+	}
+
+	template, err := h.templateService.UpdateTemplate(templateID, user.(*models.User), &req)
+	if err != nil {
+		respondTemplateError(c, err)
+		return
+	}
+
+	utils.SuccessWithMessage(c, "更新成功", template)
+}
+
+func (h *TemplateHandler) DeleteTemplate(c *gin.Context) {
+	user, _ := c.Get("user")
+
+	templateID, ok := parseTemplateID(c)
+	if !ok {
+		return
+	}
+
+	if err := h.templateService.DeleteTemplate(templateID, user.(*models.User)); err != nil {
+		respondTemplateError(c, err)
+		return
+	}
+
+	utils.SuccessWithMessage(c, "删除成功", nil)
+}
+
+// CreateNoteFromTemplate 根据模板创建笔记，values 提供模板自定义字段的取值
+func (h *TemplateHandler) CreateNoteFromTemplate(c *gin.Context) {
+	user, _ := c.Get("user")
+
+	templateID, ok := parseTemplateID(c)
+	if !ok {
+		return
+	}
+
+	var req models.NoteFromTemplateRequest
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			utils.Error(c, http.StatusBadRequest, "请求参数错误")
+			return
+		}
+	}
+
+	if err := h.validator.Struct(&req); err != nil {
+		utils.ValidationError(c, err.Error())
+		return
+	}
+
+	note, err := h.templateService.CreateNoteFromTemplate(templateID, user.(*models.User), &req)
+	if err != nil {
+		respondTemplateError(c, err)
+		return
+	}
+
+	utils.SuccessWithMessage(c, "创建成功", note)
+}
+
+func parseTemplateID(c *gin.Context) (uint, bool) {
+	templateID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		utils.Error(c, http.StatusBadRequest, "无效的模板ID")
+		return 0, false
+	}
+	return uint(templateID), true
+}
+
+func respondTemplateError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		utils.NotFound(c, "模板不存在")
+	case errors.Is(err, services.ErrTemplateForbidden):
+		utils.Forbidden(c, err.Error())
+	default:
+		utils.Error(c, http.StatusBadRequest, err.Error())
+	}
+}
@@ -0,0 +1,85 @@
+package handlers
+
+import (
+	"net/http"
+	"notes-backend/internal/models"
+	"notes-backend/internal/services"
+	"notes-backend/internal/utils"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+type TrashHandler struct {
+	trashService *services.TrashService
+}
+
+func NewTrashHandler(trashService *services.TrashService) *TrashHandler {
+	return &TrashHandler{trashService: trashService}
+}
+
+func (h *TrashHandler) GetTrash(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+
+	var req models.TrashListRequest
+	if err := c.ShouldBindQuery(&req); err != nil {
+		utils.Error(c, http.StatusBadRequest, "请求参数错误")
+		return
+	}
+
+	if req.Page <= 0 {
+		req.Page = 1
+	}
+	if req.Limit <= 0 {
+		req.Limit = 20
+	}
+	if req.Limit > 100 {
+		req.Limit = 100
+	}
+
+	notes, pagination, err := h.trashService.GetTrash(userID.(uint), &req)
+	if err != nil {
+		utils.InternalError(c)
+		return
+	}
+
+	utils.Success(c, gin.H{
+		"notes":      notes,
+		"pagination": pagination,
+	})
+}
+
+func (h *TrashHandler) RestoreNote(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+
+	noteID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		utils.Error(c, http.StatusBadRequest, "无效的笔记ID")
+		return
+	}
+
+	note, err := h.trashService.RestoreNote(uint(noteID), userID.(uint))
+	if err != nil {
+		utils.NotFound(c, err.Error())
+		return
+	}
+
+	utils.SuccessWithMessage(c, "笔记已恢复", note)
+}
+
+func (h *TrashHandler) PermanentlyDeleteNote(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+
+	noteID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		utils.Error(c, http.StatusBadRequest, "无效的笔记ID")
+		return
+	}
+
+	if err := h.trashService.PermanentlyDeleteNote(uint(noteID), userID.(uint)); err != nil {
+		utils.Error(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	utils.SuccessWithMessage(c, "笔记已彻底删除", nil)
+}
@@ -0,0 +1,193 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"notes-backend/internal/models"
+	"notes-backend/internal/services"
+	"notes-backend/internal/utils"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+)
+
+type TwoFactorHandler struct {
+	twoFactorService *services.TwoFactorService
+	sessionService   *services.SessionService
+	validator        *validator.Validate
+}
+
+func NewTwoFactorHandler(twoFactorService *services.TwoFactorService, sessionService *services.SessionService) *TwoFactorHandler {
+	return &TwoFactorHandler{
+		twoFactorService: twoFactorService,
+		sessionService:   sessionService,
+		validator:        validator.New(),
+	}
+}
+
+// GetStatus 当前用户的两步验证状态
+func (h *TwoFactorHandler) GetStatus(c *gin.Context) {
+	user, _ := c.Get("user")
+
+	status, err := h.twoFactorService.Status(user.(*models.User))
+	if err != nil {
+		fmt.Printf("GetTwoFactorStatus error: %v\n", err)
+		utils.InternalError(c)
+		return
+	}
+
+	utils.Success(c, status)
+}
+
+// Setup 生成身份验证器密钥和二维码，调用 Enable 确认后生效
+func (h *TwoFactorHandler) Setup(c *gin.Context) {
+	user, _ := c.Get("user")
+
+	setup, err := h.twoFactorService.Setup(user.(*models.User))
+	if err != nil {
+		if errors.Is(err, services.ErrTwoFactorAlreadyEnabled) {
+			utils.Error(c, http.StatusConflict, err.Error())
+			return
+		}
+		fmt.Printf("SetupTwoFactor error: %v\n", err)
+		utils.InternalError(c)
+		return
+	}
+
+	utils.Success(c, setup)
+}
+
+// Enable 使用验证码确认并开启两步验证，返回的恢复码只显示一次
+func (h *TwoFactorHandler) Enable(c *gin.Context) {
+	user, _ := c.Get("user")
+
+	var req models.TwoFactorCodeRequest
+	if !h.bind(c, &req) {
+		return
+	}
+
+	codes, err := h.twoFactorService.Enable(user.(*models.User), req.Code)
+	if err != nil {
+		h.handleError(c, "EnableTwoFactor", err)
+		return
+	}
+
+	utils.SuccessWithMessage(c, "两步验证已开启，请妥善保存恢复码", models.RecoveryCodesResponse{RecoveryCodes: codes})
+}
+
+// Disable 关闭两步验证，需要密码和验证码
+func (h *TwoFactorHandler) Disable(c *gin.Context) {
+	user, _ := c.Get("user")
+
+	var req models.TwoFactorDisableRequest
+	if !h.bind(c, &req) {
+		return
+	}
+
+	if err := h.twoFactorService.Disable(user.(*models.User), req.Password, req.Code); err != nil {
+		h.handleError(c, "DisableTwoFactor", err)
+		return
+	}
+
+	utils.SuccessWithMessage(c, "两步验证已关闭", nil)
+}
+
+// RegenerateRecoveryCodes 重新生成恢复码，旧的恢复码失效
+func (h *TwoFactorHandler) RegenerateRecoveryCodes(c *gin.Context) {
+	user, _ := c.Get("user")
+
+	var req models.TwoFactorCodeRequest
+	if !h.bind(c, &req) {
+		return
+	}
+
+	codes, err := h.twoFactorService.RegenerateRecoveryCodes(user.(*models.User), req.Code)
+	if err != nil {
+		h.handleError(c, "RegenerateRecoveryCodes", err)
+		return
+	}
+
+	utils.SuccessWithMessage(c, "恢复码已重新生成", models.RecoveryCodesResponse{RecoveryCodes: codes})
+}
+
+// Verify 登录第二步：使用挑战令牌和验证码（或恢复码）换取访问令牌和刷新令牌
+func (h *TwoFactorHandler) Verify(c *gin.Context) {
+	var req models.TwoFactorVerifyRequest
+	if !h.bind(c, &req) {
+		return
+	}
+
+	user, err := h.twoFactorService.VerifyChallenge(req.ChallengeToken, req.Code)
+	if err != nil {
+		if errors.Is(err, services.ErrTwoFactorChallenge) {
+			utils.Unauthorized(c, err.Error())
+			return
+		}
+		h.handleError(c, "VerifyTwoFactor", err)
+		return
+	}
+
+	tokens, err := h.sessionService.CreateSession(user, newClientInfo(c))
+	if err != nil {
+		utils.InternalError(c)
+		return
+	}
+
+	utils.SuccessWithMessage(c, "登录成功", models.UserResponse{
+		User:      user,
+		TokenPair: *tokens,
+	})
+}
+
+// GetPolicy 管理员：是否要求所有用户开启两步验证
+func (h *TwoFactorHandler) GetPolicy(c *gin.Context) {
+	utils.Success(c, gin.H{"required": h.twoFactorService.IsRequired()})
+}
+
+// UpdatePolicy 管理员：设置是否要求所有用户开启两步验证
+func (h *TwoFactorHandler) UpdatePolicy(c *gin.Context) {
+	var req models.TwoFactorPolicyRequest
+	if !h.bind(c, &req) {
+		return
+	}
+
+	if err := h.twoFactorService.SetRequired(*req.Required); err != nil {
+		fmt.Printf("UpdateTwoFactorPolicy error: %v\n", err)
+		utils.InternalError(c)
+		return
+	}
+
+	utils.SuccessWithMessage(c, "两步验证策略已更新", gin.H{"required": *req.Required})
+}
+
+func (h *TwoFactorHandler) bind(c *gin.Context, req interface{}) bool {
+	if err := c.ShouldBindJSON(req); err != nil {
+		utils.Error(c, http.StatusBadRequest, "请求参数错误")
+		return false
+	}
+	if err := h.validator.Struct(req); err != nil {
+		utils.ValidationError(c, err.Error())
+		return false
+	}
+	return true
+}
+
+func (h *TwoFactorHandler) handleError(c *gin.Context, action string, err error) {
+	switch {
+	case errors.Is(err, services.ErrTwoFactorThrottled):
+		utils.Error(c, http.StatusTooManyRequests, err.Error())
+	case errors.Is(err, services.ErrTwoFactorAlreadyEnabled):
+		utils.Error(c, http.StatusConflict, err.Error())
+	case errors.Is(err, services.ErrTwoFactorRequired):
+		utils.Forbidden(c, err.Error())
+	case errors.Is(err, services.ErrTwoFactorCode),
+		errors.Is(err, services.ErrTwoFactorPassword),
+		errors.Is(err, services.ErrTwoFactorNotEnabled),
+		errors.Is(err, services.ErrTwoFactorNotSetup):
+		utils.Error(c, http.StatusBadRequest, err.Error())
+	default:
+		fmt.Printf("%s error: %v\n", action, err)
+		utils.InternalError(c)
+	}
+}
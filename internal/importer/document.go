@@ -0,0 +1,97 @@
+// Package importer 将外部笔记格式（Markdown 仓库、Evernote ENEX）解析为统一的待导入文档，
+// 不涉及数据库，由 ImportService 负责创建分类、标签、笔记和附件。
+package importer
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Document 待导入的笔记。正文中引用的本地文件以 File.Ref 占位，
+// 附件保存后由调用方替换为实际地址
+type Document struct {
+	Path        string   // 源文件路径，用于报告
+	Folder      []string // 分类路径，从根分类开始
+	Title       string
+	Content     string
+	ContentType string // 为空时按 markdown 处理
+	Tags        []string
+	CreatedAt   *time.Time
+	UpdatedAt   *time.Time
+	IsPublic    bool
+	Files       []File
+}
+
+// File 笔记引用的附件
+type File struct {
+	Ref      string
+	Name     string
+	Size     int64
+	MimeType string
+	Open     func() (io.ReadCloser, error)
+}
+
+// Issue 解析过程中无法处理的内容，不影响其它文档
+type Issue struct {
+	Path    string `json:"path"`
+	Message string `json:"message"`
+}
+
+// FileRef 生成正文中的附件占位符
+func FileRef(index int) string {
+	return fmt.Sprintf("import-file://%d", index)
+}
+
+// ReplaceFileRefs 将正文中的附件占位符替换为 urls 中对应的地址，缺失的占位符保持原样。
+// import-file://1 是 import-file://10 的前缀，较长的占位符需要先参与匹配
+func ReplaceFileRefs(content string, urls map[string]string) string {
+	if len(urls) == 0 {
+		return content
+	}
+	refs := make([]string, 0, len(urls))
+	for ref := range urls {
+		refs = append(refs, ref)
+	}
+	sort.Slice(refs, func(i, j int) bool {
+		if len(refs[i]) != len(refs[j]) {
+			return len(refs[i]) > len(refs[j])
+		}
+		return refs[i] < refs[j]
+	})
+
+	pairs := make([]string, 0, len(refs)*2)
+	for _, ref := range refs {
+		pairs = append(pairs, ref, urls[ref])
+	}
+	return strings.NewReplacer(pairs...).Replace(content)
+}
+
+var dateLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02",
+	"20060102T150405Z",
+}
+
+// ParseTime 解析常见的日期时间格式，未带时区的按服务器本地时区处理
+func ParseTime(value string) (*time.Time, bool) {
+	value = strings.TrimSpace(value)
+	for _, layout := range dateLayouts {
+		var t time.Time
+		var err error
+		if strings.HasSuffix(layout, "Z") || layout == time.RFC3339 {
+			t, err = time.Parse(layout, value)
+		} else {
+			t, err = time.ParseInLocation(layout, value, time.Local)
+		}
+		if err == nil {
+			return &t, true
+		}
+	}
+	return nil, false
+}
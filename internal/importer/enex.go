@@ -0,0 +1,204 @@
+package importer
+
+import (
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"mime"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ENEXOptions Evernote 导入选项
+type ENEXOptions struct {
+	Notebook    string // 笔记本名称，作为分类；ENEX 文件本身不包含笔记本信息
+	ContentType string // markdown 将 ENML 转换为 Markdown，html 保留为 HTML
+	TempDir     string // 解码后的附件临时存放目录
+}
+
+type enexNote struct {
+	Title     string         `xml:"title"`
+	Content   string         `xml:"content"`
+	Created   string         `xml:"created"`
+	Updated   string         `xml:"updated"`
+	Tags      []string       `xml:"tag"`
+	Resources []enexResource `xml:"resource"`
+}
+
+type enexResource struct {
+	Data struct {
+		Encoding string `xml:"encoding,attr"`
+		Value    string `xml:",chardata"`
+	} `xml:"data"`
+	Mime       string `xml:"mime"`
+	Attributes struct {
+		FileName string `xml:"file-name"`
+	} `xml:"resource-attributes"`
+}
+
+// 常见 MIME 类型对应的扩展名，mime.ExtensionsByType 的返回顺序不固定
+var mimeExtensions = map[string]string{
+	"image/png":       ".png",
+	"image/jpeg":      ".jpg",
+	"image/jpg":       ".jpg",
+	"image/gif":       ".gif",
+	"image/webp":      ".webp",
+	"application/pdf": ".pdf",
+	"text/plain":      ".txt",
+}
+
+// ParseENEX 逐条解析 Evernote 导出的 ENEX 文件。资源按 base64 解码到临时目录，
+// 正文中的 <en-media hash="..."> 通过 MD5 对应到资源。单条笔记解析失败只记录问题，不影响其它笔记
+func ParseENEX(r io.Reader, opts ENEXOptions) ([]Document, []Issue, error) {
+	decoder := xml.NewDecoder(r)
+	decoder.Strict = false
+
+	var documents []Document
+	var issues []Issue
+	index := 0
+
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			if len(documents) == 0 && len(issues) == 0 {
+				return nil, nil, fmt.Errorf("ENEX 文件格式错误: %v", err)
+			}
+			issues = append(issues, Issue{Path: fmt.Sprintf("note #%d", index+1), Message: fmt.Sprintf("文件在此处损坏，之后的笔记未导入: %v", err)})
+			break
+		}
+
+		start, ok := token.(xml.StartElement)
+		if !ok || start.Name.Local != "note" {
+			continue
+		}
+		index++
+
+		var note enexNote
+		if err := decoder.DecodeElement(&note, &start); err != nil {
+			issues = append(issues, Issue{Path: fmt.Sprintf("note #%d", index), Message: fmt.Sprintf("笔记格式错误: %v", err)})
+			continue
+		}
+
+		doc, err := convertENEXNote(&note, index, opts)
+		if err != nil {
+			title := strings.TrimSpace(note.Title)
+			if title == "" {
+				title = fmt.Sprintf("note #%d", index)
+			}
+			issues = append(issues, Issue{Path: title, Message: err.Error()})
+			continue
+		}
+		documents = append(documents, *doc)
+	}
+
+	return documents, issues, nil
+}
+
+func convertENEXNote(note *enexNote, index int, opts ENEXOptions) (*Document, error) {
+	title := strings.TrimSpace(note.Title)
+	if title == "" {
+		title = fmt.Sprintf("Evernote 笔记 %d", index)
+	}
+
+	doc := &Document{
+		Path:        title,
+		Title:       title,
+		Tags:        note.Tags,
+		ContentType: opts.ContentType,
+	}
+	if opts.Notebook != "" {
+		doc.Folder = []string{opts.Notebook}
+	}
+	if t, ok := ParseTime(note.Created); ok {
+		doc.CreatedAt = t
+	}
+	if t, ok := ParseTime(note.Updated); ok {
+		doc.UpdatedAt = t
+	}
+
+	media := make(map[string]File)
+	for i := range note.Resources {
+		file, hash, err := saveENEXResource(&note.Resources[i], fmt.Sprintf("%d-%d", index, i), opts.TempDir)
+		if err != nil {
+			return nil, fmt.Errorf("附件解码失败: %v", err)
+		}
+		file.Ref = FileRef(len(doc.Files))
+		doc.Files = append(doc.Files, *file)
+		media[hash] = *file
+	}
+
+	content, err := ConvertENML(note.Content, media, opts.ContentType == "html")
+	if err != nil {
+		return nil, fmt.Errorf("正文转换失败: %v", err)
+	}
+	doc.Content = content
+
+	return doc, nil
+}
+
+// saveENEXResource 将 base64 编码的资源写入临时文件，返回附件信息和内容的 MD5
+func saveENEXResource(resource *enexResource, name, dir string) (*File, string, error) {
+	if resource.Data.Encoding != "" && resource.Data.Encoding != "base64" {
+		return nil, "", fmt.Errorf("不支持的编码 %s", resource.Data.Encoding)
+	}
+
+	tmpPath := filepath.Join(dir, name)
+	out, err := os.Create(tmpPath)
+	if err != nil {
+		return nil, "", err
+	}
+	defer out.Close()
+
+	hash := md5.New()
+	decoder := base64.NewDecoder(base64.StdEncoding, strings.NewReader(stripWhitespace(resource.Data.Value)))
+	size, err := io.Copy(io.MultiWriter(out, hash), decoder)
+	if err != nil {
+		return nil, "", err
+	}
+
+	mimeType := strings.TrimSpace(resource.Mime)
+	fileName := strings.TrimSpace(resource.Attributes.FileName)
+	if fileName == "" {
+		fileName = "attachment-" + name
+	}
+	if filepath.Ext(fileName) == "" {
+		fileName += extensionForMime(mimeType)
+	}
+
+	file := &File{
+		Name:     filepath.Base(fileName),
+		Size:     size,
+		MimeType: mimeType,
+		Open: func() (io.ReadCloser, error) {
+			return os.Open(tmpPath)
+		},
+	}
+	return file, hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+func extensionForMime(mimeType string) string {
+	if ext, ok := mimeExtensions[strings.ToLower(mimeType)]; ok {
+		return ext
+	}
+	if exts, err := mime.ExtensionsByType(mimeType); err == nil && len(exts) > 0 {
+		return exts[0]
+	}
+	return ""
+}
+
+func stripWhitespace(value string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case ' ', '\n', '\r', '\t':
+			return -1
+		}
+		return r
+	}, value)
+}
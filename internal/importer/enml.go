@@ -0,0 +1,370 @@
+package importer
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+var (
+	whitespacePattern = regexp.MustCompile(`[ \t\r\n]+`)
+	blankLinesPattern = regexp.MustCompile(`\n{3,}`)
+	markdownEscaper   = strings.NewReplacer(`\`, `\\`, "*", `\*`, "_", `\_`, "`", "\\`", "[", `\[`, "]", `\]`)
+)
+
+// ConvertENML 将 Evernote 的 ENML 正文转换为 Markdown（asHTML 为 true 时转换为普通 HTML）。
+// <en-media> 按 hash 替换为对应附件的占位地址，<en-todo> 转换为任务列表
+func ConvertENML(enml string, media map[string]File, asHTML bool) (string, error) {
+	doc, err := html.Parse(strings.NewReader(enml))
+	if err != nil {
+		return "", err
+	}
+
+	root := findElement(doc, "en-note")
+	if root == nil {
+		root = findElement(doc, "body")
+	}
+	if root == nil {
+		return "", fmt.Errorf("缺少 en-note 元素")
+	}
+
+	if asHTML {
+		return renderENMLAsHTML(root, media)
+	}
+
+	converter := &enmlConverter{media: media}
+	markdown := converter.children(root)
+
+	lines := strings.Split(markdown, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(line, " \t")
+	}
+	markdown = blankLinesPattern.ReplaceAllString(strings.Join(lines, "\n"), "\n\n")
+	return strings.TrimSpace(markdown) + "\n", nil
+}
+
+type enmlConverter struct {
+	media     map[string]File
+	listDepth int
+}
+
+func (c *enmlConverter) children(n *html.Node) string {
+	var sb strings.Builder
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		sb.WriteString(c.node(child))
+	}
+	return sb.String()
+}
+
+func (c *enmlConverter) node(n *html.Node) string {
+	switch n.Type {
+	case html.TextNode:
+		// 块级元素之间的换行缩进不属于正文
+		if strings.TrimSpace(n.Data) == "" && (isBlock(n.PrevSibling) || isBlock(n.NextSibling)) {
+			return ""
+		}
+		return markdownEscaper.Replace(whitespacePattern.ReplaceAllString(n.Data, " "))
+	case html.ElementNode:
+	default:
+		return ""
+	}
+
+	switch n.Data {
+	case "script", "style", "head", "title":
+		return ""
+	case "br":
+		return "\n"
+	case "div", "p", "section", "article", "center":
+		return "\n\n" + c.children(n) + "\n\n"
+	case "h1", "h2", "h3", "h4", "h5", "h6":
+		level, _ := strconv.Atoi(n.Data[1:])
+		text := strings.TrimSpace(singleLine(c.children(n)))
+		if text == "" {
+			return ""
+		}
+		return "\n\n" + strings.Repeat("#", level) + " " + text + "\n\n"
+	case "b", "strong":
+		return wrapInline(c.children(n), "**")
+	case "i", "em":
+		return wrapInline(c.children(n), "*")
+	case "s", "strike", "del":
+		return wrapInline(c.children(n), "~~")
+	case "code":
+		return "`" + textContent(n) + "`"
+	case "pre":
+		return "\n\n```\n" + strings.TrimRight(textContent(n), "\n") + "\n```\n\n"
+	case "hr":
+		return "\n\n---\n\n"
+	case "a":
+		text := strings.TrimSpace(c.children(n))
+		href := attr(n, "href")
+		if href == "" {
+			return text
+		}
+		if text == "" {
+			text = href
+		}
+		return "[" + text + "](" + href + ")"
+	case "img":
+		return "![" + attr(n, "alt") + "](" + attr(n, "src") + ")"
+	case "en-media":
+		// 自闭合写法被解析为容器，其后的内容可能成为子节点
+		return c.mediaLink(n) + c.children(n)
+	case "en-todo":
+		mark := "[ ] "
+		if strings.EqualFold(attr(n, "checked"), "true") {
+			mark = "[x] "
+		}
+		if c.listDepth == 0 {
+			mark = "- " + mark
+		}
+		return mark + c.children(n)
+	case "en-crypt":
+		return "\n\n`[加密内容未导入]`\n\n"
+	case "ul", "ol":
+		return "\n\n" + c.list(n) + "\n\n"
+	case "blockquote":
+		inner := strings.TrimSpace(c.children(n))
+		lines := strings.Split(blankLinesPattern.ReplaceAllString(inner, "\n\n"), "\n")
+		for i, line := range lines {
+			lines[i] = strings.TrimRight("> "+line, " ")
+		}
+		return "\n\n" + strings.Join(lines, "\n") + "\n\n"
+	case "table":
+		return "\n\n" + c.table(n) + "\n\n"
+	default:
+		return c.children(n)
+	}
+}
+
+func (c *enmlConverter) mediaLink(n *html.Node) string {
+	file, ok := c.media[strings.ToLower(attr(n, "hash"))]
+	if !ok {
+		return ""
+	}
+	if strings.HasPrefix(file.MimeType, "image/") {
+		return "![" + file.Name + "](" + file.Ref + ")"
+	}
+	return "[" + file.Name + "](" + file.Ref + ")"
+}
+
+func (c *enmlConverter) list(n *html.Node) string {
+	ordered := n.Data == "ol"
+
+	c.listDepth++
+	defer func() { c.listDepth-- }()
+
+	var items []string
+	number := 1
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		if child.Type != html.ElementNode || child.DataAtom != atom.Li {
+			continue
+		}
+
+		marker := "- "
+		if ordered {
+			marker = strconv.Itoa(number) + ". "
+			number++
+		}
+
+		// 子内容（包括嵌套列表）按列表标记的宽度缩进
+		content := strings.TrimSpace(blankLinesPattern.ReplaceAllString(c.children(child), "\n\n"))
+		content = strings.ReplaceAll(strings.ReplaceAll(content, "\n\n", "\n"), "\n", "\n"+strings.Repeat(" ", len(marker)))
+		items = append(items, marker+content)
+	}
+	return strings.Join(items, "\n")
+}
+
+func (c *enmlConverter) table(n *html.Node) string {
+	var rows [][]string
+	var walk func(*html.Node)
+	walk = func(node *html.Node) {
+		for child := node.FirstChild; child != nil; child = child.NextSibling {
+			if child.Type != html.ElementNode {
+				continue
+			}
+			if child.DataAtom == atom.Tr {
+				var cells []string
+				for cell := child.FirstChild; cell != nil; cell = cell.NextSibling {
+					if cell.Type == html.ElementNode && (cell.DataAtom == atom.Td || cell.DataAtom == atom.Th) {
+						text := strings.TrimSpace(singleLine(c.children(cell)))
+						cells = append(cells, strings.ReplaceAll(text, "|", `\|`))
+					}
+				}
+				rows = append(rows, cells)
+				continue
+			}
+			walk(child)
+		}
+	}
+	walk(n)
+
+	if len(rows) == 0 {
+		return ""
+	}
+
+	columns := 0
+	for _, row := range rows {
+		if len(row) > columns {
+			columns = len(row)
+		}
+	}
+
+	var sb strings.Builder
+	for i, row := range rows {
+		for len(row) < columns {
+			row = append(row, "")
+		}
+		sb.WriteString("| " + strings.Join(row, " | ") + " |\n")
+		if i == 0 {
+			sb.WriteString("|" + strings.Repeat(" --- |", columns) + "\n")
+		}
+	}
+	return sb.String()
+}
+
+// renderENMLAsHTML 保留 HTML 结构，只替换 Evernote 专有元素
+func renderENMLAsHTML(root *html.Node, media map[string]File) (string, error) {
+	var transform func(*html.Node)
+	transform = func(n *html.Node) {
+		for child := n.FirstChild; child != nil; {
+			next := child.NextSibling
+			if child.Type == html.ElementNode {
+				switch child.Data {
+				case "en-media":
+					if first := unwrapChildren(n, child); first != nil {
+						next = first
+					}
+					if replacement := mediaNode(child, media); replacement != nil {
+						n.InsertBefore(replacement, child)
+					}
+					n.RemoveChild(child)
+				case "en-todo":
+					if first := unwrapChildren(n, child); first != nil {
+						next = first
+					}
+					input := &html.Node{Type: html.ElementNode, Data: "input", DataAtom: atom.Input,
+						Attr: []html.Attribute{{Key: "type", Val: "checkbox"}, {Key: "disabled"}}}
+					if strings.EqualFold(attr(child, "checked"), "true") {
+						input.Attr = append(input.Attr, html.Attribute{Key: "checked"})
+					}
+					n.InsertBefore(input, child)
+					n.RemoveChild(child)
+				case "en-crypt":
+					n.RemoveChild(child)
+				default:
+					transform(child)
+				}
+			}
+			child = next
+		}
+	}
+	transform(root)
+
+	var buf bytes.Buffer
+	for child := root.FirstChild; child != nil; child = child.NextSibling {
+		if err := html.Render(&buf, child); err != nil {
+			return "", err
+		}
+	}
+	return strings.TrimSpace(buf.String()), nil
+}
+
+// unwrapChildren 把 child 的子节点移到 child 之后，返回第一个被移动的节点。
+// HTML 解析器不识别 <en-media/>、<en-todo/> 的自闭合写法，其后的内容会成为它们的子节点
+func unwrapChildren(parent, child *html.Node) *html.Node {
+	first := child.FirstChild
+	after := child.NextSibling
+	for node := child.FirstChild; node != nil; {
+		following := node.NextSibling
+		child.RemoveChild(node)
+		parent.InsertBefore(node, after)
+		node = following
+	}
+	return first
+}
+
+func isBlock(n *html.Node) bool {
+	if n == nil || n.Type != html.ElementNode {
+		return false
+	}
+	switch n.Data {
+	case "div", "p", "section", "article", "center", "h1", "h2", "h3", "h4", "h5", "h6",
+		"pre", "hr", "ul", "ol", "li", "blockquote", "table", "tr", "td", "th", "en-crypt":
+		return true
+	}
+	return false
+}
+
+func mediaNode(n *html.Node, media map[string]File) *html.Node {
+	file, ok := media[strings.ToLower(attr(n, "hash"))]
+	if !ok {
+		return nil
+	}
+	if strings.HasPrefix(file.MimeType, "image/") {
+		return &html.Node{Type: html.ElementNode, Data: "img", DataAtom: atom.Img,
+			Attr: []html.Attribute{{Key: "src", Val: file.Ref}, {Key: "alt", Val: file.Name}}}
+	}
+	link := &html.Node{Type: html.ElementNode, Data: "a", DataAtom: atom.A,
+		Attr: []html.Attribute{{Key: "href", Val: file.Ref}}}
+	link.AppendChild(&html.Node{Type: html.TextNode, Data: file.Name})
+	return link
+}
+
+func findElement(n *html.Node, name string) *html.Node {
+	if n.Type == html.ElementNode && n.Data == name {
+		return n
+	}
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		if found := findElement(child, name); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+func attr(n *html.Node, key string) string {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+func textContent(n *html.Node) string {
+	if n.Type == html.TextNode {
+		return n.Data
+	}
+	if n.Type == html.ElementNode && n.Data == "br" {
+		return "\n"
+	}
+	var sb strings.Builder
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		sb.WriteString(textContent(child))
+		if child.Type == html.ElementNode && (child.Data == "div" || child.Data == "p") {
+			sb.WriteString("\n")
+		}
+	}
+	return sb.String()
+}
+
+func singleLine(text string) string {
+	return whitespacePattern.ReplaceAllString(text, " ")
+}
+
+// wrapInline 为行内文本添加强调标记，标记放在首尾空白之内
+func wrapInline(text, mark string) string {
+	trimmed := strings.TrimSpace(text)
+	if trimmed == "" {
+		return text
+	}
+	leading := text[:strings.Index(text, trimmed)]
+	trailing := text[len(leading)+len(trimmed):]
+	return leading + mark + trimmed + mark + trailing
+}
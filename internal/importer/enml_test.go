@@ -0,0 +1,43 @@
+package importer
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// 超过 10 个附件时 import-file://1 是 import-file://10、import-file://11 的前缀，
+// 每个 <en-media> 仍应替换为各自的附件地址
+func TestConvertENMLWithManyResources(t *testing.T) {
+	const count = 12
+
+	var enml strings.Builder
+	enml.WriteString(`<?xml version="1.0" encoding="UTF-8"?><en-note>`)
+	media := make(map[string]File, count)
+	urls := make(map[string]string, count)
+	for i := 1; i <= count; i++ {
+		hash := fmt.Sprintf("%032x", i)
+		fmt.Fprintf(&enml, `<div><en-media type="application/pdf" hash="%s"/></div>`, hash)
+		media[hash] = File{Ref: FileRef(i), Name: fmt.Sprintf("file-%d.pdf", i), MimeType: "application/pdf"}
+		urls[FileRef(i)] = fmt.Sprintf("/api/files/%d", 100+i)
+	}
+	enml.WriteString(`</en-note>`)
+
+	for _, asHTML := range []bool{false, true} {
+		content, err := ConvertENML(enml.String(), media, asHTML)
+		if err != nil {
+			t.Fatalf("ConvertENML(asHTML=%v): %v", asHTML, err)
+		}
+
+		replaced := ReplaceFileRefs(content, urls)
+		if strings.Contains(replaced, "import-file://") {
+			t.Errorf("asHTML=%v: unreplaced placeholder in %q", asHTML, replaced)
+		}
+		for i := 1; i <= count; i++ {
+			url := fmt.Sprintf("/api/files/%d", 100+i)
+			if n := strings.Count(replaced, url+`"`) + strings.Count(replaced, url+")"); n != 1 {
+				t.Errorf("asHTML=%v: %s appears %d times, want 1 in %q", asHTML, url, n, replaced)
+			}
+		}
+	}
+}
@@ -0,0 +1,314 @@
+package importer
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"mime"
+	"net/url"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const maxMarkdownSize = 10 << 20
+
+var (
+	// ![alt](path "title") 与 [text](path)
+	markdownLinkPattern = regexp.MustCompile(`(!?)\[([^\]\n]*)\]\(\s*<?([^)\s>]+)>?(\s+"[^"\n]*")?\s*\)`)
+	// Obsidian 嵌入 ![[image.png]]、![[image.png|300]]
+	embedPattern = regexp.MustCompile(`!\[\[([^\]|#\n]+)(?:[|#][^\]\n]*)?\]\]`)
+	// HTML 图片 <img src="path">
+	imgSrcPattern = regexp.MustCompile(`(<img\b[^>]*?\bsrc=)("([^"]+)"|'([^']+)')`)
+)
+
+// ParseMarkdownVault 解析 Obsidian / Typora 风格的 Markdown 压缩包：目录对应分类，
+// front matter 中的 title、tags、created、updated、public 映射到笔记属性，正文引用的本地文件作为附件导入
+func ParseMarkdownVault(archive *zip.Reader) ([]Document, []Issue) {
+	entries := make(map[string]*zip.File)
+	byName := make(map[string][]*zip.File)
+	var markdownFiles []*zip.File
+
+	for _, file := range archive.File {
+		name := path.Clean(strings.ReplaceAll(file.Name, "\\", "/"))
+		if file.FileInfo().IsDir() || isHiddenPath(name) {
+			continue
+		}
+		entries[name] = file
+		base := strings.ToLower(path.Base(name))
+		byName[base] = append(byName[base], file)
+
+		if isMarkdown(name) {
+			markdownFiles = append(markdownFiles, file)
+		}
+	}
+
+	root := commonRoot(entries)
+
+	var documents []Document
+	var issues []Issue
+	for _, file := range markdownFiles {
+		name := path.Clean(strings.ReplaceAll(file.Name, "\\", "/"))
+		doc, err := parseMarkdownFile(file, name, root, entries, byName)
+		if err != nil {
+			issues = append(issues, Issue{Path: name, Message: err.Error()})
+			continue
+		}
+		documents = append(documents, *doc)
+	}
+
+	return documents, issues
+}
+
+func parseMarkdownFile(file *zip.File, name, root string, entries map[string]*zip.File, byName map[string][]*zip.File) (*Document, error) {
+	if file.UncompressedSize64 > maxMarkdownSize {
+		return nil, fmt.Errorf("文件超过 %d MB", maxMarkdownSize>>20)
+	}
+
+	data, err := readZipFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("读取失败: %v", err)
+	}
+
+	content := strings.TrimPrefix(strings.ReplaceAll(string(data), "\r\n", "\n"), "\ufeff")
+	meta, body, err := splitFrontMatter(content)
+	if err != nil {
+		return nil, err
+	}
+
+	relative := strings.TrimPrefix(name, root)
+	dir := path.Dir(relative)
+	var folder []string
+	if dir != "." {
+		folder = strings.Split(dir, "/")
+	}
+
+	doc := &Document{
+		Path:   name,
+		Folder: folder,
+		Title:  strings.TrimSuffix(path.Base(name), path.Ext(name)),
+	}
+	applyFrontMatter(doc, meta)
+	if doc.UpdatedAt == nil && !file.Modified.IsZero() {
+		modified := file.Modified
+		doc.UpdatedAt = &modified
+	}
+
+	refs := make(map[string]string)
+	resolve := func(target string) (string, bool) {
+		entry := resolveLocalFile(target, path.Dir(name), root, entries, byName)
+		if entry == nil || isMarkdown(entry.Name) {
+			return "", false
+		}
+		key := entry.Name
+		if ref, ok := refs[key]; ok {
+			return ref, true
+		}
+		ref := FileRef(len(doc.Files))
+		refs[key] = ref
+		doc.Files = append(doc.Files, zipFile(entry, ref))
+		return ref, true
+	}
+
+	body = embedPattern.ReplaceAllStringFunc(body, func(match string) string {
+		target := strings.TrimSpace(embedPattern.FindStringSubmatch(match)[1])
+		if ref, ok := resolve(target); ok {
+			return "![" + path.Base(target) + "](" + ref + ")"
+		}
+		return match
+	})
+	body = markdownLinkPattern.ReplaceAllStringFunc(body, func(match string) string {
+		m := markdownLinkPattern.FindStringSubmatch(match)
+		if ref, ok := resolve(m[3]); ok {
+			return m[1] + "[" + m[2] + "](" + ref + m[4] + ")"
+		}
+		return match
+	})
+	body = imgSrcPattern.ReplaceAllStringFunc(body, func(match string) string {
+		m := imgSrcPattern.FindStringSubmatch(match)
+		target := m[3]
+		if target == "" {
+			target = m[4]
+		}
+		if ref, ok := resolve(target); ok {
+			return m[1] + `"` + ref + `"`
+		}
+		return match
+	})
+
+	doc.Content = strings.TrimLeft(body, "\n")
+	return doc, nil
+}
+
+// splitFrontMatter 拆分 YAML front matter 和正文
+func splitFrontMatter(content string) (map[string]interface{}, string, error) {
+	if !strings.HasPrefix(content, "---\n") {
+		return nil, content, nil
+	}
+
+	rest := content[4:]
+	end := strings.Index(rest, "\n---")
+	if end < 0 {
+		return nil, content, nil
+	}
+	after := rest[end+4:]
+	if after != "" && !strings.HasPrefix(after, "\n") {
+		return nil, content, nil
+	}
+
+	meta := make(map[string]interface{})
+	if err := yaml.Unmarshal([]byte(rest[:end]), &meta); err != nil {
+		return nil, "", fmt.Errorf("front matter 格式错误: %v", err)
+	}
+	return meta, strings.TrimPrefix(after, "\n"), nil
+}
+
+func applyFrontMatter(doc *Document, meta map[string]interface{}) {
+	for key, value := range meta {
+		switch strings.ToLower(key) {
+		case "title":
+			if value != nil {
+				if title := strings.TrimSpace(fmt.Sprint(value)); title != "" {
+					doc.Title = title
+				}
+			}
+		case "tags", "tag":
+			doc.Tags = append(doc.Tags, parseTags(value)...)
+		case "created", "created_at", "date":
+			if t, ok := parseTimeValue(value); ok {
+				doc.CreatedAt = t
+			}
+		case "updated", "updated_at", "modified":
+			if t, ok := parseTimeValue(value); ok {
+				doc.UpdatedAt = t
+			}
+		case "public", "is_public":
+			switch v := value.(type) {
+			case bool:
+				doc.IsPublic = v
+			case string:
+				doc.IsPublic, _ = strconv.ParseBool(v)
+			}
+		}
+	}
+}
+
+func parseTags(value interface{}) []string {
+	var raw []string
+	switch v := value.(type) {
+	case string:
+		raw = strings.FieldsFunc(v, func(r rune) bool { return r == ',' || r == ' ' })
+	case []interface{}:
+		for _, item := range v {
+			if item != nil {
+				raw = append(raw, fmt.Sprint(item))
+			}
+		}
+	}
+
+	var tags []string
+	for _, tag := range raw {
+		tag = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(tag), "#"))
+		if tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+func parseTimeValue(value interface{}) (*time.Time, bool) {
+	switch v := value.(type) {
+	case time.Time:
+		return &v, true
+	case string:
+		return ParseTime(v)
+	}
+	return nil, false
+}
+
+// resolveLocalFile 按 Markdown 文件所在目录解析相对路径，找不到时按文件名在整个仓库中查找（Obsidian 的默认行为）
+func resolveLocalFile(target, dir, root string, entries map[string]*zip.File, byName map[string][]*zip.File) *zip.File {
+	if target == "" || strings.Contains(target, "://") || strings.HasPrefix(target, "data:") ||
+		strings.HasPrefix(target, "#") || strings.HasPrefix(target, "mailto:") {
+		return nil
+	}
+	if i := strings.IndexAny(target, "?#"); i >= 0 {
+		target = target[:i]
+	}
+	if decoded, err := url.PathUnescape(target); err == nil {
+		target = decoded
+	}
+
+	var candidate string
+	if strings.HasPrefix(target, "/") {
+		candidate = path.Clean(root + strings.TrimPrefix(target, "/"))
+	} else {
+		candidate = path.Clean(path.Join(dir, target))
+	}
+	if entry, ok := entries[candidate]; ok {
+		return entry
+	}
+
+	if matches := byName[strings.ToLower(path.Base(target))]; len(matches) == 1 {
+		return matches[0]
+	}
+	return nil
+}
+
+func zipFile(entry *zip.File, ref string) File {
+	name := path.Base(entry.Name)
+	return File{
+		Ref:      ref,
+		Name:     name,
+		Size:     int64(entry.UncompressedSize64),
+		MimeType: mime.TypeByExtension(path.Ext(name)),
+		Open: func() (io.ReadCloser, error) {
+			return entry.Open()
+		},
+	}
+}
+
+// commonRoot 压缩包内所有文件位于同一个顶层目录时返回该目录（如 "MyVault/"），该目录不作为分类
+func commonRoot(entries map[string]*zip.File) string {
+	root := ""
+	for name := range entries {
+		i := strings.Index(name, "/")
+		if i < 0 {
+			return ""
+		}
+		top := name[:i+1]
+		if root == "" {
+			root = top
+		} else if root != top {
+			return ""
+		}
+	}
+	return root
+}
+
+func isHiddenPath(name string) bool {
+	for _, segment := range strings.Split(name, "/") {
+		if strings.HasPrefix(segment, ".") || segment == "__MACOSX" {
+			return true
+		}
+	}
+	return false
+}
+
+func isMarkdown(name string) bool {
+	ext := strings.ToLower(path.Ext(name))
+	return ext == ".md" || ext == ".markdown"
+}
+
+func readZipFile(file *zip.File) ([]byte, error) {
+	reader, err := file.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return io.ReadAll(io.LimitReader(reader, maxMarkdownSize+1))
+}
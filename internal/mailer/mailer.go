@@ -0,0 +1,96 @@
+// Package mailer 发送系统邮件（邮箱验证、重置密码等）。生产环境通过 SMTP 发送，
+// 开发和测试环境使用 SinkMailer 将邮件写入文件或日志。
+package mailer
+
+import (
+	"bytes"
+	"fmt"
+	"mime"
+	"mime/quotedprintable"
+	"net/mail"
+	"notes-backend/internal/config"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Message 一封纯文本邮件
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer 邮件发送方式
+type Mailer interface {
+	Send(msg *Message) error
+}
+
+// New 按配置创建 Mailer
+func New(cfg config.MailConfig) (Mailer, error) {
+	if _, err := mail.ParseAddress(cfg.From); err != nil {
+		return nil, fmt.Errorf("invalid mail.from %q: %w", cfg.From, err)
+	}
+
+	switch cfg.Driver {
+	case "smtp":
+		if cfg.SMTP.Host == "" {
+			return nil, fmt.Errorf("mail.smtp.host is required for smtp driver")
+		}
+		return NewSMTPMailer(cfg.From, cfg.SMTP), nil
+	case "file":
+		return NewSinkMailer(cfg.From, cfg.Dir)
+	case "log":
+		fmt.Println("WARNING: mail.driver is \"log\": emails are NOT sent, only printed to the log with tokens redacted. Use smtp in production")
+		return NewSinkMailer(cfg.From, "")
+	default:
+		return nil, fmt.Errorf("unknown mail driver: %s", cfg.Driver)
+	}
+}
+
+// compose 生成 RFC 5322 格式的邮件，正文使用 quoted-printable 编码
+func compose(from string, msg *Message) ([]byte, error) {
+	var body bytes.Buffer
+	w := quotedprintable.NewWriter(&body)
+	if _, err := w.Write([]byte(strings.ReplaceAll(msg.Body, "\n", "\r\n"))); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	domain := "localhost"
+	if addr, err := mail.ParseAddress(from); err == nil {
+		if _, d, ok := strings.Cut(addr.Address, "@"); ok {
+			domain = d
+		}
+	}
+
+	var buf bytes.Buffer
+	headers := [][2]string{
+		{"From", from},
+		{"To", msg.To},
+		{"Subject", mime.QEncoding.Encode("utf-8", msg.Subject)},
+		{"Date", time.Now().Format(time.RFC1123Z)},
+		{"Message-ID", fmt.Sprintf("<%s@%s>", uuid.NewString(), domain)},
+		{"MIME-Version", "1.0"},
+		{"Content-Type", "text/plain; charset=utf-8"},
+		{"Content-Transfer-Encoding", "quoted-printable"},
+	}
+	for _, h := range headers {
+		fmt.Fprintf(&buf, "%s: %s\r\n", h[0], h[1])
+	}
+	buf.WriteString("\r\n")
+	buf.Write(body.Bytes())
+	return buf.Bytes(), nil
+}
+
+// envelopeAddress 去掉显示名称，返回 SMTP 信封使用的地址
+func envelopeAddress(addr string) (string, error) {
+	parsed, err := mail.ParseAddress(addr)
+	if err != nil {
+		return "", fmt.Errorf("invalid address %q: %w", addr, err)
+	}
+	return parsed.Address, nil
+}
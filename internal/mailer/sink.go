@@ -0,0 +1,66 @@
+package mailer
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"time"
+)
+
+var unsafeFileChars = regexp.MustCompile(`[^A-Za-z0-9._@-]+`)
+
+// tokenParam 邮件链接中的一次性令牌，只输出到日志时隐去，避免日志读者借此重置密码或验证邮箱
+var tokenParam = regexp.MustCompile(`([?&]token=)[^&\s]+`)
+
+// SinkMailer 不真正发送邮件：dir 不为空时将每封邮件写入 dir 下的 .eml 文件，
+// 否则只输出到日志（链接中的令牌会隐去）。最近发送的邮件同时保存在内存中，便于测试读取
+type SinkMailer struct {
+	from string
+	dir  string
+
+	mu   sync.Mutex
+	sent []Message
+}
+
+func NewSinkMailer(from, dir string) (*SinkMailer, error) {
+	if dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, fmt.Errorf("failed to create mail directory: %w", err)
+		}
+	}
+	return &SinkMailer{from: from, dir: dir}, nil
+}
+
+func (m *SinkMailer) Send(msg *Message) error {
+	m.mu.Lock()
+	m.sent = append(m.sent, *msg)
+	m.mu.Unlock()
+
+	if m.dir == "" {
+		body := tokenParam.ReplaceAllString(msg.Body, "${1}[REDACTED]")
+		fmt.Printf("Mail to=%s subject=%q\n%s\n", msg.To, msg.Subject, body)
+		return nil
+	}
+
+	data, err := compose(m.from, msg)
+	if err != nil {
+		return err
+	}
+
+	name := fmt.Sprintf("%s-%s.eml", time.Now().Format("20060102-150405.000000000"), unsafeFileChars.ReplaceAllString(msg.To, "_"))
+	path := filepath.Join(m.dir, name)
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return err
+	}
+	fmt.Printf("Mail to=%s subject=%q written to %s\n", msg.To, msg.Subject, path)
+	return nil
+}
+
+// Sent 已发送的邮件，按发送顺序
+func (m *SinkMailer) Sent() []Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]Message(nil), m.sent...)
+}
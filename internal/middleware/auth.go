@@ -2,8 +2,10 @@
 package middleware
 
 import (
+	"net/http"
 	"notes-backend/internal/config"
 	"notes-backend/internal/models"
+	"notes-backend/internal/services"
 	"notes-backend/internal/utils"
 	"strings"
 
@@ -11,74 +13,106 @@ import (
 	"gorm.io/gorm"
 )
 
-func AuthMiddleware(db *gorm.DB, cfg *config.Config) gin.HandlerFunc {
+func AuthMiddleware(db *gorm.DB, cfg *config.Config, sessions *services.SessionService) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		token := extractToken(c)
-		if token == "" {
-			utils.Unauthorized(c, "缺少访问令牌")
+		if !authenticate(c, db, cfg, sessions, extractToken(c)) {
 			c.Abort()
 			return
 		}
-
-		claims, err := utils.ParseToken(token, cfg.JWT.Secret)
-		if err != nil {
-			utils.Unauthorized(c, "无效的访问令牌")
-			c.Abort()
-			return
-		}
-
-		// 验证用户是否存在且活跃
-		var user models.User
-		if err := db.Where("id = ? AND is_active = ?", claims.UserID, true).First(&user).Error; err != nil {
-			if err == gorm.ErrRecordNotFound {
-				utils.Unauthorized(c, "用户不存在或已被禁用")
-			} else {
-				utils.InternalError(c)
-			}
-			c.Abort()
-			return
-		}
-
-		// 将用户信息存储到上下文中
-		c.Set("user", &user)
-		c.Set("user_id", user.ID)
 		c.Next()
 	}
 }
 
 // 修复：支持从查询参数获取token的中间件
-func AuthMiddlewareWithQuery(db *gorm.DB, cfg *config.Config) gin.HandlerFunc {
+func AuthMiddlewareWithQuery(db *gorm.DB, cfg *config.Config, sessions *services.SessionService) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		token := extractTokenWithQuery(c)
-		if token == "" {
-			utils.Unauthorized(c, "缺少访问令牌")
+		if !authenticate(c, db, cfg, sessions, extractTokenWithQuery(c)) {
 			c.Abort()
 			return
 		}
+		c.Next()
+	}
+}
+
+// authenticate 校验访问令牌，令牌本身或所属会话已被吊销（注销或会话失效）时拒绝访问。
+// 通过校验后记录会话的最近访问时间，由 SessionService 批量写入
+func authenticate(c *gin.Context, db *gorm.DB, cfg *config.Config, sessions *services.SessionService, token string) bool {
+	if token == "" {
+		utils.Unauthorized(c, "缺少访问令牌")
+		return false
+	}
 
-		claims, err := utils.ParseToken(token, cfg.JWT.Secret)
+	claims, err := utils.ParseToken(token, cfg.JWT.Secret)
+	if err != nil {
+		utils.Unauthorized(c, "无效的访问令牌")
+		return false
+	}
+
+	if claims.ID != "" {
+		revoked, err := sessions.IsTokenRevoked(claims.ID)
 		if err != nil {
-			utils.Unauthorized(c, "无效的访问令牌")
-			c.Abort()
+			utils.InternalError(c)
+			return false
+		}
+		if revoked {
+			utils.Unauthorized(c, "访问令牌已失效，请重新登录")
+			return false
+		}
+	}
+
+	if claims.SessionID != "" {
+		revoked, err := sessions.IsSessionRevoked(claims.SessionID)
+		if err != nil {
+			utils.InternalError(c)
+			return false
+		}
+		if revoked {
+			utils.Unauthorized(c, "会话已失效，请重新登录")
+			return false
+		}
+	}
+
+	// 验证用户是否存在且活跃
+	var user models.User
+	if err := db.Where("id = ? AND is_active = ?", claims.UserID, true).First(&user).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			utils.Unauthorized(c, "用户不存在或已被禁用")
+		} else {
+			utils.InternalError(c)
+		}
+		return false
+	}
+
+	sessions.Touch(claims.SessionID, c.ClientIP())
+
+	// 将用户信息存储到上下文中
+	c.Set("user", &user)
+	c.Set("user_id", user.ID)
+	c.Set("session_id", claims.SessionID)
+	c.Set("token_id", claims.ID)
+	if claims.ExpiresAt != nil {
+		c.Set("token_expires_at", claims.ExpiresAt.Time)
+	}
+	return true
+}
+
+// TwoFactorMiddleware 管理员要求开启两步验证时，尚未开启的用户只能访问 /api/auth 下的账号接口，
+// 以便完成两步验证设置
+func TwoFactorMiddleware(twoFactor *services.TwoFactorService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, exists := c.Get("user")
+		if !exists || user.(*models.User).TwoFactorEnabled || !twoFactor.IsRequired() {
+			c.Next()
 			return
 		}
 
-		// 验证用户是否存在且活跃
-		var user models.User
-		if err := db.Where("id = ? AND is_active = ?", claims.UserID, true).First(&user).Error; err != nil {
-			if err == gorm.ErrRecordNotFound {
-				utils.Unauthorized(c, "用户不存在或已被禁用")
-			} else {
-				utils.InternalError(c)
-			}
-			c.Abort()
+		if strings.HasPrefix(c.FullPath(), "/api/auth/") {
+			c.Next()
 			return
 		}
 
-		// 将用户信息存储到上下文中
-		c.Set("user", &user)
-		c.Set("user_id", user.ID)
-		c.Next()
+		utils.ErrorWithData(c, http.StatusForbidden, "请先开启两步验证", gin.H{"two_factor_setup_required": true})
+		c.Abort()
 	}
 }
 
@@ -7,19 +7,32 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
+// AllowedOrigins 允许跨域访问的前端地址，WebSocket 连接也按此校验 Origin
+var AllowedOrigins = []string{
+	"https://xiaohua.tech",
+	"https://www.xiaohua.tech",
+	"http://localhost:3000",
+	"http://localhost:5173",
+	"http://localhost:8080",
+}
+
 func CORSMiddleware() gin.HandlerFunc {
 	return cors.New(cors.Config{
-			AllowOrigins: []string{
-			"https://xiaohua.tech",
-			"https://www.xiaohua.tech",
-			"http://localhost:3000",
-			"http://localhost:5173",
-			"http://localhost:8080",
-		},
-		AllowMethods:     []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowHeaders:     []string{"Origin", "Content-Type", "Content-Length", "Accept-Encoding", "X-CSRF-Token", "Authorization"},
-		ExposeHeaders:    []string{"Content-Length"},
+		AllowOrigins:     AllowedOrigins,
+		AllowMethods:     []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
+		AllowHeaders:     []string{"Origin", "Content-Type", "Content-Length", "Accept-Encoding", "X-CSRF-Token", "Authorization", "If-Match", "X-Share-Token"},
+		ExposeHeaders:    []string{"Content-Length", "ETag"},
 		AllowCredentials: true,
 		MaxAge:           12 * time.Hour,
 	})
 }
+
+// IsAllowedOrigin 判断请求来源是否在跨域白名单中
+func IsAllowedOrigin(origin string) bool {
+	for _, allowed := range AllowedOrigins {
+		if origin == allowed {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,18 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RecoveryMiddleware 与 gin.Recovery 相同，panic 时返回 500；http.ErrAbortHandler 继续向上抛出，
+// 由 net/http 直接断开连接，用于响应已经开始发送后中止流式下载
+func RecoveryMiddleware() gin.HandlerFunc {
+	return gin.CustomRecovery(func(c *gin.Context, err any) {
+		if err == http.ErrAbortHandler {
+			panic(err)
+		}
+		c.AbortWithStatus(http.StatusInternalServerError)
+	})
+}
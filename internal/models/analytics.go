@@ -0,0 +1,96 @@
+package models
+
+import "time"
+
+// 访问统计的汇总维度
+const (
+	VisitDimensionTotal     = "total"
+	VisitDimensionShareLink = "share_link"
+	VisitDimensionReferrer  = "referrer"
+	VisitDimensionBrowser   = "browser"
+	VisitDimensionOS        = "os"
+	// 机器人访问按识别原因汇总，不计入其他维度
+	VisitDimensionBot = "bot"
+)
+
+// 访问被识别为机器人的原因
+const (
+	BotReasonUserAgent   = "user_agent"
+	BotReasonNoUserAgent = "no_user_agent"
+	BotReasonHeadRequest = "head_request"
+	BotReasonPrefetch    = "prefetch"
+)
+
+// VisitRollup 按天汇总的访问统计。Dimension 为 total 时 Value 为空，
+// 其余维度的 Value 分别为分享链接 ID、来源域名、浏览器和操作系统，空字符串表示直接访问或无法识别。
+// Visitors 为当天按访客去重的人数
+type VisitRollup struct {
+	ID        uint      `json:"-" gorm:"primaryKey"`
+	NoteID    uint      `json:"note_id" gorm:"not null;uniqueIndex:idx_visit_rollups_key"`
+	Day       time.Time `json:"day" gorm:"type:date;not null;uniqueIndex:idx_visit_rollups_key;index"`
+	Dimension string    `json:"dimension" gorm:"size:20;not null;uniqueIndex:idx_visit_rollups_key"`
+	Value     string    `json:"value" gorm:"size:255;not null;uniqueIndex:idx_visit_rollups_key"`
+	Views     int64     `json:"views" gorm:"not null;default:0"`
+	Visitors  int64     `json:"visitors" gorm:"not null;default:0"`
+}
+
+type AnalyticsRequest struct {
+	Interval string     `form:"interval" validate:"oneof=hour day week"`
+	From     *time.Time `form:"from" time_format:"2006-01-02T15:04:05Z07:00"`
+	To       *time.Time `form:"to" time_format:"2006-01-02T15:04:05Z07:00"`
+}
+
+// NoteAnalytics 笔记的访问统计，只包含人工访问，机器人访问单独按识别原因统计。
+// 按天和按周统计时，时间序列和各分布中的访客数为每天去重后的累加；UniqueVisitors 为整个区间内去重的人数，
+// 区间超出原始访问记录的保留期时只能使用每天去重后的累加，此时 UniqueVisitorsEstimated 为 true
+type NoteAnalytics struct {
+	NoteID                  uint                 `json:"note_id"`
+	Interval                string               `json:"interval"`
+	From                    time.Time            `json:"from"`
+	To                      time.Time            `json:"to"`
+	TotalViews              int64                `json:"total_views"`
+	UniqueVisitors          int64                `json:"unique_visitors"`
+	UniqueVisitorsEstimated bool                 `json:"unique_visitors_estimated"`
+	Series                  []AnalyticsBucket    `json:"series"`
+	Referrers               []AnalyticsCount     `json:"referrers"`
+	Browsers                []AnalyticsCount     `json:"browsers"`
+	OperatingSystems        []AnalyticsCount     `json:"operating_systems"`
+	ShareLinks              []ShareLinkAnalytics `json:"share_links"`
+	BotViews                int64                `json:"bot_views"`
+	Bots                    []AnalyticsCount     `json:"bots"`
+}
+
+type AnalyticsBucket struct {
+	Time     time.Time `json:"time"`
+	Views    int64     `json:"views"`
+	Visitors int64     `json:"visitors"`
+}
+
+// AnalyticsCount 单个来源、浏览器或操作系统的访问量，Name 为空表示直接访问或无法识别
+type AnalyticsCount struct {
+	Name     string `json:"name"`
+	Views    int64  `json:"views"`
+	Visitors int64  `json:"visitors"`
+}
+
+// ShareLinkAnalytics 单个分享链接的访问量，ShareLinkID 为空表示不经过分享链接的访问
+type ShareLinkAnalytics struct {
+	ShareLinkID *uint  `json:"share_link_id"`
+	Label       string `json:"label,omitempty"`
+	ShareCode   string `json:"share_code,omitempty"`
+	Deleted     bool   `json:"deleted,omitempty"`
+	Views       int64  `json:"views"`
+	Visitors    int64  `json:"visitors"`
+}
+
+// RetentionReport 一次访问记录保留策略执行的结果
+type RetentionReport struct {
+	RawRetentionDays int        `json:"raw_retention_days"`
+	Cutoff           *time.Time `json:"cutoff,omitempty"`
+	RolledUpDays     int        `json:"rolled_up_days"`
+	RolledUpVisits   int64      `json:"rolled_up_visits"`
+	DeletedVisits    int64      `json:"deleted_visits"`
+	RolledUpThrough  string     `json:"rolled_up_through,omitempty"`
+	StartedAt        time.Time  `json:"started_at"`
+	FinishedAt       time.Time  `json:"finished_at"`
+}
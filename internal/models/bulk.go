@@ -0,0 +1,34 @@
+package models
+
+const (
+	BulkActionMove          = "move"
+	BulkActionAddTags       = "add_tags"
+	BulkActionRemoveTags    = "remove_tags"
+	BulkActionSetVisibility = "set_visibility"
+	BulkActionDelete        = "delete"
+	BulkActionRestore       = "restore"
+)
+
+// BulkNoteRequest 批量操作笔记。move 使用 category_id（为空表示移出分类），
+// add_tags/remove_tags 使用 tag_ids，set_visibility 使用 is_public
+type BulkNoteRequest struct {
+	NoteIDs    []uint `json:"note_ids" validate:"required,min=1,max=500"`
+	Action     string `json:"action" validate:"required,oneof=move add_tags remove_tags set_visibility delete restore"`
+	CategoryID *uint  `json:"category_id"`
+	TagIDs     []uint `json:"tag_ids"`
+	IsPublic   *bool  `json:"is_public"`
+}
+
+type BulkItemResult struct {
+	NoteID  uint   `json:"note_id"`
+	Success bool   `json:"success"`
+	Error   string `json:"error,omitempty"`
+}
+
+type BulkNoteResponse struct {
+	Action    string           `json:"action"`
+	Total     int              `json:"total"`
+	Succeeded int              `json:"succeeded"`
+	Failed    int              `json:"failed"`
+	Results   []BulkItemResult `json:"results"`
+}
@@ -1,5 +1,11 @@
 package models
 
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+)
+
 type Response struct {
 	Code    int         `json:"code"`
 	Message string      `json:"message"`
@@ -12,4 +18,38 @@ type Pagination struct {
 	Limit int `json:"limit"`
 	Total int `json:"total"`
 	Pages int `json:"pages"`
-}
\ No newline at end of file
+}
+
+// UintList 以 JSON 数组形式存储的 ID 列表
+type UintList []uint
+
+func (l UintList) Value() (driver.Value, error) {
+	if l == nil {
+		return "[]", nil
+	}
+	data, err := json.Marshal([]uint(l))
+	if err != nil {
+		return nil, err
+	}
+	return string(data), nil
+}
+
+func (l *UintList) Scan(value interface{}) error {
+	var data []byte
+	switch v := value.(type) {
+	case nil:
+		*l = UintList{}
+		return nil
+	case []byte:
+		data = v
+	case string:
+		data = []byte(v)
+	default:
+		return fmt.Errorf("unsupported type for UintList: %T", value)
+	}
+	if len(data) == 0 {
+		*l = UintList{}
+		return nil
+	}
+	return json.Unmarshal(data, (*[]uint)(l))
+}
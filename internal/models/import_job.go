@@ -0,0 +1,62 @@
+package models
+
+import "time"
+
+const (
+	ImportStatusPending   = "pending"
+	ImportStatusRunning   = "running"
+	ImportStatusCompleted = "completed"
+	ImportStatusFailed    = "failed"
+
+	ImportConflictSkip      = "skip"
+	ImportConflictDuplicate = "duplicate"
+)
+
+// ImportJob 后台导入任务的进度和结果，保存在内存中供客户端轮询
+type ImportJob struct {
+	ID          string     `json:"id"`
+	UserID      uint       `json:"user_id"`
+	Format      string     `json:"format"`
+	ContentType string     `json:"content_type"`
+	Notebook    string     `json:"notebook,omitempty"`
+	DryRun      bool       `json:"dry_run"`
+	OnConflict  string     `json:"on_conflict"`
+	Status      string     `json:"status"`
+	Error       string     `json:"error,omitempty"`
+	Total       int        `json:"total"`
+	Processed   int        `json:"processed"`
+	StartedAt   time.Time  `json:"started_at"`
+	FinishedAt  *time.Time `json:"finished_at,omitempty"`
+
+	// 结果统计，预演模式下为将要创建的数量
+	NotesCreated       int   `json:"notes_created"`
+	NotesSkipped       int   `json:"notes_skipped"`
+	CategoriesCreated  int   `json:"categories_created"`
+	TagsCreated        int   `json:"tags_created"`
+	AttachmentsCreated int   `json:"attachments_created"`
+	AttachmentBytes    int64 `json:"attachment_bytes"`
+
+	Conflicts []ImportConflict `json:"conflicts"`
+	Issues    []ImportIssue    `json:"issues"`
+}
+
+// ImportConflict 与已有笔记同名（同一分类下）的导入文件
+type ImportConflict struct {
+	Path   string `json:"path"`
+	Title  string `json:"title"`
+	NoteID uint   `json:"note_id"`
+	Action string `json:"action"`
+}
+
+type ImportIssue struct {
+	Path    string `json:"path"`
+	Message string `json:"message"`
+}
+
+type ImportRequest struct {
+	Format      string `form:"format" validate:"oneof=markdown enex"`
+	DryRun      bool   `form:"dry_run"`
+	OnConflict  string `form:"on_conflict" validate:"omitempty,oneof=skip duplicate"`
+	ContentType string `form:"content_type" validate:"omitempty,oneof=markdown html"` // 仅 enex：ENML 转换为 Markdown 或保留为 HTML
+	Notebook    string `form:"notebook" validate:"max=100"`                           // 仅 enex：导入到的分类，默认使用文件名
+}
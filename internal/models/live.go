@@ -0,0 +1,44 @@
+package models
+
+import "encoding/json"
+
+// 协同编辑 WebSocket 消息类型。客户端发送 operation 和 cursor，其余由服务端发送
+const (
+	LiveMessageSnapshot  = "snapshot"
+	LiveMessageOperation = "operation"
+	LiveMessageAck       = "ack"
+	LiveMessageCursor    = "cursor"
+	LiveMessagePresence  = "presence"
+	LiveMessageSaved     = "saved"
+	LiveMessageError     = "error"
+)
+
+// LiveMessage 协同编辑消息。Revision 为文档的操作序号，客户端提交操作时为该操作基于的序号，
+// 服务端广播操作时为应用该操作后的序号；Operation 为 ot.js 格式的文本操作
+type LiveMessage struct {
+	Type      string            `json:"type"`
+	ClientID  string            `json:"client_id,omitempty"`
+	UserID    uint              `json:"user_id,omitempty"`
+	Revision  int               `json:"revision"`
+	Operation json.RawMessage   `json:"operation,omitempty"`
+	Cursor    *LiveCursor       `json:"cursor,omitempty"`
+	Content   *string           `json:"content,omitempty"`
+	Version   int               `json:"version,omitempty"`
+	Clients   []LiveParticipant `json:"clients,omitempty"`
+	Message   string            `json:"message,omitempty"`
+}
+
+// LiveCursor 光标位置和选区终点，按 Unicode 码点计算
+type LiveCursor struct {
+	Position     int `json:"position"`
+	SelectionEnd int `json:"selection_end"`
+}
+
+// LiveParticipant 正在查看或编辑笔记的连接
+type LiveParticipant struct {
+	ClientID string      `json:"client_id"`
+	UserID   uint        `json:"user_id"`
+	Username string      `json:"username"`
+	Role     string      `json:"role"`
+	Cursor   *LiveCursor `json:"cursor,omitempty"`
+}
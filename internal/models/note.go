@@ -15,10 +15,16 @@ type Note struct {
 	ContentType string         `json:"content_type" gorm:"size:20;default:markdown"`
 	IsPublic    bool           `json:"is_public" gorm:"default:false;index"`
 	ViewCount   int            `json:"view_count" gorm:"default:0"`
+	Version     int            `json:"version" gorm:"not null;default:1"`
 	CreatedAt   time.Time      `json:"created_at" gorm:"index"`
 	UpdatedAt   time.Time      `json:"updated_at"`
 	DeletedAt   gorm.DeletedAt `json:"-" gorm:"index"`
 
+	// 公开访问时按需返回的过滤后 HTML
+	RenderedHTML string `json:"rendered_html,omitempty" gorm:"-"`
+	// 当前用户对笔记的角色（owner、viewer、commenter 或 editor），仅在获取单个笔记时返回
+	AccessRole string `json:"access_role,omitempty" gorm:"-"`
+
 	// 关联
 	User        User         `json:"user,omitempty" gorm:"foreignKey:UserID"`
 	Category    *Category    `json:"category,omitempty" gorm:"foreignKey:CategoryID"`
@@ -51,6 +57,15 @@ type NoteListRequest struct {
 	CategoryID *uint  `form:"category_id"`
 	TagID      *uint  `form:"tag_id"`
 	Search     string `form:"search"`
+	Query      string `form:"q"` // 结构化查询，如 tag:work is:public updated:>2026-01-01
 	Sort       string `form:"sort" validate:"oneof=created_at updated_at title view_count"`
 	Order      string `form:"order" validate:"oneof=asc desc"`
-}
\ No newline at end of file
+}
+
+// RenderedNote 笔记正文渲染为 HTML 的结果
+type RenderedNote struct {
+	NoteID      uint   `json:"note_id"`
+	Version     int    `json:"version"`
+	ContentType string `json:"content_type"`
+	HTML        string `json:"html"`
+}
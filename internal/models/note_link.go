@@ -0,0 +1,61 @@
+package models
+
+import "time"
+
+const (
+	LinkStatusResolved   = "resolved"
+	LinkStatusUnresolved = "unresolved"
+	LinkStatusAmbiguous  = "ambiguous"
+)
+
+// NoteLink 笔记正文中的 [[标题]] 或 [[#ID]] 链接。
+// 标题链接匹配到多篇笔记时 Ambiguous 为 true 且 TargetNoteID 为空
+type NoteLink struct {
+	ID           uint      `json:"id" gorm:"primaryKey"`
+	SourceNoteID uint      `json:"source_note_id" gorm:"not null;index"`
+	TargetNoteID *uint     `json:"target_note_id" gorm:"index"`
+	TargetTitle  string    `json:"target_title" gorm:"size:255;index"`
+	Alias        string    `json:"alias" gorm:"size:255"`
+	Ambiguous    bool      `json:"ambiguous" gorm:"default:false"`
+	Position     int       `json:"position"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+type NoteSummary struct {
+	ID        uint      `json:"id"`
+	Title     string    `json:"title"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// OutgoingLink 笔记的出链，ambiguous 时 candidates 列出所有同名笔记
+type OutgoingLink struct {
+	TargetTitle string        `json:"target_title,omitempty"`
+	TargetID    *uint         `json:"target_id,omitempty"`
+	Alias       string        `json:"alias,omitempty"`
+	Status      string        `json:"status"`
+	Target      *NoteSummary  `json:"target,omitempty"`
+	Candidates  []NoteSummary `json:"candidates,omitempty"`
+}
+
+type Backlink struct {
+	Note  NoteSummary `json:"note"`
+	Count int         `json:"count"`
+}
+
+type GraphNode struct {
+	ID         uint   `json:"id"`
+	Title      string `json:"title"`
+	CategoryID *uint  `json:"category_id"`
+	IsPublic   bool   `json:"is_public"`
+}
+
+type GraphEdge struct {
+	Source uint `json:"source"`
+	Target uint `json:"target"`
+	Count  int  `json:"count"`
+}
+
+type Graph struct {
+	Nodes []GraphNode `json:"nodes"`
+	Edges []GraphEdge `json:"edges"`
+}
@@ -0,0 +1,71 @@
+package models
+
+import "time"
+
+// 共享权限角色，按 viewer < commenter < editor 递增
+const (
+	PermissionViewer    = "viewer"
+	PermissionCommenter = "commenter"
+	PermissionEditor    = "editor"
+	// 笔记所有者，不会保存到权限表中
+	PermissionOwner = "owner"
+)
+
+// NotePermission 笔记所有者授予其他用户的访问权限。NoteID 和 CategoryID 只设置其一，
+// 授权给分类时对该分类及其所有子分类下的笔记生效
+type NotePermission struct {
+	ID         uint      `json:"id" gorm:"primaryKey"`
+	OwnerID    uint      `json:"owner_id" gorm:"not null;index"`
+	GranteeID  uint      `json:"grantee_id" gorm:"not null;index;uniqueIndex:idx_note_permissions_note;uniqueIndex:idx_note_permissions_category"`
+	NoteID     *uint     `json:"note_id" gorm:"uniqueIndex:idx_note_permissions_note"`
+	CategoryID *uint     `json:"category_id" gorm:"uniqueIndex:idx_note_permissions_category"`
+	Role       string    `json:"role" gorm:"size:20;not null"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+
+	// 关联
+	Grantee User `json:"grantee,omitempty" gorm:"foreignKey:GranteeID"`
+}
+
+// NotePermissionRequest 按用户名或邮箱授权，已有授权时更新角色
+type NotePermissionRequest struct {
+	User string `json:"user" validate:"required,max=100"`
+	Role string `json:"role" validate:"required,oneof=viewer commenter editor"`
+}
+
+// PermissionUser 授权列表和共享列表中展示的用户信息
+type PermissionUser struct {
+	ID       uint    `json:"id"`
+	Username string  `json:"username"`
+	Avatar   *string `json:"avatar"`
+}
+
+type NotePermissionInfo struct {
+	ID         uint           `json:"id"`
+	NoteID     *uint          `json:"note_id,omitempty"`
+	CategoryID *uint          `json:"category_id,omitempty"`
+	Role       string         `json:"role"`
+	User       PermissionUser `json:"user"`
+	CreatedAt  time.Time      `json:"created_at"`
+	UpdatedAt  time.Time      `json:"updated_at"`
+}
+
+// SharedNote 其他用户共享给当前用户的笔记，Role 为直接授权和所在分类授权中最高的角色，
+// ViaCategoryID 不为空时表示权限来自该分类的授权
+type SharedNote struct {
+	ID            uint           `json:"id"`
+	Title         string         `json:"title"`
+	ContentType   string         `json:"content_type"`
+	CategoryID    *uint          `json:"category_id"`
+	Role          string         `json:"role"`
+	ViaCategoryID *uint          `json:"via_category_id,omitempty"`
+	Owner         PermissionUser `json:"owner"`
+	CreatedAt     time.Time      `json:"created_at"`
+	UpdatedAt     time.Time      `json:"updated_at"`
+}
+
+type SharedWithMeRequest struct {
+	Page  int    `form:"page" validate:"min=1"`
+	Limit int    `form:"limit" validate:"min=1,max=100"`
+	Role  string `form:"role" validate:"omitempty,oneof=viewer commenter editor"`
+}
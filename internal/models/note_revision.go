@@ -0,0 +1,42 @@
+package models
+
+import "time"
+
+// NoteRevision 笔记的历史版本，每次保存写入一条，写入后不再修改
+type NoteRevision struct {
+	ID          uint      `json:"id" gorm:"primaryKey"`
+	NoteID      uint      `json:"note_id" gorm:"not null;uniqueIndex:idx_note_revisions_note_revision"`
+	Revision    int       `json:"revision" gorm:"not null;uniqueIndex:idx_note_revisions_note_revision"`
+	AuthorID    uint      `json:"author_id" gorm:"not null;index"`
+	Title       string    `json:"title" gorm:"size:255;not null"`
+	Content     string    `json:"content,omitempty" gorm:"type:text"`
+	ContentType string    `json:"content_type" gorm:"size:20"`
+	CategoryID  *uint     `json:"category_id"`
+	TagIDs      UintList  `json:"tag_ids" gorm:"type:text"`
+	CreatedAt   time.Time `json:"created_at" gorm:"index"`
+
+	// 关联
+	Author *User `json:"author,omitempty" gorm:"foreignKey:AuthorID"`
+}
+
+// RevisionPolicy 用户的历史版本保留策略，0 表示不限制
+type RevisionPolicy struct {
+	UserID    uint      `json:"user_id" gorm:"primaryKey"`
+	KeepCount int       `json:"keep_count" gorm:"default:0"`
+	KeepDays  int       `json:"keep_days" gorm:"default:0"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+type RevisionPolicyRequest struct {
+	KeepCount int `json:"keep_count" validate:"min=0,max=10000"`
+	KeepDays  int `json:"keep_days" validate:"min=0,max=3650"`
+}
+
+type RevisionDiffResponse struct {
+	NoteID       uint   `json:"note_id"`
+	FromRevision int    `json:"from_revision"`
+	ToRevision   int    `json:"to_revision"`
+	FromTitle    string `json:"from_title"`
+	ToTitle      string `json:"to_title"`
+	Diff         string `json:"diff"`
+}
@@ -0,0 +1,97 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// NoteTemplate 笔记模板。标题和正文支持 {{date}}、{{time}}、{{datetime}}、{{weekday}}、
+// {{user.username}}、{{user.email}}、{{category}} 以及 Fields 中定义的自定义字段 {{字段名}}。
+// IsShared 的模板对所有用户可见，只有管理员可以设置
+type NoteTemplate struct {
+	ID          uint              `json:"id" gorm:"primaryKey"`
+	UserID      uint              `json:"user_id" gorm:"not null;index"`
+	Name        string            `json:"name" gorm:"size:100;not null"`
+	Description string            `json:"description" gorm:"type:text"`
+	Title       string            `json:"title" gorm:"size:255"`
+	Content     string            `json:"content" gorm:"type:text"`
+	ContentType string            `json:"content_type" gorm:"size:20;default:markdown"`
+	CategoryID  *uint             `json:"category_id"`
+	TagIDs      UintList          `json:"tag_ids" gorm:"type:text"`
+	Fields      TemplateFieldList `json:"fields" gorm:"type:text"`
+	IsShared    bool              `json:"is_shared" gorm:"default:false;index"`
+	CreatedAt   time.Time         `json:"created_at"`
+	UpdatedAt   time.Time         `json:"updated_at"`
+	DeletedAt   gorm.DeletedAt    `json:"-" gorm:"index"`
+
+	// 关联
+	User     *User     `json:"user,omitempty" gorm:"foreignKey:UserID"`
+	Category *Category `json:"category,omitempty" gorm:"foreignKey:CategoryID"`
+}
+
+// TemplateField 模板的自定义填写项，创建笔记时由用户提供取值
+type TemplateField struct {
+	Name     string `json:"name" validate:"required,max=50"`
+	Label    string `json:"label" validate:"max=100"`
+	Default  string `json:"default"`
+	Required bool   `json:"required"`
+}
+
+// TemplateFieldList 以 JSON 数组形式存储的模板字段
+type TemplateFieldList []TemplateField
+
+func (l TemplateFieldList) Value() (driver.Value, error) {
+	if l == nil {
+		return "[]", nil
+	}
+	data, err := json.Marshal([]TemplateField(l))
+	if err != nil {
+		return nil, err
+	}
+	return string(data), nil
+}
+
+func (l *TemplateFieldList) Scan(value interface{}) error {
+	var data []byte
+	switch v := value.(type) {
+	case nil:
+		*l = nil
+		return nil
+	case string:
+		data = []byte(v)
+	case []byte:
+		data = v
+	default:
+		return fmt.Errorf("unsupported type for TemplateFieldList: %T", value)
+	}
+	if len(data) == 0 {
+		*l = nil
+		return nil
+	}
+	return json.Unmarshal(data, (*[]TemplateField)(l))
+}
+
+type NoteTemplateRequest struct {
+	Name        string          `json:"name" validate:"required,max=100"`
+	Description string          `json:"description"`
+	Title       string          `json:"title" validate:"max=255"`
+	Content     string          `json:"content"`
+	ContentType string          `json:"content_type" validate:"omitempty,oneof=markdown html"`
+	CategoryID  *uint           `json:"category_id"`
+	TagIDs      []uint          `json:"tag_ids"`
+	Fields      []TemplateField `json:"fields" validate:"max=50,dive"`
+	IsShared    bool            `json:"is_shared"`
+}
+
+// NoteFromTemplateRequest 由模板创建笔记。Title、CategoryID、TagIDs 为空时使用模板的默认值
+type NoteFromTemplateRequest struct {
+	Title      string            `json:"title" validate:"max=255"`
+	Values     map[string]string `json:"values"`
+	CategoryID *uint             `json:"category_id"`
+	TagIDs     []uint            `json:"tag_ids"`
+	IsPublic   bool              `json:"is_public"`
+}
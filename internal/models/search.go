@@ -0,0 +1,15 @@
+package models
+
+type SearchRequest struct {
+	Q     string `form:"q" validate:"required,max=500"`
+	Page  int    `form:"page" validate:"min=1"`
+	Limit int    `form:"limit" validate:"min=1,max=100"`
+}
+
+// SearchResult 搜索结果，headline 为已转义的 HTML 片段，命中词以 <mark> 标记
+type SearchResult struct {
+	Note          *Note   `json:"note"`
+	Rank          float64 `json:"rank"`
+	TitleHeadline string  `json:"title_headline"`
+	Headline      string  `json:"headline"`
+}
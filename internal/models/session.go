@@ -0,0 +1,79 @@
+package models
+
+import "time"
+
+// 会话被吊销的原因
+const (
+	SessionRevokedLogout = "logout"
+	SessionRevokedReuse  = "refresh_token_reuse"
+	SessionRevokedUser   = "user_inactive"
+	// 用户在设备管理中退出了该会话
+	SessionRevokedSignOut = "signed_out"
+)
+
+// Session 一次登录产生的会话，即一个刷新令牌家族。刷新令牌每次使用后轮换，已轮换的令牌哈希记录在
+// RotatedRefreshToken 中；已轮换的令牌再次出现说明令牌可能被盗用，整个会话随即吊销
+type Session struct {
+	ID                string     `json:"id" gorm:"primaryKey;size:36"`
+	UserID            uint       `json:"user_id" gorm:"not null;index"`
+	RefreshTokenHash  string     `json:"-" gorm:"size:64;not null;uniqueIndex"`
+	PreviousTokenHash string     `json:"-" gorm:"size:64;index"`
+	AccessTokenID     string     `json:"-" gorm:"size:36"` // 最近签发的访问令牌 jti，吊销会话时一并吊销
+	UserAgent         string     `json:"user_agent" gorm:"type:text"`
+	IP                string     `json:"ip" gorm:"size:64"` // 最近一次访问的 IP
+	CreatedAt         time.Time  `json:"created_at"`
+	RotatedAt         time.Time  `json:"rotated_at"`
+	LastSeenAt        time.Time  `json:"last_seen_at"` // 由认证中间件记录，定期批量写入
+	ExpiresAt         time.Time  `json:"expires_at" gorm:"index"`
+	RevokedAt         *time.Time `json:"revoked_at,omitempty" gorm:"index"`
+	RevokeReason      string     `json:"revoke_reason,omitempty" gorm:"size:50"`
+}
+
+// SessionInfo 设备管理中展示的会话，Device 为从 User-Agent 解析出的设备名称
+type SessionInfo struct {
+	ID         string    `json:"id"`
+	Device     string    `json:"device"`
+	Browser    string    `json:"browser"`
+	OS         string    `json:"os"`
+	Mobile     bool      `json:"mobile"`
+	IP         string    `json:"ip"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	Current    bool      `json:"current"`
+}
+
+// RevokedToken 已吊销但尚未过期的访问令牌，过期后由后台任务清理
+// RotatedRefreshToken 会话已轮换掉的刷新令牌哈希，用于区分令牌重放和伪造的令牌。
+// 只有确实签发过的令牌再次出现才视为盗用，随会话一起清理
+type RotatedRefreshToken struct {
+	TokenHash string    `json:"-" gorm:"primaryKey;size:64"`
+	SessionID string    `json:"session_id" gorm:"size:36;not null;index"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type RevokedToken struct {
+	JTI       string    `json:"jti" gorm:"primaryKey;size:36"`
+	UserID    uint      `json:"user_id" gorm:"index"`
+	ExpiresAt time.Time `json:"expires_at" gorm:"index"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type RefreshTokenRequest struct {
+	RefreshToken string `json:"refresh_token" validate:"required"`
+}
+
+// TokenPair 访问令牌和刷新令牌
+type TokenPair struct {
+	Token            string    `json:"token"`
+	ExpiresAt        time.Time `json:"expires_at"`
+	RefreshToken     string    `json:"refresh_token"`
+	RefreshExpiresAt time.Time `json:"refresh_expires_at"`
+	SessionID        string    `json:"session_id"`
+}
+
+// ClientInfo 登录和刷新令牌时记录的客户端信息
+type ClientInfo struct {
+	IP        string
+	UserAgent string
+}
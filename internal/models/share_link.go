@@ -1,15 +1,100 @@
 package models
 
-import "time"
+import (
+	"encoding/json"
+	"time"
+)
+
+const (
+	ShareStatusActive    = "active"
+	ShareStatusDisabled  = "disabled"
+	ShareStatusExpired   = "expired"
+	ShareStatusExhausted = "exhausted"
+)
+
+// ShareLink 笔记的分享链接，一篇笔记可以有多个链接，各自设置密码、有效期和访问次数上限。
+// IsActive 为 false 表示链接已删除，Enabled 为 false 表示暂时停用
 type ShareLink struct {
 	ID         uint       `json:"id" gorm:"primaryKey"`
 	NoteID     uint       `json:"note_id" gorm:"not null;index"`
 	ShareCode  string     `json:"share_code" gorm:"size:32;uniqueIndex;not null"`
-	Password   *string    `json:"password,omitempty" gorm:"size:255"`
+	Label      string     `json:"label" gorm:"size:100"`
+	Password   *string    `json:"-" gorm:"size:255"` // Argon2 哈希
 	ExpireTime *time.Time `json:"expire_time"`
-	VisitCount int        `json:"visit_count" gorm:"default:0"`
+	MaxViews   *int       `json:"max_views"`                    // 独立访客数上限，与 VisitCount 比较
+	VisitCount int        `json:"visit_count" gorm:"default:0"` // 独立访客数，同一 IP 一小时内只计一次，异步写入
+	Enabled    bool       `json:"enabled" gorm:"not null;default:true"`
 	IsActive   bool       `json:"is_active" gorm:"default:true"`
 	CreatedAt  time.Time  `json:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at"`
 
 	Note Note `json:"note,omitempty" gorm:"foreignKey:NoteID"`
 }
+
+// Status 链接当前是否可以访问
+func (s *ShareLink) Status() string {
+	switch {
+	case !s.Enabled:
+		return ShareStatusDisabled
+	case s.ExpireTime != nil && time.Now().After(*s.ExpireTime):
+		return ShareStatusExpired
+	case s.MaxViews != nil && s.VisitCount >= *s.MaxViews:
+		return ShareStatusExhausted
+	default:
+		return ShareStatusActive
+	}
+}
+
+type ShareCreateRequest struct {
+	Label      string     `json:"label" validate:"max=100"`
+	Password   *string    `json:"password" validate:"omitempty,max=128"`
+	ExpireTime *time.Time `json:"expire_time"`
+	MaxViews   *int       `json:"max_views" validate:"omitempty,min=1"`
+	Enabled    *bool      `json:"enabled"`
+}
+
+// ShareUpdateRequest 只修改请求中出现的字段；expire_time、max_views 传 null 表示取消限制，password 传空字符串表示取消密码
+type ShareUpdateRequest struct {
+	Label      *string             `json:"label" validate:"omitempty,max=100"`
+	Password   *string             `json:"password" validate:"omitempty,max=128"`
+	ExpireTime Optional[time.Time] `json:"expire_time"`
+	MaxViews   Optional[int]       `json:"max_views"`
+	Enabled    *bool               `json:"enabled"`
+}
+
+// Optional 区分 JSON 中未出现的字段和显式的 null
+type Optional[T any] struct {
+	Set   bool
+	Value *T
+}
+
+func (o *Optional[T]) UnmarshalJSON(data []byte) error {
+	o.Set = true
+	if string(data) == "null" {
+		o.Value = nil
+		return nil
+	}
+	var value T
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+	o.Value = &value
+	return nil
+}
+
+// ShareLinkInfo 分享链接详情，不包含密码
+type ShareLinkInfo struct {
+	ID          uint       `json:"id"`
+	NoteID      uint       `json:"note_id"`
+	NoteTitle   string     `json:"note_title,omitempty"`
+	Label       string     `json:"label"`
+	ShareCode   string     `json:"share_code"`
+	ShareURL    string     `json:"share_url"`
+	HasPassword bool       `json:"has_password"`
+	ExpireTime  *time.Time `json:"expire_time"`
+	MaxViews    *int       `json:"max_views"`
+	VisitCount  int        `json:"visit_count"`
+	Enabled     bool       `json:"enabled"`
+	Status      string     `json:"status"`
+	CreatedAt   time.Time  `json:"created_at"`
+}
@@ -13,14 +13,25 @@ type SystemConfig struct {
 	CreatedAt  time.Time  `json:"created_at" db:"created_at"`
 }
 
+// ShareLinkCreateRequest 旧版单链接接口 POST /api/notes/:id/share 的请求
 type ShareLinkCreateRequest struct {
-	Password   *string    `json:"password"`
+	Password   *string    `json:"password" validate:"omitempty,max=128"`
 	ExpireTime *time.Time `json:"expire_time"`
 }
 
+type ShareUnlockRequest struct {
+	Password string `json:"password" validate:"required"`
+}
+
+type ShareUnlockResponse struct {
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// ShareLinkResponse 旧版单链接接口的响应
 type ShareLinkResponse struct {
-	ShareCode  string     `json:"share_code"`
-	ShareURL   string     `json:"share_url"`
-	Password   *string    `json:"password,omitempty"`
-	ExpireTime *time.Time `json:"expire_time,omitempty"`
-}
\ No newline at end of file
+	ShareCode   string     `json:"share_code"`
+	ShareURL    string     `json:"share_url"`
+	HasPassword bool       `json:"has_password"`
+	ExpireTime  *time.Time `json:"expire_time,omitempty"`
+}
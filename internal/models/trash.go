@@ -0,0 +1,15 @@
+package models
+
+import "time"
+
+// TrashedNote 回收站中的笔记，PurgeAt 为自动彻底删除的时间
+type TrashedNote struct {
+	Note
+	DeletedAt time.Time `json:"deleted_at"`
+	PurgeAt   time.Time `json:"purge_at"`
+}
+
+type TrashListRequest struct {
+	Page  int `form:"page"`
+	Limit int `form:"limit"`
+}
@@ -0,0 +1,70 @@
+package models
+
+import "time"
+
+// UserTwoFactor 用户的 TOTP 两步验证设置。PendingSecret 为尚未确认的密钥，
+// 用户输入一次正确的验证码后成为 Secret。LastUsedStep 为最近一次使用的时间步，同一验证码不能重复使用
+type UserTwoFactor struct {
+	UserID        uint       `json:"user_id" gorm:"primaryKey"`
+	Secret        string     `json:"-" gorm:"size:64"`
+	PendingSecret string     `json:"-" gorm:"size:64"`
+	LastUsedStep  int64      `json:"-" gorm:"not null;default:0"`
+	EnabledAt     *time.Time `json:"enabled_at"`
+	CreatedAt     time.Time  `json:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at"`
+}
+
+// RecoveryCode 一次性恢复码，无法使用身份验证器时代替验证码，只保存 SHA-256 哈希
+type RecoveryCode struct {
+	ID        uint       `json:"id" gorm:"primaryKey"`
+	UserID    uint       `json:"user_id" gorm:"not null;index"`
+	CodeHash  string     `json:"-" gorm:"size:64;not null;uniqueIndex"`
+	UsedAt    *time.Time `json:"used_at"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// TwoFactorSetupResponse 开启两步验证的第一步，QRCode 为 PNG 图片的 data URI
+type TwoFactorSetupResponse struct {
+	Secret     string `json:"secret"`
+	OTPAuthURL string `json:"otpauth_url"`
+	QRCode     string `json:"qr_code"`
+}
+
+// TwoFactorStatus 当前用户的两步验证状态，Required 表示管理员要求所有用户开启
+type TwoFactorStatus struct {
+	Enabled                bool       `json:"enabled"`
+	EnabledAt              *time.Time `json:"enabled_at"`
+	RecoveryCodesRemaining int        `json:"recovery_codes_remaining"`
+	Required               bool       `json:"required"`
+}
+
+// RecoveryCodesResponse 新生成的恢复码，只返回一次
+type RecoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+// LoginChallenge 开启两步验证的用户登录时返回，使用 ChallengeToken 和验证码换取登录令牌
+type LoginChallenge struct {
+	TwoFactorRequired bool      `json:"two_factor_required"`
+	ChallengeToken    string    `json:"challenge_token"`
+	ExpiresAt         time.Time `json:"expires_at"`
+}
+
+type TwoFactorCodeRequest struct {
+	Code string `json:"code" validate:"required"`
+}
+
+type TwoFactorDisableRequest struct {
+	Password string `json:"password" validate:"required"`
+	Code     string `json:"code" validate:"required"`
+}
+
+// TwoFactorVerifyRequest Code 可以是身份验证器中的 6 位验证码或恢复码
+type TwoFactorVerifyRequest struct {
+	ChallengeToken string `json:"challenge_token" validate:"required"`
+	Code           string `json:"code" validate:"required"`
+}
+
+type TwoFactorPolicyRequest struct {
+	Required *bool `json:"required" validate:"required"`
+}
@@ -7,16 +7,18 @@ import (
 )
 
 type User struct {
-	ID           uint           `json:"id" gorm:"primaryKey"`
-	Username     string         `json:"username" gorm:"uniqueIndex;size:50;not null"`
-	Email        string         `json:"email" gorm:"uniqueIndex;size:100;not null"`
-	PasswordHash string         `json:"-" gorm:"size:255;not null"`
-	Avatar       *string        `json:"avatar" gorm:"size:255"`
-	Role         string         `json:"role" gorm:"size:20;default:user"`
-	IsActive     bool           `json:"is_active" gorm:"default:true"`
-	CreatedAt    time.Time      `json:"created_at"`
-	UpdatedAt    time.Time      `json:"updated_at"`
-	DeletedAt    gorm.DeletedAt `json:"-" gorm:"index"`
+	ID               uint           `json:"id" gorm:"primaryKey"`
+	Username         string         `json:"username" gorm:"uniqueIndex;size:50;not null"`
+	Email            string         `json:"email" gorm:"uniqueIndex;size:100;not null"`
+	PasswordHash     string         `json:"-" gorm:"size:255;not null"`
+	Avatar           *string        `json:"avatar" gorm:"size:255"`
+	Role             string         `json:"role" gorm:"size:20;default:user"`
+	IsActive         bool           `json:"is_active" gorm:"default:true"`
+	EmailVerifiedAt  *time.Time     `json:"email_verified_at"` // 为空表示邮箱尚未验证
+	TwoFactorEnabled bool           `json:"two_factor_enabled" gorm:"not null;default:false"`
+	CreatedAt        time.Time      `json:"created_at"`
+	UpdatedAt        time.Time      `json:"updated_at"`
+	DeletedAt        gorm.DeletedAt `json:"-" gorm:"index"`
 
 	// 关联
 	Categories []Category `json:"categories,omitempty" gorm:"foreignKey:UserID"`
@@ -30,14 +32,28 @@ type UserRegisterRequest struct {
 	Password string `json:"password" validate:"required,min=6"`
 }
 
+type VerifyEmailRequest struct {
+	Token string `json:"token" validate:"required"`
+}
+
+type ForgotPasswordRequest struct {
+	Email string `json:"email" validate:"required,email"`
+}
+
+type ResetPasswordRequest struct {
+	Token    string `json:"token" validate:"required"`
+	Password string `json:"password" validate:"required,min=6"`
+}
+
 type UserLoginRequest struct {
 	Email    string `json:"email" validate:"required,email"`
 	Password string `json:"password" validate:"required"`
 }
 
+// UserResponse 注册和登录的返回结果，令牌字段与 TokenPair 相同
 type UserResponse struct {
-	User  *User  `json:"user"`
-	Token string `json:"token"`
+	User *User `json:"user"`
+	TokenPair
 }
 
 type UserStorage struct {
@@ -50,4 +66,4 @@ type UserStorage struct {
 
 	// 关联
 	User User `json:"user,omitempty" gorm:"foreignKey:UserID"`
-}
\ No newline at end of file
+}
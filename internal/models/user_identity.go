@@ -0,0 +1,25 @@
+package models
+
+import "time"
+
+// UserIdentity 用户在外部身份提供方（OIDC）的账号，Provider + Subject 唯一确定一个外部账号
+type UserIdentity struct {
+	ID          uint      `json:"id" gorm:"primaryKey"`
+	UserID      uint      `json:"user_id" gorm:"not null;index"`
+	Provider    string    `json:"provider" gorm:"size:50;not null;uniqueIndex:idx_identity_provider_subject"`
+	Subject     string    `json:"subject" gorm:"size:255;not null;uniqueIndex:idx_identity_provider_subject"`
+	Email       string    `json:"email" gorm:"size:100"`
+	CreatedAt   time.Time `json:"created_at"`
+	LastLoginAt time.Time `json:"last_login_at"`
+}
+
+// OIDCProviderInfo 登录页展示的身份提供方
+type OIDCProviderInfo struct {
+	Name        string `json:"name"`
+	DisplayName string `json:"display_name"`
+	StartURL    string `json:"start_url"`
+}
+
+type OIDCExchangeRequest struct {
+	Code string `json:"code" validate:"required"`
+}
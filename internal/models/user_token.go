@@ -0,0 +1,22 @@
+package models
+
+import "time"
+
+// 一次性令牌的用途
+const (
+	UserTokenVerifyEmail   = "verify_email"
+	UserTokenResetPassword = "reset_password"
+)
+
+// UserToken 通过邮件发送的一次性令牌（邮箱验证、重置密码），只保存 SHA-256 哈希。
+// 令牌使用后记录 UsedAt，不能再次使用；Email 为发送时的邮箱，用户修改邮箱后旧令牌失效
+type UserToken struct {
+	ID        uint       `json:"id" gorm:"primaryKey"`
+	UserID    uint       `json:"user_id" gorm:"not null;index"`
+	Purpose   string     `json:"purpose" gorm:"size:30;not null;index"`
+	TokenHash string     `json:"-" gorm:"size:64;not null;uniqueIndex"`
+	Email     string     `json:"email" gorm:"size:100;not null"`
+	ExpiresAt time.Time  `json:"expires_at" gorm:"index"`
+	UsedAt    *time.Time `json:"used_at"`
+	CreatedAt time.Time  `json:"created_at"`
+}
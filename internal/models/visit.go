@@ -6,12 +6,22 @@ type NoteVisit struct {
 	ID        uint      `json:"id" gorm:"primaryKey"`
 	NoteID    uint      `json:"note_id" gorm:"not null;index"`
 	ViewerID  *uint     `json:"viewer_id" gorm:"index"` 
-	VisitorIP *string   `json:"visitor_ip" gorm:"type:inet"`
+	VisitorIP *string   `json:"visitor_ip" gorm:"size:64"` // 按 analytics.ip_mode 截断或哈希后保存
 	UserAgent *string   `json:"user_agent" gorm:"type:text"`
 	Referer   *string   `json:"referer" gorm:"type:text"`
 	ViewHash  *string   `json:"view_hash" gorm:"size:32;index"` 
 	VisitedAt time.Time `json:"visited_at" gorm:"index;default:CURRENT_TIMESTAMP"`
 
+	// 以下字段在写入时从请求中解析，供访问统计汇总
+	ShareLinkID *uint   `json:"share_link_id" gorm:"index"`
+	VisitorKey  *string `json:"-" gorm:"size:32;index"`
+	Browser     string  `json:"browser" gorm:"size:50"`
+	OS          string  `json:"os" gorm:"size:50"`
+	RefererHost string  `json:"referer_host" gorm:"size:255"`
+	// 识别为机器人、爬虫或链接预览的访问不计入浏览量
+	IsBot     bool   `json:"is_bot" gorm:"not null;default:false;index"`
+	BotReason string `json:"bot_reason,omitempty" gorm:"size:50"`
+
 	Note   Note  `json:"note,omitempty" gorm:"foreignKey:NoteID"`
 	Viewer *User `json:"viewer,omitempty" gorm:"foreignKey:ViewerID"`
 }
@@ -20,4 +30,9 @@ type ViewerInfo struct {
 	IP        string
 	UserAgent string
 	Referer   string
+	// 请求方法和预取标记（Sec-Purpose、Purpose、X-Purpose、X-Moz 请求头），用于识别非人工访问
+	Method  string
+	Purpose string
+	// 通过分享链接访问时为分享链接 ID
+	ShareLinkID *uint
 }
\ No newline at end of file
@@ -3,6 +3,7 @@ package routes
 import (
 	"notes-backend/internal/config"
 	"notes-backend/internal/handlers"
+	"notes-backend/internal/mailer"
 	"notes-backend/internal/middleware"
 	"notes-backend/internal/services"
 
@@ -10,29 +11,68 @@ import (
 	"gorm.io/gorm"
 )
 
-func Setup(db *gorm.DB, cfg *config.Config) *gin.Engine {
+func Setup(db *gorm.DB, cfg *config.Config, mail mailer.Mailer) *gin.Engine {
 	router := gin.New()
 
 	router.Use(middleware.LoggerMiddleware())
-	router.Use(gin.Recovery())
+	router.Use(middleware.RecoveryMiddleware())
 	router.Use(middleware.CORSMiddleware())
 	router.Use(middleware.RateLimitMiddleware(60))
 
 	router.Static("/uploads", cfg.File.UploadPath)
 
-	authService := services.NewAuthService(db)
-	noteService := services.NewNoteService(db)
+	twoFactorService := services.NewTwoFactorService(db, cfg)
+	authService := services.NewAuthService(db, mail, twoFactorService, cfg)
+	oidcService := services.NewOIDCService(db, cfg)
+	sessionService := services.NewSessionService(db, cfg.JWT)
+	permissionService := services.NewPermissionService(db)
+	revisionService := services.NewRevisionService(db, cfg.Revision, permissionService)
+	searchService := services.NewSearchService(db, cfg.Search)
+	linkService := services.NewLinkService(db, permissionService)
+	analyticsService := services.NewAnalyticsService(db, cfg.Analytics)
+	noteService := services.NewNoteService(db, revisionService, searchService, linkService, analyticsService, permissionService)
 	categoryService := services.NewCategoryService(db)
 	tagService := services.NewTagService(db)
-	fileService := services.NewFileService(db, cfg.File.UploadPath, cfg.File.MaxUserStorage)
+	fileService := services.NewFileService(db, cfg.File.UploadPath, cfg.File.MaxUserStorage, permissionService)
+	trashService := services.NewTrashService(db, fileService, cfg.Trash)
+	templateService := services.NewTemplateService(db, noteService)
+	exportService := services.NewExportService(db)
+	importService := services.NewImportService(db, noteService, fileService, cfg)
+	renderService := services.NewRenderService(db, cfg.Render, permissionService)
+	shareService := services.NewShareService(db, cfg)
+	liveHub := services.NewMemoryLiveHub(db, noteService, cfg.Live)
 
-	authHandler := handlers.NewAuthHandler(authService, cfg)
+	authHandler := handlers.NewAuthHandler(authService, sessionService, cfg)
+	twoFactorHandler := handlers.NewTwoFactorHandler(twoFactorService, sessionService)
+	oidcHandler := handlers.NewOIDCHandler(oidcService, twoFactorService, sessionService, cfg)
 	noteHandler := handlers.NewNoteHandler(noteService)
 	categoryHandler := handlers.NewCategoryHandler(categoryService)
 	tagHandler := handlers.NewTagHandler(tagService)
-	shareHandler := handlers.NewShareHandler(db, noteService, cfg) 
+	shareHandler := handlers.NewShareHandler(noteService, shareService, renderService, cfg) 
 	fileHandler := handlers.NewFileHandler(fileService, cfg)
-	adminHandler := handlers.NewAdminHandler(fileService)
+	adminHandler := handlers.NewAdminHandler(fileService, analyticsService)
+	revisionHandler := handlers.NewRevisionHandler(revisionService, noteService)
+	searchHandler := handlers.NewSearchHandler(searchService)
+	trashHandler := handlers.NewTrashHandler(trashService)
+	linkHandler := handlers.NewLinkHandler(linkService)
+	templateHandler := handlers.NewTemplateHandler(templateService)
+	exportHandler := handlers.NewExportHandler(exportService)
+	importHandler := handlers.NewImportHandler(importService, cfg)
+	renderHandler := handlers.NewRenderHandler(renderService)
+	sharePageHandler := handlers.NewSharePageHandler(shareService, noteService, renderService, cfg)
+	analyticsHandler := handlers.NewAnalyticsHandler(analyticsService)
+	permissionHandler := handlers.NewPermissionHandler(permissionService)
+	liveHandler := handlers.NewLiveHandler(liveHub, noteService)
+
+	go searchService.ReindexMissing()
+	go linkService.Backfill()
+	go shareService.MigratePasswords()
+	shareService.StartCleanup()
+	trashService.StartPurger()
+	analyticsService.StartRetention()
+	liveHub.StartAutosave()
+	sessionService.StartCleanup()
+	sessionService.StartActivityFlush()
 
 	api := router.Group("/api")
 
@@ -42,18 +82,38 @@ func Setup(db *gorm.DB, cfg *config.Config) *gin.Engine {
 		{
 			auth.POST("/register", authHandler.Register)
 			auth.POST("/login", authHandler.Login)
+			auth.POST("/refresh", authHandler.Refresh)
+			auth.POST("/verify-email", authHandler.VerifyEmail)
+			auth.POST("/forgot-password", authHandler.ForgotPassword)
+			auth.POST("/reset-password", authHandler.ResetPassword)
+			auth.POST("/2fa/verify", twoFactorHandler.Verify)
+			auth.GET("/oidc/providers", oidcHandler.GetProviders)
+			auth.GET("/oidc/:provider/start", oidcHandler.Start)
+			auth.GET("/oidc/:provider/callback", oidcHandler.Callback)
+			auth.POST("/oidc/exchange", oidcHandler.Exchange)
 		}
 		
 		public.GET("/public/notes/:code", shareHandler.GetPublicNote)
+		public.POST("/public/notes/:code/unlock", shareHandler.UnlockPublicNote)
 	}
 
 	protected := api.Group("")
-	protected.Use(middleware.AuthMiddleware(db, cfg))
+	protected.Use(middleware.AuthMiddleware(db, cfg, sessionService))
+	protected.Use(middleware.TwoFactorMiddleware(twoFactorService))
 	{
 		user := protected.Group("/auth")
 		{
 			user.GET("/me", authHandler.GetMe)
 			user.POST("/logout", authHandler.Logout)
+			user.POST("/resend-verification", authHandler.ResendVerification)
+			user.GET("/sessions", authHandler.GetSessions)
+			user.DELETE("/sessions", authHandler.DeleteSessions)
+			user.DELETE("/sessions/:id", authHandler.DeleteSession)
+			user.GET("/2fa", twoFactorHandler.GetStatus)
+			user.POST("/2fa/setup", twoFactorHandler.Setup)
+			user.POST("/2fa/enable", twoFactorHandler.Enable)
+			user.POST("/2fa/disable", twoFactorHandler.Disable)
+			user.POST("/2fa/recovery-codes", twoFactorHandler.RegenerateRecoveryCodes)
 		}
 
 		notes := protected.Group("/notes")
@@ -61,6 +121,8 @@ func Setup(db *gorm.DB, cfg *config.Config) *gin.Engine {
 			notes.GET("", noteHandler.GetNotes)
 			notes.POST("", noteHandler.CreateNote)
 			notes.GET("/stats", noteHandler.GetUserStats)
+			notes.POST("/bulk", noteHandler.BulkUpdate)
+			notes.POST("/from-template/:id", templateHandler.CreateNoteFromTemplate)
 			
 			notes.POST("/:id/attachments", fileHandler.UploadFile)
 			notes.GET("/:id/attachments", fileHandler.GetAttachments)
@@ -68,12 +130,53 @@ func Setup(db *gorm.DB, cfg *config.Config) *gin.Engine {
 			notes.POST("/:id/share", shareHandler.CreateShareLink)
 			notes.GET("/:id/share", shareHandler.GetShareInfo)
 			notes.DELETE("/:id/share", shareHandler.DeleteShareLink)
+			notes.GET("/:id/shares", shareHandler.GetNoteShares)
+			notes.POST("/:id/shares", shareHandler.CreateShare)
+			notes.GET("/:id/shares/:shareId", shareHandler.GetShare)
+			notes.PATCH("/:id/shares/:shareId", shareHandler.UpdateShare)
+			notes.DELETE("/:id/shares/:shareId", shareHandler.DeleteShare)
+
+			notes.GET("/:id/revisions", revisionHandler.GetRevisions)
+			notes.GET("/:id/revisions/:rev", revisionHandler.GetRevision)
+			notes.GET("/:id/revisions/:rev/diff", revisionHandler.GetRevisionDiff)
+			notes.POST("/:id/revisions/:rev/restore", revisionHandler.RestoreRevision)
+
+			notes.GET("/:id/links", linkHandler.GetLinks)
+			notes.GET("/:id/backlinks", linkHandler.GetBacklinks)
+
+			notes.GET("/:id/rendered", renderHandler.GetRenderedNote)
+			notes.GET("/:id/analytics", analyticsHandler.GetNoteAnalytics)
+
+			notes.GET("/:id/permissions", permissionHandler.GetNotePermissions)
+			notes.POST("/:id/permissions", permissionHandler.GrantNotePermission)
+			notes.DELETE("/:id/permissions/:permissionId", permissionHandler.RevokeNotePermission)
 
 			notes.GET("/:id", noteHandler.GetNote)
 			notes.PUT("/:id", noteHandler.UpdateNote)
 			notes.DELETE("/:id", noteHandler.DeleteNote)
 		}
 
+		protected.GET("/search", searchHandler.Search)
+		protected.GET("/graph", linkHandler.GetGraph)
+		protected.GET("/shares", shareHandler.GetUserShares)
+		protected.GET("/shared-with-me", permissionHandler.GetSharedWithMe)
+		protected.GET("/export", exportHandler.Export)
+
+		imports := protected.Group("/import")
+		{
+			imports.POST("", importHandler.Import)
+			imports.POST("/enex", importHandler.ImportENEX)
+			imports.GET("/jobs", importHandler.GetJobs)
+			imports.GET("/jobs/:id", importHandler.GetJob)
+		}
+
+		trash := protected.Group("/trash")
+		{
+			trash.GET("", trashHandler.GetTrash)
+			trash.POST("/notes/:id/restore", trashHandler.RestoreNote)
+			trash.DELETE("/notes/:id", trashHandler.PermanentlyDeleteNote)
+		}
+
 		attachments := protected.Group("/attachments")
 		{
 			attachments.DELETE("/:id", fileHandler.DeleteAttachment)
@@ -82,6 +185,17 @@ func Setup(db *gorm.DB, cfg *config.Config) *gin.Engine {
 		user_storage := protected.Group("/user")
 		{
 			user_storage.GET("/storage", fileHandler.GetUserStorage)
+			user_storage.GET("/revision-policy", revisionHandler.GetPolicy)
+			user_storage.PUT("/revision-policy", revisionHandler.UpdatePolicy)
+		}
+
+		templates := protected.Group("/templates")
+		{
+			templates.GET("", templateHandler.GetTemplates)
+			templates.POST("", templateHandler.CreateTemplate)
+			templates.GET("/:id", templateHandler.GetTemplate)
+			templates.PUT("/:id", templateHandler.UpdateTemplate)
+			templates.DELETE("/:id", templateHandler.DeleteTemplate)
 		}
 
 		categories := protected.Group("/categories")
@@ -90,6 +204,9 @@ func Setup(db *gorm.DB, cfg *config.Config) *gin.Engine {
 			categories.POST("", categoryHandler.CreateCategory)
 			categories.PUT("/:id", categoryHandler.UpdateCategory)
 			categories.DELETE("/:id", categoryHandler.DeleteCategory)
+			categories.GET("/:id/permissions", permissionHandler.GetCategoryPermissions)
+			categories.POST("/:id/permissions", permissionHandler.GrantCategoryPermission)
+			categories.DELETE("/:id/permissions/:permissionId", permissionHandler.RevokeCategoryPermission)
 		}
 
 		tags := protected.Group("/tags")
@@ -101,21 +218,43 @@ func Setup(db *gorm.DB, cfg *config.Config) *gin.Engine {
 		}
 	}
 
+	// 浏览器无法为 WebSocket 设置请求头，协同编辑连接通过 ?token= 认证
+	live := api.Group("/notes")
+	live.Use(middleware.AuthMiddlewareWithQuery(db, cfg, sessionService))
+	live.Use(middleware.TwoFactorMiddleware(twoFactorService))
+	{
+		live.GET("/:id/live", liveHandler.Live)
+	}
+
 	files := api.Group("/files")
-	files.Use(middleware.AuthMiddlewareWithQuery(db, cfg))
+	files.Use(middleware.AuthMiddlewareWithQuery(db, cfg, sessionService))
+	files.Use(middleware.TwoFactorMiddleware(twoFactorService))
 	{
 		files.GET("/:id", fileHandler.ServeFile)        
 		files.GET("/:id/download", fileHandler.DownloadFile) 
 	}
 
 	admin := api.Group("/admin")
-	admin.Use(middleware.AuthMiddleware(db, cfg))
+	admin.Use(middleware.AuthMiddleware(db, cfg, sessionService))
+	admin.Use(middleware.TwoFactorMiddleware(twoFactorService))
 	admin.Use(middleware.AdminMiddleware())
 	{
 		admin.GET("/attachments/deleted", adminHandler.GetDeletedAttachments)
 		admin.DELETE("/attachments/:id/permanent", adminHandler.PermanentlyDeleteAttachment)
 		admin.POST("/attachments/:id/restore", adminHandler.RestoreAttachment)
 		admin.POST("/users/:userId/storage/recalculate", adminHandler.RecalculateUserStorage)
+		admin.POST("/analytics/retention", adminHandler.RunAnalyticsRetention)
+		admin.GET("/settings/two-factor", twoFactorHandler.GetPolicy)
+		admin.PUT("/settings/two-factor", twoFactorHandler.UpdatePolicy)
+	}
+
+	// 服务端渲染的分享页面
+	shared := router.Group("/shared")
+	{
+		shared.GET("/:code", sharePageHandler.ShowSharedNote)
+		shared.HEAD("/:code", sharePageHandler.ShowSharedNote)
+		shared.POST("/:code", sharePageHandler.UnlockSharedNote)
+		shared.GET("/:code/files/:id", sharePageHandler.ServeSharedFile)
 	}
 
 	router.GET("/health", func(c *gin.Context) {
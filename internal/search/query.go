@@ -0,0 +1,242 @@
+// Package search 解析笔记搜索框使用的结构化查询语法，例如：
+//
+//	tag:work category:"Projects/Q3" is:public updated:>2026-01-01 has:attachment -tag:draft "exact phrase"
+//
+// 支持的字段：tag、category、is、has、created、updated，字段或词前加 - 表示排除，
+// 双引号包裹的内容按原文匹配，其余词作为全文检索词。
+package search
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+const (
+	FieldText   = ""
+	FieldPhrase = "phrase"
+)
+
+var knownFields = map[string]bool{
+	"tag":      true,
+	"category": true,
+	"is":       true,
+	"has":      true,
+	"created":  true,
+	"updated":  true,
+}
+
+// Error 查询语法错误，Pos 为出错词在输入中的字符位置（从 0 开始）
+type Error struct {
+	Pos     int    `json:"position"`
+	Token   string `json:"token"`
+	Message string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("搜索语法错误：位置 %d 附近的 \"%s\"：%s", e.Pos, e.Token, e.Message)
+}
+
+type Term struct {
+	Field   string
+	Op      string
+	Value   string
+	Negated bool
+	Pos     int
+	Raw     string
+}
+
+type Query struct {
+	Terms []Term
+}
+
+// Parse 将查询字符串解析为搜索条件，语法错误时返回 *Error
+func Parse(input string) (*Query, error) {
+	runes := []rune(input)
+	query := &Query{}
+
+	for i := 0; i < len(runes); {
+		if unicode.IsSpace(runes[i]) {
+			i++
+			continue
+		}
+
+		start := i
+		term := Term{Pos: start}
+
+		if runes[i] == '-' && i+1 < len(runes) && !unicode.IsSpace(runes[i+1]) {
+			term.Negated = true
+			i++
+		}
+
+		if runes[i] == '"' {
+			value, next, err := readQuoted(runes, i)
+			if err != nil {
+				return nil, err
+			}
+			term.Field = FieldPhrase
+			term.Value = value
+			term.Raw = string(runes[start:next])
+			if strings.TrimSpace(value) == "" {
+				return nil, &Error{Pos: start, Token: term.Raw, Message: "短语不能为空"}
+			}
+			query.Terms = append(query.Terms, term)
+			i = next
+			continue
+		}
+
+		wordEnd := i
+		for wordEnd < len(runes) && !unicode.IsSpace(runes[wordEnd]) && runes[wordEnd] != ':' && runes[wordEnd] != '"' {
+			wordEnd++
+		}
+
+		if wordEnd < len(runes) && runes[wordEnd] == ':' && isFieldName(runes[i:wordEnd]) && !isURLLike(runes, wordEnd) {
+			field := strings.ToLower(string(runes[i:wordEnd]))
+			if !knownFields[field] {
+				end := skipToken(runes, wordEnd)
+				return nil, &Error{Pos: start, Token: string(runes[start:end]), Message: fmt.Sprintf("未知的搜索字段 %s", field)}
+			}
+
+			valueStart := wordEnd + 1
+			var value string
+			var next int
+			if valueStart < len(runes) && runes[valueStart] == '"' {
+				var err error
+				value, next, err = readQuoted(runes, valueStart)
+				if err != nil {
+					return nil, err
+				}
+			} else {
+				next = skipToken(runes, valueStart)
+				value = string(runes[valueStart:next])
+			}
+
+			term.Field = field
+			term.Raw = string(runes[start:next])
+			if strings.TrimSpace(value) == "" {
+				return nil, &Error{Pos: start, Token: term.Raw, Message: fmt.Sprintf("字段 %s 缺少取值", field)}
+			}
+
+			if field == "created" || field == "updated" {
+				term.Op, value = splitOperator(value)
+			}
+			term.Value = value
+
+			query.Terms = append(query.Terms, term)
+			i = next
+			continue
+		}
+
+		next := skipToken(runes, i)
+		term.Field = FieldText
+		term.Value = string(runes[i:next])
+		term.Raw = string(runes[start:next])
+		query.Terms = append(query.Terms, term)
+		i = next
+	}
+
+	for _, term := range query.Terms {
+		if err := validateTerm(term); err != nil {
+			return nil, err
+		}
+	}
+
+	return query, nil
+}
+
+// TextTerms 返回需要参与全文检索的词（未排除的普通词和短语）
+func (q *Query) TextTerms() []string {
+	var terms []string
+	for _, term := range q.Terms {
+		if term.Negated {
+			continue
+		}
+		if term.Field == FieldText || term.Field == FieldPhrase {
+			terms = append(terms, term.Value)
+		}
+	}
+	return terms
+}
+
+func validateTerm(term Term) *Error {
+	fail := func(message string) *Error {
+		return &Error{Pos: term.Pos, Token: term.Raw, Message: message}
+	}
+
+	switch term.Field {
+	case "is":
+		switch strings.ToLower(term.Value) {
+		case "public", "private":
+		default:
+			return fail("is 只支持 public 或 private")
+		}
+	case "has":
+		switch strings.ToLower(term.Value) {
+		case "attachment", "attachments", "image", "images", "tag", "tags", "category":
+		default:
+			return fail("has 只支持 attachment、image、tag 或 category")
+		}
+	case "created", "updated":
+		if _, _, err := parseDateRange(term.Op, term.Value); err != nil {
+			return fail(err.Error())
+		}
+	case "category":
+		for _, segment := range strings.Split(term.Value, "/") {
+			if strings.TrimSpace(segment) == "" {
+				return fail("分类路径格式错误")
+			}
+		}
+	}
+
+	return nil
+}
+
+func readQuoted(runes []rune, start int) (string, int, error) {
+	var sb strings.Builder
+	for i := start + 1; i < len(runes); i++ {
+		if runes[i] == '\\' && i+1 < len(runes) && (runes[i+1] == '"' || runes[i+1] == '\\') {
+			sb.WriteRune(runes[i+1])
+			i++
+			continue
+		}
+		if runes[i] == '"' {
+			return sb.String(), i + 1, nil
+		}
+		sb.WriteRune(runes[i])
+	}
+	return "", 0, &Error{Pos: start, Token: string(runes[start:]), Message: "引号未闭合"}
+}
+
+func skipToken(runes []rune, start int) int {
+	i := start
+	for i < len(runes) && !unicode.IsSpace(runes[i]) {
+		i++
+	}
+	return i
+}
+
+func isFieldName(runes []rune) bool {
+	if len(runes) == 0 {
+		return false
+	}
+	for _, r := range runes {
+		if !unicode.IsLetter(r) || r > unicode.MaxASCII {
+			return false
+		}
+	}
+	return true
+}
+
+// isURLLike 避免把 http://example.com 之类的文本当成字段
+func isURLLike(runes []rune, colon int) bool {
+	return colon+2 < len(runes) && runes[colon+1] == '/' && runes[colon+2] == '/'
+}
+
+func splitOperator(value string) (string, string) {
+	for _, op := range []string{">=", "<=", ">", "<", "="} {
+		if strings.HasPrefix(value, op) {
+			return op, strings.TrimPrefix(value, op)
+		}
+	}
+	return "", value
+}
@@ -0,0 +1,276 @@
+package search
+
+import (
+	"fmt"
+	"notes-backend/internal/models"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const dateLayout = "2006-01-02"
+
+// Scopes 将查询条件转换为作用于 notes 表的 GORM scope。
+// includeText 为 false 时普通词不生成过滤条件，由调用方自行处理（例如交给全文索引）
+func (q *Query) Scopes(db *gorm.DB, userID uint, includeText bool) ([]func(*gorm.DB) *gorm.DB, error) {
+	resolver := &resolver{db: db, userID: userID}
+	var scopes []func(*gorm.DB) *gorm.DB
+
+	for _, term := range q.Terms {
+		if term.Field == FieldText && !term.Negated && !includeText {
+			continue
+		}
+
+		scope, err := resolver.scope(term)
+		if err != nil {
+			return nil, err
+		}
+		scopes = append(scopes, scope)
+	}
+
+	return scopes, nil
+}
+
+type resolver struct {
+	db         *gorm.DB
+	userID     uint
+	categories []models.Category
+	loaded     bool
+}
+
+func (r *resolver) scope(term Term) (func(*gorm.DB) *gorm.DB, error) {
+	fail := func(message string) error {
+		return &Error{Pos: term.Pos, Token: term.Raw, Message: message}
+	}
+
+	var condition string
+	var args []interface{}
+
+	switch term.Field {
+	case FieldText, FieldPhrase:
+		pattern := "%" + escapeLike(term.Value) + "%"
+		condition = "(notes.title ILIKE ? OR COALESCE(notes.content, '') ILIKE ?)"
+		args = []interface{}{pattern, pattern}
+
+	case "tag":
+		var tagIDs []uint
+		err := r.db.Model(&models.Tag{}).
+			Where("user_id = ? AND LOWER(name) = LOWER(?)", r.userID, term.Value).
+			Pluck("id", &tagIDs).Error
+		if err != nil {
+			return nil, err
+		}
+		if len(tagIDs) == 0 {
+			// 排除不存在的标签不影响结果
+			if term.Negated {
+				return noopScope, nil
+			}
+			return nil, fail(fmt.Sprintf("标签 %s 不存在", term.Value))
+		}
+		condition = "notes.id IN (SELECT note_id FROM note_tags WHERE tag_id IN ?)"
+		args = []interface{}{tagIDs}
+
+	case "category":
+		categoryIDs, err := r.resolveCategory(term.Value)
+		if err != nil {
+			return nil, err
+		}
+		if len(categoryIDs) == 0 {
+			if term.Negated {
+				return noopScope, nil
+			}
+			return nil, fail(fmt.Sprintf("分类 %s 不存在", term.Value))
+		}
+		if term.Negated {
+			return func(db *gorm.DB) *gorm.DB {
+				return db.Where("(notes.category_id IS NULL OR notes.category_id NOT IN ?)", categoryIDs)
+			}, nil
+		}
+		condition = "notes.category_id IN ?"
+		args = []interface{}{categoryIDs}
+
+	case "is":
+		condition = "notes.is_public = ?"
+		args = []interface{}{strings.ToLower(term.Value) == "public"}
+
+	case "has":
+		switch strings.ToLower(term.Value) {
+		case "attachment", "attachments":
+			condition = "EXISTS (SELECT 1 FROM attachments WHERE attachments.note_id = notes.id AND attachments.deleted_at IS NULL)"
+		case "image", "images":
+			condition = "EXISTS (SELECT 1 FROM attachments WHERE attachments.note_id = notes.id AND attachments.deleted_at IS NULL AND attachments.is_image = true)"
+		case "tag", "tags":
+			condition = "EXISTS (SELECT 1 FROM note_tags WHERE note_tags.note_id = notes.id)"
+		case "category":
+			condition = "notes.category_id IS NOT NULL"
+		}
+
+	case "created", "updated":
+		from, to, err := parseDateRange(term.Op, term.Value)
+		if err != nil {
+			return nil, fail(err.Error())
+		}
+		column := "notes.created_at"
+		if term.Field == "updated" {
+			column = "notes.updated_at"
+		}
+		var parts []string
+		if from != nil {
+			parts = append(parts, column+" >= ?")
+			args = append(args, *from)
+		}
+		if to != nil {
+			parts = append(parts, column+" < ?")
+			args = append(args, *to)
+		}
+		condition = "(" + strings.Join(parts, " AND ") + ")"
+
+	default:
+		return nil, fail(fmt.Sprintf("未知的搜索字段 %s", term.Field))
+	}
+
+	if term.Negated {
+		condition = "NOT " + condition
+	}
+
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Where(condition, args...)
+	}, nil
+}
+
+func noopScope(db *gorm.DB) *gorm.DB {
+	return db
+}
+
+// resolveCategory 按路径（如 Projects/Q3）查找分类，返回该分类及其所有子分类的 ID。
+// 只有一级名称时匹配任意层级的同名分类
+func (r *resolver) resolveCategory(path string) ([]uint, error) {
+	if !r.loaded {
+		if err := r.db.Where("user_id = ?", r.userID).Find(&r.categories).Error; err != nil {
+			return nil, err
+		}
+		r.loaded = true
+	}
+
+	children := make(map[uint][]uint)
+	for _, category := range r.categories {
+		if category.ParentID != nil {
+			children[*category.ParentID] = append(children[*category.ParentID], category.ID)
+		}
+	}
+
+	segments := strings.Split(path, "/")
+	var matches []uint
+
+	if len(segments) == 1 {
+		for _, category := range r.categories {
+			if strings.EqualFold(category.Name, strings.TrimSpace(segments[0])) {
+				matches = append(matches, category.ID)
+			}
+		}
+	} else {
+		var parents []*uint
+		parents = append(parents, nil)
+		for _, segment := range segments {
+			segment = strings.TrimSpace(segment)
+			var next []*uint
+			for i := range r.categories {
+				category := &r.categories[i]
+				if !strings.EqualFold(category.Name, segment) {
+					continue
+				}
+				for _, parent := range parents {
+					if (parent == nil && category.ParentID == nil) ||
+						(parent != nil && category.ParentID != nil && *parent == *category.ParentID) {
+						next = append(next, &category.ID)
+						break
+					}
+				}
+			}
+			parents = next
+		}
+		for _, id := range parents {
+			matches = append(matches, *id)
+		}
+	}
+
+	// 收集子分类
+	seen := make(map[uint]bool)
+	var result []uint
+	for len(matches) > 0 {
+		id := matches[0]
+		matches = matches[1:]
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		result = append(result, id)
+		matches = append(matches, children[id]...)
+	}
+
+	return result, nil
+}
+
+// parseDateRange 解析日期条件，返回 [from, to) 区间，日期按服务器本地时区的整天计算。
+// 支持 2026-01-01、>2026-01-01、>=、<、<=、= 以及 2026-01-01..2026-02-01
+func parseDateRange(op, value string) (*time.Time, *time.Time, error) {
+	if strings.Contains(value, "..") {
+		if op != "" {
+			return nil, nil, fmt.Errorf("日期区间不能与比较运算符同时使用")
+		}
+		parts := strings.SplitN(value, "..", 2)
+		var from, to *time.Time
+		if parts[0] != "" {
+			start, err := parseDate(parts[0])
+			if err != nil {
+				return nil, nil, err
+			}
+			from = &start
+		}
+		if parts[1] != "" {
+			end, err := parseDate(parts[1])
+			if err != nil {
+				return nil, nil, err
+			}
+			end = end.AddDate(0, 0, 1)
+			to = &end
+		}
+		if from == nil && to == nil {
+			return nil, nil, fmt.Errorf("日期区间格式错误")
+		}
+		return from, to, nil
+	}
+
+	day, err := parseDate(value)
+	if err != nil {
+		return nil, nil, err
+	}
+	nextDay := day.AddDate(0, 0, 1)
+
+	switch op {
+	case ">":
+		return &nextDay, nil, nil
+	case ">=":
+		return &day, nil, nil
+	case "<":
+		return nil, &day, nil
+	case "<=":
+		return nil, &nextDay, nil
+	default:
+		return &day, &nextDay, nil
+	}
+}
+
+func parseDate(value string) (time.Time, error) {
+	day, err := time.ParseInLocation(dateLayout, value, time.Local)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("日期格式应为 YYYY-MM-DD")
+	}
+	return day, nil
+}
+
+func escapeLike(value string) string {
+	replacer := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+	return replacer.Replace(value)
+}
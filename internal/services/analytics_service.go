@@ -0,0 +1,877 @@
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"notes-backend/internal/config"
+	"notes-backend/internal/models"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mssola/useragent"
+	"gorm.io/gorm"
+)
+
+// ErrAnalyticsRange 统计的起止时间无效或时间段过多
+var ErrAnalyticsRange = errors.New("统计时间范围无效或过大")
+
+const (
+	rollupWatermarkKey = "analytics_rollup_watermark"
+	dayLayout          = "2006-01-02"
+	// 单次查询最多返回的时间段数
+	maxAnalyticsBuckets = 1000
+	topReferrerCount    = 10
+	backfillBatchSize   = 500
+	retentionBatchSize  = 10000
+)
+
+// visitDimension 汇总维度及其在 note_visits 中对应的列，column 为空表示不分组的总计。
+// bots 为 true 的维度只统计机器人访问，其余维度只统计人工访问
+type visitDimension struct {
+	name   string
+	column string
+	bots   bool
+}
+
+var visitDimensions = []visitDimension{
+	{name: models.VisitDimensionTotal},
+	{name: models.VisitDimensionShareLink, column: "COALESCE(CAST(share_link_id AS TEXT), '')"},
+	{name: models.VisitDimensionReferrer, column: "COALESCE(referer_host, '')"},
+	{name: models.VisitDimensionBrowser, column: "COALESCE(browser, '')"},
+	{name: models.VisitDimensionOS, column: "COALESCE(os, '')"},
+	{name: models.VisitDimensionBot, column: "COALESCE(bot_reason, '')", bots: true},
+}
+
+// defaultBotUserAgents 未配置 analytics.bot_user_agents 时使用的 User-Agent 关键字：
+// 搜索引擎和 SEO 爬虫、聊天软件的链接预览、监控服务以及常见的 HTTP 客户端库
+var defaultBotUserAgents = []string{
+	"bot", "crawl", "spider", "slurp", "archive.org", "yandex", "sogou", "ahrefs", "semrush",
+	"facebookexternalhit", "facebookcatalog", "whatsapp", "skypeuripreview", "bingpreview", "embedly",
+	"preview", "vkshare", "pinterest", "headlesschrome", "phantomjs", "lighthouse",
+	"pingdom", "uptimerobot", "statuscake", "site24x7", "check_http", "nagios", "zabbix", "monitor",
+	"curl", "wget", "python-requests", "python-urllib", "aiohttp", "httpx", "go-http-client",
+	"java/", "okhttp", "libwww-perl", "axios", "node-fetch", "scrapy",
+}
+
+func (d visitDimension) value() string {
+	if d.column == "" {
+		return "''"
+	}
+	return d.column
+}
+
+func (d visitDimension) group() string {
+	if d.column == "" {
+		return "note_id"
+	}
+	return "note_id, " + d.column
+}
+
+// AnalyticsService 笔记访问统计。访问记录按天汇总到 visit_rollups，
+// 已汇总的日期直接读取汇总表，当天及尚未汇总的日期从访问记录实时计算
+type AnalyticsService struct {
+	db  *gorm.DB
+	cfg config.AnalyticsConfig
+	loc *time.Location
+	// 小写的机器人 User-Agent 关键字
+	botUserAgents []string
+
+	// 汇总任务串行执行
+	mu sync.Mutex
+}
+
+func NewAnalyticsService(db *gorm.DB, cfg config.AnalyticsConfig) *AnalyticsService {
+	loc := time.Local
+	if cfg.Timezone != "" {
+		if l, err := time.LoadLocation(cfg.Timezone); err == nil {
+			loc = l
+		} else {
+			fmt.Printf("Invalid analytics timezone %q, using local time: %v\n", cfg.Timezone, err)
+		}
+	}
+
+	patterns := cfg.BotUserAgents
+	if len(patterns) == 0 {
+		patterns = defaultBotUserAgents
+	}
+	botUserAgents := make([]string, 0, len(patterns))
+	for _, pattern := range patterns {
+		if pattern = strings.ToLower(strings.TrimSpace(pattern)); pattern != "" {
+			botUserAgents = append(botUserAgents, pattern)
+		}
+	}
+
+	return &AnalyticsService{db: db, cfg: cfg, loc: loc, botUserAgents: botUserAgents}
+}
+
+// GetNoteAnalytics 获取笔记在指定时间段内的访问统计。
+// 按小时统计直接查询访问记录，按天和按周统计使用每日汇总
+func (s *AnalyticsService) GetNoteAnalytics(noteID, userID uint, req *models.AnalyticsRequest) (*models.NoteAnalytics, error) {
+	var note models.Note
+	if err := s.db.Select("id").Where("id = ? AND user_id = ?", noteID, userID).First(&note).Error; err != nil {
+		return nil, err
+	}
+
+	from, to, err := s.analyticsRange(req)
+	if err != nil {
+		return nil, err
+	}
+
+	analytics := &models.NoteAnalytics{
+		NoteID:   noteID,
+		Interval: req.Interval,
+		From:     from,
+		To:       to,
+	}
+
+	var rollups []models.VisitRollup
+	if req.Interval == "hour" {
+		if analytics.Series, err = s.hourlySeries(noteID, from, to); err != nil {
+			return nil, err
+		}
+		if rollups, err = s.aggregateVisits(noteID, from, to); err != nil {
+			return nil, err
+		}
+	} else {
+		if rollups, err = s.dailyRollups(noteID, from, s.startOfDay(to.Add(-time.Nanosecond))); err != nil {
+			return nil, err
+		}
+		analytics.Series = s.dailySeries(rollups, req.Interval, from, to)
+	}
+
+	counts := make(map[string]map[string]*models.AnalyticsCount)
+	for _, rollup := range rollups {
+		switch rollup.Dimension {
+		case models.VisitDimensionTotal:
+			analytics.TotalViews += rollup.Views
+			analytics.UniqueVisitors += rollup.Visitors
+			continue
+		case models.VisitDimensionBot:
+			analytics.BotViews += rollup.Views
+		}
+		if counts[rollup.Dimension] == nil {
+			counts[rollup.Dimension] = make(map[string]*models.AnalyticsCount)
+		}
+		count := counts[rollup.Dimension][rollup.Value]
+		if count == nil {
+			count = &models.AnalyticsCount{Name: rollup.Value}
+			counts[rollup.Dimension][rollup.Value] = count
+		}
+		count.Views += rollup.Views
+		count.Visitors += rollup.Visitors
+	}
+
+	// 按天和按周统计时，汇总表中的访客数只在当天内去重。原始访问记录仍保留时按整个区间重新去重
+	if req.Interval != "hour" {
+		visitors, ok, err := s.rangeVisitors(noteID, from, to)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			analytics.UniqueVisitors = visitors
+		} else {
+			analytics.UniqueVisitorsEstimated = true
+		}
+	}
+
+	analytics.Referrers = sortedCounts(counts[models.VisitDimensionReferrer], topReferrerCount)
+	analytics.Browsers = sortedCounts(counts[models.VisitDimensionBrowser], 0)
+	analytics.OperatingSystems = sortedCounts(counts[models.VisitDimensionOS], 0)
+	analytics.Bots = sortedCounts(counts[models.VisitDimensionBot], 0)
+
+	if analytics.ShareLinks, err = s.shareLinkBreakdown(noteID, counts[models.VisitDimensionShareLink]); err != nil {
+		return nil, err
+	}
+
+	return analytics, nil
+}
+
+// analyticsRange 计算统计区间 [from, to)，from 对齐到时间段的开始
+func (s *AnalyticsService) analyticsRange(req *models.AnalyticsRequest) (time.Time, time.Time, error) {
+	to := time.Now().In(s.loc)
+	if req.To != nil {
+		to = req.To.In(s.loc)
+	}
+
+	var from time.Time
+	if req.From != nil {
+		from = req.From.In(s.loc)
+	} else {
+		switch req.Interval {
+		case "hour":
+			from = to.Add(-24 * time.Hour)
+		case "week":
+			from = to.AddDate(0, 0, -7*12)
+		default:
+			from = to.AddDate(0, 0, -30)
+		}
+	}
+
+	var step time.Duration
+	switch req.Interval {
+	case "hour":
+		from = from.Truncate(time.Hour)
+		step = time.Hour
+	case "week":
+		from = s.startOfWeek(from)
+		step = 7 * 24 * time.Hour
+	default:
+		from = s.startOfDay(from)
+		step = 24 * time.Hour
+	}
+
+	if !from.Before(to) || to.Sub(from)/step > maxAnalyticsBuckets {
+		return time.Time{}, time.Time{}, ErrAnalyticsRange
+	}
+	return from, to, nil
+}
+
+func (s *AnalyticsService) hourlySeries(noteID uint, from, to time.Time) ([]models.AnalyticsBucket, error) {
+	var rows []struct {
+		Bucket   time.Time
+		Views    int64
+		Visitors int64
+	}
+	err := s.db.Model(&models.NoteVisit{}).
+		Select("date_trunc('hour', visited_at) AS bucket, COUNT(*) AS views, COUNT(DISTINCT visitor_key) AS visitors").
+		Where("note_id = ? AND visited_at >= ? AND visited_at < ? AND is_bot = ?", noteID, from, to, false).
+		Group("bucket").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	byHour := make(map[int64]int, len(rows))
+	for i, row := range rows {
+		byHour[row.Bucket.Unix()] = i
+	}
+
+	var series []models.AnalyticsBucket
+	for t := from; t.Before(to); t = t.Add(time.Hour) {
+		bucket := models.AnalyticsBucket{Time: t}
+		if i, ok := byHour[t.Unix()]; ok {
+			bucket.Views = rows[i].Views
+			bucket.Visitors = rows[i].Visitors
+		}
+		series = append(series, bucket)
+	}
+	return series, nil
+}
+
+// dailySeries 将每日总计合并为按天或按周的时间序列，没有访问的时间段补 0
+func (s *AnalyticsService) dailySeries(rollups []models.VisitRollup, interval string, from, to time.Time) []models.AnalyticsBucket {
+	next := func(t time.Time) time.Time { return t.AddDate(0, 0, 1) }
+	bucketOf := s.startOfDay
+	if interval == "week" {
+		next = func(t time.Time) time.Time { return t.AddDate(0, 0, 7) }
+		bucketOf = s.startOfWeek
+	}
+
+	var series []models.AnalyticsBucket
+	index := make(map[int64]int)
+	for t := from; t.Before(to); t = next(t) {
+		index[t.Unix()] = len(series)
+		series = append(series, models.AnalyticsBucket{Time: t})
+	}
+
+	for _, rollup := range rollups {
+		if rollup.Dimension != models.VisitDimensionTotal {
+			continue
+		}
+		if i, ok := index[bucketOf(rollup.Day).Unix()]; ok {
+			series[i].Views += rollup.Views
+			series[i].Visitors += rollup.Visitors
+		}
+	}
+	return series
+}
+
+// dailyRollups 获取 [fromDay, toDay] 每天的汇总，已汇总的日期读取汇总表，其余日期实时计算
+func (s *AnalyticsService) dailyRollups(noteID uint, fromDay, toDay time.Time) ([]models.VisitRollup, error) {
+	watermark, ok, err := s.watermark()
+	if err != nil {
+		return nil, err
+	}
+
+	var rollups []models.VisitRollup
+	day := fromDay
+	if ok && !watermark.Before(fromDay) {
+		end := watermark
+		if toDay.Before(end) {
+			end = toDay
+		}
+		err := s.db.Where("note_id = ? AND day >= ? AND day <= ?", noteID, fromDay.Format(dayLayout), end.Format(dayLayout)).
+			Find(&rollups).Error
+		if err != nil {
+			return nil, err
+		}
+		for i := range rollups {
+			// date 列读出为 UTC 零点，换算为统计时区的零点
+			d := rollups[i].Day
+			rollups[i].Day = time.Date(d.Year(), d.Month(), d.Day(), 0, 0, 0, 0, s.loc)
+		}
+		day = watermark.AddDate(0, 0, 1)
+	}
+
+	var days []time.Time
+	for ; !day.After(toDay); day = day.AddDate(0, 0, 1) {
+		days = append(days, day)
+	}
+	if len(days) == 0 {
+		return rollups, nil
+	}
+
+	rows, err := s.aggregateVisitsByDay(noteID, days)
+	if err != nil {
+		return nil, err
+	}
+	return append(rollups, rows...), nil
+}
+
+// dayRollupRow 按天分组的统计结果，Bucket 为所在日期在 days 中的序号（从 1 开始）
+type dayRollupRow struct {
+	NoteID   uint
+	Bucket   int
+	Value    string
+	Views    int64
+	Visitors int64
+}
+
+// aggregateVisitsByDay 按各维度统计笔记在 days 各天的访问记录，每个维度只查询一次。
+// 日期边界在统计时区中计算后传给 width_bucket 分组，不依赖数据库的时区设置
+func (s *AnalyticsService) aggregateVisitsByDay(noteID uint, days []time.Time) ([]models.VisitRollup, error) {
+	bounds := make([]string, len(days))
+	for i, day := range days {
+		bounds[i] = strconv.Quote(day.Format(time.RFC3339))
+	}
+	thresholds := "{" + strings.Join(bounds, ",") + "}"
+	start, end := days[0], days[len(days)-1].AddDate(0, 0, 1)
+
+	var rollups []models.VisitRollup
+	for _, dimension := range visitDimensions {
+		var rows []dayRollupRow
+		err := s.db.Model(&models.NoteVisit{}).
+			Select("note_id, width_bucket(visited_at, CAST(? AS TIMESTAMPTZ[])) AS bucket, "+
+				dimension.value()+" AS value, COUNT(*) AS views, COUNT(DISTINCT visitor_key) AS visitors", thresholds).
+			Where("note_id = ? AND visited_at >= ? AND visited_at < ? AND is_bot = ?", noteID, start, end, dimension.bots).
+			Group(dimension.group() + ", bucket").
+			Scan(&rows).Error
+		if err != nil {
+			return nil, err
+		}
+		for _, row := range rows {
+			if row.Bucket < 1 || row.Bucket > len(days) {
+				continue
+			}
+			rollups = append(rollups, models.VisitRollup{
+				NoteID:    row.NoteID,
+				Day:       days[row.Bucket-1],
+				Dimension: dimension.name,
+				Value:     row.Value,
+				Views:     row.Views,
+				Visitors:  row.Visitors,
+			})
+		}
+	}
+	return rollups, nil
+}
+
+// rangeVisitors 统计笔记在 [from, to) 内按访客去重的人数。区间开始于原始访问记录的保留期之前时返回 false
+func (s *AnalyticsService) rangeVisitors(noteID uint, from, to time.Time) (int64, bool, error) {
+	if s.cfg.RawRetentionDays >= 0 && from.Before(s.startOfDay(time.Now()).AddDate(0, 0, -s.cfg.RawRetentionDays)) {
+		return 0, false, nil
+	}
+
+	var visitors int64
+	err := s.db.Model(&models.NoteVisit{}).
+		Select("COUNT(DISTINCT visitor_key)").
+		Where("note_id = ? AND visited_at >= ? AND visited_at < ? AND is_bot = ?", noteID, from, to, false).
+		Scan(&visitors).Error
+	if err != nil {
+		return 0, false, err
+	}
+	return visitors, true, nil
+}
+
+// aggregateVisits 按各维度统计笔记在 [start, end) 内的访问记录
+func (s *AnalyticsService) aggregateVisits(noteID uint, start, end time.Time) ([]models.VisitRollup, error) {
+	var rollups []models.VisitRollup
+	for _, dimension := range visitDimensions {
+		var rows []models.VisitRollup
+		err := s.db.Model(&models.NoteVisit{}).
+			Select("note_id, "+dimension.value()+" AS value, COUNT(*) AS views, COUNT(DISTINCT visitor_key) AS visitors").
+			Where("note_id = ? AND visited_at >= ? AND visited_at < ? AND is_bot = ?", noteID, start, end, dimension.bots).
+			Group(dimension.group()).
+			Scan(&rows).Error
+		if err != nil {
+			return nil, err
+		}
+		for i := range rows {
+			rows[i].Dimension = dimension.name
+		}
+		rollups = append(rollups, rows...)
+	}
+	return rollups, nil
+}
+
+// shareLinkBreakdown 各分享链接的访问量。笔记当前的分享链接即使没有访问也会列出，
+// 已删除的分享链接和不经过分享链接的访问只在有访问量时列出
+func (s *AnalyticsService) shareLinkBreakdown(noteID uint, counts map[string]*models.AnalyticsCount) ([]models.ShareLinkAnalytics, error) {
+	var shareLinks []models.ShareLink
+	if err := s.db.Where("note_id = ?", noteID).Order("id").Find(&shareLinks).Error; err != nil {
+		return nil, err
+	}
+
+	breakdown := make([]models.ShareLinkAnalytics, 0, len(shareLinks)+1)
+	for _, shareLink := range shareLinks {
+		id := shareLink.ID
+		count := counts[strconv.FormatUint(uint64(id), 10)]
+		delete(counts, strconv.FormatUint(uint64(id), 10))
+		if !shareLink.IsActive && count == nil {
+			continue
+		}
+
+		item := models.ShareLinkAnalytics{
+			ShareLinkID: &id,
+			Label:       shareLink.Label,
+			ShareCode:   shareLink.ShareCode,
+			Deleted:     !shareLink.IsActive,
+		}
+		if count != nil {
+			item.Views = count.Views
+			item.Visitors = count.Visitors
+		}
+		breakdown = append(breakdown, item)
+	}
+
+	// 剩余的为不经过分享链接的访问，以及已彻底删除的分享链接
+	for value, count := range counts {
+		item := models.ShareLinkAnalytics{Views: count.Views, Visitors: count.Visitors}
+		if value != "" {
+			id, err := strconv.ParseUint(value, 10, 32)
+			if err != nil {
+				continue
+			}
+			shareLinkID := uint(id)
+			item.ShareLinkID = &shareLinkID
+			item.Deleted = true
+		}
+		breakdown = append(breakdown, item)
+	}
+
+	sort.SliceStable(breakdown, func(i, j int) bool {
+		return breakdown[i].Views > breakdown[j].Views
+	})
+	return breakdown, nil
+}
+
+// ApplyRetention 汇总尚未汇总的完整日期，然后删除超过保留天数的原始访问记录。
+// 只删除已汇总日期内的记录，保证删除前每条记录都已计入按天统计
+func (s *AnalyticsService) ApplyRetention() (*models.RetentionReport, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	report := &models.RetentionReport{
+		RawRetentionDays: s.cfg.RawRetentionDays,
+		StartedAt:        time.Now(),
+	}
+
+	days, visits, err := s.rollupPending()
+	report.RolledUpDays = days
+	report.RolledUpVisits = visits
+	if err != nil {
+		return report, err
+	}
+
+	watermark, ok, err := s.watermark()
+	if err != nil {
+		return report, err
+	}
+
+	if ok {
+		report.RolledUpThrough = watermark.Format(dayLayout)
+
+		if s.cfg.RawRetentionDays >= 0 {
+			cutoff := s.startOfDay(time.Now()).AddDate(0, 0, -s.cfg.RawRetentionDays)
+			if rolledEnd := watermark.AddDate(0, 0, 1); rolledEnd.Before(cutoff) {
+				cutoff = rolledEnd
+			}
+			report.Cutoff = &cutoff
+
+			deleted, err := s.deleteVisitsBefore(cutoff)
+			report.DeletedVisits = deleted
+			if err != nil {
+				return report, err
+			}
+		}
+	}
+
+	report.FinishedAt = time.Now()
+	return report, nil
+}
+
+// rollupPending 将尚未汇总的完整日期按天写入汇总表，返回汇总的天数和访问记录数
+func (s *AnalyticsService) rollupPending() (int, int64, error) {
+	day, err := s.nextRollupDay()
+	if err != nil || day.IsZero() {
+		return 0, 0, err
+	}
+
+	// 访问记录异步写入，零点一小时后再汇总前一天
+	cutoff := s.startOfDay(time.Now().In(s.loc).Add(-time.Hour))
+
+	rolled := 0
+	var visits int64
+	for ; day.Before(cutoff); day = day.AddDate(0, 0, 1) {
+		count, err := s.rollupDay(day)
+		if err != nil {
+			return rolled, visits, fmt.Errorf("汇总 %s 的访问记录失败: %v", day.Format(dayLayout), err)
+		}
+		rolled++
+		visits += count
+	}
+	return rolled, visits, nil
+}
+
+// deleteVisitsBefore 分批删除 cutoff 之前的访问记录，避免长时间锁表
+func (s *AnalyticsService) deleteVisitsBefore(cutoff time.Time) (int64, error) {
+	var deleted int64
+	for {
+		result := s.db.Exec("DELETE FROM note_visits WHERE id IN (SELECT id FROM note_visits WHERE visited_at < ? LIMIT ?)",
+			cutoff, retentionBatchSize)
+		if result.Error != nil {
+			return deleted, result.Error
+		}
+		deleted += result.RowsAffected
+		if result.RowsAffected < retentionBatchSize {
+			return deleted, nil
+		}
+	}
+}
+
+// nextRollupDay 下一个需要汇总的日期，从未汇总过时为最早的访问日期，没有访问记录时为零值
+func (s *AnalyticsService) nextRollupDay() (time.Time, error) {
+	watermark, ok, err := s.watermark()
+	if err != nil {
+		return time.Time{}, err
+	}
+	if ok {
+		return watermark.AddDate(0, 0, 1), nil
+	}
+
+	var first *time.Time
+	if err := s.db.Model(&models.NoteVisit{}).Select("MIN(visited_at)").Scan(&first).Error; err != nil {
+		return time.Time{}, err
+	}
+	if first == nil {
+		return time.Time{}, nil
+	}
+	return s.startOfDay(*first), nil
+}
+
+// rollupDay 重新汇总某一天的访问记录并推进汇总进度，返回当天的访问记录数
+func (s *AnalyticsService) rollupDay(day time.Time) (int64, error) {
+	date := day.Format(dayLayout)
+	start, end := day, day.AddDate(0, 0, 1)
+
+	var visits int64
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("day = ?", date).Delete(&models.VisitRollup{}).Error; err != nil {
+			return err
+		}
+
+		for _, dimension := range visitDimensions {
+			err := tx.Exec("INSERT INTO visit_rollups (note_id, day, dimension, value, views, visitors) "+
+				"SELECT note_id, CAST(? AS DATE), CAST(? AS TEXT), "+dimension.value()+", COUNT(*), COUNT(DISTINCT visitor_key) "+
+				"FROM note_visits WHERE visited_at >= ? AND visited_at < ? AND is_bot = ? GROUP BY "+dimension.group(),
+				date, dimension.name, start, end, dimension.bots).Error
+			if err != nil {
+				return err
+			}
+		}
+
+		err := tx.Model(&models.VisitRollup{}).
+			Where("day = ? AND dimension = ?", date, models.VisitDimensionTotal).
+			Select("COALESCE(SUM(views), 0)").Scan(&visits).Error
+		if err != nil {
+			return err
+		}
+
+		return tx.Save(&models.SystemConfig{
+			Key:         rollupWatermarkKey,
+			Value:       date,
+			Description: "访问统计已汇总到的日期",
+			IsActive:    true,
+		}).Error
+	})
+	return visits, err
+}
+
+// watermark 最后一个已汇总的日期
+func (s *AnalyticsService) watermark() (time.Time, bool, error) {
+	var config models.SystemConfig
+	if err := s.db.Where("key = ?", rollupWatermarkKey).First(&config).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return time.Time{}, false, nil
+		}
+		return time.Time{}, false, err
+	}
+
+	day, err := time.ParseInLocation(dayLayout, config.Value, s.loc)
+	if err != nil {
+		return time.Time{}, false, nil
+	}
+	return day, true, nil
+}
+
+// BackfillVisits 为早期的访问记录补充访客标识、浏览器、操作系统和来源域名，并按当前配置处理 IP
+func (s *AnalyticsService) BackfillVisits() {
+	filled := 0
+	for {
+		var visits []models.NoteVisit
+		err := s.db.Select("id, viewer_id, visitor_ip, user_agent, referer").
+			Where("visitor_key IS NULL").Order("id").Limit(backfillBatchSize).
+			Find(&visits).Error
+		if err != nil {
+			fmt.Printf("Visit backfill failed: %v\n", err)
+			return
+		}
+		if len(visits) == 0 {
+			break
+		}
+
+		for i := range visits {
+			// 早期记录没有保存请求方法和请求头，只按 User-Agent 识别机器人
+			s.describeVisit(&visits[i], nil)
+			err := s.db.Model(&models.NoteVisit{}).Where("id = ?", visits[i].ID).Updates(map[string]interface{}{
+				"visitor_ip":   visits[i].VisitorIP,
+				"visitor_key":  visits[i].VisitorKey,
+				"browser":      visits[i].Browser,
+				"os":           visits[i].OS,
+				"referer_host": visits[i].RefererHost,
+				"is_bot":       visits[i].IsBot,
+				"bot_reason":   visits[i].BotReason,
+			}).Error
+			if err != nil {
+				fmt.Printf("Visit backfill failed: %v\n", err)
+				return
+			}
+		}
+		filled += len(visits)
+	}
+
+	if filled > 0 {
+		fmt.Printf("Visit backfill completed: %d visits\n", filled)
+	}
+}
+
+// StartRetention 启动后台任务，补充历史访问记录后按配置的间隔汇总访问统计并清理过期的访问记录
+func (s *AnalyticsService) StartRetention() {
+	interval := time.Duration(s.cfg.RollupIntervalMinutes) * time.Minute
+	if interval <= 0 {
+		interval = time.Hour
+	}
+
+	go func() {
+		s.BackfillVisits()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			report, err := s.ApplyRetention()
+			if err != nil {
+				fmt.Printf("Visit retention failed: %v\n", err)
+			} else if report.RolledUpDays > 0 || report.DeletedVisits > 0 {
+				fmt.Printf("Visit retention completed: %d days rolled up, %d visits deleted\n", report.RolledUpDays, report.DeletedVisits)
+			}
+			<-ticker.C
+		}
+	}()
+}
+
+func (s *AnalyticsService) startOfDay(t time.Time) time.Time {
+	t = t.In(s.loc)
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, s.loc)
+}
+
+// startOfWeek 一周从周一开始
+func (s *AnalyticsService) startOfWeek(t time.Time) time.Time {
+	day := s.startOfDay(t)
+	return day.AddDate(0, 0, -(int(day.Weekday())+6)%7)
+}
+
+// sortedCounts 按访问量倒序排列，limit 大于 0 时只保留前 limit 项
+func sortedCounts(counts map[string]*models.AnalyticsCount, limit int) []models.AnalyticsCount {
+	result := make([]models.AnalyticsCount, 0, len(counts))
+	for _, count := range counts {
+		result = append(result, *count)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Views != result[j].Views {
+			return result[i].Views > result[j].Views
+		}
+		return result[i].Name < result[j].Name
+	})
+
+	if limit > 0 && len(result) > limit {
+		result = result[:limit]
+	}
+	return result
+}
+
+// PrepareVisit 在写入访问记录前调用：识别机器人访问，解析访客标识、浏览器、操作系统和来源域名，
+// 按 ip_mode 截断或哈希 IP，并将去重用的 view_hash 换成带密钥的哈希，避免从中反推出 IP。
+// 去重查询需要使用处理后的 VisitorIP 和 ViewHash
+func (s *AnalyticsService) PrepareVisit(visit *models.NoteVisit, viewerInfo *models.ViewerInfo) {
+	s.describeVisit(visit, viewerInfo)
+	if visit.ViewHash != nil {
+		hash := s.keyedHash("view", *visit.ViewHash)
+		visit.ViewHash = &hash
+	}
+}
+
+// describeVisit 解析访问记录的访客标识、浏览器、操作系统和来源域名，访客标识使用处理前的完整 IP
+func (s *AnalyticsService) describeVisit(visit *models.NoteVisit, viewerInfo *models.ViewerInfo) {
+	var ip, userAgent, referer string
+	if visit.VisitorIP != nil {
+		ip = *visit.VisitorIP
+	}
+	if visit.UserAgent != nil {
+		userAgent = *visit.UserAgent
+	}
+	if visit.Referer != nil {
+		referer = *visit.Referer
+	}
+
+	// 登录用户按用户 ID，匿名访客按 IP 和 User-Agent 区分
+	key := s.keyedHash("visitor", ip, userAgent)
+	if visit.ViewerID != nil {
+		key = s.keyedHash("user", strconv.FormatUint(uint64(*visit.ViewerID), 10))
+	}
+	visit.VisitorKey = &key
+	visit.Browser, visit.OS = parseUserAgent(userAgent)
+	visit.RefererHost = refererHost(referer)
+	visit.VisitorIP = s.anonymizeIP(ip)
+	visit.IsBot, visit.BotReason = s.classify(userAgent, viewerInfo)
+}
+
+// classify 识别机器人、爬虫、监控和链接预览等非人工访问，返回识别原因。
+// viewerInfo 为空时只按 User-Agent 判断
+func (s *AnalyticsService) classify(userAgent string, viewerInfo *models.ViewerInfo) (bool, string) {
+	if strings.TrimSpace(userAgent) == "" {
+		return true, models.BotReasonNoUserAgent
+	}
+
+	if viewerInfo != nil {
+		if viewerInfo.Method == http.MethodHead {
+			return true, models.BotReasonHeadRequest
+		}
+		purpose := strings.ToLower(viewerInfo.Purpose)
+		if strings.Contains(purpose, "prefetch") || strings.Contains(purpose, "prerender") || strings.Contains(purpose, "preview") {
+			return true, models.BotReasonPrefetch
+		}
+	}
+
+	lower := strings.ToLower(userAgent)
+	for _, pattern := range s.botUserAgents {
+		if strings.Contains(lower, pattern) {
+			return true, models.BotReasonUserAgent
+		}
+	}
+	return false, ""
+}
+
+// anonymizeIP 按 ip_mode 处理访问者 IP，无法解析的地址在非 full 模式下不保存
+func (s *AnalyticsService) anonymizeIP(ip string) *string {
+	if ip == "" {
+		return nil
+	}
+
+	// 早期 inet 列转换为文本后带有掩码后缀
+	if i := strings.IndexByte(ip, '/'); i >= 0 {
+		ip = ip[:i]
+	}
+
+	switch s.cfg.IPMode {
+	case "truncate":
+		addr, err := netip.ParseAddr(ip)
+		if err != nil {
+			return nil
+		}
+		addr = addr.Unmap()
+		bits := 48
+		if addr.Is4() {
+			bits = 24
+		}
+		prefix, err := addr.Prefix(bits)
+		if err != nil {
+			return nil
+		}
+		truncated := prefix.Addr().String()
+		return &truncated
+	case "hash":
+		if _, err := netip.ParseAddr(ip); err != nil {
+			return nil
+		}
+		hashed := s.keyedHash("ip", ip)
+		return &hashed
+	case "full":
+		return &ip
+	default:
+		// 配置加载时已校验，未知的模式不保存 IP
+		return nil
+	}
+}
+
+// keyedHash 带密钥的 HMAC-SHA256，取前 16 字节的十六进制
+func (s *AnalyticsService) keyedHash(parts ...string) string {
+	mac := hmac.New(sha256.New, []byte(s.cfg.IPHashKey))
+	mac.Write([]byte(strings.Join(parts, "\x00")))
+	return hex.EncodeToString(mac.Sum(nil)[:16])
+}
+
+// osNames 统一常见操作系统的名称
+var osNames = map[string]string{
+	"iPhone OS":  "iOS",
+	"OS":         "iOS",
+	"Mac OS X":   "macOS",
+	"Windows NT": "Windows",
+}
+
+func parseUserAgent(userAgent string) (string, string) {
+	if userAgent == "" {
+		return "", ""
+	}
+
+	ua := useragent.New(userAgent)
+	browser, _ := ua.Browser()
+	os := ua.OSInfo().Name
+	if name, ok := osNames[os]; ok {
+		os = name
+	}
+	return truncateRunes(browser, 50), truncateRunes(os, 50)
+}
+
+// refererHost 来源页面的域名，去掉 www. 前缀
+func refererHost(referer string) string {
+	if referer == "" {
+		return ""
+	}
+	u, err := url.Parse(referer)
+	if err != nil {
+		return ""
+	}
+	host := strings.TrimPrefix(strings.ToLower(u.Hostname()), "www.")
+	return truncateRunes(host, 255)
+}
@@ -1,19 +1,46 @@
 package services
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"net/url"
+	"notes-backend/internal/config"
+	"notes-backend/internal/mailer"
 	"notes-backend/internal/models"
 	"notes-backend/internal/utils"
+	"strings"
+	"time"
 
 	"gorm.io/gorm"
 )
 
+var (
+	// ErrUserTokenInvalid 邮件中的令牌不存在、已过期或已使用
+	ErrUserTokenInvalid = errors.New("链接无效或已过期")
+	// ErrEmailNotVerified 要求邮箱验证时，未验证的账号不能登录
+	ErrEmailNotVerified = errors.New("邮箱尚未验证，请先查收验证邮件")
+	// ErrEmailAlreadyVerified 邮箱已验证，无需重新发送验证邮件
+	ErrEmailAlreadyVerified = errors.New("邮箱已验证")
+	// ErrMailCooldown 同一用途的邮件发送过于频繁
+	ErrMailCooldown = errors.New("邮件发送过于频繁，请稍后再试")
+)
+
+// userTokenCooldown 同一用户同一用途的邮件最短发送间隔
+const userTokenCooldown = time.Minute
+
 type AuthService struct {
-	db *gorm.DB
+	db        *gorm.DB
+	mailer    mailer.Mailer
+	twoFactor *TwoFactorService
+	config    *config.Config
 }
 
-func NewAuthService(db *gorm.DB) *AuthService {
-	return &AuthService{db: db}
+func NewAuthService(db *gorm.DB, m mailer.Mailer, twoFactor *TwoFactorService, cfg *config.Config) *AuthService {
+	return &AuthService{db: db, mailer: m, twoFactor: twoFactor, config: cfg}
 }
 
 func (s *AuthService) Register(req *models.UserRegisterRequest) (*models.User, error) {
@@ -66,31 +93,251 @@ func (s *AuthService) Register(req *models.UserRegisterRequest) (*models.User, e
 		return nil, err
 	}
 
+	// 验证邮件发送失败不影响注册，用户可以稍后重新发送
+	if err := s.SendVerificationEmail(&user); err != nil {
+		fmt.Printf("Failed to send verification email: user_id=%d, error=%v\n", user.ID, err)
+	}
+
 	return &user, nil
 }
 
-func (s *AuthService) Login(req *models.UserLoginRequest) (*models.User, error) {
+// Login 校验邮箱和密码。开启两步验证的用户返回挑战令牌而不是用户，
+// 需要通过 TwoFactorService.VerifyChallenge 提交验证码后才能登录
+func (s *AuthService) Login(req *models.UserLoginRequest) (*models.User, *models.LoginChallenge, error) {
 	var user models.User
 	err := s.db.Where("email = ? AND is_active = ?", req.Email, true).First(&user).Error
 	if err != nil {
 		if err == gorm.ErrRecordNotFound {
-			return nil, fmt.Errorf("邮箱或密码错误")
+			return nil, nil, fmt.Errorf("邮箱或密码错误")
 		}
-		return nil, err
+		return nil, nil, err
 	}
 
 	// 验证密码
 	valid, err := utils.VerifyPassword(req.Password, user.PasswordHash)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	if !valid {
-		return nil, fmt.Errorf("邮箱或密码错误")
+		return nil, nil, fmt.Errorf("邮箱或密码错误")
+	}
+
+	if s.config.Auth.RequireEmailVerification && user.EmailVerifiedAt == nil {
+		return nil, nil, ErrEmailNotVerified
+	}
+
+	if user.TwoFactorEnabled {
+		challenge, err := s.twoFactor.NewChallenge(&user)
+		if err != nil {
+			return nil, nil, err
+		}
+		return nil, challenge, nil
+	}
+
+	return &user, nil, nil
+}
+
+// SendVerificationEmail 向用户当前邮箱发送验证链接
+func (s *AuthService) SendVerificationEmail(user *models.User) error {
+	if user.EmailVerifiedAt != nil {
+		return ErrEmailAlreadyVerified
+	}
+
+	ttl := time.Duration(s.config.Auth.VerifyTokenHours) * time.Hour
+	token, err := s.issueUserToken(user, models.UserTokenVerifyEmail, ttl)
+	if err != nil {
+		return err
+	}
+
+	link := s.frontendLink("/verify-email", token)
+	return s.mailer.Send(&mailer.Message{
+		To:      user.Email,
+		Subject: "请验证你的邮箱",
+		Body: fmt.Sprintf("%s，你好：\n\n请打开以下链接完成邮箱验证，链接 %d 小时内有效：\n\n%s\n\n如果这不是你的操作，请忽略本邮件。\n",
+			user.Username, s.config.Auth.VerifyTokenHours, link),
+	})
+}
+
+// VerifyEmail 使用邮件中的令牌验证邮箱，令牌只能使用一次
+func (s *AuthService) VerifyEmail(token string) (*models.User, error) {
+	var user *models.User
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		user, err = consumeUserToken(tx, models.UserTokenVerifyEmail, token)
+		if err != nil {
+			return err
+		}
+		if user.EmailVerifiedAt != nil {
+			return nil
+		}
+
+		now := time.Now()
+		if err := tx.Model(user).Update("email_verified_at", now).Error; err != nil {
+			return err
+		}
+		user.EmailVerifiedAt = &now
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// ForgotPassword 发送重置密码邮件。邮箱未注册或发送过于频繁时同样返回成功，避免泄露账号是否存在
+func (s *AuthService) ForgotPassword(email string) error {
+	var user models.User
+	err := s.db.Where("email = ? AND is_active = ?", email, true).First(&user).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		return err
+	}
+
+	ttl := time.Duration(s.config.Auth.ResetTokenMinutes) * time.Minute
+	token, err := s.issueUserToken(&user, models.UserTokenResetPassword, ttl)
+	if err != nil {
+		if errors.Is(err, ErrMailCooldown) {
+			return nil
+		}
+		return err
 	}
 
+	link := s.frontendLink("/reset-password", token)
+	return s.mailer.Send(&mailer.Message{
+		To:      user.Email,
+		Subject: "重置密码",
+		Body: fmt.Sprintf("%s，你好：\n\n我们收到了重置密码的请求，请打开以下链接设置新密码，链接 %d 分钟内有效且只能使用一次：\n\n%s\n\n如果这不是你的操作，请忽略本邮件，你的密码不会改变。\n",
+			user.Username, s.config.Auth.ResetTokenMinutes, link),
+	})
+}
+
+// ResetPassword 使用邮件中的令牌设置新密码，同时作废该用户其它未使用的重置令牌。
+// 调用方负责吊销用户已有的会话
+func (s *AuthService) ResetPassword(token, password string) (*models.User, error) {
+	hashedPassword, err := utils.HashPassword(password)
+	if err != nil {
+		return nil, err
+	}
+
+	var user *models.User
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		user, err = consumeUserToken(tx, models.UserTokenResetPassword, token)
+		if err != nil {
+			return err
+		}
+
+		now := time.Now()
+		updates := map[string]interface{}{"password_hash": hashedPassword}
+		// 能收到重置邮件说明邮箱属于该用户
+		if user.EmailVerifiedAt == nil {
+			updates["email_verified_at"] = now
+			user.EmailVerifiedAt = &now
+		}
+		if err := tx.Model(user).Updates(updates).Error; err != nil {
+			return err
+		}
+		user.PasswordHash = hashedPassword
+
+		return tx.Model(&models.UserToken{}).
+			Where("user_id = ? AND purpose = ? AND used_at IS NULL", user.ID, models.UserTokenResetPassword).
+			Update("used_at", now).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// issueUserToken 生成一次性令牌并保存哈希，同时清理已过期的令牌
+func (s *AuthService) issueUserToken(user *models.User, purpose string, ttl time.Duration) (string, error) {
+	now := time.Now()
+
+	var recent int64
+	err := s.db.Model(&models.UserToken{}).
+		Where("user_id = ? AND purpose = ? AND created_at > ?", user.ID, purpose, now.Add(-userTokenCooldown)).
+		Count(&recent).Error
+	if err != nil {
+		return "", err
+	}
+	if recent > 0 {
+		return "", ErrMailCooldown
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(secret)
+
+	if err := s.db.Where("expires_at < ?", now).Delete(&models.UserToken{}).Error; err != nil {
+		fmt.Printf("Failed to clean up expired user tokens: %v\n", err)
+	}
+
+	record := models.UserToken{
+		UserID:    user.ID,
+		Purpose:   purpose,
+		TokenHash: hashUserToken(token),
+		Email:     user.Email,
+		ExpiresAt: now.Add(ttl),
+	}
+	if err := s.db.Create(&record).Error; err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+func (s *AuthService) frontendLink(path, token string) string {
+	return strings.TrimRight(s.config.Frontend.BaseURL, "/") + path + "?token=" + url.QueryEscape(token)
+}
+
+// consumeUserToken 在事务中将令牌标记为已使用，并返回令牌所属的用户。
+// 以 used_at IS NULL 为条件更新，并发请求中只有一个能成功
+func consumeUserToken(tx *gorm.DB, purpose, token string) (*models.User, error) {
+	var record models.UserToken
+	err := tx.Where("token_hash = ? AND purpose = ?", hashUserToken(token), purpose).First(&record).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrUserTokenInvalid
+		}
+		return nil, err
+	}
+
+	now := time.Now()
+	if record.UsedAt != nil || now.After(record.ExpiresAt) {
+		return nil, ErrUserTokenInvalid
+	}
+
+	result := tx.Model(&models.UserToken{}).
+		Where("id = ? AND used_at IS NULL", record.ID).
+		Update("used_at", now)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, ErrUserTokenInvalid
+	}
+
+	var user models.User
+	if err := tx.Where("id = ? AND is_active = ?", record.UserID, true).First(&user).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrUserTokenInvalid
+		}
+		return nil, err
+	}
+	if !strings.EqualFold(user.Email, record.Email) {
+		return nil, ErrUserTokenInvalid
+	}
 	return &user, nil
 }
 
+func hashUserToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
 func (s *AuthService) GetUserByID(userID uint) (*models.User, error) {
 	var user models.User
 	err := s.db.Where("id = ? AND is_active = ?", userID, true).First(&user).Error
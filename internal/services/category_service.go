@@ -186,5 +186,6 @@ func (s *CategoryService) DeleteCategory(categoryID, userID uint) error {
 		return fmt.Errorf("分类不存在或无权限删除")
 	}
 
-	return nil
+	// 分类上的共享授权随分类一起删除
+	return s.db.Where("category_id = ?", categoryID).Delete(&models.NotePermission{}).Error
 }
\ No newline at end of file
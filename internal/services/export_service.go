@@ -0,0 +1,257 @@
+package services
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"notes-backend/internal/models"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const exportBatchSize = 100
+
+var (
+	// 正文中引用附件的地址：/api/files/:id[/download][?token=...]，可带域名
+	exportFileURLPattern = regexp.MustCompile(`(?:https?://[^\s()<>"']+?)?/api/files/(\d+)(?:/download)?(?:\?[^\s()<>"']*)?`)
+	exportUploadPattern  = regexp.MustCompile(`(?:https?://[^\s()<>"']+?)?/uploads/users/\d+/([A-Za-z0-9-]+\.?[A-Za-z0-9]*)`)
+	unsafeNameChars      = regexp.MustCompile(`[\\/:*?"<>|\x00-\x1f]`)
+)
+
+// ExportService 将用户的笔记导出为 Markdown 文件夹结构的 ZIP 压缩包
+type ExportService struct {
+	db *gorm.DB
+}
+
+func NewExportService(db *gorm.DB) *ExportService {
+	return &ExportService{db: db}
+}
+
+type exportAttachment struct {
+	attachment models.Attachment
+	zipPath    string
+	link       string
+}
+
+// ExportMarkdown 以流的方式写出 ZIP：每篇笔记一个带 YAML front matter 的 .md 文件，
+// 目录结构与分类树一致，附件放在笔记旁的 <笔记名>_files 目录中，正文中的附件地址改写为相对路径
+func (s *ExportService) ExportMarkdown(userID uint, w io.Writer) error {
+	folders, err := s.categoryFolders(userID)
+	if err != nil {
+		return err
+	}
+
+	archive := zip.NewWriter(w)
+	usedPaths := make(map[string]bool)
+
+	var notes []models.Note
+	err = s.db.Preload("Tags").
+		Where("user_id = ?", userID).
+		Order("id").
+		FindInBatches(&notes, exportBatchSize, func(tx *gorm.DB, batch int) error {
+			for i := range notes {
+				if err := s.writeNote(archive, &notes[i], folders, usedPaths); err != nil {
+					return err
+				}
+			}
+			return nil
+		}).Error
+	if err != nil {
+		return err
+	}
+
+	return archive.Close()
+}
+
+func (s *ExportService) writeNote(archive *zip.Writer, note *models.Note, folders map[uint]string, usedPaths map[string]bool) error {
+	folder := ""
+	category := ""
+	if note.CategoryID != nil {
+		folder = folders[*note.CategoryID]
+		category = folder
+	}
+
+	base := uniqueExportPath(usedPaths, folder, sanitizeFileName(note.Title), ".md")
+	notePath := path.Join(folder, base+".md")
+
+	var attachments []models.Attachment
+	if err := s.db.Where("note_id = ?", note.ID).Order("id").Find(&attachments).Error; err != nil {
+		return err
+	}
+
+	files := make(map[string]*exportAttachment)
+	byFilename := make(map[string]*exportAttachment)
+	usedNames := make(map[string]bool)
+	for _, attachment := range attachments {
+		name := sanitizeFileName(attachment.OriginalFilename)
+		ext := path.Ext(name)
+		name = uniqueExportPath(usedNames, "", strings.TrimSuffix(name, ext), ext) + ext
+
+		dir := base + "_files"
+		item := &exportAttachment{
+			attachment: attachment,
+			zipPath:    path.Join(folder, dir, name),
+			link:       url.PathEscape(dir) + "/" + url.PathEscape(name),
+		}
+		files[strconv.FormatUint(uint64(attachment.ID), 10)] = item
+		byFilename[attachment.Filename] = item
+	}
+
+	content := exportFileURLPattern.ReplaceAllStringFunc(note.Content, func(match string) string {
+		if item, ok := files[exportFileURLPattern.FindStringSubmatch(match)[1]]; ok {
+			return item.link
+		}
+		return match
+	})
+	content = exportUploadPattern.ReplaceAllStringFunc(content, func(match string) string {
+		if item, ok := byFilename[exportUploadPattern.FindStringSubmatch(match)[1]]; ok {
+			return item.link
+		}
+		return match
+	})
+
+	writer, err := archive.CreateHeader(&zip.FileHeader{
+		Name:     notePath,
+		Method:   zip.Deflate,
+		Modified: note.UpdatedAt,
+	})
+	if err != nil {
+		return err
+	}
+	if _, err := io.WriteString(writer, frontMatter(note, category)+content); err != nil {
+		return err
+	}
+
+	for _, attachment := range attachments {
+		item := files[strconv.FormatUint(uint64(attachment.ID), 10)]
+		if err := writeExportFile(archive, item); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// writeExportFile 将附件原样写入压缩包（不再压缩），物理文件缺失时跳过
+func writeExportFile(archive *zip.Writer, item *exportAttachment) error {
+	file, err := os.Open(item.attachment.FilePath)
+	if err != nil {
+		fmt.Printf("Export: skip missing attachment %d (%s): %v\n", item.attachment.ID, item.attachment.FilePath, err)
+		return nil
+	}
+	defer file.Close()
+
+	writer, err := archive.CreateHeader(&zip.FileHeader{
+		Name:     item.zipPath,
+		Method:   zip.Store,
+		Modified: item.attachment.CreatedAt,
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(writer, file)
+	return err
+}
+
+// categoryFolders 根据分类树计算每个分类对应的目录路径，同级重名的分类追加序号
+func (s *ExportService) categoryFolders(userID uint) (map[uint]string, error) {
+	var categories []models.Category
+	if err := s.db.Where("user_id = ?", userID).Order("sort_order, id").Find(&categories).Error; err != nil {
+		return nil, err
+	}
+
+	byID := make(map[uint]*models.Category, len(categories))
+	for i := range categories {
+		byID[categories[i].ID] = &categories[i]
+	}
+
+	folders := make(map[uint]string, len(categories))
+	usedPaths := make(map[string]bool)
+
+	var resolve func(category *models.Category, depth int) string
+	resolve = func(category *models.Category, depth int) string {
+		if folder, ok := folders[category.ID]; ok {
+			return folder
+		}
+
+		parent := ""
+		if category.ParentID != nil && depth < len(categories) {
+			if p, ok := byID[*category.ParentID]; ok {
+				parent = resolve(p, depth+1)
+			}
+		}
+
+		folder := path.Join(parent, uniqueExportPath(usedPaths, parent, sanitizeFileName(category.Name), "/"))
+		folders[category.ID] = folder
+		return folder
+	}
+
+	for i := range categories {
+		resolve(&categories[i], 0)
+	}
+
+	return folders, nil
+}
+
+// frontMatter 生成 YAML front matter，字符串使用 JSON 转义（JSON 字符串同时是合法的 YAML）
+func frontMatter(note *models.Note, category string) string {
+	quote := func(value string) string {
+		data, _ := json.Marshal(value)
+		return string(data)
+	}
+
+	var sb strings.Builder
+	sb.WriteString("---\n")
+	sb.WriteString("id: " + strconv.FormatUint(uint64(note.ID), 10) + "\n")
+	sb.WriteString("title: " + quote(note.Title) + "\n")
+	if category != "" {
+		sb.WriteString("category: " + quote(category) + "\n")
+	}
+	if len(note.Tags) > 0 {
+		sb.WriteString("tags:\n")
+		for _, tag := range note.Tags {
+			sb.WriteString("  - " + quote(tag.Name) + "\n")
+		}
+	} else {
+		sb.WriteString("tags: []\n")
+	}
+	if note.ContentType != "" && note.ContentType != "markdown" {
+		sb.WriteString("content_type: " + note.ContentType + "\n")
+	}
+	sb.WriteString("public: " + strconv.FormatBool(note.IsPublic) + "\n")
+	sb.WriteString("created: " + note.CreatedAt.Format(time.RFC3339) + "\n")
+	sb.WriteString("updated: " + note.UpdatedAt.Format(time.RFC3339) + "\n")
+	sb.WriteString("---\n\n")
+	return sb.String()
+}
+
+// uniqueExportPath 在同一目录下为重名文件追加序号，返回不含扩展名的文件名
+func uniqueExportPath(used map[string]bool, dir, name, ext string) string {
+	candidate := name
+	for i := 2; used[strings.ToLower(path.Join(dir, candidate)+ext)]; i++ {
+		candidate = fmt.Sprintf("%s (%d)", name, i)
+	}
+	used[strings.ToLower(path.Join(dir, candidate)+ext)] = true
+	return candidate
+}
+
+func sanitizeFileName(name string) string {
+	name = unsafeNameChars.ReplaceAllString(name, "_")
+	name = strings.Trim(strings.TrimSpace(name), ".")
+	if runes := []rune(name); len(runes) > 100 {
+		name = string(runes[:100])
+	}
+	if name == "" {
+		name = "untitled"
+	}
+	return name
+}
@@ -16,16 +16,18 @@ import (
 )
 
 type FileService struct {
-	db         *gorm.DB
-	uploadPath string
-	maxStorage int64
+	db          *gorm.DB
+	uploadPath  string
+	maxStorage  int64
+	permissions *PermissionService
 }
 
-func NewFileService(db *gorm.DB, uploadPath string, maxStorage int64) *FileService {
+func NewFileService(db *gorm.DB, uploadPath string, maxStorage int64, permissions *PermissionService) *FileService {
 	return &FileService{
-		db:         db,
-		uploadPath: uploadPath,
-		maxStorage: maxStorage,
+		db:          db,
+		uploadPath:  uploadPath,
+		maxStorage:  maxStorage,
+		permissions: permissions,
 	}
 }
 
@@ -139,10 +141,17 @@ func (s *FileService) RestoreAttachment(attachmentID, userID uint) error {
 // 修复：获取附件时排除软删除的记录
 func (s *FileService) GetAttachments(noteID, userID uint) ([]models.Attachment, error) {
 	var attachments []models.Attachment
+
+	if _, err := s.permissions.NoteRole(noteID, userID); err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return attachments, nil
+		}
+		return nil, err
+	}
 	
 	// 修复：默认不查询软删除的附件
 	err := s.db.Joins("JOIN notes ON attachments.note_id = notes.id").
-		Where("attachments.note_id = ? AND notes.user_id = ?", noteID, userID).
+		Where("attachments.note_id = ? AND notes.deleted_at IS NULL", noteID).
 		Find(&attachments).Error
 	
 	if err != nil {
@@ -164,7 +173,7 @@ func (s *FileService) GetAttachmentByID(attachmentID, userID uint) (*models.Atta
 	
 	// 修复：默认不查询软删除的附件
 	err := s.db.Joins("JOIN notes ON attachments.note_id = notes.id").
-		Where("attachments.id = ? AND notes.user_id = ?", attachmentID, userID).
+		Where("attachments.id = ? AND notes.deleted_at IS NULL", attachmentID).
 		First(&attachment).Error
 	
 	if err != nil {
@@ -174,6 +183,14 @@ func (s *FileService) GetAttachmentByID(attachmentID, userID uint) (*models.Atta
 		return nil, err
 	}
 
+	// 笔记所有者和被授权查看笔记的用户都可以访问附件
+	if _, err := s.permissions.NoteRole(attachment.NoteID, userID); err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, fmt.Errorf("附件不存在或无权限访问")
+		}
+		return nil, err
+	}
+
 	attachment.URLs = &models.FileURLs{
 		Original: fmt.Sprintf("/api/files/%d", attachment.ID),
 	}
@@ -331,12 +348,18 @@ func (s *FileService) updateUserStorage(userID uint, sizeChange int64, isImage b
 
 // 其他方法保持不变...
 func (s *FileService) UploadFile(noteID, userID uint, file multipart.File, header *multipart.FileHeader) (*models.Attachment, error) {
+	return s.SaveAttachment(noteID, userID, file, header.Filename, header.Header.Get("Content-Type"))
+}
+
+// SaveAttachment 将文件内容保存到用户目录并创建附件记录，供上传和导入共用。
+// 文件大小以实际写入的字节数为准
+func (s *FileService) SaveAttachment(noteID, userID uint, src io.Reader, originalFilename, contentType string) (*models.Attachment, error) {
 	var note models.Note
 	if err := s.db.Where("id = ? AND user_id = ?", noteID, userID).First(&note).Error; err != nil {
 		return nil, fmt.Errorf("笔记不存在或无权限")
 	}
 
-	ext := filepath.Ext(header.Filename)
+	ext := filepath.Ext(originalFilename)
 	newFileName := uuid.New().String() + ext
 	
 	userDir := filepath.Join(s.uploadPath, "users", fmt.Sprintf("%d", userID))
@@ -351,21 +374,21 @@ func (s *FileService) UploadFile(noteID, userID uint, file multipart.File, heade
 	}
 	defer dst.Close()
 
-	if _, err := io.Copy(dst, file); err != nil {
+	size, err := io.Copy(dst, src)
+	if err != nil {
+		os.Remove(filePath)
 		return nil, fmt.Errorf("保存文件失败: %v", err)
 	}
 
 	ext = strings.ToLower(strings.TrimPrefix(ext, "."))
 	isImage := s.isImageType(ext)
 
-	contentType := header.Header.Get("Content-Type")
-
 	attachment := models.Attachment{
 		NoteID:           noteID,
 		Filename:         newFileName,
-		OriginalFilename: header.Filename,
+		OriginalFilename: originalFilename,
 		FilePath:         filePath,
-		FileSize:         header.Size,
+		FileSize:         size,
 		FileType:         ext,
 		MimeType:         &contentType,
 		IsImage:          isImage,
@@ -376,7 +399,7 @@ func (s *FileService) UploadFile(noteID, userID uint, file multipart.File, heade
 		return nil, fmt.Errorf("保存附件记录失败: %v", err)
 	}
 
-	s.updateUserStorage(userID, header.Size, isImage)
+	s.updateUserStorage(userID, size, isImage)
 
 	attachment.URLs = &models.FileURLs{
 		Original: fmt.Sprintf("/api/files/%d", attachment.ID),
@@ -0,0 +1,509 @@
+package services
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	"notes-backend/internal/config"
+	"notes-backend/internal/importer"
+	"notes-backend/internal/models"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// ErrImportInProgress 用户已有正在进行的导入任务
+var ErrImportInProgress = errors.New("已有导入任务正在进行，请稍后再试")
+
+// ImportService 在后台执行笔记导入任务。任务状态保存在内存中，服务重启后丢失
+type ImportService struct {
+	db          *gorm.DB
+	noteService *NoteService
+	fileService *FileService
+	cfg         *config.Config
+
+	mu   sync.RWMutex
+	jobs map[string]*models.ImportJob
+}
+
+// importState 单个任务中已解析的分类和标签，预演模式下新建的分类 ID 为 0
+type importState struct {
+	categories map[string]uint
+	tags       map[string]uint
+}
+
+func NewImportService(db *gorm.DB, noteService *NoteService, fileService *FileService, cfg *config.Config) *ImportService {
+	return &ImportService{
+		db:          db,
+		noteService: noteService,
+		fileService: fileService,
+		cfg:         cfg,
+		jobs:        make(map[string]*models.ImportJob),
+	}
+}
+
+// StartImport 创建导入任务并在后台处理 archivePath 指向的上传文件，处理完成后删除该文件
+func (s *ImportService) StartImport(userID uint, req *models.ImportRequest, archivePath string) (*models.ImportJob, error) {
+	s.mu.Lock()
+	s.cleanupLocked()
+	for _, job := range s.jobs {
+		if job.UserID == userID && (job.Status == models.ImportStatusPending || job.Status == models.ImportStatusRunning) {
+			s.mu.Unlock()
+			return nil, ErrImportInProgress
+		}
+	}
+
+	job := &models.ImportJob{
+		ID:          uuid.New().String(),
+		UserID:      userID,
+		Format:      req.Format,
+		ContentType: req.ContentType,
+		Notebook:    req.Notebook,
+		DryRun:      req.DryRun,
+		OnConflict:  req.OnConflict,
+		Status:      models.ImportStatusPending,
+		StartedAt:   time.Now(),
+		Conflicts:   []models.ImportConflict{},
+		Issues:      []models.ImportIssue{},
+	}
+	s.jobs[job.ID] = job
+	snapshot := copyImportJob(job)
+	s.mu.Unlock()
+
+	go s.run(job, archivePath)
+
+	return snapshot, nil
+}
+
+func (s *ImportService) GetJob(jobID string, userID uint) (*models.ImportJob, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	job, ok := s.jobs[jobID]
+	if !ok || job.UserID != userID {
+		return nil, fmt.Errorf("导入任务不存在")
+	}
+	return copyImportJob(job), nil
+}
+
+// GetJobs 获取用户的导入任务，按开始时间倒序
+func (s *ImportService) GetJobs(userID uint) []*models.ImportJob {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	jobs := []*models.ImportJob{}
+	for _, job := range s.jobs {
+		if job.UserID == userID {
+			jobs = append(jobs, copyImportJob(job))
+		}
+	}
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].StartedAt.After(jobs[j].StartedAt)
+	})
+	return jobs
+}
+
+func (s *ImportService) run(job *models.ImportJob, archivePath string) {
+	defer os.Remove(archivePath)
+
+	s.update(job, func(j *models.ImportJob) { j.Status = models.ImportStatusRunning })
+
+	err := s.process(job, archivePath)
+
+	var result models.ImportJob
+	s.update(job, func(j *models.ImportJob) {
+		now := time.Now()
+		j.FinishedAt = &now
+		if err != nil {
+			j.Status = models.ImportStatusFailed
+			j.Error = err.Error()
+			return
+		}
+		j.Status = models.ImportStatusCompleted
+		result = *j
+	})
+
+	if err != nil {
+		fmt.Printf("Import job %s failed: %v\n", job.ID, err)
+	} else {
+		fmt.Printf("Import job %s completed: %d notes, %d attachments\n", job.ID, result.NotesCreated, result.AttachmentsCreated)
+	}
+}
+
+func (s *ImportService) process(job *models.ImportJob, archivePath string) error {
+	documents, issues, closer, err := s.parse(job, archivePath)
+	if err != nil {
+		return err
+	}
+	defer closer()
+
+	var totalBytes int64
+	for _, doc := range documents {
+		for _, file := range doc.Files {
+			totalBytes += file.Size
+		}
+	}
+
+	s.update(job, func(j *models.ImportJob) {
+		j.Total = len(documents)
+		j.AttachmentBytes = totalBytes
+		for _, issue := range issues {
+			j.Issues = append(j.Issues, models.ImportIssue{Path: issue.Path, Message: issue.Message})
+		}
+	})
+
+	if totalBytes > 0 {
+		ok, err := s.fileService.CheckUserStorage(job.UserID, totalBytes)
+		if err != nil {
+			return fmt.Errorf("检查存储空间失败: %v", err)
+		}
+		if !ok {
+			return fmt.Errorf("存储空间不足：导入的附件共 %.1f MB", float64(totalBytes)/(1024*1024))
+		}
+	}
+
+	state, err := s.loadState(job.UserID)
+	if err != nil {
+		return err
+	}
+
+	for i := range documents {
+		if err := s.importDocument(job, state, &documents[i]); err != nil {
+			s.update(job, func(j *models.ImportJob) {
+				j.Issues = append(j.Issues, models.ImportIssue{Path: documents[i].Path, Message: err.Error()})
+			})
+		}
+		s.update(job, func(j *models.ImportJob) { j.Processed++ })
+	}
+
+	return nil
+}
+
+// parse 按格式解析上传文件，返回的 closer 用于在导入结束后关闭压缩包或删除临时文件
+func (s *ImportService) parse(job *models.ImportJob, archivePath string) ([]importer.Document, []importer.Issue, func(), error) {
+	switch job.Format {
+	case "markdown":
+		archive, err := zip.OpenReader(archivePath)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("无法读取 ZIP 文件: %v", err)
+		}
+		documents, issues := importer.ParseMarkdownVault(&archive.Reader)
+		return documents, issues, func() { archive.Close() }, nil
+	case "enex":
+		file, err := os.Open(archivePath)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		defer file.Close()
+
+		tempDir, err := os.MkdirTemp("", "notes-enex-*")
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("创建临时目录失败: %v", err)
+		}
+		documents, issues, err := importer.ParseENEX(file, importer.ENEXOptions{
+			Notebook:    job.Notebook,
+			ContentType: job.ContentType,
+			TempDir:     tempDir,
+		})
+		if err != nil {
+			os.RemoveAll(tempDir)
+			return nil, nil, nil, err
+		}
+		return documents, issues, func() { os.RemoveAll(tempDir) }, nil
+	default:
+		return nil, nil, nil, fmt.Errorf("不支持的导入格式: %s", job.Format)
+	}
+}
+
+func (s *ImportService) importDocument(job *models.ImportJob, state *importState, doc *importer.Document) error {
+	title := truncateRunes(strings.TrimSpace(doc.Title), 255)
+	if title == "" {
+		title = "未命名笔记"
+	}
+
+	categoryID, err := s.ensureCategory(job, state, doc.Folder)
+	if err != nil {
+		return err
+	}
+
+	// 分类为本次预演中新建的分类时不可能存在同名笔记
+	if categoryID == nil || *categoryID != 0 {
+		existing, err := s.findExistingNote(job.UserID, categoryID, title)
+		if err != nil {
+			return err
+		}
+		if existing != 0 {
+			action := job.OnConflict
+			s.update(job, func(j *models.ImportJob) {
+				j.Conflicts = append(j.Conflicts, models.ImportConflict{Path: doc.Path, Title: title, NoteID: existing, Action: action})
+				if action == models.ImportConflictSkip {
+					j.NotesSkipped++
+				}
+			})
+			if action == models.ImportConflictSkip {
+				return nil
+			}
+		}
+	}
+
+	var files []importer.File
+	for _, file := range doc.Files {
+		if err := s.validateFile(file); err != nil {
+			s.update(job, func(j *models.ImportJob) {
+				j.Issues = append(j.Issues, models.ImportIssue{Path: doc.Path, Message: fmt.Sprintf("%s: %v", file.Name, err)})
+			})
+			continue
+		}
+		files = append(files, file)
+	}
+
+	tagIDs, err := s.ensureTags(job, state, doc.Tags)
+	if err != nil {
+		return err
+	}
+
+	if job.DryRun {
+		s.update(job, func(j *models.ImportJob) {
+			j.NotesCreated++
+			j.AttachmentsCreated += len(files)
+		})
+		return nil
+	}
+
+	contentType := doc.ContentType
+	if contentType == "" {
+		contentType = "markdown"
+	}
+
+	note, err := s.noteService.CreateNote(job.UserID, &models.NoteCreateRequest{
+		Title:       title,
+		Content:     doc.Content,
+		ContentType: contentType,
+		CategoryID:  categoryID,
+		TagIDs:      tagIDs,
+		IsPublic:    doc.IsPublic,
+	})
+	if err != nil {
+		return fmt.Errorf("创建笔记失败: %v", err)
+	}
+	s.update(job, func(j *models.ImportJob) { j.NotesCreated++ })
+
+	// 无法导入的附件在正文中以文件名代替占位符
+	urls := make(map[string]string, len(doc.Files))
+	for _, file := range doc.Files {
+		urls[file.Ref] = file.Name
+	}
+	for _, file := range files {
+		attachment, err := s.saveFile(job.UserID, note.ID, file)
+		if err != nil {
+			s.update(job, func(j *models.ImportJob) {
+				j.Issues = append(j.Issues, models.ImportIssue{Path: doc.Path, Message: fmt.Sprintf("%s: %v", file.Name, err)})
+			})
+			continue
+		}
+		urls[file.Ref] = fmt.Sprintf("/api/files/%d", attachment.ID)
+		s.update(job, func(j *models.ImportJob) { j.AttachmentsCreated++ })
+	}
+
+	content := importer.ReplaceFileRefs(doc.Content, urls)
+	return s.noteService.finalizeImport(note.ID, job.UserID, content, doc.CreatedAt, doc.UpdatedAt)
+}
+
+func (s *ImportService) saveFile(userID, noteID uint, file importer.File) (*models.Attachment, error) {
+	ok, err := s.fileService.CheckUserStorage(userID, file.Size)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, fmt.Errorf("存储空间不足")
+	}
+
+	reader, err := file.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	return s.fileService.SaveAttachment(noteID, userID, reader, file.Name, file.MimeType)
+}
+
+// validateFile 按上传接口相同的规则检查附件类型和大小
+func (s *ImportService) validateFile(file importer.File) error {
+	ext := strings.TrimPrefix(strings.ToLower(path.Ext(file.Name)), ".")
+
+	isImage := s.cfg.IsImageType(ext)
+	if isImage && file.Size > s.cfg.File.MaxImageSize {
+		return fmt.Errorf("图片文件大小不能超过 %d MB", s.cfg.File.MaxImageSize/(1024*1024))
+	}
+
+	isDocument := s.cfg.IsDocumentType(ext)
+	if isDocument && file.Size > s.cfg.File.MaxDocumentSize {
+		return fmt.Errorf("文档文件大小不能超过 %d MB", s.cfg.File.MaxDocumentSize/(1024*1024))
+	}
+
+	if !isImage && !isDocument {
+		return fmt.Errorf("不支持的文件类型: %s", ext)
+	}
+	return nil
+}
+
+// loadState 读取用户已有的分类（按路径索引）和标签（按名称索引），均不区分大小写
+func (s *ImportService) loadState(userID uint) (*importState, error) {
+	var categories []models.Category
+	if err := s.db.Where("user_id = ?", userID).Find(&categories).Error; err != nil {
+		return nil, err
+	}
+
+	byID := make(map[uint]*models.Category, len(categories))
+	for i := range categories {
+		byID[categories[i].ID] = &categories[i]
+	}
+
+	state := &importState{
+		categories: make(map[string]uint),
+		tags:       make(map[string]uint),
+	}
+	for _, category := range categories {
+		var segments []string
+		current := &category
+		for depth := 0; current != nil && depth <= len(categories); depth++ {
+			segments = append([]string{strings.ToLower(current.Name)}, segments...)
+			if current.ParentID == nil {
+				break
+			}
+			current = byID[*current.ParentID]
+		}
+		key := strings.Join(segments, "/")
+		if _, exists := state.categories[key]; !exists {
+			state.categories[key] = category.ID
+		}
+	}
+
+	var tags []models.Tag
+	if err := s.db.Where("user_id = ?", userID).Find(&tags).Error; err != nil {
+		return nil, err
+	}
+	for _, tag := range tags {
+		state.tags[strings.ToLower(tag.Name)] = tag.ID
+	}
+
+	return state, nil
+}
+
+// ensureCategory 按目录层级查找或创建分类，返回最后一级分类的 ID。预演模式下新分类的 ID 为 0
+func (s *ImportService) ensureCategory(job *models.ImportJob, state *importState, folder []string) (*uint, error) {
+	if len(folder) == 0 {
+		return nil, nil
+	}
+
+	var parentID *uint
+	var segments []string
+	for _, name := range folder {
+		name = truncateRunes(strings.TrimSpace(name), 100)
+		if name == "" {
+			continue
+		}
+		segments = append(segments, strings.ToLower(name))
+		key := strings.Join(segments, "/")
+
+		id, ok := state.categories[key]
+		if !ok {
+			if !job.DryRun {
+				category := models.Category{UserID: job.UserID, Name: name, ParentID: parentID}
+				if err := s.db.Create(&category).Error; err != nil {
+					return nil, fmt.Errorf("创建分类失败: %v", err)
+				}
+				id = category.ID
+			}
+			state.categories[key] = id
+			s.update(job, func(j *models.ImportJob) { j.CategoriesCreated++ })
+		}
+
+		categoryID := id
+		parentID = &categoryID
+	}
+
+	return parentID, nil
+}
+
+func (s *ImportService) ensureTags(job *models.ImportJob, state *importState, names []string) ([]uint, error) {
+	var ids []uint
+	for _, name := range names {
+		name = truncateRunes(strings.TrimSpace(name), 50)
+		if name == "" {
+			continue
+		}
+		key := strings.ToLower(name)
+
+		id, ok := state.tags[key]
+		if !ok {
+			if !job.DryRun {
+				tag := models.Tag{UserID: job.UserID, Name: name}
+				if err := s.db.Create(&tag).Error; err != nil {
+					return nil, fmt.Errorf("创建标签失败: %v", err)
+				}
+				id = tag.ID
+			}
+			state.tags[key] = id
+			s.update(job, func(j *models.ImportJob) { j.TagsCreated++ })
+		}
+		if id != 0 {
+			ids = append(ids, id)
+		}
+	}
+	return uniqueIDs(ids), nil
+}
+
+func (s *ImportService) findExistingNote(userID uint, categoryID *uint, title string) (uint, error) {
+	query := s.db.Model(&models.Note{}).Where("user_id = ? AND LOWER(title) = LOWER(?)", userID, title)
+	if categoryID == nil {
+		query = query.Where("category_id IS NULL")
+	} else {
+		query = query.Where("category_id = ?", *categoryID)
+	}
+
+	var ids []uint
+	if err := query.Limit(1).Pluck("id", &ids).Error; err != nil {
+		return 0, err
+	}
+	if len(ids) == 0 {
+		return 0, nil
+	}
+	return ids[0], nil
+}
+
+func (s *ImportService) update(job *models.ImportJob, fn func(*models.ImportJob)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fn(job)
+}
+
+// cleanupLocked 清理超过保留时间的已结束任务，调用方需持有锁
+func (s *ImportService) cleanupLocked() {
+	cutoff := time.Now().Add(-time.Duration(s.cfg.Import.JobRetentionHours) * time.Hour)
+	for id, job := range s.jobs {
+		if job.FinishedAt != nil && job.FinishedAt.Before(cutoff) {
+			delete(s.jobs, id)
+		}
+	}
+}
+
+func copyImportJob(job *models.ImportJob) *models.ImportJob {
+	snapshot := *job
+	snapshot.Conflicts = append([]models.ImportConflict{}, job.Conflicts...)
+	snapshot.Issues = append([]models.ImportIssue{}, job.Issues...)
+	return &snapshot
+}
+
+func truncateRunes(value string, max int) string {
+	if runes := []rune(value); len(runes) > max {
+		return string(runes[:max])
+	}
+	return value
+}
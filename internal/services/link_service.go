@@ -0,0 +1,385 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"notes-backend/internal/models"
+	"notes-backend/internal/utils"
+	"sort"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+const linksBackfilledKey = "note_links_backfilled"
+
+// LinkService 维护笔记之间的 [[标题]] / [[#ID]] 链接，提供出链、反向链接和关系图查询。
+// 链接只在同一用户的笔记之间解析，被授权的用户只能看到其中共享给自己的笔记
+type LinkService struct {
+	db          *gorm.DB
+	permissions *PermissionService
+}
+
+func NewLinkService(db *gorm.DB, permissions *PermissionService) *LinkService {
+	return &LinkService{db: db, permissions: permissions}
+}
+
+// linkScope 查看者在笔记所有者的笔记中能看到的范围，shared 为 nil 表示查看者就是所有者
+type linkScope struct {
+	ownerID uint
+	shared  map[uint]bool
+}
+
+func (v *linkScope) visible(noteID uint) bool {
+	return v.shared == nil || v.shared[noteID]
+}
+
+// GetLinks 获取笔记的出链，按在正文中出现的顺序排列
+func (s *LinkService) GetLinks(noteID, userID uint) ([]models.OutgoingLink, error) {
+	scope, err := s.noteScope(noteID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	var links []models.NoteLink
+	if err := s.db.Where("source_note_id = ?", noteID).Order("position").Find(&links).Error; err != nil {
+		return nil, err
+	}
+
+	var targetIDs []uint
+	for _, link := range links {
+		if link.TargetNoteID != nil {
+			targetIDs = append(targetIDs, *link.TargetNoteID)
+		}
+	}
+	targets, err := s.summaries(scope, targetIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]models.OutgoingLink, 0, len(links))
+	for _, link := range links {
+		outgoing := models.OutgoingLink{
+			TargetTitle: link.TargetTitle,
+			TargetID:    link.TargetNoteID,
+			Alias:       link.Alias,
+			Status:      models.LinkStatusUnresolved,
+		}
+
+		switch {
+		case link.TargetNoteID != nil:
+			if target, ok := targets[*link.TargetNoteID]; ok {
+				outgoing.Status = models.LinkStatusResolved
+				outgoing.Target = &target
+			}
+		case link.Ambiguous:
+			candidates, err := s.candidates(scope, link.TargetTitle)
+			if err != nil {
+				return nil, err
+			}
+			outgoing.Status = models.LinkStatusAmbiguous
+			outgoing.Candidates = candidates
+		}
+
+		result = append(result, outgoing)
+	}
+
+	return result, nil
+}
+
+// GetBacklinks 获取链接到该笔记的其它笔记，按更新时间倒序
+func (s *LinkService) GetBacklinks(noteID, userID uint) ([]models.Backlink, error) {
+	scope, err := s.noteScope(noteID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	var rows []struct {
+		SourceNoteID uint
+		Count        int
+	}
+	err = s.db.Table("note_links").
+		Select("note_links.source_note_id, COUNT(*) AS count").
+		Joins("JOIN notes ON notes.id = note_links.source_note_id").
+		Where("note_links.target_note_id = ? AND notes.user_id = ? AND notes.deleted_at IS NULL", noteID, scope.ownerID).
+		Group("note_links.source_note_id").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]uint, len(rows))
+	for i, row := range rows {
+		ids[i] = row.SourceNoteID
+	}
+	sources, err := s.summaries(scope, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	backlinks := make([]models.Backlink, 0, len(rows))
+	for _, row := range rows {
+		if source, ok := sources[row.SourceNoteID]; ok {
+			backlinks = append(backlinks, models.Backlink{Note: source, Count: row.Count})
+		}
+	}
+	sort.Slice(backlinks, func(i, j int) bool {
+		return backlinks[i].Note.UpdatedAt.After(backlinks[j].Note.UpdatedAt)
+	})
+
+	return backlinks, nil
+}
+
+// GetGraph 返回用户可以访问的笔记（自己的笔记和共享给自己的笔记）及其之间已解析链接组成的关系图
+func (s *LinkService) GetGraph(userID uint) (*models.Graph, error) {
+	graph := &models.Graph{
+		Nodes: []models.GraphNode{},
+		Edges: []models.GraphEdge{},
+	}
+
+	sharedIDs, err := s.permissions.SharedNoteIDs(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	nodes := s.db.Model(&models.Note{}).Select("id, title, category_id, is_public")
+	edges := s.db.Table("note_links").
+		Select("note_links.source_note_id AS source, note_links.target_note_id AS target, COUNT(*) AS count").
+		Joins("JOIN notes src ON src.id = note_links.source_note_id").
+		Joins("JOIN notes dst ON dst.id = note_links.target_note_id").
+		Where("src.deleted_at IS NULL AND dst.deleted_at IS NULL")
+	if len(sharedIDs) > 0 {
+		nodes = nodes.Where("user_id = ? OR id IN ?", userID, sharedIDs)
+		edges = edges.Where("(src.user_id = ? OR src.id IN ?) AND (dst.user_id = ? OR dst.id IN ?)",
+			userID, sharedIDs, userID, sharedIDs)
+	} else {
+		nodes = nodes.Where("user_id = ?", userID)
+		edges = edges.Where("src.user_id = ?", userID)
+	}
+
+	if err := nodes.Order("id").Scan(&graph.Nodes).Error; err != nil {
+		return nil, err
+	}
+
+	err = edges.
+		Group("note_links.source_note_id, note_links.target_note_id").
+		Order("note_links.source_note_id, note_links.target_note_id").
+		Scan(&graph.Edges).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return graph, nil
+}
+
+// Backfill 为启用链接功能之前创建的笔记解析链接，只执行一次
+func (s *LinkService) Backfill() {
+	var count int64
+	if err := s.db.Model(&models.SystemConfig{}).Where("key = ?", linksBackfilledKey).Count(&count).Error; err != nil {
+		fmt.Printf("Note link backfill failed: %v\n", err)
+		return
+	}
+	if count > 0 {
+		return
+	}
+
+	var notes []models.Note
+	if err := s.db.Unscoped().Select("id, user_id, content").Order("id").Find(&notes).Error; err != nil {
+		fmt.Printf("Note link backfill failed: %v\n", err)
+		return
+	}
+
+	for _, note := range notes {
+		if err := s.syncInTx(s.db, note.ID, note.UserID, note.Content); err != nil {
+			fmt.Printf("Note link backfill failed for note %d: %v\n", note.ID, err)
+			return
+		}
+	}
+
+	err := s.db.Create(&models.SystemConfig{
+		Key:         linksBackfilledKey,
+		Value:       "true",
+		Description: "已为历史笔记解析 [[链接]]",
+		IsActive:    true,
+	}).Error
+	if err != nil {
+		fmt.Printf("Note link backfill failed: %v\n", err)
+		return
+	}
+
+	fmt.Printf("Note links parsed for %d notes\n", len(notes))
+}
+
+// syncInTx 重新解析笔记正文中的链接并替换已有记录
+func (s *LinkService) syncInTx(tx *gorm.DB, noteID, userID uint, content string) error {
+	if err := tx.Where("source_note_id = ?", noteID).Delete(&models.NoteLink{}).Error; err != nil {
+		return err
+	}
+
+	parsed := utils.ParseWikiLinks(content)
+	if len(parsed) == 0 {
+		return nil
+	}
+
+	resolved := make(map[string][]uint)
+	links := make([]models.NoteLink, 0, len(parsed))
+	for i, wikiLink := range parsed {
+		link := models.NoteLink{
+			SourceNoteID: noteID,
+			TargetTitle:  wikiLink.Title,
+			Alias:        wikiLink.Alias,
+			Position:     i,
+		}
+
+		if wikiLink.NoteID != 0 {
+			var count int64
+			if err := tx.Unscoped().Model(&models.Note{}).Where("id = ? AND user_id = ?", wikiLink.NoteID, userID).Count(&count).Error; err != nil {
+				return err
+			}
+			if count > 0 {
+				targetID := wikiLink.NoteID
+				link.TargetNoteID = &targetID
+			}
+		} else {
+			key := strings.ToLower(wikiLink.Title)
+			ids, ok := resolved[key]
+			if !ok {
+				var err error
+				if ids, err = matchTitle(tx, userID, wikiLink.Title); err != nil {
+					return err
+				}
+				resolved[key] = ids
+			}
+			applyMatches(&link, ids)
+		}
+
+		links = append(links, link)
+	}
+
+	return tx.Create(&links).Error
+}
+
+// resolveTitleLinksInTx 在笔记标题变化、删除或恢复后重新解析指向这些标题的链接
+func resolveTitleLinksInTx(tx *gorm.DB, userID uint, titles ...string) error {
+	seen := make(map[string]bool)
+	for _, title := range titles {
+		title = strings.TrimSpace(title)
+		key := strings.ToLower(title)
+		if title == "" || seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		ids, err := matchTitle(tx, userID, title)
+		if err != nil {
+			return err
+		}
+
+		var link models.NoteLink
+		applyMatches(&link, ids)
+
+		err = tx.Model(&models.NoteLink{}).
+			Where("target_title <> '' AND LOWER(target_title) = LOWER(?)", title).
+			Where("source_note_id IN (SELECT id FROM notes WHERE user_id = ?)", userID).
+			Updates(map[string]interface{}{
+				"target_note_id": link.TargetNoteID,
+				"ambiguous":      link.Ambiguous,
+			}).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// matchTitle 查找标题匹配（不区分大小写）的未删除笔记
+func matchTitle(tx *gorm.DB, userID uint, title string) ([]uint, error) {
+	var ids []uint
+	err := tx.Model(&models.Note{}).
+		Where("user_id = ? AND LOWER(title) = LOWER(?)", userID, strings.TrimSpace(title)).
+		Order("id").
+		Pluck("id", &ids).Error
+	return ids, err
+}
+
+func applyMatches(link *models.NoteLink, ids []uint) {
+	link.TargetNoteID = nil
+	link.Ambiguous = len(ids) > 1
+	if len(ids) == 1 {
+		targetID := ids[0]
+		link.TargetNoteID = &targetID
+	}
+}
+
+func (s *LinkService) candidates(scope *linkScope, title string) ([]models.NoteSummary, error) {
+	var matches []models.NoteSummary
+	err := s.db.Model(&models.Note{}).
+		Select("id, title, updated_at").
+		Where("user_id = ? AND LOWER(title) = LOWER(?)", scope.ownerID, title).
+		Order("id").
+		Scan(&matches).Error
+	if err != nil {
+		return nil, err
+	}
+
+	candidates := make([]models.NoteSummary, 0, len(matches))
+	for _, match := range matches {
+		if scope.visible(match.ID) {
+			candidates = append(candidates, match)
+		}
+	}
+	return candidates, nil
+}
+
+// summaries 查询链接两端的笔记摘要，查看者看不到的笔记不返回
+func (s *LinkService) summaries(scope *linkScope, ids []uint) (map[uint]models.NoteSummary, error) {
+	result := make(map[uint]models.NoteSummary, len(ids))
+	if len(ids) == 0 {
+		return result, nil
+	}
+
+	var notes []models.NoteSummary
+	err := s.db.Model(&models.Note{}).
+		Select("id, title, updated_at").
+		Where("id IN ? AND user_id = ?", ids, scope.ownerID).
+		Scan(&notes).Error
+	if err != nil {
+		return nil, err
+	}
+
+	for _, note := range notes {
+		if scope.visible(note.ID) {
+			result[note.ID] = note
+		}
+	}
+	return result, nil
+}
+
+// noteScope 检查用户能否查看笔记，返回查看者在笔记所有者的笔记中能看到的范围
+func (s *LinkService) noteScope(noteID, userID uint) (*linkScope, error) {
+	role, err := s.permissions.NoteRole(noteID, userID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("笔记不存在")
+		}
+		return nil, err
+	}
+	if role == models.PermissionOwner {
+		return &linkScope{ownerID: userID}, nil
+	}
+
+	var note models.Note
+	if err := s.db.Select("id", "user_id").Where("id = ?", noteID).First(&note).Error; err != nil {
+		return nil, err
+	}
+
+	sharedIDs, err := s.permissions.SharedNoteIDs(userID)
+	if err != nil {
+		return nil, err
+	}
+	scope := &linkScope{ownerID: note.UserID, shared: make(map[uint]bool, len(sharedIDs))}
+	for _, id := range sharedIDs {
+		scope.shared[id] = true
+	}
+	return scope, nil
+}
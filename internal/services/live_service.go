@@ -0,0 +1,609 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"notes-backend/internal/config"
+	"notes-backend/internal/models"
+	"notes-backend/internal/utils"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	// liveSendBuffer 每个连接待发送消息的缓冲数，写满说明客户端跟不上，直接断开
+	liveSendBuffer = 256
+	// liveRoleCheckInterval 提交修改时重新检查连接用户角色的间隔，授权被收回后最迟在此时间后生效
+	liveRoleCheckInterval = 5 * time.Second
+)
+
+// LiveHub 协同编辑会话：按笔记分发文本操作、光标和在线状态。
+// 当前只有单进程的内存实现 MemoryLiveHub；多实例部署时可以基于发布订阅实现同一接口，
+// 但同一笔记的操作仍需由一处按顺序分配序号
+type LiveHub interface {
+	// Join 加入笔记的协同编辑，客户端随后会收到文档快照
+	Join(noteID uint, client *LiveClient) error
+	Leave(noteID uint, client *LiveClient)
+	// Submit 提交基于 revision 的操作，服务端变换到最新序号后应用并广播
+	Submit(noteID uint, client *LiveClient, revision int, op utils.TextOperation)
+	MoveCursor(noteID uint, client *LiveClient, cursor models.LiveCursor)
+}
+
+// LiveClient 一个 WebSocket 连接，发给它的消息通过 Messages 读取，连接被服务端断开时通道关闭
+type LiveClient struct {
+	ID       string
+	UserID   uint
+	Username string
+	Role     string
+
+	cursor        *models.LiveCursor
+	roleCheckedAt time.Time
+	send          chan []byte
+	once          sync.Once
+}
+
+func NewLiveClient(userID uint, username, role string) *LiveClient {
+	return &LiveClient{
+		ID:            uuid.NewString(),
+		UserID:        userID,
+		Username:      username,
+		Role:          role,
+		roleCheckedAt: time.Now(),
+		send:          make(chan []byte, liveSendBuffer),
+	}
+}
+
+func (c *LiveClient) Messages() <-chan []byte {
+	return c.send
+}
+
+// CanEdit 只有所有者和 editor 可以提交修改，其他角色只能查看和同步光标
+func (c *LiveClient) CanEdit() bool {
+	return roleAtLeast(c.Role, models.PermissionEditor)
+}
+
+func (c *LiveClient) deliver(data []byte) bool {
+	select {
+	case c.send <- data:
+		return true
+	default:
+		return false
+	}
+}
+
+func (c *LiveClient) close() {
+	c.once.Do(func() { close(c.send) })
+}
+
+// liveDocument 内存中正在协同编辑的笔记。history 保存序号 historyStart 之后的操作，
+// savedContent 为数据库中版本 version 的正文，用于和其他途径的修改做三方合并。
+// unrecorded 表示已保存的正文还没有记录历史版本，editors 为上次保存之后提交过修改的用户
+type liveDocument struct {
+	mu           sync.Mutex
+	noteID       uint
+	content      string
+	length       int
+	revision     int
+	history      []utils.TextOperation
+	historyStart int
+	clients      map[string]*LiveClient
+	closed       bool
+
+	version      int
+	savedContent string
+	dirty        bool
+	ownerID      uint
+	lastEditor   uint
+	editors      map[uint]bool
+	unrecorded   bool
+	revisionAt   time.Time
+}
+
+// MemoryLiveHub 单进程的协同编辑实现，正文定期通过 NoteService 保存，
+// 历史版本按 revision_interval_minutes 记录，最后一个连接断开时立即保存、记录历史版本并释放
+type MemoryLiveHub struct {
+	db    *gorm.DB
+	notes *NoteService
+	cfg   config.LiveConfig
+
+	mu   sync.Mutex
+	docs map[uint]*liveDocument
+}
+
+func NewMemoryLiveHub(db *gorm.DB, notes *NoteService, cfg config.LiveConfig) *MemoryLiveHub {
+	return &MemoryLiveHub{
+		db:    db,
+		notes: notes,
+		cfg:   cfg,
+		docs:  make(map[uint]*liveDocument),
+	}
+}
+
+// StartAutosave 启动后台任务，定期保存有修改的协同编辑文档
+func (h *MemoryLiveHub) StartAutosave() {
+	interval := time.Duration(h.cfg.SaveIntervalSeconds) * time.Second
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			h.SaveAll()
+		}
+	}()
+}
+
+// SaveAll 保存所有有修改的文档，并释放已经没有连接的文档
+func (h *MemoryLiveHub) SaveAll() {
+	h.mu.Lock()
+	docs := make([]*liveDocument, 0, len(h.docs))
+	for _, doc := range h.docs {
+		docs = append(docs, doc)
+	}
+	h.mu.Unlock()
+
+	for _, doc := range docs {
+		doc.mu.Lock()
+		if !doc.closed {
+			final := len(doc.clients) == 0
+			h.persist(doc, final)
+			if final {
+				h.release(doc)
+			}
+		}
+		doc.mu.Unlock()
+	}
+}
+
+func (h *MemoryLiveHub) Join(noteID uint, client *LiveClient) error {
+	for {
+		doc, err := h.document(noteID)
+		if err != nil {
+			return err
+		}
+
+		doc.mu.Lock()
+		if doc.closed {
+			// 文档刚被释放，重新加载
+			doc.mu.Unlock()
+			continue
+		}
+
+		doc.clients[client.ID] = client
+		h.send(doc, client, h.snapshot(doc, client))
+		h.broadcastPresence(doc, client.ID)
+		doc.mu.Unlock()
+		return nil
+	}
+}
+
+func (h *MemoryLiveHub) Leave(noteID uint, client *LiveClient) {
+	h.mu.Lock()
+	doc := h.docs[noteID]
+	h.mu.Unlock()
+
+	if doc == nil {
+		client.close()
+		return
+	}
+
+	doc.mu.Lock()
+	defer doc.mu.Unlock()
+
+	delete(doc.clients, client.ID)
+	client.close()
+
+	if doc.closed {
+		return
+	}
+	if len(doc.clients) == 0 {
+		h.persist(doc, true)
+		h.release(doc)
+		return
+	}
+	h.broadcastPresence(doc, "")
+}
+
+func (h *MemoryLiveHub) Submit(noteID uint, client *LiveClient, revision int, op utils.TextOperation) {
+	doc := h.lookup(noteID)
+	if doc == nil {
+		return
+	}
+
+	doc.mu.Lock()
+	defer doc.mu.Unlock()
+
+	if _, ok := doc.clients[client.ID]; !ok {
+		return
+	}
+	if !h.refreshRole(doc, client) {
+		return
+	}
+	if !client.CanEdit() {
+		h.sendError(doc, client, ErrNoteForbidden.Error())
+		return
+	}
+	if revision < doc.historyStart || revision > doc.revision {
+		h.sendError(doc, client, "文档版本已过期，请重新同步")
+		h.send(doc, client, h.snapshot(doc, client))
+		return
+	}
+
+	// 将操作变换到最新序号之后
+	for _, past := range doc.history[revision-doc.historyStart:] {
+		transformed, _, err := utils.TransformText(op, past)
+		if err != nil {
+			h.sendError(doc, client, "无效的操作: "+err.Error())
+			h.send(doc, client, h.snapshot(doc, client))
+			return
+		}
+		op = transformed
+	}
+
+	if err := h.apply(doc, op); err != nil {
+		h.sendError(doc, client, "无效的操作: "+err.Error())
+		h.send(doc, client, h.snapshot(doc, client))
+		return
+	}
+	doc.lastEditor = client.UserID
+	doc.editors[client.UserID] = true
+
+	h.send(doc, client, &models.LiveMessage{Type: models.LiveMessageAck, Revision: doc.revision})
+	h.broadcastOperation(doc, client, op)
+}
+
+func (h *MemoryLiveHub) MoveCursor(noteID uint, client *LiveClient, cursor models.LiveCursor) {
+	doc := h.lookup(noteID)
+	if doc == nil {
+		return
+	}
+
+	doc.mu.Lock()
+	defer doc.mu.Unlock()
+
+	if _, ok := doc.clients[client.ID]; !ok {
+		return
+	}
+	if cursor.Position < 0 || cursor.Position > doc.length || cursor.SelectionEnd < 0 || cursor.SelectionEnd > doc.length {
+		return
+	}
+
+	client.cursor = &cursor
+	h.broadcast(doc, client.ID, &models.LiveMessage{
+		Type:     models.LiveMessageCursor,
+		ClientID: client.ID,
+		UserID:   client.UserID,
+		Revision: doc.revision,
+		Cursor:   &cursor,
+	})
+}
+
+func (h *MemoryLiveHub) lookup(noteID uint) *liveDocument {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.docs[noteID]
+}
+
+// document 返回内存中的文档，不存在时从数据库加载
+func (h *MemoryLiveHub) document(noteID uint) (*liveDocument, error) {
+	if doc := h.lookup(noteID); doc != nil {
+		return doc, nil
+	}
+
+	var note models.Note
+	if err := h.db.Select("id", "user_id", "content", "version").Where("id = ?", noteID).First(&note).Error; err != nil {
+		return nil, err
+	}
+
+	loaded := &liveDocument{
+		noteID:       noteID,
+		ownerID:      note.UserID,
+		editors:      make(map[uint]bool),
+		content:      note.Content,
+		length:       len([]rune(note.Content)),
+		clients:      make(map[string]*LiveClient),
+		version:      note.Version,
+		savedContent: note.Content,
+		revisionAt:   time.Now(),
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if doc := h.docs[noteID]; doc != nil {
+		return doc, nil
+	}
+	h.docs[noteID] = loaded
+	return loaded, nil
+}
+
+// release 释放没有连接的文档，调用方需持有 doc.mu
+func (h *MemoryLiveHub) release(doc *liveDocument) {
+	doc.closed = true
+
+	h.mu.Lock()
+	if h.docs[doc.noteID] == doc {
+		delete(h.docs, doc.noteID)
+	}
+	h.mu.Unlock()
+}
+
+// apply 应用已变换到最新序号的操作，并移动所有连接的光标
+func (h *MemoryLiveHub) apply(doc *liveDocument, op utils.TextOperation) error {
+	content, err := op.Apply(doc.content)
+	if err != nil {
+		return err
+	}
+
+	doc.content = content
+	doc.length = op.TargetLength()
+	doc.revision++
+	doc.history = append(doc.history, op)
+	doc.dirty = true
+
+	if size := h.cfg.HistorySize; size > 0 && len(doc.history) > size {
+		drop := len(doc.history) - size
+		doc.history = append([]utils.TextOperation(nil), doc.history[drop:]...)
+		doc.historyStart += drop
+	}
+
+	for _, client := range doc.clients {
+		if client.cursor != nil {
+			client.cursor.Position = op.TransformIndex(client.cursor.Position)
+			client.cursor.SelectionEnd = op.TransformIndex(client.cursor.SelectionEnd)
+		}
+	}
+
+	return nil
+}
+
+// persist 保存有修改的文档，调用方需持有 doc.mu。笔记在此期间被其他途径修改时，
+// 以上次保存的正文为基准做三方合并，合并结果作为一次服务端操作广播给所有连接后再保存。
+// 距上次记录历史版本超过间隔或 final 为 true（会话结束）时同时记录历史版本
+func (h *MemoryLiveHub) persist(doc *liveDocument, final bool) {
+	if !doc.dirty {
+		if final && doc.unrecorded {
+			h.recordRevision(doc)
+		}
+		return
+	}
+
+	record := final || time.Since(doc.revisionAt) >= h.revisionInterval()
+	note, err := h.saveContent(doc, record)
+	if errors.Is(err, ErrVersionConflict) {
+		if err = h.mergeExternal(doc); err == nil {
+			note, err = h.saveContent(doc, record)
+		}
+	}
+
+	if err != nil {
+		fmt.Printf("Live note save failed: note_id=%d, error=%v\n", doc.noteID, err)
+		// 以所有者保存仍然失败说明笔记已被删除，不再重试，断开所有连接
+		if errors.Is(err, gorm.ErrRecordNotFound) || errors.Is(err, ErrNoteForbidden) {
+			doc.dirty = false
+			doc.unrecorded = false
+			h.disconnectAll(doc, "笔记已被删除或没有编辑权限，协同编辑已结束")
+		}
+		return
+	}
+
+	doc.version = note.Version
+	doc.savedContent = doc.content
+	doc.dirty = false
+	doc.editors = make(map[uint]bool)
+	if record {
+		doc.unrecorded = false
+		doc.revisionAt = time.Now()
+	} else {
+		doc.unrecorded = true
+	}
+
+	h.broadcast(doc, "", &models.LiveMessage{
+		Type:     models.LiveMessageSaved,
+		Revision: doc.revision,
+		Version:  note.Version,
+	})
+}
+
+// saveContent 以仍有编辑权限的用户保存合并后的正文：依次尝试最后一个编辑者、
+// 上次保存之后参与编辑的其他用户，最后以笔记所有者保存，某个编辑者失去权限不会丢弃其他人的修改
+func (h *MemoryLiveHub) saveContent(doc *liveDocument, record bool) (*models.Note, error) {
+	savers := []uint{doc.lastEditor}
+	for userID := range doc.editors {
+		if userID != doc.lastEditor && userID != doc.ownerID {
+			savers = append(savers, userID)
+		}
+	}
+	if doc.lastEditor != doc.ownerID {
+		savers = append(savers, doc.ownerID)
+	}
+
+	var note *models.Note
+	var err error
+	for _, userID := range savers {
+		note, err = h.notes.SaveLiveContent(doc.noteID, userID, doc.content, doc.version, record)
+		// 授权被完全收回时 NoteRole 返回 ErrRecordNotFound，同样换下一个用户
+		if !errors.Is(err, ErrNoteForbidden) && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return note, err
+		}
+	}
+	return note, err
+}
+
+// refreshRole 定期重新检查连接用户的角色，调用方需持有 doc.mu。
+// 已经没有任何权限时断开该连接并返回 false，角色变化时广播在线状态
+func (h *MemoryLiveHub) refreshRole(doc *liveDocument, client *LiveClient) bool {
+	if time.Since(client.roleCheckedAt) < liveRoleCheckInterval {
+		return true
+	}
+
+	role, err := h.notes.permissions.NoteRole(doc.noteID, client.UserID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		h.sendError(doc, client, "没有访问该笔记的权限，协同编辑已结束")
+		delete(doc.clients, client.ID)
+		client.close()
+		h.broadcastPresence(doc, "")
+		return false
+	}
+	if err != nil {
+		// 检查失败时沿用原来的角色，下次提交时重试
+		fmt.Printf("Live role check failed: note_id=%d, user_id=%d, error=%v\n", doc.noteID, client.UserID, err)
+		return true
+	}
+
+	client.roleCheckedAt = time.Now()
+	if role != client.Role {
+		client.Role = role
+		h.broadcastPresence(doc, "")
+	}
+	return true
+}
+
+// recordRevision 为已保存但尚未记录的正文补记历史版本，调用方需持有 doc.mu
+func (h *MemoryLiveHub) recordRevision(doc *liveDocument) {
+	if err := h.notes.RecordLiveRevision(doc.noteID, doc.lastEditor); err != nil {
+		fmt.Printf("Live note revision failed: note_id=%d, error=%v\n", doc.noteID, err)
+		return
+	}
+	doc.unrecorded = false
+	doc.revisionAt = time.Now()
+}
+
+func (h *MemoryLiveHub) revisionInterval() time.Duration {
+	return time.Duration(h.cfg.RevisionIntervalMinutes) * time.Minute
+}
+
+// mergeExternal 将数据库中的新版本合并到当前文档
+func (h *MemoryLiveHub) mergeExternal(doc *liveDocument) error {
+	var note models.Note
+	if err := h.db.Select("id", "content", "version").Where("id = ?", doc.noteID).First(&note).Error; err != nil {
+		return err
+	}
+
+	theirs := utils.DiffText(doc.savedContent, note.Content)
+	ours := utils.DiffText(doc.savedContent, doc.content)
+	op, _, err := utils.TransformText(theirs, ours)
+	if err != nil {
+		return err
+	}
+
+	if !op.IsNoop() {
+		if err := h.apply(doc, op); err != nil {
+			return err
+		}
+		h.broadcastOperation(doc, nil, op)
+	}
+
+	doc.version = note.Version
+	doc.savedContent = note.Content
+	return nil
+}
+
+func (h *MemoryLiveHub) snapshot(doc *liveDocument, client *LiveClient) *models.LiveMessage {
+	content := doc.content
+	return &models.LiveMessage{
+		Type:     models.LiveMessageSnapshot,
+		ClientID: client.ID,
+		UserID:   client.UserID,
+		Revision: doc.revision,
+		Content:  &content,
+		Version:  doc.version,
+		Clients:  participants(doc),
+	}
+}
+
+// broadcastOperation 广播操作，client 为空表示服务端合并产生的操作
+func (h *MemoryLiveHub) broadcastOperation(doc *liveDocument, client *LiveClient, op utils.TextOperation) {
+	data, err := json.Marshal(op)
+	if err != nil {
+		return
+	}
+
+	msg := &models.LiveMessage{
+		Type:      models.LiveMessageOperation,
+		Revision:  doc.revision,
+		Operation: data,
+	}
+	exclude := ""
+	if client != nil {
+		msg.ClientID = client.ID
+		msg.UserID = client.UserID
+		exclude = client.ID
+	}
+	h.broadcast(doc, exclude, msg)
+}
+
+func (h *MemoryLiveHub) broadcastPresence(doc *liveDocument, exclude string) {
+	h.broadcast(doc, exclude, &models.LiveMessage{
+		Type:     models.LiveMessagePresence,
+		Revision: doc.revision,
+		Clients:  participants(doc),
+	})
+}
+
+func (h *MemoryLiveHub) broadcast(doc *liveDocument, exclude string, msg *models.LiveMessage) {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return
+	}
+	for id, client := range doc.clients {
+		if id != exclude {
+			h.deliver(doc, client, data)
+		}
+	}
+}
+
+func (h *MemoryLiveHub) send(doc *liveDocument, client *LiveClient, msg *models.LiveMessage) {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return
+	}
+	h.deliver(doc, client, data)
+}
+
+func (h *MemoryLiveHub) sendError(doc *liveDocument, client *LiveClient, message string) {
+	h.send(doc, client, &models.LiveMessage{Type: models.LiveMessageError, Revision: doc.revision, Message: message})
+}
+
+// deliver 发送消息，缓冲区已满时断开该连接
+func (h *MemoryLiveHub) deliver(doc *liveDocument, client *LiveClient, data []byte) {
+	if !client.deliver(data) {
+		fmt.Printf("Live client too slow, disconnecting: note_id=%d, client_id=%s\n", doc.noteID, client.ID)
+		delete(doc.clients, client.ID)
+		client.close()
+	}
+}
+
+func (h *MemoryLiveHub) disconnectAll(doc *liveDocument, message string) {
+	data, _ := json.Marshal(&models.LiveMessage{Type: models.LiveMessageError, Revision: doc.revision, Message: message})
+	for id, client := range doc.clients {
+		client.deliver(data)
+		delete(doc.clients, id)
+		client.close()
+	}
+}
+
+func participants(doc *liveDocument) []models.LiveParticipant {
+	list := make([]models.LiveParticipant, 0, len(doc.clients))
+	for _, client := range doc.clients {
+		var cursor *models.LiveCursor
+		if client.cursor != nil {
+			c := *client.cursor
+			cursor = &c
+		}
+		list = append(list, models.LiveParticipant{
+			ClientID: client.ID,
+			UserID:   client.UserID,
+			Username: client.Username,
+			Role:     client.Role,
+			Cursor:   cursor,
+		})
+	}
+	return list
+}
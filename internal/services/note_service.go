@@ -3,16 +3,28 @@ package services
 
 import (
 	"crypto/md5"
+	"errors"
 	"fmt"
 	"math"
 	"notes-backend/internal/models"
+	"notes-backend/internal/search"
+	"notes-backend/internal/utils"
+	"strings"
 	"time"
 
 	"gorm.io/gorm"
 )
 
+// ErrVersionConflict 客户端提交的版本号与服务端不一致（If-Match 校验失败）
+var ErrVersionConflict = errors.New("笔记已被其他客户端修改，请刷新后重试")
+
 type NoteService struct {
-	db *gorm.DB
+	db          *gorm.DB
+	revisions   *RevisionService
+	search      *SearchService
+	links       *LinkService
+	analytics   *AnalyticsService
+	permissions *PermissionService
 }
 
 type UserStats struct {
@@ -24,8 +36,8 @@ type UserStats struct {
 	TotalViews      int64 `json:"total_views"`
 }
 
-func NewNoteService(db *gorm.DB) *NoteService {
-	return &NoteService{db: db}
+func NewNoteService(db *gorm.DB, revisions *RevisionService, search *SearchService, links *LinkService, analytics *AnalyticsService, permissions *PermissionService) *NoteService {
+	return &NoteService{db: db, revisions: revisions, search: search, links: links, analytics: analytics, permissions: permissions}
 }
 
 func (s *NoteService) GetNotes(userID uint, req *models.NoteListRequest) ([]models.Note, *models.Pagination, error) {
@@ -46,6 +58,18 @@ func (s *NoteService) GetNotes(userID uint, req *models.NoteListRequest) ([]mode
 		query = query.Joins("JOIN note_tags ON notes.id = note_tags.note_id").Where("note_tags.tag_id = ?", *req.TagID)
 	}
 
+	if req.Query != "" {
+		parsed, err := search.Parse(req.Query)
+		if err != nil {
+			return nil, nil, err
+		}
+		scopes, err := parsed.Scopes(s.db, userID, true)
+		if err != nil {
+			return nil, nil, err
+		}
+		query = query.Scopes(scopes...)
+	}
+
 	if err := query.Count(&total).Error; err != nil {
 		return nil, nil, err
 	}
@@ -104,7 +128,20 @@ func (s *NoteService) CreateNote(userID uint, req *models.NoteCreateRequest) (*m
 			}
 		}
 
-		return nil
+		if err := s.search.indexNoteInTx(tx, note.ID, note.Title, note.Content); err != nil {
+			return err
+		}
+
+		if err := s.links.syncInTx(tx, note.ID, userID, note.Content); err != nil {
+			return err
+		}
+
+		// 新笔记可能让其它笔记中尚未解析的链接指向它
+		if err := resolveTitleLinksInTx(tx, userID, note.Title); err != nil {
+			return err
+		}
+
+		return s.revisions.recordInTx(tx, note.ID, userID)
 	})
 
 	if err != nil {
@@ -116,40 +153,123 @@ func (s *NoteService) CreateNote(userID uint, req *models.NoteCreateRequest) (*m
 	return &note, nil
 }
 
-func (s *NoteService) UpdateNote(noteID, userID uint, req *models.NoteUpdateRequest) (*models.Note, error) {
+// finalizeImport 导入的笔记在附件保存后写入最终正文和原始时间戳。
+// 导入视为一次创建，不增加版本号，并按最终内容重新记录首个历史版本
+func (s *NoteService) finalizeImport(noteID, userID uint, content string, createdAt, updatedAt *time.Time) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		var note models.Note
+		if err := tx.Where("id = ? AND user_id = ?", noteID, userID).First(&note).Error; err != nil {
+			return err
+		}
+
+		updates := map[string]interface{}{"content": content}
+		if createdAt != nil {
+			updates["created_at"] = *createdAt
+		}
+		if updatedAt != nil {
+			updates["updated_at"] = *updatedAt
+		}
+		if err := tx.Model(&note).UpdateColumns(updates).Error; err != nil {
+			return err
+		}
+
+		if content != note.Content {
+			if err := s.search.indexNoteInTx(tx, note.ID, note.Title, content); err != nil {
+				return err
+			}
+			if err := s.links.syncInTx(tx, note.ID, userID, content); err != nil {
+				return err
+			}
+		}
+
+		if err := tx.Where("note_id = ?", note.ID).Delete(&models.NoteRevision{}).Error; err != nil {
+			return err
+		}
+		return s.revisions.recordInTx(tx, note.ID, userID)
+	})
+}
+
+// UpdateNote 更新笔记，所有者和被授权为 editor 的用户可以编辑，被授权的用户只能修改标题和正文，
+// 分类、标签和公开状态仍由所有者管理。expectedVersion 不为 nil 时仅在版本号一致时更新，否则返回 ErrVersionConflict
+func (s *NoteService) UpdateNote(noteID, userID uint, req *models.NoteUpdateRequest, expectedVersion *int) (*models.Note, error) {
+	role, err := s.permissions.NoteRole(noteID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if !roleAtLeast(role, models.PermissionEditor) {
+		return nil, ErrNoteForbidden
+	}
+	isOwner := role == models.PermissionOwner
+
 	var note models.Note
-	
-	if err := s.db.Where("id = ? AND user_id = ?", noteID, userID).First(&note).Error; err != nil {
+	if err := s.db.Where("id = ?", noteID).First(&note).Error; err != nil {
 		return nil, err
 	}
 
-	err := s.db.Transaction(func(tx *gorm.DB) error {
+	if expectedVersion != nil && *expectedVersion != note.Version {
+		return nil, ErrVersionConflict
+	}
+
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if err := s.revisions.ensureBaselineInTx(tx, note.ID, note.UserID); err != nil {
+			return err
+		}
+
 		updates := map[string]interface{}{
-			"title":       req.Title,
-			"content":     req.Content,
-			"category_id": req.CategoryID,
-			"is_public":   req.IsPublic,
+			"title":   req.Title,
+			"content": req.Content,
+			"version": gorm.Expr("version + 1"),
 		}
-		
-		if err := tx.Model(&note).Updates(updates).Error; err != nil {
-			return err
+		if isOwner {
+			updates["category_id"] = req.CategoryID
+			updates["is_public"] = req.IsPublic
 		}
 
-		if err := tx.Model(&note).Association("Tags").Clear(); err != nil {
-			return err
+		query := tx.Model(&note)
+		if expectedVersion != nil {
+			query = query.Where("version = ?", *expectedVersion)
 		}
 
-		if len(req.TagIDs) > 0 {
-			var tags []models.Tag
-			if err := tx.Where("id IN ? AND user_id = ?", req.TagIDs, userID).Find(&tags).Error; err != nil {
+		result := query.Updates(updates)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrVersionConflict
+		}
+
+		if isOwner {
+			if err := tx.Model(&note).Association("Tags").Clear(); err != nil {
 				return err
 			}
-			if err := tx.Model(&note).Association("Tags").Append(tags); err != nil {
+
+			if len(req.TagIDs) > 0 {
+				var tags []models.Tag
+				if err := tx.Where("id IN ? AND user_id = ?", req.TagIDs, userID).Find(&tags).Error; err != nil {
+					return err
+				}
+				if err := tx.Model(&note).Association("Tags").Append(tags); err != nil {
+					return err
+				}
+			}
+		}
+
+		if err := s.search.indexNoteInTx(tx, note.ID, req.Title, req.Content); err != nil {
+			return err
+		}
+
+		// 双向链接按所有者的笔记解析
+		if err := s.links.syncInTx(tx, note.ID, note.UserID, req.Content); err != nil {
+			return err
+		}
+
+		if note.Title != req.Title {
+			if err := s.renameLinksInTx(tx, note.ID, note.UserID, note.Title, req.Title); err != nil {
 				return err
 			}
 		}
 
-		return nil
+		return s.revisions.recordInTx(tx, note.ID, userID)
 	})
 
 	if err != nil {
@@ -157,12 +277,184 @@ func (s *NoteService) UpdateNote(noteID, userID uint, req *models.NoteUpdateRequ
 	}
 
 	s.db.Preload("Category").Preload("Tags").Preload("Attachments").First(&note, note.ID)
+	note.AccessRole = role
+
+	return &note, nil
+}
+
+// SaveLiveContent 保存协同编辑合并后的正文，只更新正文和版本号，标题、分类、标签和公开状态保持不变。
+// recordRevision 为 false 时不记录历史版本，由协同编辑按较长的间隔或在会话结束时记录。
+// 笔记版本不等于 expectedVersion 时返回 ErrVersionConflict
+func (s *NoteService) SaveLiveContent(noteID, userID uint, content string, expectedVersion int, recordRevision bool) (*models.Note, error) {
+	role, err := s.permissions.NoteRole(noteID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if !roleAtLeast(role, models.PermissionEditor) {
+		return nil, ErrNoteForbidden
+	}
+
+	var note models.Note
+	if err := s.db.Where("id = ?", noteID).First(&note).Error; err != nil {
+		return nil, err
+	}
+	if note.Version != expectedVersion {
+		return nil, ErrVersionConflict
+	}
+
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if err := s.revisions.ensureBaselineInTx(tx, note.ID, note.UserID); err != nil {
+			return err
+		}
+
+		result := tx.Model(&note).Where("version = ?", expectedVersion).Updates(map[string]interface{}{
+			"content": content,
+			"version": gorm.Expr("version + 1"),
+		})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrVersionConflict
+		}
 
+		if err := s.search.indexNoteInTx(tx, note.ID, note.Title, content); err != nil {
+			return err
+		}
+		if err := s.links.syncInTx(tx, note.ID, note.UserID, content); err != nil {
+			return err
+		}
+
+		if !recordRevision {
+			return nil
+		}
+		return s.revisions.recordInTx(tx, note.ID, userID)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.db.Where("id = ?", note.ID).First(&note).Error; err != nil {
+		return nil, err
+	}
 	return &note, nil
 }
 
-// DeleteNote 删除笔记 - 修复版本，添加详细日志和错误处理
-func (s *NoteService) DeleteNote(noteID, userID uint) error {
+// RecordLiveRevision 按笔记当前内容记录历史版本，用于协同编辑结束时补记尚未记录的修改
+func (s *NoteService) RecordLiveRevision(noteID, authorID uint) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		return s.revisions.recordInTx(tx, noteID, authorID)
+	})
+}
+
+// RestoreRevision 将笔记恢复到指定历史版本，恢复本身也会产生一个新版本
+func (s *NoteService) RestoreRevision(noteID, userID uint, revision int, expectedVersion *int) (*models.Note, error) {
+	rev, err := s.revisions.GetRevision(noteID, userID, revision)
+	if err != nil {
+		return nil, err
+	}
+
+	var note models.Note
+	if err := s.db.Where("id = ? AND user_id = ?", noteID, userID).First(&note).Error; err != nil {
+		return nil, err
+	}
+
+	// 原分类已被删除时不再关联
+	categoryID := rev.CategoryID
+	if categoryID != nil {
+		var count int64
+		if err := s.db.Model(&models.Category{}).Where("id = ? AND user_id = ?", *categoryID, userID).Count(&count).Error; err != nil {
+			return nil, err
+		}
+		if count == 0 {
+			categoryID = nil
+		}
+	}
+
+	req := &models.NoteUpdateRequest{
+		Title:      rev.Title,
+		Content:    rev.Content,
+		CategoryID: categoryID,
+		TagIDs:     rev.TagIDs,
+		IsPublic:   note.IsPublic,
+	}
+
+	return s.UpdateNote(noteID, userID, req, expectedVersion)
+}
+
+// renameLinksInTx 笔记改名后同步其它笔记中指向旧标题的 [[链接]]。
+// 新标题与其它笔记重名时改写为 [[#ID|显示文本]]，避免链接变为歧义
+func (s *NoteService) renameLinksInTx(tx *gorm.DB, noteID, userID uint, oldTitle, newTitle string) error {
+	if !strings.EqualFold(strings.TrimSpace(oldTitle), strings.TrimSpace(newTitle)) {
+		var sourceIDs []uint
+		err := tx.Model(&models.NoteLink{}).
+			Where("target_note_id = ? AND source_note_id <> ?", noteID, noteID).
+			Where("target_title <> '' AND LOWER(target_title) = LOWER(?)", strings.TrimSpace(oldTitle)).
+			Distinct().Pluck("source_note_id", &sourceIDs).Error
+		if err != nil {
+			return err
+		}
+
+		matches, err := matchTitle(tx, userID, newTitle)
+		if err != nil {
+			return err
+		}
+		useID := len(matches) > 1
+
+		for _, sourceID := range sourceIDs {
+			var source models.Note
+			if err := tx.Where("id = ?", sourceID).First(&source).Error; err != nil {
+				if err == gorm.ErrRecordNotFound {
+					continue
+				}
+				return err
+			}
+
+			content := utils.RewriteWikiLinks(source.Content, func(link utils.WikiLink) (string, bool) {
+				if link.Title == "" || !strings.EqualFold(link.Title, strings.TrimSpace(oldTitle)) {
+					return "", false
+				}
+				if useID {
+					alias := link.Alias
+					if alias == "" {
+						alias = link.Title
+					}
+					return utils.FormatWikiLink("", noteID, alias), true
+				}
+				return utils.FormatWikiLink(strings.TrimSpace(newTitle), 0, link.Alias), true
+			})
+			if content == source.Content {
+				continue
+			}
+
+			if err := s.revisions.ensureBaselineInTx(tx, source.ID, userID); err != nil {
+				return err
+			}
+			err := tx.Model(&source).Updates(map[string]interface{}{
+				"content": content,
+				"version": gorm.Expr("version + 1"),
+			}).Error
+			if err != nil {
+				return err
+			}
+			if err := s.search.indexNoteInTx(tx, source.ID, source.Title, content); err != nil {
+				return err
+			}
+			if err := s.links.syncInTx(tx, source.ID, userID, content); err != nil {
+				return err
+			}
+			if err := s.revisions.recordInTx(tx, source.ID, userID); err != nil {
+				return err
+			}
+		}
+	}
+
+	return resolveTitleLinksInTx(tx, userID, oldTitle, newTitle)
+}
+
+// DeleteNote 删除笔记（移入回收站） - 修复版本，添加详细日志和错误处理
+// expectedVersion 不为 nil 时仅在版本号一致时删除，否则返回 ErrVersionConflict
+func (s *NoteService) DeleteNote(noteID, userID uint, expectedVersion *int) error {
 	fmt.Printf("NoteService.DeleteNote called: noteID=%d, userID=%d\n", noteID, userID)
 
 	// 使用事务确保数据一致性
@@ -180,47 +472,180 @@ func (s *NoteService) DeleteNote(noteID, userID uint) error {
 
 		fmt.Printf("Found note to delete: %+v\n", note)
 
-		// 2. 删除相关的标签关联（多对多关系）
-		if err := tx.Model(&note).Association("Tags").Clear(); err != nil {
-			fmt.Printf("Error clearing note tags: %v\n", err)
-			return fmt.Errorf("删除标签关联失败: %v", err)
-		}
-
-		// 3. 删除相关的附件
-		if err := tx.Where("note_id = ?", noteID).Delete(&models.Attachment{}).Error; err != nil {
-			fmt.Printf("Error deleting attachments: %v\n", err)
-			return fmt.Errorf("删除附件失败: %v", err)
+		if expectedVersion != nil && *expectedVersion != note.Version {
+			return ErrVersionConflict
 		}
 
-		// 4. 删除相关的分享链接
-		if err := tx.Where("note_id = ?", noteID).Delete(&models.ShareLink{}).Error; err != nil {
-			fmt.Printf("Error deleting share links: %v\n", err)
-			return fmt.Errorf("删除分享链接失败: %v", err)
-		}
+		// 2. 标签关联、附件、分享链接和访问记录全部保留，以便从回收站恢复；
+		//    彻底删除时由 TrashService 统一清理
 
-		// 5. 删除相关的访问记录
-		if err := tx.Where("note_id = ?", noteID).Delete(&models.NoteVisit{}).Error; err != nil {
-			fmt.Printf("Error deleting note visits: %v\n", err)
-			return fmt.Errorf("删除访问记录失败: %v", err)
+		// 3. 软删除笔记本身，移入回收站
+		query := tx
+		if expectedVersion != nil {
+			query = query.Where("version = ?", *expectedVersion)
 		}
-
-		// 6. 最后删除笔记本身（软删除）
-		result := tx.Delete(&note)
+		result := query.Delete(&note)
 		if result.Error != nil {
 			fmt.Printf("Error deleting note: %v\n", result.Error)
 			return fmt.Errorf("删除笔记失败: %v", result.Error)
 		}
 
+		if result.RowsAffected == 0 && expectedVersion != nil {
+			return ErrVersionConflict
+		}
+
 		if result.RowsAffected == 0 {
 			fmt.Printf("No rows affected when deleting note: noteID=%d\n", noteID)
 			return fmt.Errorf("笔记不存在或无权限删除")
 		}
 
+		// 指向该标题的链接改为未解析（或由同名笔记接管）
+		if err := resolveTitleLinksInTx(tx, userID, note.Title); err != nil {
+			return err
+		}
+
 		fmt.Printf("Note deleted successfully: noteID=%d, rowsAffected=%d\n", noteID, result.RowsAffected)
 		return nil
 	})
 }
 
+// BulkUpdate 在同一事务中批量处理笔记，每条笔记使用独立的保存点，
+// 单条失败只回滚该条并记录原因，不影响其它笔记
+func (s *NoteService) BulkUpdate(userID uint, req *models.BulkNoteRequest) (*models.BulkNoteResponse, error) {
+	var tags []models.Tag
+
+	switch req.Action {
+	case models.BulkActionMove:
+		if req.CategoryID != nil {
+			var count int64
+			if err := s.db.Model(&models.Category{}).Where("id = ? AND user_id = ?", *req.CategoryID, userID).Count(&count).Error; err != nil {
+				return nil, err
+			}
+			if count == 0 {
+				return nil, fmt.Errorf("分类不存在")
+			}
+		}
+	case models.BulkActionAddTags, models.BulkActionRemoveTags:
+		if len(req.TagIDs) == 0 {
+			return nil, fmt.Errorf("请指定标签")
+		}
+		if err := s.db.Where("id IN ? AND user_id = ?", req.TagIDs, userID).Find(&tags).Error; err != nil {
+			return nil, err
+		}
+		if len(tags) != len(uniqueIDs(req.TagIDs)) {
+			return nil, fmt.Errorf("标签不存在")
+		}
+	case models.BulkActionSetVisibility:
+		if req.IsPublic == nil {
+			return nil, fmt.Errorf("请指定 is_public")
+		}
+	}
+
+	noteIDs := uniqueIDs(req.NoteIDs)
+	response := &models.BulkNoteResponse{
+		Action:  req.Action,
+		Total:   len(noteIDs),
+		Results: make([]models.BulkItemResult, 0, len(noteIDs)),
+	}
+
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		for i, noteID := range noteIDs {
+			savepoint := fmt.Sprintf("bulk_item_%d", i)
+			if err := tx.SavePoint(savepoint).Error; err != nil {
+				return err
+			}
+
+			result := models.BulkItemResult{NoteID: noteID, Success: true}
+			if err := s.bulkApplyInTx(tx, userID, noteID, req, tags); err != nil {
+				if err := tx.RollbackTo(savepoint).Error; err != nil {
+					return err
+				}
+				result.Success = false
+				result.Error = err.Error()
+				response.Failed++
+			} else {
+				response.Succeeded++
+			}
+			response.Results = append(response.Results, result)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return response, nil
+}
+
+// bulkApplyInTx 对单条笔记执行批量操作，先校验笔记归属
+func (s *NoteService) bulkApplyInTx(tx *gorm.DB, userID, noteID uint, req *models.BulkNoteRequest, tags []models.Tag) error {
+	if req.Action == models.BulkActionRestore {
+		return restoreNoteInTx(tx, noteID, userID)
+	}
+
+	var note models.Note
+	if err := tx.Where("id = ? AND user_id = ?", noteID, userID).First(&note).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return fmt.Errorf("笔记不存在或无权限操作")
+		}
+		return err
+	}
+
+	if req.Action == models.BulkActionDelete {
+		if err := tx.Delete(&note).Error; err != nil {
+			return err
+		}
+		return resolveTitleLinksInTx(tx, userID, note.Title)
+	}
+
+	// 分类和标签变化会记入历史版本，公开状态不属于版本内容
+	recordRevision := req.Action != models.BulkActionSetVisibility
+	if recordRevision {
+		if err := s.revisions.ensureBaselineInTx(tx, note.ID, userID); err != nil {
+			return err
+		}
+	}
+
+	updates := map[string]interface{}{"version": gorm.Expr("version + 1")}
+
+	switch req.Action {
+	case models.BulkActionMove:
+		updates["category_id"] = req.CategoryID
+	case models.BulkActionSetVisibility:
+		updates["is_public"] = *req.IsPublic
+	case models.BulkActionAddTags:
+		if err := tx.Model(&note).Association("Tags").Append(tags); err != nil {
+			return err
+		}
+	case models.BulkActionRemoveTags:
+		if err := tx.Model(&note).Association("Tags").Delete(tags); err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Model(&note).Updates(updates).Error; err != nil {
+		return err
+	}
+
+	if recordRevision {
+		return s.revisions.recordInTx(tx, note.ID, userID)
+	}
+	return nil
+}
+
+func uniqueIDs(ids []uint) []uint {
+	seen := make(map[uint]bool, len(ids))
+	result := make([]uint, 0, len(ids))
+	for _, id := range ids {
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		result = append(result, id)
+	}
+	return result
+}
+
 func (s *NoteService) GetUserStats(userID uint) (*UserStats, error) {
 	var stats UserStats
 
@@ -253,7 +678,8 @@ func (s *NoteService) GetUserStats(userID uint) (*UserStats, error) {
 	return &stats, nil
 }
 
-func (s *NoteService) RecordView(noteID, viewerID uint, clientIP, userAgent, viewHash string) error {
+// RecordView 记录登录用户的浏览，机器人访问只记录不计入浏览量
+func (s *NoteService) RecordView(noteID, viewerID uint, viewerInfo *models.ViewerInfo, viewHash string) error {
 	return s.db.Transaction(func(tx *gorm.DB) error {
 		var note models.Note
 		if err := tx.Where("id = ?", noteID).First(&note).Error; err != nil {
@@ -264,40 +690,52 @@ func (s *NoteService) RecordView(noteID, viewerID uint, clientIP, userAgent, vie
 			return nil
 		}
 
+		visit := models.NoteVisit{
+			NoteID:    noteID,
+			ViewerID:  &viewerID,
+			VisitorIP: &viewerInfo.IP,
+			UserAgent: &viewerInfo.UserAgent,
+			ViewHash:  &viewHash,
+			VisitedAt: time.Now(),
+		}
+		// IP 按配置截断或哈希后再去重和保存
+		s.analytics.PrepareVisit(&visit, viewerInfo)
+
 		var existingVisit models.NoteVisit
 		oneHourAgo := time.Now().Add(-1 * time.Hour)
 		
-		err := tx.Where("note_id = ? AND visitor_ip = ? AND visited_at > ? AND view_hash = ?", 
-			noteID, clientIP, oneHourAgo, viewHash).First(&existingVisit).Error
+		err := tx.Where("note_id = ? AND visited_at > ? AND view_hash = ? AND is_bot = ?", 
+			noteID, oneHourAgo, *visit.ViewHash, visit.IsBot).First(&existingVisit).Error
 		
 		if err == nil {
 			return nil
 		}
 
-		visit := models.NoteVisit{
-			NoteID:    noteID,
-			ViewerID:  &viewerID,
-			VisitorIP: &clientIP,
-			UserAgent: &userAgent,
-			ViewHash:  &viewHash,
-			VisitedAt: time.Now(),
-		}
-
 		if err := tx.Create(&visit).Error; err != nil {
 			return err
 		}
 
+		if visit.IsBot {
+			return nil
+		}
 		return tx.Model(&note).Update("view_count", gorm.Expr("view_count + 1")).Error
 	})
 }
 
+// GetNoteByID 获取笔记，所有者和被授权的用户都可以查看
 func (s *NoteService) GetNoteByID(noteID, userID uint) (*models.Note, error) {
+	role, err := s.permissions.NoteRole(noteID, userID)
+	if err != nil {
+		return nil, err
+	}
+
 	var note models.Note
-	err := s.db.Preload("Category").Preload("Tags").Preload("Attachments").
-		Where("id = ? AND user_id = ?", noteID, userID).First(&note).Error
+	err = s.db.Preload("Category").Preload("Tags").Preload("Attachments").
+		Where("id = ?", noteID).First(&note).Error
 	if err != nil {
 		return nil, err
 	}
+	note.AccessRole = role
 	return &note, nil
 }
 
@@ -316,30 +754,43 @@ func (s *NoteService) GetPublicNoteByID(noteID uint, viewerInfo *models.ViewerIn
 	return &note, nil
 }
 
+// recordPublicView 记录公开访问，同一访客一小时内只计一次
 func (s *NoteService) recordPublicView(noteID uint, viewerInfo *models.ViewerInfo) {
 	timeWindow := time.Now().Format("2006-01-02-15")
 	identifier := fmt.Sprintf("%s-%d-%s", viewerInfo.IP, noteID, timeWindow)
 	hash := fmt.Sprintf("%x", md5.Sum([]byte(identifier)))
 
+	visit := models.NoteVisit{
+		NoteID:      noteID,
+		VisitorIP:   &viewerInfo.IP,
+		UserAgent:   &viewerInfo.UserAgent,
+		Referer:     &viewerInfo.Referer,
+		ViewHash:    &hash,
+		VisitedAt:   time.Now(),
+		ShareLinkID: viewerInfo.ShareLinkID,
+	}
+	// IP 按配置截断或哈希后再去重和保存
+	s.analytics.PrepareVisit(&visit, viewerInfo)
+
 	var existingVisit models.NoteVisit
 	oneHourAgo := time.Now().Add(-1 * time.Hour)
 	
-	err := s.db.Where("note_id = ? AND visitor_ip = ? AND visited_at > ? AND view_hash = ?", 
-		noteID, viewerInfo.IP, oneHourAgo, hash).First(&existingVisit).Error
+	// 预取等机器人访问不影响随后真实访问的计数
+	err := s.db.Where("note_id = ? AND visited_at > ? AND view_hash = ? AND is_bot = ?", 
+		noteID, oneHourAgo, *visit.ViewHash, visit.IsBot).First(&existingVisit).Error
 	
 	if err == nil {
 		return
 	}
 
-	visit := models.NoteVisit{
-		NoteID:    noteID,
-		VisitorIP: &viewerInfo.IP,
-		UserAgent: &viewerInfo.UserAgent,
-		Referer:   &viewerInfo.Referer,
-		ViewHash:  &hash,
-		VisitedAt: time.Now(),
-	}
-
 	s.db.Create(&visit)
+
+	// 只有人工访问计入笔记和分享链接的访问次数
+	if visit.IsBot {
+		return
+	}
 	s.db.Model(&models.Note{}).Where("id = ?", noteID).Update("view_count", gorm.Expr("view_count + 1"))
+	if visit.ShareLinkID != nil {
+		s.db.Model(&models.ShareLink{}).Where("id = ?", *visit.ShareLinkID).Update("visit_count", gorm.Expr("visit_count + 1"))
+	}
 }
\ No newline at end of file
@@ -0,0 +1,493 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"notes-backend/internal/config"
+	"notes-backend/internal/models"
+	"notes-backend/internal/utils"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+	"gorm.io/gorm"
+)
+
+var (
+	// ErrOIDCProviderNotFound 未配置的身份提供方
+	ErrOIDCProviderNotFound = errors.New("身份提供方不存在")
+	// ErrOIDCState state 不存在、已过期或与发起登录的浏览器不一致
+	ErrOIDCState = errors.New("登录请求已失效，请重新登录")
+	// ErrOIDCLoginFailed 身份提供方返回错误或 ID Token 校验失败
+	ErrOIDCLoginFailed = errors.New("单点登录失败")
+	// ErrOIDCEmailMissing 身份提供方没有返回邮箱
+	ErrOIDCEmailMissing = errors.New("身份提供方没有返回邮箱")
+	// ErrOIDCDomainNotAllowed 邮箱域名不在允许范围内
+	ErrOIDCDomainNotAllowed = errors.New("该邮箱不允许登录")
+	// ErrOIDCNoAccount 没有关联的账号且未开启自动创建
+	ErrOIDCNoAccount = errors.New("没有与该身份关联的账号，请联系管理员")
+	// ErrOIDCEmailUnverified 要求邮箱验证时，身份提供方未确认的邮箱不能自动创建账号
+	ErrOIDCEmailUnverified = errors.New("身份提供方未确认该邮箱，无法创建账号")
+	// ErrOIDCLoginCode 一次性登录码不存在、已过期或已使用
+	ErrOIDCLoginCode = errors.New("登录码无效或已过期")
+)
+
+const (
+	// oidcStateTTL 从跳转到身份提供方到回调的最长时间
+	oidcStateTTL = 10 * time.Minute
+	// oidcLoginCodeTTL 回调后前端换取令牌的最长时间
+	oidcLoginCodeTTL = time.Minute
+	// oidcRequestTimeout 与身份提供方通信（发现、换取令牌、UserInfo）的超时时间
+	oidcRequestTimeout = 15 * time.Second
+)
+
+var usernameUnsafeChars = regexp.MustCompile(`[^A-Za-z0-9_.-]+`)
+
+// oidcPending 已跳转到身份提供方、等待回调的登录请求
+type oidcPending struct {
+	provider    string
+	nonce       string
+	verifier    string
+	redirectURL string
+	returnTo    string
+	expiresAt   time.Time
+}
+
+// oidcLoginCode 回调完成后交给前端的一次性登录码
+type oidcLoginCode struct {
+	userID    uint
+	expiresAt time.Time
+}
+
+// OIDCService OpenID Connect 单点登录。使用授权码流程和 PKCE，校验 ID Token 后按 provider + sub
+// 找到关联的用户；没有关联时按已验证的邮箱关联已有用户，或按配置自动创建用户。
+// 身份提供方的配置在第一次使用时通过 discovery 获取并缓存，HTTPClient 可替换为测试用的客户端
+type OIDCService struct {
+	db  *gorm.DB
+	cfg *config.Config
+
+	HTTPClient *http.Client
+
+	mu         sync.Mutex
+	providers  map[string]*oidc.Provider
+	pending    map[string]*oidcPending
+	loginCodes map[string]*oidcLoginCode
+}
+
+func NewOIDCService(db *gorm.DB, cfg *config.Config) *OIDCService {
+	return &OIDCService{
+		db:         db,
+		cfg:        cfg,
+		HTTPClient: &http.Client{Timeout: oidcRequestTimeout},
+		providers:  make(map[string]*oidc.Provider),
+		pending:    make(map[string]*oidcPending),
+		loginCodes: make(map[string]*oidcLoginCode),
+	}
+}
+
+// Providers 已配置的身份提供方，按名称排序
+func (s *OIDCService) Providers() []models.OIDCProviderInfo {
+	infos := make([]models.OIDCProviderInfo, 0, len(s.cfg.OIDC.Providers))
+	for name, provider := range s.cfg.OIDC.Providers {
+		infos = append(infos, models.OIDCProviderInfo{
+			Name:        name,
+			DisplayName: provider.DisplayName,
+			StartURL:    "/api/auth/oidc/" + name + "/start",
+		})
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Name < infos[j].Name
+	})
+	return infos
+}
+
+// Start 生成 state、nonce 和 PKCE 校验码，返回身份提供方的授权地址和 state。
+// defaultRedirectURL 为根据请求生成的回调地址，配置了 redirect_url 时以配置为准；returnTo 为登录后前端要跳转的页面
+func (s *OIDCService) Start(ctx context.Context, name, defaultRedirectURL, returnTo string) (string, string, error) {
+	providerCfg, ok := s.cfg.OIDC.Providers[name]
+	if !ok {
+		return "", "", ErrOIDCProviderNotFound
+	}
+	provider, err := s.provider(ctx, name, providerCfg)
+	if err != nil {
+		return "", "", err
+	}
+
+	redirectURL := providerCfg.RedirectURL
+	if redirectURL == "" {
+		redirectURL = defaultRedirectURL
+	}
+
+	state, err := randomToken()
+	if err != nil {
+		return "", "", err
+	}
+	nonce, err := randomToken()
+	if err != nil {
+		return "", "", err
+	}
+	verifier := oauth2.GenerateVerifier()
+
+	s.mu.Lock()
+	now := time.Now()
+	for key, p := range s.pending {
+		if now.After(p.expiresAt) {
+			delete(s.pending, key)
+		}
+	}
+	s.pending[state] = &oidcPending{
+		provider:    name,
+		nonce:       nonce,
+		verifier:    verifier,
+		redirectURL: redirectURL,
+		returnTo:    returnTo,
+		expiresAt:   now.Add(oidcStateTTL),
+	}
+	s.mu.Unlock()
+
+	oauthCfg := s.oauthConfig(providerCfg, provider, redirectURL)
+	authURL := oauthCfg.AuthCodeURL(state, oidc.Nonce(nonce), oauth2.S256ChallengeOption(verifier))
+	return authURL, state, nil
+}
+
+// Callback 处理身份提供方的回调：用授权码换取令牌，校验 ID Token 后返回对应的用户，以及发起登录时指定的 returnTo。
+// state 只能使用一次
+func (s *OIDCService) Callback(ctx context.Context, name, state, code string) (*models.User, string, error) {
+	s.mu.Lock()
+	pending, ok := s.pending[state]
+	delete(s.pending, state)
+	s.mu.Unlock()
+
+	if !ok || pending.provider != name || time.Now().After(pending.expiresAt) {
+		return nil, "", ErrOIDCState
+	}
+
+	providerCfg, ok := s.cfg.OIDC.Providers[name]
+	if !ok {
+		return nil, "", ErrOIDCProviderNotFound
+	}
+	provider, err := s.provider(ctx, name, providerCfg)
+	if err != nil {
+		return nil, pending.returnTo, err
+	}
+
+	ctx, cancel := context.WithTimeout(oidc.ClientContext(ctx, s.HTTPClient), oidcRequestTimeout)
+	defer cancel()
+
+	oauthCfg := s.oauthConfig(providerCfg, provider, pending.redirectURL)
+	token, err := oauthCfg.Exchange(ctx, code, oauth2.VerifierOption(pending.verifier))
+	if err != nil {
+		fmt.Printf("OIDC code exchange failed: provider=%s, error=%v\n", name, err)
+		return nil, pending.returnTo, ErrOIDCLoginFailed
+	}
+
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		fmt.Printf("OIDC token response has no id_token: provider=%s\n", name)
+		return nil, pending.returnTo, ErrOIDCLoginFailed
+	}
+	idToken, err := provider.Verifier(&oidc.Config{ClientID: providerCfg.ClientID}).Verify(ctx, rawIDToken)
+	if err != nil {
+		fmt.Printf("OIDC id_token verification failed: provider=%s, error=%v\n", name, err)
+		return nil, pending.returnTo, ErrOIDCLoginFailed
+	}
+	if idToken.Nonce != pending.nonce {
+		fmt.Printf("OIDC nonce mismatch: provider=%s\n", name)
+		return nil, pending.returnTo, ErrOIDCLoginFailed
+	}
+
+	claims := map[string]interface{}{}
+	if err := idToken.Claims(&claims); err != nil {
+		return nil, pending.returnTo, err
+	}
+
+	// ID Token 中没有邮箱时从 UserInfo 接口补充
+	if claimString(claims, providerCfg.Claims.Email) == "" && provider.UserInfoEndpoint() != "" {
+		userInfo, err := provider.UserInfo(ctx, oauth2.StaticTokenSource(token))
+		if err != nil {
+			fmt.Printf("OIDC userinfo request failed: provider=%s, error=%v\n", name, err)
+		} else {
+			extra := map[string]interface{}{}
+			if err := userInfo.Claims(&extra); err == nil && extra["sub"] == idToken.Subject {
+				for key, value := range extra {
+					if _, exists := claims[key]; !exists {
+						claims[key] = value
+					}
+				}
+			}
+		}
+	}
+
+	user, err := s.resolveUser(name, providerCfg, idToken.Subject, claims)
+	if err != nil {
+		return nil, pending.returnTo, err
+	}
+	return user, pending.returnTo, nil
+}
+
+// IssueLoginCode 生成一次性登录码，前端通过 ExchangeLoginCode 换取令牌，避免令牌出现在跳转地址中
+func (s *OIDCService) IssueLoginCode(user *models.User) (string, error) {
+	code, err := randomToken()
+	if err != nil {
+		return "", err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for key, c := range s.loginCodes {
+		if now.After(c.expiresAt) {
+			delete(s.loginCodes, key)
+		}
+	}
+	s.loginCodes[code] = &oidcLoginCode{userID: user.ID, expiresAt: now.Add(oidcLoginCodeTTL)}
+	return code, nil
+}
+
+// ExchangeLoginCode 使用一次性登录码获取用户，登录码只能使用一次。
+// 要求邮箱验证时，邮箱未验证的用户与密码登录一样返回 ErrEmailNotVerified
+func (s *OIDCService) ExchangeLoginCode(code string) (*models.User, error) {
+	s.mu.Lock()
+	loginCode, ok := s.loginCodes[code]
+	delete(s.loginCodes, code)
+	s.mu.Unlock()
+
+	if !ok || time.Now().After(loginCode.expiresAt) {
+		return nil, ErrOIDCLoginCode
+	}
+
+	var user models.User
+	if err := s.db.Where("id = ? AND is_active = ?", loginCode.userID, true).First(&user).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrOIDCLoginCode
+		}
+		return nil, err
+	}
+
+	if s.cfg.Auth.RequireEmailVerification && user.EmailVerifiedAt == nil {
+		return nil, ErrEmailNotVerified
+	}
+	return &user, nil
+}
+
+// resolveUser 按 provider + sub 查找关联的用户；没有关联时按已验证的邮箱关联已有用户，或自动创建用户
+func (s *OIDCService) resolveUser(name string, providerCfg config.OIDCProviderConfig, subject string, claims map[string]interface{}) (*models.User, error) {
+	email := strings.ToLower(claimString(claims, providerCfg.Claims.Email))
+	now := time.Now()
+
+	var identity models.UserIdentity
+	err := s.db.Where("provider = ? AND subject = ?", name, subject).First(&identity).Error
+	if err == nil {
+		var user models.User
+		if err := s.db.Where("id = ? AND is_active = ?", identity.UserID, true).First(&user).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil, ErrOIDCNoAccount
+			}
+			return nil, err
+		}
+		err := s.db.Model(&identity).Updates(map[string]interface{}{"email": email, "last_login_at": now}).Error
+		if err != nil {
+			return nil, err
+		}
+		return &user, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	if email == "" {
+		return nil, ErrOIDCEmailMissing
+	}
+	if !emailDomainAllowed(email, providerCfg.AllowedDomains) {
+		return nil, ErrOIDCDomainNotAllowed
+	}
+	emailVerified := providerCfg.TrustEmail || claimBool(claims, providerCfg.Claims.EmailVerified)
+
+	var user models.User
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Where("LOWER(email) = ?", email).First(&user).Error
+		switch {
+		case err == nil:
+			// 只有身份提供方确认过的邮箱才能关联已有账号，否则任何人都能用同名邮箱接管账号
+			if !emailVerified || !user.IsActive {
+				return ErrOIDCNoAccount
+			}
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			if !providerCfg.AutoProvision {
+				return ErrOIDCNoAccount
+			}
+			if !emailVerified && s.cfg.Auth.RequireEmailVerification {
+				return ErrOIDCEmailUnverified
+			}
+			if err := s.provisionUser(tx, &user, email, emailVerified, providerCfg, claims); err != nil {
+				return err
+			}
+		default:
+			return err
+		}
+
+		return tx.Create(&models.UserIdentity{
+			UserID:      user.ID,
+			Provider:    name,
+			Subject:     subject,
+			Email:       email,
+			LastLoginAt: now,
+		}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// provisionUser 为首次登录的外部账号创建用户。密码为随机值，需要本地密码时通过找回密码设置
+func (s *OIDCService) provisionUser(tx *gorm.DB, user *models.User, email string, emailVerified bool, providerCfg config.OIDCProviderConfig, claims map[string]interface{}) error {
+	username, err := uniqueUsername(tx, usernameCandidate(email, providerCfg, claims))
+	if err != nil {
+		return err
+	}
+
+	password, err := randomToken()
+	if err != nil {
+		return err
+	}
+	hashedPassword, err := utils.HashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	*user = models.User{
+		Username:     username,
+		Email:        email,
+		PasswordHash: hashedPassword,
+		Role:         "user",
+		IsActive:     true,
+	}
+	if emailVerified {
+		now := time.Now()
+		user.EmailVerifiedAt = &now
+	}
+	if err := tx.Create(user).Error; err != nil {
+		return err
+	}
+
+	return tx.Create(&models.UserStorage{UserID: user.ID}).Error
+}
+
+func (s *OIDCService) provider(ctx context.Context, name string, providerCfg config.OIDCProviderConfig) (*oidc.Provider, error) {
+	s.mu.Lock()
+	provider, ok := s.providers[name]
+	s.mu.Unlock()
+	if ok {
+		return provider, nil
+	}
+
+	ctx, cancel := context.WithTimeout(oidc.ClientContext(ctx, s.HTTPClient), oidcRequestTimeout)
+	defer cancel()
+
+	// discovery 失败时不缓存，下次登录重试
+	provider, err := oidc.NewProvider(ctx, providerCfg.Issuer)
+	if err != nil {
+		fmt.Printf("OIDC discovery failed: provider=%s, issuer=%s, error=%v\n", name, providerCfg.Issuer, err)
+		return nil, ErrOIDCLoginFailed
+	}
+
+	s.mu.Lock()
+	s.providers[name] = provider
+	s.mu.Unlock()
+	return provider, nil
+}
+
+func (s *OIDCService) oauthConfig(providerCfg config.OIDCProviderConfig, provider *oidc.Provider, redirectURL string) *oauth2.Config {
+	return &oauth2.Config{
+		ClientID:     providerCfg.ClientID,
+		ClientSecret: providerCfg.ClientSecret,
+		Endpoint:     provider.Endpoint(),
+		RedirectURL:  redirectURL,
+		Scopes:       providerCfg.Scopes,
+	}
+}
+
+// usernameCandidate 按 username、name claim 和邮箱前缀的顺序选择用户名，只保留字母、数字和 _.-
+func usernameCandidate(email string, providerCfg config.OIDCProviderConfig, claims map[string]interface{}) string {
+	localPart, _, _ := strings.Cut(email, "@")
+	for _, candidate := range []string{
+		claimString(claims, providerCfg.Claims.Username),
+		claimString(claims, providerCfg.Claims.Name),
+		localPart,
+	} {
+		candidate = strings.Trim(usernameUnsafeChars.ReplaceAllString(candidate, "_"), "_")
+		if len(candidate) > 40 {
+			candidate = candidate[:40]
+		}
+		if len(candidate) >= 3 {
+			return candidate
+		}
+	}
+	return "user"
+}
+
+// uniqueUsername 用户名已存在时追加数字后缀
+func uniqueUsername(tx *gorm.DB, base string) (string, error) {
+	candidate := base
+	for i := 2; i < 1000; i++ {
+		var count int64
+		if err := tx.Model(&models.User{}).Where("username = ?", candidate).Count(&count).Error; err != nil {
+			return "", err
+		}
+		if count == 0 {
+			return candidate, nil
+		}
+		candidate = fmt.Sprintf("%s%d", base, i)
+	}
+	return "", fmt.Errorf("无法为 %s 生成可用的用户名", base)
+}
+
+func emailDomainAllowed(email string, domains []string) bool {
+	if len(domains) == 0 {
+		return true
+	}
+	_, domain, _ := strings.Cut(email, "@")
+	for _, allowed := range domains {
+		if strings.EqualFold(domain, strings.TrimPrefix(allowed, "@")) {
+			return true
+		}
+	}
+	return false
+}
+
+func claimString(claims map[string]interface{}, key string) string {
+	if value, ok := claims[key].(string); ok {
+		return strings.TrimSpace(value)
+	}
+	return ""
+}
+
+// claimBool 兼容部分身份提供方以字符串返回的布尔值
+func claimBool(claims map[string]interface{}, key string) bool {
+	switch value := claims[key].(type) {
+	case bool:
+		return value
+	case string:
+		return strings.EqualFold(value, "true")
+	default:
+		return false
+	}
+}
+
+func randomToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
@@ -0,0 +1,407 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"notes-backend/internal/config"
+	"notes-backend/internal/models"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+const (
+	testOIDCProvider = "test"
+	testOIDCClientID = "notes-test"
+	testOIDCKeyID    = "test-key"
+)
+
+// testIssuer 测试用的身份提供方，提供 discovery、JWKS 和 token 接口。
+// 授权码通过 issue 登记，换取令牌时签发包含对应 claims 的 ID Token
+type testIssuer struct {
+	*httptest.Server
+	key *rsa.PrivateKey
+
+	mu    sync.Mutex
+	codes map[string]map[string]interface{}
+}
+
+func newTestIssuer(t *testing.T) *testIssuer {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	issuer := &testIssuer{key: key, codes: make(map[string]map[string]interface{})}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		writeTestJSON(w, map[string]interface{}{
+			"issuer":                                issuer.URL,
+			"authorization_endpoint":                issuer.URL + "/authorize",
+			"token_endpoint":                        issuer.URL + "/token",
+			"jwks_uri":                              issuer.URL + "/keys",
+			"response_types_supported":              []string{"code"},
+			"subject_types_supported":               []string{"public"},
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		})
+	})
+	mux.HandleFunc("/keys", func(w http.ResponseWriter, r *http.Request) {
+		writeTestJSON(w, jose.JSONWebKeySet{Keys: []jose.JSONWebKey{{
+			Key:       &key.PublicKey,
+			KeyID:     testOIDCKeyID,
+			Algorithm: string(jose.RS256),
+			Use:       "sig",
+		}}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil || r.PostForm.Get("code_verifier") == "" {
+			http.Error(w, `{"error":"invalid_request"}`, http.StatusBadRequest)
+			return
+		}
+
+		issuer.mu.Lock()
+		claims, ok := issuer.codes[r.PostForm.Get("code")]
+		delete(issuer.codes, r.PostForm.Get("code"))
+		issuer.mu.Unlock()
+		if !ok {
+			http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+			return
+		}
+
+		idToken, err := issuer.sign(claims)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeTestJSON(w, map[string]interface{}{
+			"access_token": "test-access-token",
+			"token_type":   "Bearer",
+			"expires_in":   3600,
+			"id_token":     idToken,
+		})
+	})
+
+	issuer.Server = httptest.NewServer(mux)
+	t.Cleanup(issuer.Close)
+	return issuer
+}
+
+// issue 登记授权码，换取令牌时签发的 ID Token 包含 claims 以及 iss、aud、exp、iat
+func (i *testIssuer) issue(code string, claims map[string]interface{}) {
+	now := time.Now()
+	full := map[string]interface{}{
+		"iss": i.URL,
+		"aud": testOIDCClientID,
+		"iat": now.Unix(),
+		"exp": now.Add(time.Hour).Unix(),
+	}
+	for key, value := range claims {
+		full[key] = value
+	}
+
+	i.mu.Lock()
+	i.codes[code] = full
+	i.mu.Unlock()
+}
+
+func (i *testIssuer) sign(claims map[string]interface{}) (string, error) {
+	signer, err := jose.NewSigner(
+		jose.SigningKey{Algorithm: jose.RS256, Key: jose.JSONWebKey{Key: i.key, KeyID: testOIDCKeyID}},
+		(&jose.SignerOptions{}).WithType("JWT"),
+	)
+	if err != nil {
+		return "", err
+	}
+
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	signed, err := signer.Sign(payload)
+	if err != nil {
+		return "", err
+	}
+	return signed.CompactSerialize()
+}
+
+// login 走完一次登录：Start 生成 state 和 nonce，身份提供方按 claims 签发 ID Token，再用授权码调用 Callback。
+// claims 中没有 nonce 时使用 Start 生成的 nonce
+func (i *testIssuer) login(t *testing.T, svc *OIDCService, claims map[string]interface{}) (*models.User, error) {
+	t.Helper()
+
+	authURL, state, err := svc.Start(context.Background(), testOIDCProvider, "http://notes.example/api/auth/oidc/test/callback", "")
+	if err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	parsed, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	withNonce := map[string]interface{}{"nonce": parsed.Query().Get("nonce")}
+	for key, value := range claims {
+		withNonce[key] = value
+	}
+	i.issue("code-"+state, withNonce)
+
+	user, _, err := svc.Callback(context.Background(), testOIDCProvider, state, "code-"+state)
+	return user, err
+}
+
+func newTestOIDCService(issuer *testIssuer, db *gorm.DB, configure func(*config.Config)) *OIDCService {
+	cfg := &config.Config{
+		OIDC: config.OIDCConfig{
+			Providers: map[string]config.OIDCProviderConfig{
+				testOIDCProvider: {
+					Issuer:       issuer.URL,
+					ClientID:     testOIDCClientID,
+					ClientSecret: "secret",
+					Scopes:       []string{"openid", "email", "profile"},
+					Claims: config.OIDCClaimConfig{
+						Email:         "email",
+						EmailVerified: "email_verified",
+						Username:      "preferred_username",
+						Name:          "name",
+					},
+				},
+			},
+		},
+	}
+	if configure != nil {
+		configure(cfg)
+	}
+
+	svc := NewOIDCService(db, cfg)
+	svc.HTTPClient = issuer.Client()
+	return svc
+}
+
+// testDB 连接 TEST_DATABASE_DSN 指定的 PostgreSQL，返回在测试结束时回滚的事务；未设置时跳过测试
+func testDB(t *testing.T) *gorm.DB {
+	t.Helper()
+
+	dsn := os.Getenv("TEST_DATABASE_DSN")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_DSN 未设置，跳过需要数据库的测试")
+	}
+
+	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatalf("连接测试数据库失败: %v", err)
+	}
+	if err := db.AutoMigrate(&models.User{}, &models.UserStorage{}, &models.UserIdentity{}); err != nil {
+		t.Fatalf("迁移测试数据库失败: %v", err)
+	}
+
+	tx := db.Begin()
+	t.Cleanup(func() { tx.Rollback() })
+	return tx
+}
+
+// unreachableDB 不可连接的数据库。ID Token 校验通过后查询用户时才会出错，用于区分校验失败和后续步骤
+func unreachableDB(t *testing.T) *gorm.DB {
+	t.Helper()
+
+	db, err := gorm.Open(postgres.Open("host=127.0.0.1 port=1 user=test dbname=test sslmode=disable connect_timeout=1"), &gorm.Config{
+		DisableAutomaticPing: true,
+		Logger:               logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func writeTestJSON(w http.ResponseWriter, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(value)
+}
+
+func TestOIDCCallbackRejectsInvalidState(t *testing.T) {
+	issuer := newTestIssuer(t)
+	svc := newTestOIDCService(issuer, unreachableDB(t), nil)
+	ctx := context.Background()
+
+	if _, _, err := svc.Callback(ctx, testOIDCProvider, "unknown", "code"); !errors.Is(err, ErrOIDCState) {
+		t.Fatalf("unknown state: got %v, want ErrOIDCState", err)
+	}
+
+	_, state, err := svc.Start(ctx, testOIDCProvider, "http://notes.example/callback", "")
+	if err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	if _, _, err := svc.Callback(ctx, "other", state, "code"); !errors.Is(err, ErrOIDCState) {
+		t.Fatalf("state from another provider: got %v, want ErrOIDCState", err)
+	}
+	// state 只能使用一次，上面的失败请求已经将其作废
+	if _, _, err := svc.Callback(ctx, testOIDCProvider, state, "code"); !errors.Is(err, ErrOIDCState) {
+		t.Fatalf("reused state: got %v, want ErrOIDCState", err)
+	}
+}
+
+func TestOIDCCallbackRejectsNonceMismatch(t *testing.T) {
+	issuer := newTestIssuer(t)
+	svc := newTestOIDCService(issuer, unreachableDB(t), nil)
+
+	claims := map[string]interface{}{"sub": "user-1", "email": "alice@example.com", "email_verified": true}
+
+	// nonce 正确时校验通过，随后因为数据库不可用而失败
+	if _, err := issuer.login(t, svc, claims); err == nil || errors.Is(err, ErrOIDCLoginFailed) {
+		t.Fatalf("matching nonce: got %v, want a database error after verification", err)
+	}
+
+	claims["nonce"] = "another-nonce"
+	if _, err := issuer.login(t, svc, claims); !errors.Is(err, ErrOIDCLoginFailed) {
+		t.Fatalf("nonce mismatch: got %v, want ErrOIDCLoginFailed", err)
+	}
+}
+
+func TestOIDCLinksExistingUserByVerifiedEmail(t *testing.T) {
+	db := testDB(t)
+	issuer := newTestIssuer(t)
+	svc := newTestOIDCService(issuer, db, nil)
+
+	existing := models.User{Username: "alice", Email: "alice@example.com", PasswordHash: "x", IsActive: true}
+	if err := db.Create(&existing).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	user, err := issuer.login(t, svc, map[string]interface{}{
+		"sub":            "alice-sub",
+		"email":          "Alice@Example.com",
+		"email_verified": true,
+	})
+	if err != nil {
+		t.Fatalf("login: %v", err)
+	}
+	if user.ID != existing.ID {
+		t.Fatalf("linked user %d, want %d", user.ID, existing.ID)
+	}
+
+	var identity models.UserIdentity
+	if err := db.Where("provider = ? AND subject = ?", testOIDCProvider, "alice-sub").First(&identity).Error; err != nil {
+		t.Fatalf("identity not created: %v", err)
+	}
+	if identity.UserID != existing.ID {
+		t.Fatalf("identity user %d, want %d", identity.UserID, existing.ID)
+	}
+}
+
+func TestOIDCRefusesUnverifiedEmailForExistingUser(t *testing.T) {
+	db := testDB(t)
+	issuer := newTestIssuer(t)
+	// 开启自动创建时也不能借同名邮箱接管已有账号
+	svc := newTestOIDCService(issuer, db, func(cfg *config.Config) {
+		provider := cfg.OIDC.Providers[testOIDCProvider]
+		provider.AutoProvision = true
+		cfg.OIDC.Providers[testOIDCProvider] = provider
+	})
+
+	existing := models.User{Username: "bob", Email: "bob@example.com", PasswordHash: "x", IsActive: true}
+	if err := db.Create(&existing).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := issuer.login(t, svc, map[string]interface{}{
+		"sub":            "bob-sub",
+		"email":          "bob@example.com",
+		"email_verified": false,
+	})
+	if !errors.Is(err, ErrOIDCNoAccount) {
+		t.Fatalf("got %v, want ErrOIDCNoAccount", err)
+	}
+
+	var count int64
+	if err := db.Model(&models.UserIdentity{}).Where("subject = ?", "bob-sub").Count(&count).Error; err != nil {
+		t.Fatal(err)
+	}
+	if count != 0 {
+		t.Fatalf("identity created for unverified email")
+	}
+}
+
+func TestOIDCAutoProvisionsUser(t *testing.T) {
+	db := testDB(t)
+	issuer := newTestIssuer(t)
+	svc := newTestOIDCService(issuer, db, func(cfg *config.Config) {
+		provider := cfg.OIDC.Providers[testOIDCProvider]
+		provider.AutoProvision = true
+		cfg.OIDC.Providers[testOIDCProvider] = provider
+	})
+
+	claims := map[string]interface{}{
+		"sub":                "carol-sub",
+		"email":              "carol@example.com",
+		"email_verified":     true,
+		"preferred_username": "carol",
+	}
+	user, err := issuer.login(t, svc, claims)
+	if err != nil {
+		t.Fatalf("login: %v", err)
+	}
+	if user.ID == 0 || user.Username != "carol" || user.Email != "carol@example.com" {
+		t.Fatalf("unexpected provisioned user: %+v", user)
+	}
+	if user.EmailVerifiedAt == nil {
+		t.Fatalf("provisioned user email not marked verified")
+	}
+
+	var storage int64
+	if err := db.Model(&models.UserStorage{}).Where("user_id = ?", user.ID).Count(&storage).Error; err != nil {
+		t.Fatal(err)
+	}
+	if storage != 1 {
+		t.Fatalf("user storage not created")
+	}
+
+	// 再次登录按 provider + sub 找到同一个用户
+	again, err := issuer.login(t, svc, claims)
+	if err != nil {
+		t.Fatalf("second login: %v", err)
+	}
+	if again.ID != user.ID {
+		t.Fatalf("second login user %d, want %d", again.ID, user.ID)
+	}
+}
+
+func TestOIDCAutoProvisionRequiresVerifiedEmail(t *testing.T) {
+	db := testDB(t)
+	issuer := newTestIssuer(t)
+	svc := newTestOIDCService(issuer, db, func(cfg *config.Config) {
+		provider := cfg.OIDC.Providers[testOIDCProvider]
+		provider.AutoProvision = true
+		cfg.OIDC.Providers[testOIDCProvider] = provider
+		cfg.Auth.RequireEmailVerification = true
+	})
+
+	_, err := issuer.login(t, svc, map[string]interface{}{
+		"sub":            "dave-sub",
+		"email":          "dave@example.com",
+		"email_verified": false,
+	})
+	if !errors.Is(err, ErrOIDCEmailUnverified) {
+		t.Fatalf("got %v, want ErrOIDCEmailUnverified", err)
+	}
+
+	var count int64
+	if err := db.Model(&models.User{}).Where("email = ?", "dave@example.com").Count(&count).Error; err != nil {
+		t.Fatal(err)
+	}
+	if count != 0 {
+		t.Fatalf("user provisioned for unverified email")
+	}
+}
@@ -0,0 +1,500 @@
+package services
+
+import (
+	"errors"
+	"math"
+	"notes-backend/internal/models"
+	"sort"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+var (
+	// ErrNoteForbidden 对共享笔记只有查看或评论权限
+	ErrNoteForbidden = errors.New("没有编辑该笔记的权限")
+	// ErrGranteeNotFound 按用户名或邮箱找不到可授权的用户
+	ErrGranteeNotFound = errors.New("用户不存在")
+	// ErrGrantSelf 不能给自己授权
+	ErrGrantSelf = errors.New("不能授权给自己")
+	// ErrPermissionNotFound 授权记录不存在
+	ErrPermissionNotFound = errors.New("授权不存在")
+)
+
+// maxCategoryDepth 向上查找分类祖先的最大层数，防止父子关系成环时死循环
+const maxCategoryDepth = 64
+
+var permissionRanks = map[string]int{
+	models.PermissionViewer:    1,
+	models.PermissionCommenter: 2,
+	models.PermissionEditor:    3,
+	models.PermissionOwner:     4,
+}
+
+// roleAtLeast 判断角色是否不低于要求的角色
+func roleAtLeast(role, required string) bool {
+	return permissionRanks[role] >= permissionRanks[required]
+}
+
+// higherRole 返回两个角色中较高的一个
+func higherRole(a, b string) string {
+	if permissionRanks[b] > permissionRanks[a] {
+		return b
+	}
+	return a
+}
+
+// permissionTarget 授权对象，column 为 note_id 或 category_id
+type permissionTarget struct {
+	column string
+	id     uint
+}
+
+// PermissionService 笔记和分类的共享授权。授权给分类时对其所有子分类下的笔记生效，
+// 同一笔记有多条授权时取最高的角色
+type PermissionService struct {
+	db *gorm.DB
+}
+
+func NewPermissionService(db *gorm.DB) *PermissionService {
+	return &PermissionService{db: db}
+}
+
+// NoteRole 返回用户对笔记的角色，所有者为 owner；没有任何权限时返回 gorm.ErrRecordNotFound，
+// 避免泄露笔记是否存在
+func (s *PermissionService) NoteRole(noteID, userID uint) (string, error) {
+	var note models.Note
+	if err := s.db.Select("id", "user_id", "category_id").Where("id = ?", noteID).First(&note).Error; err != nil {
+		return "", err
+	}
+
+	if note.UserID == userID {
+		return models.PermissionOwner, nil
+	}
+
+	categoryIDs, err := s.categoryAncestors(note.UserID, note.CategoryID)
+	if err != nil {
+		return "", err
+	}
+
+	query := s.db.Model(&models.NotePermission{}).Where("grantee_id = ? AND owner_id = ?", userID, note.UserID)
+	if len(categoryIDs) > 0 {
+		query = query.Where("note_id = ? OR category_id IN ?", noteID, categoryIDs)
+	} else {
+		query = query.Where("note_id = ?", noteID)
+	}
+
+	var roles []string
+	if err := query.Pluck("role", &roles).Error; err != nil {
+		return "", err
+	}
+
+	role := ""
+	for _, r := range roles {
+		role = higherRole(role, r)
+	}
+	if role == "" {
+		return "", gorm.ErrRecordNotFound
+	}
+
+	return role, nil
+}
+
+// categoryAncestors 返回分类本身及其所有上级分类的 ID
+func (s *PermissionService) categoryAncestors(ownerID uint, categoryID *uint) ([]uint, error) {
+	var ids []uint
+	seen := make(map[uint]bool)
+
+	for categoryID != nil && !seen[*categoryID] && len(ids) < maxCategoryDepth {
+		var category models.Category
+		err := s.db.Select("id", "parent_id").
+			Where("id = ? AND user_id = ?", *categoryID, ownerID).First(&category).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		seen[category.ID] = true
+		ids = append(ids, category.ID)
+		categoryID = category.ParentID
+	}
+
+	return ids, nil
+}
+
+func (s *PermissionService) GetNotePermissions(noteID, ownerID uint) ([]models.NotePermissionInfo, error) {
+	if err := s.checkNoteOwner(noteID, ownerID); err != nil {
+		return nil, err
+	}
+	return s.listPermissions(ownerID, permissionTarget{column: "note_id", id: noteID})
+}
+
+func (s *PermissionService) GetCategoryPermissions(categoryID, ownerID uint) ([]models.NotePermissionInfo, error) {
+	if err := s.checkCategoryOwner(categoryID, ownerID); err != nil {
+		return nil, err
+	}
+	return s.listPermissions(ownerID, permissionTarget{column: "category_id", id: categoryID})
+}
+
+// GrantNote 授权其他用户访问笔记，已有授权时更新角色
+func (s *PermissionService) GrantNote(noteID, ownerID uint, req *models.NotePermissionRequest) (*models.NotePermissionInfo, error) {
+	if err := s.checkNoteOwner(noteID, ownerID); err != nil {
+		return nil, err
+	}
+	return s.grant(ownerID, permissionTarget{column: "note_id", id: noteID}, req)
+}
+
+// GrantCategory 授权其他用户访问分类及其子分类下的所有笔记，已有授权时更新角色
+func (s *PermissionService) GrantCategory(categoryID, ownerID uint, req *models.NotePermissionRequest) (*models.NotePermissionInfo, error) {
+	if err := s.checkCategoryOwner(categoryID, ownerID); err != nil {
+		return nil, err
+	}
+	return s.grant(ownerID, permissionTarget{column: "category_id", id: categoryID}, req)
+}
+
+func (s *PermissionService) RevokeNote(noteID, permissionID, ownerID uint) error {
+	return s.revoke(ownerID, permissionID, permissionTarget{column: "note_id", id: noteID})
+}
+
+func (s *PermissionService) RevokeCategory(categoryID, permissionID, ownerID uint) error {
+	return s.revoke(ownerID, permissionID, permissionTarget{column: "category_id", id: categoryID})
+}
+
+func (s *PermissionService) checkNoteOwner(noteID, ownerID uint) error {
+	var count int64
+	if err := s.db.Model(&models.Note{}).Where("id = ? AND user_id = ?", noteID, ownerID).Count(&count).Error; err != nil {
+		return err
+	}
+	if count == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+func (s *PermissionService) checkCategoryOwner(categoryID, ownerID uint) error {
+	var count int64
+	if err := s.db.Model(&models.Category{}).Where("id = ? AND user_id = ?", categoryID, ownerID).Count(&count).Error; err != nil {
+		return err
+	}
+	if count == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+func (s *PermissionService) listPermissions(ownerID uint, target permissionTarget) ([]models.NotePermissionInfo, error) {
+	var permissions []models.NotePermission
+	err := s.db.Preload("Grantee").
+		Where("owner_id = ? AND "+target.column+" = ?", ownerID, target.id).
+		Order("created_at ASC").Find(&permissions).Error
+	if err != nil {
+		return nil, err
+	}
+
+	infos := make([]models.NotePermissionInfo, 0, len(permissions))
+	for i := range permissions {
+		infos = append(infos, permissionInfo(&permissions[i]))
+	}
+	return infos, nil
+}
+
+func (s *PermissionService) grant(ownerID uint, target permissionTarget, req *models.NotePermissionRequest) (*models.NotePermissionInfo, error) {
+	identifier := strings.TrimSpace(req.User)
+
+	var grantee models.User
+	err := s.db.Where("(username = ? OR LOWER(email) = LOWER(?)) AND is_active = ?", identifier, identifier, true).
+		First(&grantee).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrGranteeNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if grantee.ID == ownerID {
+		return nil, ErrGrantSelf
+	}
+
+	var permission models.NotePermission
+	err = s.db.Where("owner_id = ? AND grantee_id = ? AND "+target.column+" = ?", ownerID, grantee.ID, target.id).
+		First(&permission).Error
+	switch {
+	case err == nil:
+		if err := s.db.Model(&permission).Update("role", req.Role).Error; err != nil {
+			return nil, err
+		}
+		permission.Role = req.Role
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		id := target.id
+		permission = models.NotePermission{
+			OwnerID:   ownerID,
+			GranteeID: grantee.ID,
+			Role:      req.Role,
+		}
+		if target.column == "note_id" {
+			permission.NoteID = &id
+		} else {
+			permission.CategoryID = &id
+		}
+		if err := s.db.Create(&permission).Error; err != nil {
+			return nil, err
+		}
+	default:
+		return nil, err
+	}
+
+	permission.Grantee = grantee
+	info := permissionInfo(&permission)
+	return &info, nil
+}
+
+func (s *PermissionService) revoke(ownerID, permissionID uint, target permissionTarget) error {
+	result := s.db.Where("id = ? AND owner_id = ? AND "+target.column+" = ?", permissionID, ownerID, target.id).
+		Delete(&models.NotePermission{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrPermissionNotFound
+	}
+	return nil
+}
+
+// GetSharedWithMe 列出其他用户共享给当前用户的笔记，包括通过分类授权可访问的笔记，按更新时间倒序
+func (s *PermissionService) GetSharedWithMe(userID uint, req *models.SharedWithMeRequest) ([]models.SharedNote, *models.Pagination, error) {
+	all, err := s.sharedNotes(userID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	shared := make([]models.SharedNote, 0, len(all))
+	for _, item := range all {
+		if req.Role != "" && item.Role != req.Role {
+			continue
+		}
+		shared = append(shared, item)
+	}
+
+	sort.SliceStable(shared, func(i, j int) bool {
+		if shared[i].UpdatedAt.Equal(shared[j].UpdatedAt) {
+			return shared[i].ID > shared[j].ID
+		}
+		return shared[i].UpdatedAt.After(shared[j].UpdatedAt)
+	})
+
+	total := len(shared)
+	start := (req.Page - 1) * req.Limit
+	if start > total {
+		start = total
+	}
+	end := start + req.Limit
+	if end > total {
+		end = total
+	}
+	page := shared[start:end]
+
+	if err := s.fillOwners(page); err != nil {
+		return nil, nil, err
+	}
+
+	pagination := &models.Pagination{
+		Page:  req.Page,
+		Limit: req.Limit,
+		Total: total,
+		Pages: int(math.Ceil(float64(total) / float64(req.Limit))),
+	}
+
+	return page, pagination, nil
+}
+
+// SharedNoteIDs 其他用户共享给当前用户的笔记 ID，包括通过分类授权可访问的笔记
+func (s *PermissionService) SharedNoteIDs(userID uint) ([]uint, error) {
+	shared, err := s.sharedNotes(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]uint, 0, len(shared))
+	for _, item := range shared {
+		ids = append(ids, item.ID)
+	}
+	return ids, nil
+}
+
+// sharedNotes 其他用户共享给当前用户的全部笔记及角色，不含所有者信息
+func (s *PermissionService) sharedNotes(userID uint) ([]models.SharedNote, error) {
+	var permissions []models.NotePermission
+	if err := s.db.Where("grantee_id = ?", userID).Find(&permissions).Error; err != nil {
+		return nil, err
+	}
+
+	noteRoles := make(map[uint]string)
+	var categoryGrants []models.NotePermission
+	for _, permission := range permissions {
+		if permission.NoteID != nil {
+			noteRoles[*permission.NoteID] = higherRole(noteRoles[*permission.NoteID], permission.Role)
+		} else if permission.CategoryID != nil {
+			categoryGrants = append(categoryGrants, permission)
+		}
+	}
+
+	categoryRoles, categoryVia, err := s.expandCategoryGrants(categoryGrants)
+	if err != nil {
+		return nil, err
+	}
+
+	shared := make([]models.SharedNote, 0)
+	if len(noteRoles) > 0 || len(categoryRoles) > 0 {
+		noteIDs := make([]uint, 0, len(noteRoles))
+		for id := range noteRoles {
+			noteIDs = append(noteIDs, id)
+		}
+		categoryIDs := make([]uint, 0, len(categoryRoles))
+		for id := range categoryRoles {
+			categoryIDs = append(categoryIDs, id)
+		}
+
+		query := s.db.Model(&models.Note{}).
+			Select("id", "user_id", "category_id", "title", "content_type", "created_at", "updated_at").
+			Where("user_id <> ?", userID)
+		switch {
+		case len(noteIDs) > 0 && len(categoryIDs) > 0:
+			query = query.Where("id IN ? OR category_id IN ?", noteIDs, categoryIDs)
+		case len(noteIDs) > 0:
+			query = query.Where("id IN ?", noteIDs)
+		default:
+			query = query.Where("category_id IN ?", categoryIDs)
+		}
+
+		var notes []models.Note
+		if err := query.Find(&notes).Error; err != nil {
+			return nil, err
+		}
+
+		for _, note := range notes {
+			item := models.SharedNote{
+				ID:          note.ID,
+				Title:       note.Title,
+				ContentType: note.ContentType,
+				CategoryID:  note.CategoryID,
+				Role:        noteRoles[note.ID],
+				Owner:       models.PermissionUser{ID: note.UserID},
+				CreatedAt:   note.CreatedAt,
+				UpdatedAt:   note.UpdatedAt,
+			}
+			// 分类授权的角色更高时以分类为准
+			if note.CategoryID != nil {
+				if role, ok := categoryRoles[*note.CategoryID]; ok && permissionRanks[role] > permissionRanks[item.Role] {
+					via := categoryVia[*note.CategoryID]
+					item.Role = role
+					item.ViaCategoryID = &via
+				}
+			}
+			shared = append(shared, item)
+		}
+	}
+
+	return shared, nil
+}
+
+// expandCategoryGrants 将分类授权展开到所有子分类，返回每个分类的角色以及该角色来自哪个被授权的分类。
+// 角色高的授权先展开，子分类同时继承多条授权时取最高的角色
+func (s *PermissionService) expandCategoryGrants(grants []models.NotePermission) (map[uint]string, map[uint]uint, error) {
+	roles := make(map[uint]string)
+	via := make(map[uint]uint)
+
+	sort.SliceStable(grants, func(i, j int) bool {
+		return permissionRanks[grants[i].Role] > permissionRanks[grants[j].Role]
+	})
+
+	for _, grant := range grants {
+		root := *grant.CategoryID
+		if _, done := roles[root]; done {
+			continue
+		}
+
+		var count int64
+		if err := s.db.Model(&models.Category{}).Where("id = ? AND user_id = ?", root, grant.OwnerID).Count(&count).Error; err != nil {
+			return nil, nil, err
+		}
+		if count == 0 {
+			continue
+		}
+
+		roles[root] = grant.Role
+		via[root] = root
+		frontier := []uint{root}
+
+		for depth := 0; len(frontier) > 0 && depth < maxCategoryDepth; depth++ {
+			var children []uint
+			err := s.db.Model(&models.Category{}).
+				Where("parent_id IN ? AND user_id = ?", frontier, grant.OwnerID).
+				Pluck("id", &children).Error
+			if err != nil {
+				return nil, nil, err
+			}
+
+			frontier = frontier[:0]
+			for _, child := range children {
+				if _, done := roles[child]; done {
+					continue
+				}
+				roles[child] = grant.Role
+				via[child] = root
+				frontier = append(frontier, child)
+			}
+		}
+	}
+
+	return roles, via, nil
+}
+
+// fillOwners 填充共享笔记所有者的用户名和头像
+func (s *PermissionService) fillOwners(notes []models.SharedNote) error {
+	if len(notes) == 0 {
+		return nil
+	}
+
+	ownerIDs := make([]uint, 0, len(notes))
+	for _, note := range notes {
+		ownerIDs = append(ownerIDs, note.Owner.ID)
+	}
+
+	var users []models.User
+	if err := s.db.Select("id", "username", "avatar").Where("id IN ?", ownerIDs).Find(&users).Error; err != nil {
+		return err
+	}
+
+	owners := make(map[uint]models.PermissionUser, len(users))
+	for _, user := range users {
+		owners[user.ID] = permissionUser(&user)
+	}
+	for i := range notes {
+		if owner, ok := owners[notes[i].Owner.ID]; ok {
+			notes[i].Owner = owner
+		}
+	}
+
+	return nil
+}
+
+func permissionUser(user *models.User) models.PermissionUser {
+	return models.PermissionUser{
+		ID:       user.ID,
+		Username: user.Username,
+		Avatar:   user.Avatar,
+	}
+}
+
+func permissionInfo(permission *models.NotePermission) models.NotePermissionInfo {
+	return models.NotePermissionInfo{
+		ID:         permission.ID,
+		NoteID:     permission.NoteID,
+		CategoryID: permission.CategoryID,
+		Role:       permission.Role,
+		User:       permissionUser(&permission.Grantee),
+		CreatedAt:  permission.CreatedAt,
+		UpdatedAt:  permission.UpdatedAt,
+	}
+}
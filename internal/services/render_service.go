@@ -0,0 +1,311 @@
+package services
+
+import (
+	"bytes"
+	"container/list"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"hash/fnv"
+	"html"
+	"notes-backend/internal/config"
+	"notes-backend/internal/models"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/microcosm-cc/bluemonday"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/extension"
+	"github.com/yuin/goldmark/parser"
+	goldmarkhtml "github.com/yuin/goldmark/renderer/html"
+	nethtml "golang.org/x/net/html"
+	"gorm.io/gorm"
+)
+
+// userContentPrefix 用户正文中的 id 统一加上此前缀，避免覆盖页面中的全局变量和 DOM 属性（DOM clobbering）
+const userContentPrefix = "user-content-"
+
+// RenderService 将笔记正文渲染为经过白名单过滤的 HTML。
+// Markdown 支持 GFM 表格、任务列表、删除线、脚注和标题锚点；HTML 笔记只做过滤。
+// 渲染结果按笔记 ID 和版本号缓存
+type RenderService struct {
+	db          *gorm.DB
+	permissions *PermissionService
+	markdown    goldmark.Markdown
+	policy      *bluemonday.Policy
+	strip       *bluemonday.Policy
+	capacity    int
+	// idMarker 标记渲染器生成的 id，每个进程随机生成，用户正文无法伪造
+	idMarker string
+
+	mu      sync.Mutex
+	entries map[renderKey]*list.Element
+	order   *list.List
+}
+
+type renderKey struct {
+	noteID  uint
+	version int
+}
+
+type renderEntry struct {
+	key renderKey
+	// 正文摘要，导入等不增加版本号的写入也能使缓存失效
+	checksum uint64
+	html     string
+}
+
+func NewRenderService(db *gorm.DB, cfg config.RenderConfig, permissions *PermissionService) *RenderService {
+	idMarker := newIDMarker()
+	return &RenderService{
+		db:          db,
+		permissions: permissions,
+		markdown: goldmark.New(
+			goldmark.WithExtensions(
+				extension.GFM,
+				extension.NewFootnote(extension.WithFootnoteIDPrefix(idMarker+userContentPrefix)),
+			),
+			goldmark.WithParserOptions(parser.WithAutoHeadingID()),
+			// 原始 HTML 交给 bluemonday 过滤
+			goldmark.WithRendererOptions(goldmarkhtml.WithUnsafe()),
+		),
+		policy:   newSanitizePolicy(),
+		strip:    bluemonday.StrictPolicy(),
+		capacity: cfg.CacheSize,
+		idMarker: idMarker,
+		entries:  make(map[renderKey]*list.Element),
+		order:    list.New(),
+	}
+}
+
+func newIDMarker() string {
+	buf := make([]byte, 8)
+	if _, err := rand.Read(buf); err != nil {
+		panic(fmt.Sprintf("生成渲染标记失败: %v", err))
+	}
+	return "r" + hex.EncodeToString(buf) + "-"
+}
+
+// newSanitizePolicy 在 UGC 白名单基础上允许任务列表复选框、代码语言、脚注和非 ASCII 的标题锚点
+func newSanitizePolicy() *bluemonday.Policy {
+	policy := bluemonday.UGCPolicy()
+	policy.AllowRelativeURLs(true)
+	policy.AllowAttrs("id").Matching(regexp.MustCompile(`^[\p{L}\p{N}:._-]+$`)).Globally()
+	policy.AllowAttrs("type").Matching(regexp.MustCompile(`^checkbox$`)).OnElements("input")
+	policy.AllowAttrs("checked", "disabled").OnElements("input")
+	policy.AllowAttrs("class").Matching(regexp.MustCompile(`^language-[\w+#-]+$`)).OnElements("code")
+	policy.AllowAttrs("class").Matching(regexp.MustCompile(`^(footnotes|footnote-ref|footnote-backref)$`)).OnElements("div", "a")
+	policy.AllowAttrs("role").Matching(regexp.MustCompile(`^doc-(endnotes|noteref|backlink)$`)).OnElements("div", "a")
+	return policy
+}
+
+// GetRenderedNote 获取笔记的渲染结果，所有者和被授权查看的用户都可以获取
+func (s *RenderService) GetRenderedNote(noteID, userID uint) (*models.RenderedNote, error) {
+	if _, err := s.permissions.NoteRole(noteID, userID); err != nil {
+		return nil, err
+	}
+
+	var note models.Note
+	if err := s.db.Where("id = ?", noteID).First(&note).Error; err != nil {
+		return nil, err
+	}
+
+	html, err := s.Render(&note)
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.RenderedNote{
+		NoteID:      note.ID,
+		Version:     note.Version,
+		ContentType: note.ContentType,
+		HTML:        html,
+	}, nil
+}
+
+// Render 渲染笔记正文，命中缓存时直接返回
+func (s *RenderService) Render(note *models.Note) (string, error) {
+	key := renderKey{noteID: note.ID, version: note.Version}
+	checksum := contentChecksum(note.ContentType, note.Content)
+
+	if html, ok := s.lookup(key, checksum); ok {
+		return html, nil
+	}
+
+	var html string
+	if note.ContentType == "html" {
+		html = s.Sanitize(note.Content)
+	} else {
+		var buf bytes.Buffer
+		ctx := parser.NewContext(parser.WithIDs(newHeadingIDs(s.idMarker)))
+		if err := s.markdown.Convert([]byte(note.Content), &buf, parser.WithContext(ctx)); err != nil {
+			return "", fmt.Errorf("渲染失败: %v", err)
+		}
+		html = prefixUserIDs(s.policy.Sanitize(buf.String()), s.idMarker)
+	}
+
+	s.store(key, checksum, html)
+	return html, nil
+}
+
+// Sanitize 按白名单过滤 HTML，id 加上 user-content- 前缀
+func (s *RenderService) Sanitize(html string) string {
+	return prefixUserIDs(s.policy.Sanitize(html), "")
+}
+
+// prefixUserIDs 为用户编写的 id 加上 user-content- 前缀。带 marker 的 id 和锚点链接由渲染器生成，
+// 去掉 marker 后原样保留，因此标题锚点不加前缀，脚注的 id 和链接保持一致
+func prefixUserIDs(sanitized, marker string) string {
+	var out strings.Builder
+	out.Grow(len(sanitized))
+
+	z := nethtml.NewTokenizer(strings.NewReader(sanitized))
+	for {
+		tt := z.Next()
+		if tt == nethtml.ErrorToken {
+			break
+		}
+		if tt != nethtml.StartTagToken && tt != nethtml.SelfClosingTagToken {
+			out.Write(z.Raw())
+			continue
+		}
+
+		raw := string(z.Raw())
+		token := z.Token()
+		changed := false
+		for i, attr := range token.Attr {
+			switch {
+			case attr.Key == "id" && marker != "" && strings.HasPrefix(attr.Val, marker):
+				token.Attr[i].Val = strings.TrimPrefix(attr.Val, marker)
+			case attr.Key == "id":
+				token.Attr[i].Val = userContentPrefix + attr.Val
+			case attr.Key == "href" && marker != "" && strings.HasPrefix(attr.Val, "#"+marker):
+				token.Attr[i].Val = "#" + strings.TrimPrefix(attr.Val, "#"+marker)
+			default:
+				continue
+			}
+			changed = true
+		}
+
+		if changed {
+			out.WriteString(token.String())
+		} else {
+			out.WriteString(raw)
+		}
+	}
+	return out.String()
+}
+
+// Excerpt 从渲染后的 HTML 中提取纯文本摘要，超过 maxRunes 时截断
+func (s *RenderService) Excerpt(renderedHTML string, maxRunes int) string {
+	// 标签之间补空格，避免相邻段落的文字连在一起
+	text := html.UnescapeString(s.strip.Sanitize(strings.ReplaceAll(renderedHTML, "<", " <")))
+	text = strings.Join(strings.Fields(text), " ")
+	if runes := []rune(text); len(runes) > maxRunes {
+		return strings.TrimSpace(string(runes[:maxRunes])) + "…"
+	}
+	return text
+}
+
+func (s *RenderService) lookup(key renderKey, checksum uint64) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	element, ok := s.entries[key]
+	if !ok {
+		return "", false
+	}
+	entry := element.Value.(*renderEntry)
+	if entry.checksum != checksum {
+		return "", false
+	}
+	s.order.MoveToFront(element)
+	return entry.html, true
+}
+
+func (s *RenderService) store(key renderKey, checksum uint64, html string) {
+	if s.capacity <= 0 {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if element, ok := s.entries[key]; ok {
+		entry := element.Value.(*renderEntry)
+		entry.checksum = checksum
+		entry.html = html
+		s.order.MoveToFront(element)
+		return
+	}
+
+	s.entries[key] = s.order.PushFront(&renderEntry{key: key, checksum: checksum, html: html})
+	for s.order.Len() > s.capacity {
+		oldest := s.order.Back()
+		s.order.Remove(oldest)
+		delete(s.entries, oldest.Value.(*renderEntry).key)
+	}
+}
+
+func contentChecksum(contentType, content string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(contentType))
+	h.Write([]byte{0})
+	h.Write([]byte(content))
+	return h.Sum64()
+}
+
+// headingIDs 生成标题锚点。goldmark 默认会丢弃非 ASCII 字符，中文标题全部变成 heading-N，
+// 这里保留 Unicode 字母和数字。生成的 id 带有 marker，过滤后据此与用户编写的 id 区分
+type headingIDs struct {
+	marker string
+	values map[string]bool
+}
+
+func newHeadingIDs(marker string) *headingIDs {
+	return &headingIDs{marker: marker, values: make(map[string]bool)}
+}
+
+func (s *headingIDs) Generate(value []byte, kind ast.NodeKind) []byte {
+	var result []rune
+	for len(value) > 0 {
+		r, size := utf8.DecodeRune(value)
+		value = value[size:]
+		switch {
+		case unicode.IsLetter(r) || unicode.IsNumber(r):
+			result = append(result, unicode.ToLower(r))
+		case unicode.IsSpace(r) || r == '-' || r == '_':
+			if len(result) > 0 && result[len(result)-1] != '-' {
+				result = append(result, '-')
+			}
+		}
+	}
+
+	id := string(result)
+	for len(id) > 0 && id[len(id)-1] == '-' {
+		id = id[:len(id)-1]
+	}
+	if id == "" {
+		if kind == ast.KindHeading {
+			id = "heading"
+		} else {
+			id = "id"
+		}
+	}
+
+	candidate := id
+	for i := 1; s.values[candidate]; i++ {
+		candidate = id + "-" + strconv.Itoa(i)
+	}
+	s.values[candidate] = true
+	return []byte(s.marker + candidate)
+}
+
+func (s *headingIDs) Put(value []byte) {
+	s.values[string(value)] = true
+}
@@ -0,0 +1,236 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"notes-backend/internal/config"
+	"notes-backend/internal/models"
+	"notes-backend/internal/utils"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// RevisionService 管理笔记历史版本。历史版本不计入用户存储空间统计（UserStorage）
+type RevisionService struct {
+	db          *gorm.DB
+	cfg         config.RevisionConfig
+	permissions *PermissionService
+}
+
+func NewRevisionService(db *gorm.DB, cfg config.RevisionConfig, permissions *PermissionService) *RevisionService {
+	return &RevisionService{db: db, cfg: cfg, permissions: permissions}
+}
+
+// GetRevisions 获取笔记的历史版本列表（不含正文），按版本号倒序
+func (s *RevisionService) GetRevisions(noteID, userID uint) ([]models.NoteRevision, error) {
+	if err := s.checkNoteAccess(noteID, userID); err != nil {
+		return nil, err
+	}
+
+	var revisions []models.NoteRevision
+	err := s.db.Select("id, note_id, revision, author_id, title, content_type, category_id, tag_ids, created_at").
+		Preload("Author", revisionAuthorColumns).
+		Where("note_id = ?", noteID).
+		Order("revision DESC").
+		Find(&revisions).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return revisions, nil
+}
+
+func (s *RevisionService) GetRevision(noteID, userID uint, revision int) (*models.NoteRevision, error) {
+	if err := s.checkNoteAccess(noteID, userID); err != nil {
+		return nil, err
+	}
+	return s.findRevision(s.db, noteID, revision)
+}
+
+// DiffRevisions 比较两个历史版本的正文。against 为 nil 时与上一个版本比较，为 0 时与空文档比较
+func (s *RevisionService) DiffRevisions(noteID, userID uint, revision int, against *int) (*models.RevisionDiffResponse, error) {
+	if err := s.checkNoteAccess(noteID, userID); err != nil {
+		return nil, err
+	}
+
+	to, err := s.findRevision(s.db, noteID, revision)
+	if err != nil {
+		return nil, err
+	}
+
+	from := &models.NoteRevision{NoteID: noteID}
+	if against == nil {
+		var previous models.NoteRevision
+		err := s.db.Where("note_id = ? AND revision < ?", noteID, revision).
+			Order("revision DESC").First(&previous).Error
+		if err == nil {
+			from = &previous
+		} else if err != gorm.ErrRecordNotFound {
+			return nil, err
+		}
+	} else if *against > 0 {
+		if from, err = s.findRevision(s.db, noteID, *against); err != nil {
+			return nil, err
+		}
+	}
+
+	diff := utils.UnifiedDiff(
+		fmt.Sprintf("revision %d", from.Revision),
+		fmt.Sprintf("revision %d", to.Revision),
+		from.Content, to.Content, 3)
+
+	return &models.RevisionDiffResponse{
+		NoteID:       noteID,
+		FromRevision: from.Revision,
+		ToRevision:   to.Revision,
+		FromTitle:    from.Title,
+		ToTitle:      to.Title,
+		Diff:         diff,
+	}, nil
+}
+
+func (s *RevisionService) GetPolicy(userID uint) (*models.RevisionPolicy, error) {
+	return s.policyFor(s.db, userID)
+}
+
+func (s *RevisionService) UpdatePolicy(userID uint, req *models.RevisionPolicyRequest) (*models.RevisionPolicy, error) {
+	policy := models.RevisionPolicy{
+		UserID:    userID,
+		KeepCount: req.KeepCount,
+		KeepDays:  req.KeepDays,
+	}
+
+	if err := s.db.Save(&policy).Error; err != nil {
+		return nil, err
+	}
+
+	return &policy, nil
+}
+
+// ensureBaselineInTx 为尚无历史版本的旧笔记补记当前内容，避免首次修改时丢失原始内容
+func (s *RevisionService) ensureBaselineInTx(tx *gorm.DB, noteID, authorID uint) error {
+	// 先锁定笔记行，避免并发保存时重复补记
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id").
+		Where("id = ?", noteID).First(&models.Note{}).Error
+	if err != nil {
+		return err
+	}
+
+	var count int64
+	if err := tx.Model(&models.NoteRevision{}).Where("note_id = ?", noteID).Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return nil
+	}
+	return s.recordInTx(tx, noteID, authorID)
+}
+
+// recordInTx 按笔记当前状态写入一个新的历史版本，并按笔记所有者的策略清理旧版本
+func (s *RevisionService) recordInTx(tx *gorm.DB, noteID, authorID uint) error {
+	// 锁定笔记行，并发保存同一笔记时依次分配版本号
+	var note models.Note
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Preload("Tags").
+		Where("id = ?", noteID).First(&note).Error
+	if err != nil {
+		return err
+	}
+
+	var latest int
+	if err := tx.Model(&models.NoteRevision{}).Where("note_id = ?", noteID).
+		Select("COALESCE(MAX(revision), 0)").Scan(&latest).Error; err != nil {
+		return err
+	}
+
+	tagIDs := make(models.UintList, 0, len(note.Tags))
+	for _, tag := range note.Tags {
+		tagIDs = append(tagIDs, tag.ID)
+	}
+
+	revision := models.NoteRevision{
+		NoteID:      note.ID,
+		Revision:    latest + 1,
+		AuthorID:    authorID,
+		Title:       note.Title,
+		Content:     note.Content,
+		ContentType: note.ContentType,
+		CategoryID:  note.CategoryID,
+		TagIDs:      tagIDs,
+	}
+
+	if err := tx.Create(&revision).Error; err != nil {
+		return err
+	}
+
+	return s.pruneInTx(tx, note.ID, note.UserID, revision.Revision)
+}
+
+// pruneInTx 清理超出保留策略的历史版本，最新版本始终保留
+func (s *RevisionService) pruneInTx(tx *gorm.DB, noteID, ownerID uint, latest int) error {
+	policy, err := s.policyFor(tx, ownerID)
+	if err != nil {
+		return err
+	}
+
+	if policy.KeepCount > 0 {
+		if err := tx.Where("note_id = ? AND revision <= ?", noteID, latest-policy.KeepCount).
+			Delete(&models.NoteRevision{}).Error; err != nil {
+			return err
+		}
+	}
+
+	if policy.KeepDays > 0 {
+		cutoff := time.Now().AddDate(0, 0, -policy.KeepDays)
+		if err := tx.Where("note_id = ? AND created_at < ? AND revision < ?", noteID, cutoff, latest).
+			Delete(&models.NoteRevision{}).Error; err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *RevisionService) policyFor(db *gorm.DB, userID uint) (*models.RevisionPolicy, error) {
+	var policy models.RevisionPolicy
+	err := db.Where("user_id = ?", userID).First(&policy).Error
+	if err == gorm.ErrRecordNotFound {
+		return &models.RevisionPolicy{
+			UserID:    userID,
+			KeepCount: s.cfg.DefaultKeepCount,
+			KeepDays:  s.cfg.DefaultKeepDays,
+		}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &policy, nil
+}
+
+// revisionAuthorColumns 共享笔记的协作者也能查看历史版本，作者只加载 ID 和用户名
+func revisionAuthorColumns(db *gorm.DB) *gorm.DB {
+	return db.Select("id", "username")
+}
+
+func (s *RevisionService) findRevision(db *gorm.DB, noteID uint, revision int) (*models.NoteRevision, error) {
+	var rev models.NoteRevision
+	if err := db.Preload("Author", revisionAuthorColumns).Where("note_id = ? AND revision = ?", noteID, revision).First(&rev).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, fmt.Errorf("历史版本不存在")
+		}
+		return nil, err
+	}
+	return &rev, nil
+}
+
+// checkNoteAccess 所有者和被授权查看笔记的用户都可以查看历史版本
+func (s *RevisionService) checkNoteAccess(noteID, userID uint) error {
+	if _, err := s.permissions.NoteRole(noteID, userID); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return fmt.Errorf("笔记不存在")
+		}
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,316 @@
+package services
+
+import (
+	"fmt"
+	"html"
+	"math"
+	"notes-backend/internal/config"
+	"notes-backend/internal/models"
+	"notes-backend/internal/search"
+	"sort"
+	"strings"
+	"unicode"
+
+	"gorm.io/gorm"
+)
+
+const (
+	highlightStart  = "<mark>"
+	highlightStop   = "</mark>"
+	headlineOptions = "StartSel=<mark>, StopSel=</mark>, MaxWords=35, MinWords=15, MaxFragments=2, FragmentDelimiter=\" … \""
+	snippetLength   = 120
+	reindexBatch    = 200
+)
+
+// SearchService 基于 PostgreSQL tsvector 的笔记全文搜索，标题权重高于正文
+type SearchService struct {
+	db  *gorm.DB
+	cfg config.SearchConfig
+}
+
+type searchHit struct {
+	ID            uint
+	Rank          float64
+	TitleHeadline string
+	Headline      string
+}
+
+func NewSearchService(db *gorm.DB, cfg config.SearchConfig) *SearchService {
+	return &SearchService{db: db, cfg: cfg}
+}
+
+// Search 按结构化查询搜索笔记，普通词和短语使用全文索引匹配并按相关度排序，其余条件作为过滤
+func (s *SearchService) Search(userID uint, req *models.SearchRequest) ([]models.SearchResult, *models.Pagination, error) {
+	parsed, err := search.Parse(req.Q)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	scopes, err := parsed.Scopes(s.db, userID, false)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	text := strings.Join(parsed.TextTerms(), " ")
+	terms := s.tokenize(text)
+	tsquery := "plainto_tsquery(?::regconfig, ?)"
+
+	query := s.db.Model(&models.Note{}).
+		Where("notes.user_id = ?", userID).
+		Scopes(scopes...)
+	if text != "" {
+		query = query.Where("notes.search_vector @@ "+tsquery, s.cfg.TextSearchConfig, terms)
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, nil, err
+	}
+
+	selectSQL := "notes.id, 0 AS rank"
+	var args []interface{}
+
+	if text != "" {
+		selectSQL = "notes.id, ts_rank(notes.search_vector, " + tsquery + ") AS rank"
+		args = []interface{}{s.cfg.TextSearchConfig, terms}
+
+		// 二元切分时数据库中的原文无法与查询词对应，摘要改为在应用层生成
+		if !s.useBigram() {
+			selectSQL += ", ts_headline(?::regconfig, " + escapeHTMLSQL("notes.title") + ", " + tsquery + ", ?) AS title_headline"
+			selectSQL += ", ts_headline(?::regconfig, " + escapeHTMLSQL("notes.content") + ", " + tsquery + ", ?) AS headline"
+			args = append(args,
+				s.cfg.TextSearchConfig, s.cfg.TextSearchConfig, terms, "HighlightAll=true, "+headlineOptions,
+				s.cfg.TextSearchConfig, s.cfg.TextSearchConfig, terms, headlineOptions)
+		}
+	}
+
+	var hits []searchHit
+	offset := (req.Page - 1) * req.Limit
+	err = query.Select(selectSQL, args...).
+		Order("rank DESC, notes.updated_at DESC").
+		Limit(req.Limit).Offset(offset).
+		Scan(&hits).Error
+	if err != nil {
+		return nil, nil, err
+	}
+
+	results := make([]models.SearchResult, 0, len(hits))
+	if len(hits) > 0 {
+		ids := make([]uint, len(hits))
+		for i, hit := range hits {
+			ids[i] = hit.ID
+		}
+
+		var notes []models.Note
+		if err := s.db.Preload("Category").Preload("Tags").Where("id IN ?", ids).Find(&notes).Error; err != nil {
+			return nil, nil, err
+		}
+		noteMap := make(map[uint]*models.Note, len(notes))
+		for i := range notes {
+			noteMap[notes[i].ID] = &notes[i]
+		}
+
+		highlightTerms := s.highlightTerms(text)
+		for _, hit := range hits {
+			note, ok := noteMap[hit.ID]
+			if !ok {
+				continue
+			}
+
+			result := models.SearchResult{
+				Note:          note,
+				Rank:          hit.Rank,
+				TitleHeadline: hit.TitleHeadline,
+				Headline:      hit.Headline,
+			}
+			if text == "" || s.useBigram() {
+				result.TitleHeadline = highlightSnippet(note.Title, highlightTerms, 0)
+				result.Headline = highlightSnippet(note.Content, highlightTerms, snippetLength)
+			}
+
+			results = append(results, result)
+		}
+	}
+
+	pagination := &models.Pagination{
+		Page:  req.Page,
+		Limit: req.Limit,
+		Total: int(total),
+		Pages: int(math.Ceil(float64(total) / float64(req.Limit))),
+	}
+
+	return results, pagination, nil
+}
+
+// ReindexMissing 为尚未建立索引的笔记（包括旧数据和分词配置变更后的数据）补建索引
+func (s *SearchService) ReindexMissing() {
+	indexed := 0
+	for {
+		var notes []models.Note
+		err := s.db.Unscoped().Select("id, title, content").
+			Where("search_vector IS NULL").
+			Limit(reindexBatch).Find(&notes).Error
+		if err != nil {
+			fmt.Printf("Search reindex failed: %v\n", err)
+			return
+		}
+		if len(notes) == 0 {
+			break
+		}
+
+		for _, note := range notes {
+			if err := s.indexNoteInTx(s.db, note.ID, note.Title, note.Content); err != nil {
+				fmt.Printf("Search reindex failed for note %d: %v\n", note.ID, err)
+				return
+			}
+		}
+		indexed += len(notes)
+	}
+
+	if indexed > 0 {
+		fmt.Printf("Search index rebuilt for %d notes\n", indexed)
+	}
+}
+
+// indexNoteInTx 更新笔记的 tsvector，标题权重 A，正文权重 B
+func (s *SearchService) indexNoteInTx(tx *gorm.DB, noteID uint, title, content string) error {
+	return tx.Exec(
+		"UPDATE notes SET search_vector = setweight(to_tsvector(?::regconfig, ?), 'A') || setweight(to_tsvector(?::regconfig, ?), 'B') WHERE id = ?",
+		s.cfg.TextSearchConfig, s.tokenize(title),
+		s.cfg.TextSearchConfig, s.tokenize(content),
+		noteID,
+	).Error
+}
+
+func (s *SearchService) useBigram() bool {
+	return s.cfg.Tokenizer == "bigram"
+}
+
+func (s *SearchService) tokenize(text string) string {
+	if s.useBigram() {
+		return bigramTokenize(text)
+	}
+	return text
+}
+
+// highlightTerms 返回应用层高亮使用的词，包括原始查询词和中日韩文本的二元切分结果，长词优先
+func (s *SearchService) highlightTerms(query string) []string {
+	seen := make(map[string]bool)
+	var terms []string
+	for _, field := range append(strings.Fields(query), strings.Fields(bigramTokenize(query))...) {
+		term := strings.ToLower(field)
+		if term == "" || seen[term] {
+			continue
+		}
+		seen[term] = true
+		terms = append(terms, term)
+	}
+
+	sort.SliceStable(terms, func(i, j int) bool {
+		return len([]rune(terms[i])) > len([]rune(terms[j]))
+	})
+	return terms
+}
+
+// bigramTokenize 将连续的中日韩字符切分为重叠的二元词组，其它文本保持不变
+func bigramTokenize(text string) string {
+	var sb strings.Builder
+	var run []rune
+
+	flush := func() {
+		switch {
+		case len(run) == 1:
+			sb.WriteString(" " + string(run) + " ")
+		case len(run) > 1:
+			for i := 0; i+1 < len(run); i++ {
+				sb.WriteString(" " + string(run[i:i+2]))
+			}
+			sb.WriteString(" ")
+		}
+		run = run[:0]
+	}
+
+	for _, r := range text {
+		if isCJK(r) {
+			run = append(run, r)
+			continue
+		}
+		flush()
+		sb.WriteRune(r)
+	}
+	flush()
+
+	return sb.String()
+}
+
+func isCJK(r rune) bool {
+	return unicode.Is(unicode.Han, r) ||
+		unicode.Is(unicode.Hiragana, r) ||
+		unicode.Is(unicode.Katakana, r) ||
+		unicode.Is(unicode.Hangul, r)
+}
+
+// highlightSnippet 截取第一个命中词附近的文本并转义，命中词以 <mark> 标记。maxRunes 为 0 时不截取
+func highlightSnippet(text string, terms []string, maxRunes int) string {
+	runes := []rune(text)
+	lower := []rune(strings.ToLower(text))
+	if len(lower) != len(runes) {
+		lower = runes
+	}
+
+	matchAt := func(i int) int {
+		for _, term := range terms {
+			termRunes := []rune(term)
+			if i+len(termRunes) <= len(lower) && string(lower[i:i+len(termRunes)]) == term {
+				return len(termRunes)
+			}
+		}
+		return 0
+	}
+
+	start, end := 0, len(runes)
+	if maxRunes > 0 && len(runes) > maxRunes {
+		first := 0
+		for i := range lower {
+			if matchAt(i) > 0 {
+				first = i
+				break
+			}
+		}
+		start = first - maxRunes/3
+		if start < 0 {
+			start = 0
+		}
+		end = start + maxRunes
+		if end > len(runes) {
+			end = len(runes)
+		}
+	}
+
+	var sb strings.Builder
+	if start > 0 {
+		sb.WriteString("… ")
+	}
+	for i := start; i < end; {
+		if n := matchAt(i); n > 0 {
+			stop := i + n
+			if stop > end {
+				stop = end
+			}
+			sb.WriteString(highlightStart + html.EscapeString(string(runes[i:stop])) + highlightStop)
+			i = stop
+			continue
+		}
+		sb.WriteString(html.EscapeString(string(runes[i])))
+		i++
+	}
+	if end < len(runes) {
+		sb.WriteString(" …")
+	}
+
+	return sb.String()
+}
+
+func escapeHTMLSQL(column string) string {
+	return fmt.Sprintf("replace(replace(replace(%s, '&', '&amp;'), '<', '&lt;'), '>', '&gt;')", column)
+}
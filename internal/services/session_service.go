@@ -0,0 +1,538 @@
+package services
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"notes-backend/internal/config"
+	"notes-backend/internal/models"
+	"notes-backend/internal/utils"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/mssola/useragent"
+	"gorm.io/gorm"
+)
+
+var (
+	// ErrRefreshTokenInvalid 刷新令牌不存在、已过期或会话已吊销
+	ErrRefreshTokenInvalid = errors.New("刷新令牌无效或已过期，请重新登录")
+	// ErrRefreshTokenReused 已轮换的刷新令牌被再次使用，会话已吊销
+	ErrRefreshTokenReused = errors.New("刷新令牌已被使用，会话已失效，请重新登录")
+	// ErrRefreshInProgress 宽限时间内再次使用上一个刷新令牌，通常是客户端并发刷新
+	ErrRefreshInProgress = errors.New("刷新令牌已更新，请使用最新的令牌")
+	// ErrSessionNotFound 会话不存在、不属于当前用户或已失效
+	ErrSessionNotFound = errors.New("会话不存在或已失效")
+)
+
+const (
+	// sessionCleanupInterval 清理过期会话和吊销记录的间隔
+	sessionCleanupInterval = time.Hour
+	// sessionActivityFlushInterval 最近访问时间批量写入数据库的间隔
+	sessionActivityFlushInterval = time.Minute
+	// sessionStateCacheTTL 会话状态的缓存时间。多实例部署时，其他实例吊销的会话最迟在此时间后生效
+	sessionStateCacheTTL = 30 * time.Second
+)
+
+// sessionActivity 尚未写入数据库的会话最近访问记录
+type sessionActivity struct {
+	at time.Time
+	ip string
+}
+
+// sessionState 缓存的会话状态，已吊销的会话一直保留到清理任务删除
+type sessionState struct {
+	revoked   bool
+	checkedAt time.Time
+}
+
+// SessionService 登录会话、访问令牌签发和吊销。访问令牌为短期 JWT，
+// 刷新令牌以 SHA-256 哈希保存在 sessions 表中，每次刷新后轮换
+type SessionService struct {
+	db  *gorm.DB
+	cfg config.JWTConfig
+
+	activityMu sync.Mutex
+	activity   map[string]sessionActivity
+
+	stateMu sync.Mutex
+	states  map[string]sessionState
+}
+
+func NewSessionService(db *gorm.DB, cfg config.JWTConfig) *SessionService {
+	return &SessionService{
+		db:       db,
+		cfg:      cfg,
+		activity: make(map[string]sessionActivity),
+		states:   make(map[string]sessionState),
+	}
+}
+
+// CreateSession 登录或注册后创建会话，签发访问令牌和刷新令牌
+func (s *SessionService) CreateSession(user *models.User, client *models.ClientInfo) (*models.TokenPair, error) {
+	refreshToken, refreshHash, sessionID, err := s.newRefreshToken("")
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	session := models.Session{
+		ID:               sessionID,
+		UserID:           user.ID,
+		RefreshTokenHash: refreshHash,
+		UserAgent:        client.UserAgent,
+		IP:               client.IP,
+		RotatedAt:        now,
+		LastSeenAt:       now,
+		ExpiresAt:        now.Add(s.refreshTTL()),
+	}
+
+	token, claims, err := s.accessToken(user, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	session.AccessTokenID = claims.ID
+
+	if err := s.db.Create(&session).Error; err != nil {
+		return nil, err
+	}
+
+	return &models.TokenPair{
+		Token:            token,
+		ExpiresAt:        claims.ExpiresAt.Time,
+		RefreshToken:     refreshToken,
+		RefreshExpiresAt: session.ExpiresAt,
+		SessionID:        session.ID,
+	}, nil
+}
+
+// Refresh 使用刷新令牌换取新的访问令牌和刷新令牌。已轮换的令牌在宽限时间之后再次出现时，
+// 说明令牌可能被盗用，吊销整个会话；从未签发过的令牌只返回无效，不影响会话
+func (s *SessionService) Refresh(refreshToken string, client *models.ClientInfo) (*models.User, *models.TokenPair, error) {
+	sessionID, ok := parseRefreshToken(refreshToken)
+	if !ok {
+		return nil, nil, ErrRefreshTokenInvalid
+	}
+	hash := hashRefreshToken(refreshToken)
+
+	var session models.Session
+	if err := s.db.Where("id = ?", sessionID).First(&session).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil, ErrRefreshTokenInvalid
+		}
+		return nil, nil, err
+	}
+
+	now := time.Now()
+	if session.RevokedAt != nil || now.After(session.ExpiresAt) {
+		return nil, nil, ErrRefreshTokenInvalid
+	}
+
+	if hash != session.RefreshTokenHash {
+		grace := time.Duration(s.cfg.RefreshReuseGraceSeconds) * time.Second
+		if hash == session.PreviousTokenHash && now.Sub(session.RotatedAt) <= grace {
+			return nil, nil, ErrRefreshInProgress
+		}
+
+		// 会话 ID 可能随链接泄露，只有该会话确实签发过的令牌再次出现才吊销会话，
+		// 否则任何人都能用 <会话 ID>.<随机串> 让用户下线
+		if hash != session.PreviousTokenHash {
+			var count int64
+			err := s.db.Model(&models.RotatedRefreshToken{}).
+				Where("token_hash = ? AND session_id = ?", hash, session.ID).
+				Count(&count).Error
+			if err != nil {
+				return nil, nil, err
+			}
+			if count == 0 {
+				return nil, nil, ErrRefreshTokenInvalid
+			}
+		}
+
+		fmt.Printf("Refresh token reuse detected, revoking session: session_id=%s, user_id=%d, ip=%s\n",
+			session.ID, session.UserID, client.IP)
+		if err := s.revoke(&session, models.SessionRevokedReuse); err != nil {
+			return nil, nil, err
+		}
+		return nil, nil, ErrRefreshTokenReused
+	}
+
+	var user models.User
+	if err := s.db.Where("id = ? AND is_active = ?", session.UserID, true).First(&user).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			if err := s.revoke(&session, models.SessionRevokedUser); err != nil {
+				return nil, nil, err
+			}
+			return nil, nil, ErrRefreshTokenInvalid
+		}
+		return nil, nil, err
+	}
+
+	newToken, newHash, _, err := s.newRefreshToken(session.ID)
+	if err != nil {
+		return nil, nil, err
+	}
+	token, claims, err := s.accessToken(&user, session.ID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	expiresAt := now.Add(s.refreshTTL())
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		// 以当前哈希为条件更新，并发刷新时只有一个请求成功
+		result := tx.Model(&models.Session{}).
+			Where("id = ? AND refresh_token_hash = ? AND revoked_at IS NULL", session.ID, hash).
+			Updates(map[string]interface{}{
+				"refresh_token_hash":  newHash,
+				"previous_token_hash": hash,
+				"access_token_id":     claims.ID,
+				"user_agent":          client.UserAgent,
+				"ip":                  client.IP,
+				"rotated_at":          now,
+				"last_seen_at":        now,
+				"expires_at":          expiresAt,
+			})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrRefreshInProgress
+		}
+
+		return tx.Create(&models.RotatedRefreshToken{TokenHash: hash, SessionID: session.ID}).Error
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return &user, &models.TokenPair{
+		Token:            token,
+		ExpiresAt:        claims.ExpiresAt.Time,
+		RefreshToken:     newToken,
+		RefreshExpiresAt: expiresAt,
+		SessionID:        session.ID,
+	}, nil
+}
+
+// Logout 吊销当前会话和正在使用的访问令牌。旧版本签发的令牌没有会话 ID，只吊销令牌本身
+func (s *SessionService) Logout(userID uint, sessionID, tokenID string, tokenExpiresAt time.Time) error {
+	if tokenID != "" {
+		if err := s.revokeToken(tokenID, userID, tokenExpiresAt); err != nil {
+			return err
+		}
+	}
+	if sessionID == "" {
+		return nil
+	}
+
+	var session models.Session
+	if err := s.db.Where("id = ? AND user_id = ?", sessionID, userID).First(&session).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		return err
+	}
+	if session.RevokedAt != nil {
+		return nil
+	}
+	return s.revoke(&session, models.SessionRevokedLogout)
+}
+
+// GetSessions 用户当前有效的会话，按最近访问时间倒序，currentSessionID 对应的会话标记为当前设备
+func (s *SessionService) GetSessions(userID uint, currentSessionID string) ([]models.SessionInfo, error) {
+	var sessions []models.Session
+	err := s.db.Where("user_id = ? AND revoked_at IS NULL AND expires_at > ?", userID, time.Now()).
+		Find(&sessions).Error
+	if err != nil {
+		return nil, err
+	}
+
+	s.activityMu.Lock()
+	for i := range sessions {
+		// 合并尚未写入数据库的访问记录
+		if a, ok := s.activity[sessions[i].ID]; ok && a.at.After(sessions[i].LastSeenAt) {
+			sessions[i].LastSeenAt = a.at
+			sessions[i].IP = a.ip
+		}
+	}
+	s.activityMu.Unlock()
+
+	infos := make([]models.SessionInfo, 0, len(sessions))
+	for _, session := range sessions {
+		lastSeen := session.LastSeenAt
+		if lastSeen.IsZero() {
+			lastSeen = session.RotatedAt
+		}
+
+		browser, os := parseUserAgent(session.UserAgent)
+		infos = append(infos, models.SessionInfo{
+			ID:         session.ID,
+			Device:     deviceName(browser, os),
+			Browser:    browser,
+			OS:         os,
+			Mobile:     session.UserAgent != "" && useragent.New(session.UserAgent).Mobile(),
+			IP:         session.IP,
+			CreatedAt:  session.CreatedAt,
+			LastSeenAt: lastSeen,
+			ExpiresAt:  session.ExpiresAt,
+			Current:    session.ID == currentSessionID,
+		})
+	}
+
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].LastSeenAt.After(infos[j].LastSeenAt)
+	})
+	return infos, nil
+}
+
+// RevokeSession 退出指定会话，该会话的刷新令牌和已签发的访问令牌立即失效
+func (s *SessionService) RevokeSession(userID uint, sessionID string) error {
+	var session models.Session
+	err := s.db.Where("id = ? AND user_id = ? AND revoked_at IS NULL", sessionID, userID).First(&session).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrSessionNotFound
+		}
+		return err
+	}
+	return s.revoke(&session, models.SessionRevokedSignOut)
+}
+
+// RevokeSessions 退出用户的所有会话，exceptSessionID 不为空时保留该会话，返回退出的会话数
+func (s *SessionService) RevokeSessions(userID uint, exceptSessionID string) (int, error) {
+	query := s.db.Where("user_id = ? AND revoked_at IS NULL", userID)
+	if exceptSessionID != "" {
+		query = query.Where("id <> ?", exceptSessionID)
+	}
+
+	var sessions []models.Session
+	if err := query.Find(&sessions).Error; err != nil {
+		return 0, err
+	}
+
+	for i := range sessions {
+		if err := s.revoke(&sessions[i], models.SessionRevokedSignOut); err != nil {
+			return i, err
+		}
+	}
+	return len(sessions), nil
+}
+
+// Touch 记录会话的最近访问时间和 IP。只写入内存，由后台任务定期批量写入数据库，
+// 避免每个请求都更新 sessions 表
+func (s *SessionService) Touch(sessionID, ip string) {
+	if sessionID == "" {
+		return
+	}
+
+	s.activityMu.Lock()
+	s.activity[sessionID] = sessionActivity{at: time.Now(), ip: ip}
+	s.activityMu.Unlock()
+}
+
+// StartActivityFlush 启动后台任务，定期将会话访问记录写入数据库
+func (s *SessionService) StartActivityFlush() {
+	go func() {
+		ticker := time.NewTicker(sessionActivityFlushInterval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			s.FlushActivity()
+		}
+	}()
+}
+
+// FlushActivity 将内存中的会话访问记录写入数据库
+func (s *SessionService) FlushActivity() {
+	s.activityMu.Lock()
+	pending := s.activity
+	s.activity = make(map[string]sessionActivity, len(pending))
+	s.activityMu.Unlock()
+
+	for sessionID, a := range pending {
+		err := s.db.Model(&models.Session{}).
+			Where("id = ? AND revoked_at IS NULL AND (last_seen_at IS NULL OR last_seen_at < ?)", sessionID, a.at).
+			Updates(map[string]interface{}{"last_seen_at": a.at, "ip": a.ip}).Error
+		if err != nil {
+			fmt.Printf("Failed to update session activity: session_id=%s, error=%v\n", sessionID, err)
+		}
+	}
+}
+
+// IsTokenRevoked 检查访问令牌是否已被吊销
+func (s *SessionService) IsTokenRevoked(tokenID string) (bool, error) {
+	var count int64
+	if err := s.db.Model(&models.RevokedToken{}).Where("jti = ?", tokenID).Count(&count).Error; err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// IsSessionRevoked 检查会话是否已吊销、过期或已删除。会话的所有访问令牌都随之失效，
+// 而不只是最近签发的一个。结果缓存 sessionStateCacheTTL，本实例吊销的会话立即生效
+func (s *SessionService) IsSessionRevoked(sessionID string) (bool, error) {
+	now := time.Now()
+
+	s.stateMu.Lock()
+	state, ok := s.states[sessionID]
+	s.stateMu.Unlock()
+	if ok && (state.revoked || now.Sub(state.checkedAt) < sessionStateCacheTTL) {
+		return state.revoked, nil
+	}
+
+	var session models.Session
+	err := s.db.Select("id", "revoked_at", "expires_at").Where("id = ?", sessionID).First(&session).Error
+	revoked := false
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		revoked = true
+	case err != nil:
+		return false, err
+	default:
+		revoked = session.RevokedAt != nil || now.After(session.ExpiresAt)
+	}
+
+	s.setSessionState(sessionID, revoked)
+	return revoked, nil
+}
+
+func (s *SessionService) setSessionState(sessionID string, revoked bool) {
+	s.stateMu.Lock()
+	s.states[sessionID] = sessionState{revoked: revoked, checkedAt: time.Now()}
+	s.stateMu.Unlock()
+}
+
+// StartCleanup 启动后台任务，定期删除过期的会话和吊销记录
+func (s *SessionService) StartCleanup() {
+	go func() {
+		ticker := time.NewTicker(sessionCleanupInterval)
+		defer ticker.Stop()
+
+		for {
+			s.cleanup()
+			<-ticker.C
+		}
+	}()
+}
+
+func (s *SessionService) cleanup() {
+	now := time.Now()
+	if err := s.db.Where("expires_at < ?", now).Delete(&models.RevokedToken{}).Error; err != nil {
+		fmt.Printf("Failed to clean up revoked tokens: %v\n", err)
+	}
+	if err := s.db.Where("expires_at < ?", now).Delete(&models.Session{}).Error; err != nil {
+		fmt.Printf("Failed to clean up expired sessions: %v\n", err)
+	}
+	err := s.db.Where("session_id NOT IN (?)", s.db.Model(&models.Session{}).Select("id")).
+		Delete(&models.RotatedRefreshToken{}).Error
+	if err != nil {
+		fmt.Printf("Failed to clean up rotated refresh tokens: %v\n", err)
+	}
+
+	// 吊销超过访问令牌有效期的会话不会再有有效令牌，不必继续缓存
+	s.stateMu.Lock()
+	for id, state := range s.states {
+		if now.Sub(state.checkedAt) > s.accessTTL() {
+			delete(s.states, id)
+		}
+	}
+	s.stateMu.Unlock()
+}
+
+// revoke 吊销会话，并吊销会话最近签发的访问令牌
+func (s *SessionService) revoke(session *models.Session, reason string) error {
+	now := time.Now()
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&models.Session{}).Where("id = ? AND revoked_at IS NULL", session.ID).
+			Updates(map[string]interface{}{"revoked_at": now, "revoke_reason": reason}).Error
+		if err != nil {
+			return err
+		}
+
+		if session.AccessTokenID == "" {
+			return nil
+		}
+		return tx.Save(&models.RevokedToken{
+			JTI:       session.AccessTokenID,
+			UserID:    session.UserID,
+			ExpiresAt: now.Add(s.accessTTL()),
+		}).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	s.setSessionState(session.ID, true)
+	return nil
+}
+
+// deviceName 设备名称，如 "Chrome (macOS)"
+func deviceName(browser, os string) string {
+	switch {
+	case browser != "" && os != "":
+		return fmt.Sprintf("%s (%s)", browser, os)
+	case browser != "":
+		return browser
+	case os != "":
+		return os
+	default:
+		return "未知设备"
+	}
+}
+
+func (s *SessionService) revokeToken(tokenID string, userID uint, expiresAt time.Time) error {
+	return s.db.Save(&models.RevokedToken{
+		JTI:       tokenID,
+		UserID:    userID,
+		ExpiresAt: expiresAt,
+	}).Error
+}
+
+func (s *SessionService) accessToken(user *models.User, sessionID string) (string, *utils.Claims, error) {
+	return utils.GenerateToken(user.ID, user.Username, user.Email, user.Role, sessionID, s.cfg.Secret, s.accessTTL())
+}
+
+func (s *SessionService) accessTTL() time.Duration {
+	return time.Duration(s.cfg.AccessTokenMinutes) * time.Minute
+}
+
+func (s *SessionService) refreshTTL() time.Duration {
+	return time.Duration(s.cfg.RefreshTokenDays) * 24 * time.Hour
+}
+
+// newRefreshToken 生成刷新令牌，格式为 <会话 ID>.<随机串>，sessionID 为空时生成新的会话 ID
+func (s *SessionService) newRefreshToken(sessionID string) (string, string, string, error) {
+	if sessionID == "" {
+		sessionID = uuid.NewString()
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", "", "", err
+	}
+
+	token := sessionID + "." + base64.RawURLEncoding.EncodeToString(secret)
+	return token, hashRefreshToken(token), sessionID, nil
+}
+
+func parseRefreshToken(token string) (string, bool) {
+	sessionID, secret, ok := strings.Cut(token, ".")
+	if !ok || secret == "" {
+		return "", false
+	}
+	if _, err := uuid.Parse(sessionID); err != nil {
+		return "", false
+	}
+	return sessionID, true
+}
+
+func hashRefreshToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
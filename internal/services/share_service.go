@@ -0,0 +1,531 @@
+package services
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"notes-backend/internal/config"
+	"notes-backend/internal/models"
+	"notes-backend/internal/utils"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+var (
+	// ErrShareNotFound 分享链接不存在、已停用或笔记已不再公开
+	ErrShareNotFound = errors.New("分享链接不存在或已失效")
+	// ErrShareExpired 分享链接已过期
+	ErrShareExpired = errors.New("分享链接已过期")
+	// ErrShareViewLimit 分享链接的访问次数已用完
+	ErrShareViewLimit = errors.New("分享链接访问次数已用完")
+	// ErrInvalidMaxViews 访问次数上限必须为正数
+	ErrInvalidMaxViews = errors.New("访问次数上限必须大于 0")
+	// ErrNoteNotShareable 笔记不存在或未公开，不能创建分享链接
+	ErrNoteNotShareable = errors.New("笔记不存在或非公开笔记")
+	// ErrSharePassword 访问密码错误
+	ErrSharePassword = errors.New("访问密码错误")
+	// ErrShareLocked 分享链接设置了密码，缺少有效的访问令牌
+	ErrShareLocked = errors.New("该分享需要访问密码")
+	// ErrShareThrottled 密码错误次数过多
+	ErrShareThrottled = errors.New("密码错误次数过多，请稍后再试")
+)
+
+const argon2Prefix = "$argon2id$"
+
+// ShareService 公开分享链接的查找与校验，供 JSON 接口和服务端渲染页面共用。
+// 分享密码以 Argon2 哈希保存，验证通过后签发只对该分享码有效的短期令牌
+type ShareService struct {
+	db  *gorm.DB
+	cfg *config.Config
+
+	mu       sync.Mutex
+	failures map[string]*unlockFailures
+}
+
+// unlockFailures 同一 IP 对单个分享码在当前时间窗口内未成功的密码尝试次数
+type unlockFailures struct {
+	count       int
+	windowStart time.Time
+}
+
+func NewShareService(db *gorm.DB, cfg *config.Config) *ShareService {
+	return &ShareService{
+		db:       db,
+		cfg:      cfg,
+		failures: make(map[string]*unlockFailures),
+	}
+}
+
+// GetShareLink 按分享码获取可访问的分享链接，过期或访问次数用完时分别返回 ErrShareExpired、ErrShareViewLimit
+func (s *ShareService) GetShareLink(code string) (*models.ShareLink, error) {
+	var shareLink models.ShareLink
+	if err := s.db.Where("share_code = ? AND is_active = ?", code, true).First(&shareLink).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, ErrShareNotFound
+		}
+		return nil, err
+	}
+
+	switch shareLink.Status() {
+	case models.ShareStatusDisabled:
+		return nil, ErrShareNotFound
+	case models.ShareStatusExpired:
+		return nil, ErrShareExpired
+	case models.ShareStatusExhausted:
+		return nil, ErrShareViewLimit
+	}
+
+	return &shareLink, nil
+}
+
+// GetNoteShares 获取笔记的全部分享链接（不含已删除的），按创建时间倒序
+func (s *ShareService) GetNoteShares(noteID, userID uint) ([]models.ShareLinkInfo, error) {
+	var note models.Note
+	if err := s.db.Where("id = ? AND user_id = ?", noteID, userID).First(&note).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, ErrShareNotFound
+		}
+		return nil, err
+	}
+
+	var shareLinks []models.ShareLink
+	if err := s.db.Where("note_id = ? AND is_active = ?", noteID, true).Order("created_at DESC").Find(&shareLinks).Error; err != nil {
+		return nil, err
+	}
+
+	infos := make([]models.ShareLinkInfo, 0, len(shareLinks))
+	for i := range shareLinks {
+		infos = append(infos, s.toInfo(&shareLinks[i], ""))
+	}
+	return infos, nil
+}
+
+// GetUserShares 获取用户所有当前可访问的分享链接
+func (s *ShareService) GetUserShares(userID uint) ([]models.ShareLinkInfo, error) {
+	var shareLinks []models.ShareLink
+	err := s.db.Joins("Note").
+		Where("\"Note\".user_id = ? AND \"Note\".is_public = ?", userID, true).
+		Where("share_links.is_active = ? AND share_links.enabled = ?", true, true).
+		Where("share_links.expire_time IS NULL OR share_links.expire_time > ?", time.Now()).
+		Where("share_links.max_views IS NULL OR share_links.visit_count < share_links.max_views").
+		Order("share_links.created_at DESC").
+		Find(&shareLinks).Error
+	if err != nil {
+		return nil, err
+	}
+
+	infos := make([]models.ShareLinkInfo, 0, len(shareLinks))
+	for i := range shareLinks {
+		infos = append(infos, s.toInfo(&shareLinks[i], shareLinks[i].Note.Title))
+	}
+	return infos, nil
+}
+
+func (s *ShareService) GetShare(noteID, shareID, userID uint) (*models.ShareLinkInfo, error) {
+	shareLink, err := s.findOwnedShare(noteID, shareID, userID)
+	if err != nil {
+		return nil, err
+	}
+	info := s.toInfo(shareLink, "")
+	return &info, nil
+}
+
+// CreateShare 为公开笔记新建分享链接
+func (s *ShareService) CreateShare(noteID, userID uint, req *models.ShareCreateRequest) (*models.ShareLinkInfo, error) {
+	var note models.Note
+	if err := s.db.Where("id = ? AND user_id = ? AND is_public = ?", noteID, userID, true).First(&note).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, ErrNoteNotShareable
+		}
+		return nil, err
+	}
+
+	password, err := s.HashPassword(req.Password)
+	if err != nil {
+		return nil, err
+	}
+
+	shareCode, err := generateShareCode()
+	if err != nil {
+		return nil, err
+	}
+
+	shareLink := models.ShareLink{
+		NoteID:     noteID,
+		ShareCode:  shareCode,
+		Label:      strings.TrimSpace(req.Label),
+		Password:   password,
+		ExpireTime: req.ExpireTime,
+		MaxViews:   req.MaxViews,
+		Enabled:    true,
+		IsActive:   true,
+	}
+	if err := s.db.Create(&shareLink).Error; err != nil {
+		return nil, err
+	}
+
+	// enabled 有数据库默认值，创建时 false 会被忽略，需要单独更新
+	if req.Enabled != nil && !*req.Enabled {
+		if err := s.db.Model(&shareLink).Update("enabled", false).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	info := s.toInfo(&shareLink, "")
+	return &info, nil
+}
+
+// UpdateShare 修改分享链接，只更新请求中出现的字段。修改密码后已签发的访问令牌失效
+func (s *ShareService) UpdateShare(noteID, shareID, userID uint, req *models.ShareUpdateRequest) (*models.ShareLinkInfo, error) {
+	shareLink, err := s.findOwnedShare(noteID, shareID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.MaxViews.Value != nil && *req.MaxViews.Value < 1 {
+		return nil, ErrInvalidMaxViews
+	}
+
+	updates := map[string]interface{}{}
+	if req.Label != nil {
+		updates["label"] = strings.TrimSpace(*req.Label)
+	}
+	if req.Password != nil {
+		password, err := s.HashPassword(req.Password)
+		if err != nil {
+			return nil, err
+		}
+		updates["password"] = *password
+	}
+	if req.ExpireTime.Set {
+		updates["expire_time"] = req.ExpireTime.Value
+	}
+	if req.MaxViews.Set {
+		updates["max_views"] = req.MaxViews.Value
+	}
+	if req.Enabled != nil {
+		updates["enabled"] = *req.Enabled
+	}
+
+	if len(updates) > 0 {
+		if err := s.db.Model(shareLink).Updates(updates).Error; err != nil {
+			return nil, err
+		}
+		if err := s.db.First(shareLink, shareLink.ID).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	info := s.toInfo(shareLink, "")
+	return &info, nil
+}
+
+// DeleteShare 删除分享链接（保留记录用于统计）
+func (s *ShareService) DeleteShare(noteID, shareID, userID uint) error {
+	shareLink, err := s.findOwnedShare(noteID, shareID, userID)
+	if err != nil {
+		return err
+	}
+	return s.db.Model(shareLink).Update("is_active", false).Error
+}
+
+// GetLegacyShare 旧版单链接接口使用的分享链接，即笔记最早创建的未删除链接
+func (s *ShareService) GetLegacyShare(noteID, userID uint) (*models.ShareLinkInfo, error) {
+	var shareLink models.ShareLink
+	err := s.db.Joins("JOIN notes ON notes.id = share_links.note_id").
+		Where("share_links.note_id = ? AND share_links.is_active = ?", noteID, true).
+		Where("notes.user_id = ? AND notes.deleted_at IS NULL", userID).
+		Order("share_links.created_at ASC, share_links.id ASC").
+		First(&shareLink).Error
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, ErrShareNotFound
+		}
+		return nil, err
+	}
+	info := s.toInfo(&shareLink, "")
+	return &info, nil
+}
+
+// SaveLegacyShare 旧版单链接接口：更新笔记最早的分享链接的密码和过期时间，没有链接时新建，
+// 返回的 bool 表示是否新建
+func (s *ShareService) SaveLegacyShare(noteID, userID uint, req *models.ShareLinkCreateRequest) (*models.ShareLinkInfo, bool, error) {
+	var note models.Note
+	if err := s.db.Where("id = ? AND user_id = ? AND is_public = ?", noteID, userID, true).First(&note).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, false, ErrNoteNotShareable
+		}
+		return nil, false, err
+	}
+
+	existing, err := s.GetLegacyShare(noteID, userID)
+	if errors.Is(err, ErrShareNotFound) {
+		info, err := s.CreateShare(noteID, userID, &models.ShareCreateRequest{
+			Password:   req.Password,
+			ExpireTime: req.ExpireTime,
+		})
+		return info, true, err
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	info, err := s.UpdateShare(noteID, existing.ID, userID, &models.ShareUpdateRequest{
+		Password:   req.Password,
+		ExpireTime: models.Optional[time.Time]{Set: req.ExpireTime != nil, Value: req.ExpireTime},
+	})
+	return info, false, err
+}
+
+// DeleteNoteShares 旧版单链接接口：删除笔记的全部分享链接
+func (s *ShareService) DeleteNoteShares(noteID, userID uint) error {
+	var note models.Note
+	if err := s.db.Where("id = ? AND user_id = ?", noteID, userID).First(&note).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return ErrShareNotFound
+		}
+		return err
+	}
+
+	result := s.db.Model(&models.ShareLink{}).Where("note_id = ? AND is_active = ?", noteID, true).Update("is_active", false)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrShareNotFound
+	}
+	return nil
+}
+
+func (s *ShareService) findOwnedShare(noteID, shareID, userID uint) (*models.ShareLink, error) {
+	var shareLink models.ShareLink
+	err := s.db.Joins("JOIN notes ON notes.id = share_links.note_id").
+		Where("share_links.id = ? AND share_links.note_id = ? AND share_links.is_active = ?", shareID, noteID, true).
+		Where("notes.user_id = ? AND notes.deleted_at IS NULL", userID).
+		First(&shareLink).Error
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, ErrShareNotFound
+		}
+		return nil, err
+	}
+	return &shareLink, nil
+}
+
+func (s *ShareService) toInfo(shareLink *models.ShareLink, noteTitle string) models.ShareLinkInfo {
+	return models.ShareLinkInfo{
+		ID:          shareLink.ID,
+		NoteID:      shareLink.NoteID,
+		NoteTitle:   noteTitle,
+		Label:       shareLink.Label,
+		ShareCode:   shareLink.ShareCode,
+		ShareURL:    s.ShareURL(shareLink.ShareCode),
+		HasPassword: s.RequiresPassword(shareLink),
+		ExpireTime:  shareLink.ExpireTime,
+		MaxViews:    shareLink.MaxViews,
+		VisitCount:  shareLink.VisitCount,
+		Enabled:     shareLink.Enabled,
+		Status:      shareLink.Status(),
+		CreatedAt:   shareLink.CreatedAt,
+	}
+}
+
+// ShareURL 分享链接的完整地址
+func (s *ShareService) ShareURL(code string) string {
+	return fmt.Sprintf("%s/shared/%s", strings.TrimRight(s.cfg.Frontend.BaseURL, "/"), code)
+}
+
+// HashPassword 哈希用户设置的分享密码，nil 表示不修改，空字符串表示取消密码
+func (s *ShareService) HashPassword(password *string) (*string, error) {
+	if password == nil || *password == "" {
+		return password, nil
+	}
+	hashed, err := utils.HashPassword(*password)
+	if err != nil {
+		return nil, err
+	}
+	return &hashed, nil
+}
+
+// RequiresPassword 分享链接是否设置了访问密码
+func (s *ShareService) RequiresPassword(shareLink *models.ShareLink) bool {
+	return shareLink.Password != nil && *shareLink.Password != ""
+}
+
+// Unlock 校验访问密码并签发访问令牌。同一 IP 对同一分享码密码错误次数过多时在时间窗口内拒绝尝试，
+// 按 IP 区分，避免他人故意输错密码导致正常访客无法解锁
+func (s *ShareService) Unlock(shareLink *models.ShareLink, password, clientIP string) (string, time.Time, error) {
+	if s.RequiresPassword(shareLink) {
+		key := shareLink.ShareCode + "|" + clientIP
+		if !s.reserveAttempt(key) {
+			return "", time.Time{}, ErrShareThrottled
+		}
+		if !s.checkPassword(shareLink, password) {
+			return "", time.Time{}, ErrSharePassword
+		}
+		s.resetFailures(key)
+	}
+
+	expire := time.Duration(s.cfg.Share.AccessTokenMinutes) * time.Minute
+	token, expiresAt, err := utils.GenerateShareToken(shareLink.ShareCode, passwordTag(shareLink), s.cfg.JWT.Secret, expire)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return token, expiresAt, nil
+}
+
+// VerifyAccess 检查访问令牌，未设置密码的分享总是通过。密码修改后旧令牌失效
+func (s *ShareService) VerifyAccess(shareLink *models.ShareLink, token string) error {
+	if !s.RequiresPassword(shareLink) {
+		return nil
+	}
+	if token == "" {
+		return ErrShareLocked
+	}
+
+	claims, err := utils.ParseShareToken(token, s.cfg.JWT.Secret)
+	if err != nil || claims.ShareCode != shareLink.ShareCode || claims.PasswordTag != passwordTag(shareLink) {
+		return ErrShareLocked
+	}
+	return nil
+}
+
+// checkPassword 兼容迁移完成前仍为明文的旧密码
+func (s *ShareService) checkPassword(shareLink *models.ShareLink, password string) bool {
+	stored := *shareLink.Password
+	if !strings.HasPrefix(stored, argon2Prefix) {
+		return subtle.ConstantTimeCompare([]byte(stored), []byte(password)) == 1
+	}
+
+	ok, err := utils.VerifyPassword(password, stored)
+	if err != nil {
+		fmt.Printf("Failed to verify share password for link %d: %v\n", shareLink.ID, err)
+		return false
+	}
+	return ok
+}
+
+// reserveAttempt 在验证密码前先计入一次尝试，保证并发请求也不能超过次数限制；验证成功后清零。
+// key 为分享码和客户端 IP
+func (s *ShareService) reserveAttempt(key string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	window := time.Duration(s.cfg.Share.UnlockWindowMinutes) * time.Minute
+	failures, ok := s.failures[key]
+	if !ok || time.Since(failures.windowStart) > window {
+		failures = &unlockFailures{windowStart: time.Now()}
+		s.failures[key] = failures
+	}
+	if failures.count >= s.cfg.Share.UnlockMaxAttempts {
+		return false
+	}
+	failures.count++
+	return true
+}
+
+func (s *ShareService) resetFailures(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.failures, key)
+}
+
+// StartCleanup 启动后台任务，定期清理时间窗口已过的密码尝试记录
+func (s *ShareService) StartCleanup() {
+	window := time.Duration(s.cfg.Share.UnlockWindowMinutes) * time.Minute
+	if window <= 0 {
+		window = time.Minute
+	}
+
+	go func() {
+		ticker := time.NewTicker(window)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			s.pruneFailures(window)
+		}
+	}()
+}
+
+func (s *ShareService) pruneFailures(window time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for key, failures := range s.failures {
+		if time.Since(failures.windowStart) > window {
+			delete(s.failures, key)
+		}
+	}
+}
+
+// MigratePasswords 将历史上以明文保存的分享密码改为哈希，可重复执行
+func (s *ShareService) MigratePasswords() {
+	var shareLinks []models.ShareLink
+	err := s.db.Where("password IS NOT NULL AND password <> '' AND password NOT LIKE ?", argon2Prefix+"%").
+		Find(&shareLinks).Error
+	if err != nil {
+		fmt.Printf("Failed to load share links for password migration: %v\n", err)
+		return
+	}
+
+	migrated := 0
+	for _, shareLink := range shareLinks {
+		hashed, err := utils.HashPassword(*shareLink.Password)
+		if err != nil {
+			fmt.Printf("Failed to hash password for share link %d: %v\n", shareLink.ID, err)
+			continue
+		}
+		// 仅在密码未被并发修改时更新
+		result := s.db.Model(&models.ShareLink{}).
+			Where("id = ? AND password = ?", shareLink.ID, *shareLink.Password).
+			Update("password", hashed)
+		if result.Error != nil {
+			fmt.Printf("Failed to migrate password for share link %d: %v\n", shareLink.ID, result.Error)
+			continue
+		}
+		migrated += int(result.RowsAffected)
+	}
+
+	if migrated > 0 {
+		fmt.Printf("Share link password migration completed: %d links\n", migrated)
+	}
+}
+
+// GetSharedAttachment 获取分享笔记的附件，笔记需仍为公开且未删除
+func (s *ShareService) GetSharedAttachment(shareLink *models.ShareLink, attachmentID uint) (*models.Attachment, error) {
+	var attachment models.Attachment
+	err := s.db.Joins("JOIN notes ON attachments.note_id = notes.id").
+		Where("attachments.id = ? AND attachments.note_id = ?", attachmentID, shareLink.NoteID).
+		Where("notes.is_public = ? AND notes.deleted_at IS NULL", true).
+		First(&attachment).Error
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, ErrShareNotFound
+		}
+		return nil, err
+	}
+	return &attachment, nil
+}
+
+func generateShareCode() (string, error) {
+	bytes := make([]byte, 16)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(bytes), nil
+}
+
+// passwordTag 密码哈希的摘要，写入访问令牌用于在密码修改后使令牌失效
+func passwordTag(shareLink *models.ShareLink) string {
+	if shareLink.Password == nil || *shareLink.Password == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(*shareLink.Password))
+	return hex.EncodeToString(sum[:8])
+}
@@ -0,0 +1,337 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"notes-backend/internal/models"
+	"regexp"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ErrTemplateForbidden 无权修改模板或设置共享
+var ErrTemplateForbidden = errors.New("无权操作该模板")
+
+var (
+	placeholderPattern   = regexp.MustCompile(`\{\{\s*([A-Za-z0-9_.]+)\s*\}\}`)
+	templateFieldPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+	reservedPlaceholders = map[string]bool{
+		"date": true, "time": true, "datetime": true, "weekday": true, "category": true,
+	}
+	weekdayNames = []string{"星期日", "星期一", "星期二", "星期三", "星期四", "星期五", "星期六"}
+)
+
+// TemplateService 管理笔记模板并根据模板创建笔记
+type TemplateService struct {
+	db          *gorm.DB
+	noteService *NoteService
+}
+
+func NewTemplateService(db *gorm.DB, noteService *NoteService) *TemplateService {
+	return &TemplateService{db: db, noteService: noteService}
+}
+
+// templateAuthorColumns 共享模板对所有用户可见，作者只加载 ID 和用户名
+func templateAuthorColumns(db *gorm.DB) *gorm.DB {
+	return db.Select("id", "username")
+}
+
+// GetTemplates 获取用户自己的模板和所有共享模板
+func (s *TemplateService) GetTemplates(userID uint) ([]models.NoteTemplate, error) {
+	var templates []models.NoteTemplate
+	err := s.db.Preload("User", templateAuthorColumns).
+		Where("user_id = ? OR is_shared = ?", userID, true).
+		Order("is_shared ASC, name ASC").
+		Find(&templates).Error
+	if err != nil {
+		return nil, err
+	}
+	return templates, nil
+}
+
+func (s *TemplateService) GetTemplate(templateID, userID uint) (*models.NoteTemplate, error) {
+	var template models.NoteTemplate
+	err := s.db.Preload("User", templateAuthorColumns).Preload("Category").
+		Where("id = ? AND (user_id = ? OR is_shared = ?)", templateID, userID, true).
+		First(&template).Error
+	if err != nil {
+		return nil, err
+	}
+	return &template, nil
+}
+
+func (s *TemplateService) CreateTemplate(user *models.User, req *models.NoteTemplateRequest) (*models.NoteTemplate, error) {
+	if req.IsShared && user.Role != "admin" {
+		return nil, ErrTemplateForbidden
+	}
+	if err := s.validateTemplate(user.ID, req); err != nil {
+		return nil, err
+	}
+
+	template := models.NoteTemplate{UserID: user.ID}
+	applyTemplateRequest(&template, req)
+
+	if err := s.db.Create(&template).Error; err != nil {
+		return nil, err
+	}
+
+	return s.GetTemplate(template.ID, user.ID)
+}
+
+// UpdateTemplate 更新模板，模板所有者或管理员（仅共享模板）可以修改
+func (s *TemplateService) UpdateTemplate(templateID uint, user *models.User, req *models.NoteTemplateRequest) (*models.NoteTemplate, error) {
+	template, err := s.GetTemplate(templateID, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	if !canEditTemplate(template, user) {
+		return nil, ErrTemplateForbidden
+	}
+	if req.IsShared != template.IsShared && user.Role != "admin" {
+		return nil, ErrTemplateForbidden
+	}
+	if err := s.validateTemplate(template.UserID, req); err != nil {
+		return nil, err
+	}
+
+	applyTemplateRequest(template, req)
+	template.User = nil
+	template.Category = nil
+
+	if err := s.db.Save(template).Error; err != nil {
+		return nil, err
+	}
+
+	return s.GetTemplate(template.ID, user.ID)
+}
+
+func (s *TemplateService) DeleteTemplate(templateID uint, user *models.User) error {
+	template, err := s.GetTemplate(templateID, user.ID)
+	if err != nil {
+		return err
+	}
+	if !canEditTemplate(template, user) {
+		return ErrTemplateForbidden
+	}
+	return s.db.Delete(&models.NoteTemplate{}, template.ID).Error
+}
+
+// CreateNoteFromTemplate 渲染模板中的占位符并创建笔记。
+// 共享模板的默认分类和标签按名称匹配到当前用户自己的分类和标签，匹配不到时忽略
+func (s *TemplateService) CreateNoteFromTemplate(templateID uint, user *models.User, req *models.NoteFromTemplateRequest) (*models.Note, error) {
+	template, err := s.GetTemplate(templateID, user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	values := make(map[string]string)
+	for _, field := range template.Fields {
+		value := strings.TrimSpace(req.Values[field.Name])
+		if value == "" {
+			value = field.Default
+		}
+		if field.Required && value == "" {
+			label := field.Label
+			if label == "" {
+				label = field.Name
+			}
+			return nil, fmt.Errorf("请填写%s", label)
+		}
+		values[field.Name] = value
+	}
+
+	categoryID, err := s.resolveCategory(template, user.ID, req.CategoryID)
+	if err != nil {
+		return nil, err
+	}
+	tagIDs, err := s.resolveTags(template, user.ID, req.TagIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	values["date"] = now.Format("2006-01-02")
+	values["time"] = now.Format("15:04")
+	values["datetime"] = now.Format("2006-01-02 15:04")
+	values["weekday"] = weekdayNames[now.Weekday()]
+	values["user.username"] = user.Username
+	values["user.email"] = user.Email
+	values["category"] = ""
+	if categoryID != nil {
+		var category models.Category
+		if err := s.db.Select("name").First(&category, *categoryID).Error; err == nil {
+			values["category"] = category.Name
+		}
+	}
+
+	title := req.Title
+	if title == "" {
+		title = template.Title
+	}
+	title = strings.TrimSpace(renderPlaceholders(title, values))
+	if title == "" {
+		title = template.Name
+	}
+	if len([]rune(title)) > 255 {
+		title = string([]rune(title)[:255])
+	}
+
+	contentType := template.ContentType
+	if contentType == "" {
+		contentType = "markdown"
+	}
+
+	return s.noteService.CreateNote(user.ID, &models.NoteCreateRequest{
+		Title:       title,
+		Content:     renderPlaceholders(template.Content, values),
+		ContentType: contentType,
+		CategoryID:  categoryID,
+		TagIDs:      tagIDs,
+		IsPublic:    req.IsPublic,
+	})
+}
+
+func (s *TemplateService) validateTemplate(ownerID uint, req *models.NoteTemplateRequest) error {
+	if req.CategoryID != nil {
+		var count int64
+		if err := s.db.Model(&models.Category{}).Where("id = ? AND user_id = ?", *req.CategoryID, ownerID).Count(&count).Error; err != nil {
+			return err
+		}
+		if count == 0 {
+			return fmt.Errorf("分类不存在")
+		}
+	}
+
+	if len(req.TagIDs) > 0 {
+		var count int64
+		ids := uniqueIDs(req.TagIDs)
+		if err := s.db.Model(&models.Tag{}).Where("id IN ? AND user_id = ?", ids, ownerID).Count(&count).Error; err != nil {
+			return err
+		}
+		if int(count) != len(ids) {
+			return fmt.Errorf("标签不存在")
+		}
+	}
+
+	seen := make(map[string]bool)
+	for _, field := range req.Fields {
+		if !templateFieldPattern.MatchString(field.Name) {
+			return fmt.Errorf("字段名 %s 只能包含字母、数字和下划线", field.Name)
+		}
+		if reservedPlaceholders[field.Name] {
+			return fmt.Errorf("字段名 %s 为系统保留名称", field.Name)
+		}
+		if seen[field.Name] {
+			return fmt.Errorf("字段名 %s 重复", field.Name)
+		}
+		seen[field.Name] = true
+	}
+
+	return nil
+}
+
+// resolveCategory 确定新笔记的分类：请求指定的分类优先，其次为模板默认分类
+func (s *TemplateService) resolveCategory(template *models.NoteTemplate, userID uint, requested *uint) (*uint, error) {
+	if requested != nil {
+		var count int64
+		if err := s.db.Model(&models.Category{}).Where("id = ? AND user_id = ?", *requested, userID).Count(&count).Error; err != nil {
+			return nil, err
+		}
+		if count == 0 {
+			return nil, fmt.Errorf("分类不存在")
+		}
+		return requested, nil
+	}
+
+	if template.CategoryID == nil {
+		return nil, nil
+	}
+
+	var category models.Category
+	if err := s.db.Where("id = ?", *template.CategoryID).First(&category).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if category.UserID == userID {
+		return &category.ID, nil
+	}
+
+	var own models.Category
+	err := s.db.Where("user_id = ? AND LOWER(name) = LOWER(?)", userID, category.Name).First(&own).Error
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &own.ID, nil
+}
+
+// resolveTags 确定新笔记的标签：请求指定的标签优先，其次为模板默认标签
+func (s *TemplateService) resolveTags(template *models.NoteTemplate, userID uint, requested []uint) ([]uint, error) {
+	if requested != nil {
+		return requested, nil
+	}
+	if len(template.TagIDs) == 0 {
+		return nil, nil
+	}
+
+	var tags []models.Tag
+	if err := s.db.Where("id IN ?", []uint(template.TagIDs)).Find(&tags).Error; err != nil {
+		return nil, err
+	}
+
+	var ids []uint
+	var names []string
+	for _, tag := range tags {
+		if tag.UserID == userID {
+			ids = append(ids, tag.ID)
+		} else {
+			names = append(names, strings.ToLower(tag.Name))
+		}
+	}
+
+	if len(names) > 0 {
+		var own []uint
+		if err := s.db.Model(&models.Tag{}).Where("user_id = ? AND LOWER(name) IN ?", userID, names).Pluck("id", &own).Error; err != nil {
+			return nil, err
+		}
+		ids = append(ids, own...)
+	}
+
+	return ids, nil
+}
+
+func applyTemplateRequest(template *models.NoteTemplate, req *models.NoteTemplateRequest) {
+	template.Name = req.Name
+	template.Description = req.Description
+	template.Title = req.Title
+	template.Content = req.Content
+	template.ContentType = req.ContentType
+	if template.ContentType == "" {
+		template.ContentType = "markdown"
+	}
+	template.CategoryID = req.CategoryID
+	template.TagIDs = models.UintList(uniqueIDs(req.TagIDs))
+	template.Fields = models.TemplateFieldList(req.Fields)
+	template.IsShared = req.IsShared
+}
+
+func canEditTemplate(template *models.NoteTemplate, user *models.User) bool {
+	return template.UserID == user.ID || (template.IsShared && user.Role == "admin")
+}
+
+// renderPlaceholders 替换 {{name}} 占位符，未知的占位符保持原样
+func renderPlaceholders(text string, values map[string]string) string {
+	return placeholderPattern.ReplaceAllStringFunc(text, func(match string) string {
+		name := placeholderPattern.FindStringSubmatch(match)[1]
+		if value, ok := values[name]; ok {
+			return value
+		}
+		return match
+	})
+}
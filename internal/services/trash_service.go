@@ -0,0 +1,226 @@
+package services
+
+import (
+	"fmt"
+	"math"
+	"notes-backend/internal/config"
+	"notes-backend/internal/models"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// TrashService 管理回收站中的笔记。笔记删除后保留标签、附件和分享链接，
+// 超过保留天数后由后台任务彻底删除
+type TrashService struct {
+	db          *gorm.DB
+	fileService *FileService
+	cfg         config.TrashConfig
+}
+
+func NewTrashService(db *gorm.DB, fileService *FileService, cfg config.TrashConfig) *TrashService {
+	return &TrashService{db: db, fileService: fileService, cfg: cfg}
+}
+
+// GetTrash 获取回收站中的笔记，按删除时间倒序
+func (s *TrashService) GetTrash(userID uint, req *models.TrashListRequest) ([]models.TrashedNote, *models.Pagination, error) {
+	query := s.db.Unscoped().Model(&models.Note{}).
+		Where("user_id = ? AND deleted_at IS NOT NULL", userID)
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, nil, err
+	}
+
+	var notes []models.Note
+	offset := (req.Page - 1) * req.Limit
+	err := query.Preload("Category", func(db *gorm.DB) *gorm.DB { return db.Unscoped() }).
+		Preload("Tags").
+		Order("deleted_at DESC").
+		Limit(req.Limit).Offset(offset).
+		Find(&notes).Error
+	if err != nil {
+		return nil, nil, err
+	}
+
+	retention := s.retention()
+	trashed := make([]models.TrashedNote, 0, len(notes))
+	for _, note := range notes {
+		deletedAt := note.DeletedAt.Time
+		trashed = append(trashed, models.TrashedNote{
+			Note:      note,
+			DeletedAt: deletedAt,
+			PurgeAt:   deletedAt.Add(retention),
+		})
+	}
+
+	pagination := &models.Pagination{
+		Page:  req.Page,
+		Limit: req.Limit,
+		Total: int(total),
+		Pages: int(math.Ceil(float64(total) / float64(req.Limit))),
+	}
+
+	return trashed, pagination, nil
+}
+
+// RestoreNote 从回收站恢复笔记，标签、附件和分享链接随笔记一起恢复
+func (s *TrashService) RestoreNote(noteID, userID uint) (*models.Note, error) {
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		return restoreNoteInTx(tx, noteID, userID)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var note models.Note
+	err = s.db.Preload("Category").Preload("Tags").Preload("Attachments").
+		Where("id = ? AND user_id = ?", noteID, userID).First(&note).Error
+	if err != nil {
+		return nil, err
+	}
+	return &note, nil
+}
+
+// PermanentlyDeleteNote 彻底删除回收站中的笔记，包括附件的物理文件
+func (s *TrashService) PermanentlyDeleteNote(noteID, userID uint) error {
+	var note models.Note
+	err := s.db.Unscoped().
+		Where("id = ? AND user_id = ? AND deleted_at IS NOT NULL", noteID, userID).
+		First(&note).Error
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return fmt.Errorf("回收站中不存在该笔记")
+		}
+		return err
+	}
+
+	return s.purgeNote(&note)
+}
+
+// PurgeExpired 彻底删除超过保留天数的笔记，返回删除的数量
+func (s *TrashService) PurgeExpired() (int, error) {
+	cutoff := time.Now().Add(-s.retention())
+
+	var notes []models.Note
+	err := s.db.Unscoped().
+		Where("deleted_at IS NOT NULL AND deleted_at < ?", cutoff).
+		Find(&notes).Error
+	if err != nil {
+		return 0, err
+	}
+
+	purged := 0
+	for i := range notes {
+		if err := s.purgeNote(&notes[i]); err != nil {
+			fmt.Printf("Failed to purge note %d: %v\n", notes[i].ID, err)
+			continue
+		}
+		purged++
+	}
+
+	return purged, nil
+}
+
+// StartPurger 启动后台任务，按配置的间隔清理过期的回收站笔记
+func (s *TrashService) StartPurger() {
+	interval := time.Duration(s.cfg.PurgeIntervalMinutes) * time.Minute
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			purged, err := s.PurgeExpired()
+			if err != nil {
+				fmt.Printf("Trash purge failed: %v\n", err)
+			} else if purged > 0 {
+				fmt.Printf("Trash purge removed %d notes\n", purged)
+			}
+			<-ticker.C
+		}
+	}()
+}
+
+func (s *TrashService) retention() time.Duration {
+	return time.Duration(s.cfg.RetentionDays) * 24 * time.Hour
+}
+
+// purgeNote 彻底删除笔记及其关联数据。附件先删除物理文件，再在事务中清理其余记录
+func (s *TrashService) purgeNote(note *models.Note) error {
+	var attachments []models.Attachment
+	if err := s.db.Unscoped().Where("note_id = ?", note.ID).Find(&attachments).Error; err != nil {
+		return err
+	}
+
+	for _, attachment := range attachments {
+		// 仍处于正常状态的附件先软删除，以便同步更新用户存储空间统计
+		if !attachment.DeletedAt.Valid {
+			if err := s.fileService.DeleteAttachment(attachment.ID, note.UserID); err != nil {
+				return fmt.Errorf("删除附件失败: %v", err)
+			}
+		}
+		if err := s.fileService.PermanentlyDeleteAttachment(attachment.ID); err != nil {
+			return fmt.Errorf("彻底删除附件失败: %v", err)
+		}
+	}
+
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("DELETE FROM note_tags WHERE note_id = ?", note.ID).Error; err != nil {
+			return fmt.Errorf("删除标签关联失败: %v", err)
+		}
+		if err := tx.Where("note_id = ?", note.ID).Delete(&models.ShareLink{}).Error; err != nil {
+			return fmt.Errorf("删除分享链接失败: %v", err)
+		}
+		if err := tx.Where("note_id = ?", note.ID).Delete(&models.NoteVisit{}).Error; err != nil {
+			return fmt.Errorf("删除访问记录失败: %v", err)
+		}
+		if err := tx.Where("note_id = ?", note.ID).Delete(&models.VisitRollup{}).Error; err != nil {
+			return fmt.Errorf("删除访问统计失败: %v", err)
+		}
+		if err := tx.Where("note_id = ?", note.ID).Delete(&models.NotePermission{}).Error; err != nil {
+			return fmt.Errorf("删除共享授权失败: %v", err)
+		}
+		if err := tx.Where("note_id = ?", note.ID).Delete(&models.NoteRevision{}).Error; err != nil {
+			return fmt.Errorf("删除历史版本失败: %v", err)
+		}
+		if err := tx.Where("source_note_id = ?", note.ID).Delete(&models.NoteLink{}).Error; err != nil {
+			return fmt.Errorf("删除笔记链接失败: %v", err)
+		}
+		if err := tx.Model(&models.NoteLink{}).Where("target_note_id = ?", note.ID).Update("target_note_id", nil).Error; err != nil {
+			return fmt.Errorf("更新笔记链接失败: %v", err)
+		}
+		return tx.Unscoped().Delete(&models.Note{}, note.ID).Error
+	})
+}
+
+// restoreNoteInTx 恢复回收站中的笔记。所属分类已被删除时笔记恢复为未分类
+func restoreNoteInTx(tx *gorm.DB, noteID, userID uint) error {
+	var note models.Note
+	err := tx.Unscoped().
+		Where("id = ? AND user_id = ? AND deleted_at IS NOT NULL", noteID, userID).
+		First(&note).Error
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return fmt.Errorf("回收站中不存在该笔记")
+		}
+		return err
+	}
+
+	updates := map[string]interface{}{"deleted_at": nil}
+	if note.CategoryID != nil {
+		var count int64
+		if err := tx.Model(&models.Category{}).Where("id = ? AND user_id = ?", *note.CategoryID, userID).Count(&count).Error; err != nil {
+			return err
+		}
+		if count == 0 {
+			updates["category_id"] = nil
+		}
+	}
+
+	if err := tx.Unscoped().Model(&models.Note{}).Where("id = ?", noteID).Updates(updates).Error; err != nil {
+		return err
+	}
+
+	return resolveTitleLinksInTx(tx, userID, note.Title)
+}
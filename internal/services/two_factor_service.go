@@ -0,0 +1,521 @@
+package services
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image/png"
+	"notes-backend/internal/config"
+	"notes-backend/internal/models"
+	"notes-backend/internal/utils"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pquerna/otp"
+	"github.com/pquerna/otp/totp"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	// ErrTwoFactorAlreadyEnabled 已开启两步验证，需要先关闭才能重新设置
+	ErrTwoFactorAlreadyEnabled = errors.New("两步验证已开启")
+	// ErrTwoFactorNotEnabled 尚未开启两步验证
+	ErrTwoFactorNotEnabled = errors.New("两步验证未开启")
+	// ErrTwoFactorNotSetup 确认开启前需要先调用 setup 生成密钥
+	ErrTwoFactorNotSetup = errors.New("请先设置身份验证器")
+	// ErrTwoFactorPassword 关闭两步验证时密码错误
+	ErrTwoFactorPassword = errors.New("密码错误")
+	// ErrTwoFactorCode 验证码或恢复码错误，或验证码已使用过
+	ErrTwoFactorCode = errors.New("验证码错误")
+	// ErrTwoFactorChallenge 挑战令牌无效、已过期或已使用
+	ErrTwoFactorChallenge = errors.New("登录验证已失效，请重新登录")
+	// ErrTwoFactorThrottled 验证码错误次数过多
+	ErrTwoFactorThrottled = errors.New("验证码错误次数过多，请稍后再试")
+	// ErrTwoFactorRequired 管理员要求开启两步验证时，不能关闭
+	ErrTwoFactorRequired = errors.New("管理员要求所有用户开启两步验证，不能关闭")
+)
+
+const (
+	// requireTwoFactorKey 管理员是否要求所有用户开启两步验证，保存在 system_configs 中
+	requireTwoFactorKey = "require_two_factor"
+	// recoveryCodeCount 每次生成的恢复码数量
+	recoveryCodeCount = 10
+	// totpSkew 允许前后各一个时间步（30 秒）的时钟误差
+	totpSkew   = 1
+	totpPeriod = 30
+)
+
+var totpOpts = totp.ValidateOpts{
+	Period:    totpPeriod,
+	Digits:    otp.DigitsSix,
+	Algorithm: otp.AlgorithmSHA1,
+}
+
+// TwoFactorService TOTP 两步验证：设置身份验证器、恢复码、登录挑战和管理员策略
+type TwoFactorService struct {
+	db  *gorm.DB
+	cfg *config.Config
+
+	mu       sync.Mutex
+	failures map[uint]*unlockFailures
+
+	policyMu     sync.RWMutex
+	policyLoaded bool
+	required     bool
+}
+
+func NewTwoFactorService(db *gorm.DB, cfg *config.Config) *TwoFactorService {
+	return &TwoFactorService{
+		db:       db,
+		cfg:      cfg,
+		failures: make(map[uint]*unlockFailures),
+	}
+}
+
+// Status 用户的两步验证状态
+func (s *TwoFactorService) Status(user *models.User) (*models.TwoFactorStatus, error) {
+	status := &models.TwoFactorStatus{
+		Enabled:  user.TwoFactorEnabled,
+		Required: s.IsRequired(),
+	}
+	if !user.TwoFactorEnabled {
+		return status, nil
+	}
+
+	var tf models.UserTwoFactor
+	if err := s.db.Where("user_id = ?", user.ID).First(&tf).Error; err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+	status.EnabledAt = tf.EnabledAt
+
+	var remaining int64
+	err := s.db.Model(&models.RecoveryCode{}).Where("user_id = ? AND used_at IS NULL", user.ID).Count(&remaining).Error
+	if err != nil {
+		return nil, err
+	}
+	status.RecoveryCodesRemaining = int(remaining)
+	return status, nil
+}
+
+// Setup 生成新的 TOTP 密钥，返回 otpauth 地址和二维码。密钥在 Enable 确认前不生效，重复调用会替换未确认的密钥
+func (s *TwoFactorService) Setup(user *models.User) (*models.TwoFactorSetupResponse, error) {
+	if user.TwoFactorEnabled {
+		return nil, ErrTwoFactorAlreadyEnabled
+	}
+
+	key, err := totp.Generate(totp.GenerateOpts{
+		Issuer:      s.cfg.Auth.TOTPIssuer,
+		AccountName: user.Email,
+		Period:      totpPeriod,
+		Digits:      otp.DigitsSix,
+		Algorithm:   otp.AlgorithmSHA1,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	tf := models.UserTwoFactor{UserID: user.ID, PendingSecret: key.Secret()}
+	err = s.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"pending_secret", "updated_at"}),
+	}).Create(&tf).Error
+	if err != nil {
+		return nil, err
+	}
+
+	img, err := key.Image(256, 256)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+
+	return &models.TwoFactorSetupResponse{
+		Secret:     key.Secret(),
+		OTPAuthURL: key.URL(),
+		QRCode:     "data:image/png;base64," + base64.StdEncoding.EncodeToString(buf.Bytes()),
+	}, nil
+}
+
+// Enable 使用身份验证器中的验证码确认密钥，开启两步验证并返回恢复码
+func (s *TwoFactorService) Enable(user *models.User, code string) ([]string, error) {
+	if user.TwoFactorEnabled {
+		return nil, ErrTwoFactorAlreadyEnabled
+	}
+
+	var tf models.UserTwoFactor
+	if err := s.db.Where("user_id = ?", user.ID).First(&tf).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrTwoFactorNotSetup
+		}
+		return nil, err
+	}
+	if tf.PendingSecret == "" {
+		return nil, ErrTwoFactorNotSetup
+	}
+
+	if !s.reserveAttempt(user.ID) {
+		return nil, ErrTwoFactorThrottled
+	}
+	step, ok := matchTOTP(tf.PendingSecret, strings.TrimSpace(code), 0)
+	if !ok {
+		return nil, ErrTwoFactorCode
+	}
+	s.resetFailures(user.ID)
+
+	var codes []string
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		result := tx.Model(&models.UserTwoFactor{}).
+			Where("user_id = ? AND pending_secret = ?", user.ID, tf.PendingSecret).
+			Updates(map[string]interface{}{
+				"secret":         tf.PendingSecret,
+				"pending_secret": "",
+				"last_used_step": step,
+				"enabled_at":     now,
+			})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrTwoFactorNotSetup
+		}
+
+		if err := tx.Model(user).Update("two_factor_enabled", true).Error; err != nil {
+			return err
+		}
+
+		var err error
+		codes, err = replaceRecoveryCodes(tx, user.ID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	user.TwoFactorEnabled = true
+	return codes, nil
+}
+
+// Disable 关闭两步验证，需要同时提供密码和验证码（或恢复码）
+func (s *TwoFactorService) Disable(user *models.User, password, code string) error {
+	if !user.TwoFactorEnabled {
+		return ErrTwoFactorNotEnabled
+	}
+	if s.IsRequired() {
+		return ErrTwoFactorRequired
+	}
+
+	valid, err := utils.VerifyPassword(password, user.PasswordHash)
+	if err != nil {
+		return err
+	}
+	if !valid {
+		return ErrTwoFactorPassword
+	}
+
+	if err := s.checkCode(user.ID, code); err != nil {
+		return err
+	}
+
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", user.ID).Delete(&models.UserTwoFactor{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("user_id = ?", user.ID).Delete(&models.RecoveryCode{}).Error; err != nil {
+			return err
+		}
+		return tx.Model(user).Update("two_factor_enabled", false).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	user.TwoFactorEnabled = false
+	return nil
+}
+
+// RegenerateRecoveryCodes 生成新的恢复码，之前的恢复码全部失效
+func (s *TwoFactorService) RegenerateRecoveryCodes(user *models.User, code string) ([]string, error) {
+	if !user.TwoFactorEnabled {
+		return nil, ErrTwoFactorNotEnabled
+	}
+	if err := s.checkCode(user.ID, code); err != nil {
+		return nil, err
+	}
+
+	var codes []string
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		codes, err = replaceRecoveryCodes(tx, user.ID)
+		return err
+	})
+	return codes, err
+}
+
+// NewChallenge 密码验证通过后签发挑战令牌
+func (s *TwoFactorService) NewChallenge(user *models.User) (*models.LoginChallenge, error) {
+	expire := time.Duration(s.cfg.Auth.ChallengeMinutes) * time.Minute
+	token, claims, err := utils.GenerateChallengeToken(user.ID, s.cfg.JWT.Secret, expire)
+	if err != nil {
+		return nil, err
+	}
+	return &models.LoginChallenge{
+		TwoFactorRequired: true,
+		ChallengeToken:    token,
+		ExpiresAt:         claims.ExpiresAt.Time,
+	}, nil
+}
+
+// VerifyChallenge 校验挑战令牌和验证码（或恢复码），成功后挑战令牌作废并返回用户。
+// 同一用户验证码错误次数过多时在时间窗口内拒绝尝试
+func (s *TwoFactorService) VerifyChallenge(challengeToken, code string) (*models.User, error) {
+	claims, err := utils.ParseChallengeToken(challengeToken, s.cfg.JWT.Secret)
+	if err != nil {
+		return nil, ErrTwoFactorChallenge
+	}
+
+	var used int64
+	if err := s.db.Model(&models.RevokedToken{}).Where("jti = ?", claims.ID).Count(&used).Error; err != nil {
+		return nil, err
+	}
+	if used > 0 {
+		return nil, ErrTwoFactorChallenge
+	}
+
+	var user models.User
+	if err := s.db.Where("id = ? AND is_active = ?", claims.UserID, true).First(&user).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrTwoFactorChallenge
+		}
+		return nil, err
+	}
+	if !user.TwoFactorEnabled {
+		return nil, ErrTwoFactorChallenge
+	}
+
+	if err := s.checkCode(user.ID, code); err != nil {
+		return nil, err
+	}
+
+	// 挑战令牌只能使用一次，按 jti 加入吊销列表
+	result := s.db.Clauses(clause.OnConflict{DoNothing: true}).Create(&models.RevokedToken{
+		JTI:       claims.ID,
+		UserID:    user.ID,
+		ExpiresAt: claims.ExpiresAt.Time,
+	})
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, ErrTwoFactorChallenge
+	}
+
+	return &user, nil
+}
+
+// IsRequired 管理员是否要求所有用户开启两步验证
+func (s *TwoFactorService) IsRequired() bool {
+	s.policyMu.RLock()
+	if s.policyLoaded {
+		defer s.policyMu.RUnlock()
+		return s.required
+	}
+	s.policyMu.RUnlock()
+
+	s.policyMu.Lock()
+	defer s.policyMu.Unlock()
+	if !s.policyLoaded {
+		var config models.SystemConfig
+		err := s.db.Where("key = ?", requireTwoFactorKey).First(&config).Error
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			fmt.Printf("Failed to load two-factor policy: %v\n", err)
+			return false
+		}
+		s.required = err == nil && config.Value == "true"
+		s.policyLoaded = true
+	}
+	return s.required
+}
+
+// SetRequired 设置是否要求所有用户开启两步验证。开启后未设置两步验证的用户只能访问账号相关接口
+func (s *TwoFactorService) SetRequired(required bool) error {
+	err := s.db.Save(&models.SystemConfig{
+		Key:         requireTwoFactorKey,
+		Value:       strconv.FormatBool(required),
+		Description: "是否要求所有用户开启两步验证",
+		IsActive:    true,
+	}).Error
+	if err != nil {
+		return err
+	}
+
+	s.policyMu.Lock()
+	s.required = required
+	s.policyLoaded = true
+	s.policyMu.Unlock()
+	return nil
+}
+
+// checkCode 校验已开启两步验证用户的验证码或恢复码，计入错误次数
+func (s *TwoFactorService) checkCode(userID uint, code string) error {
+	if !s.reserveAttempt(userID) {
+		return ErrTwoFactorThrottled
+	}
+
+	var tf models.UserTwoFactor
+	if err := s.db.Where("user_id = ?", userID).First(&tf).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrTwoFactorNotEnabled
+		}
+		return err
+	}
+
+	code = strings.TrimSpace(code)
+	if isTOTPCode(code) {
+		step, ok := matchTOTP(tf.Secret, code, tf.LastUsedStep)
+		if !ok {
+			return ErrTwoFactorCode
+		}
+		// 以上次使用的时间步为条件更新，同一验证码并发提交时只有一个成功
+		result := s.db.Model(&models.UserTwoFactor{}).
+			Where("user_id = ? AND last_used_step < ?", userID, step).
+			Update("last_used_step", step)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrTwoFactorCode
+		}
+	} else {
+		result := s.db.Model(&models.RecoveryCode{}).
+			Where("user_id = ? AND code_hash = ? AND used_at IS NULL", userID, hashRecoveryCode(code)).
+			Update("used_at", time.Now())
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrTwoFactorCode
+		}
+	}
+
+	s.resetFailures(userID)
+	return nil
+}
+
+// reserveAttempt 与分享密码相同，验证前先计入一次尝试，验证成功后清零
+func (s *TwoFactorService) reserveAttempt(userID uint) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	window := time.Duration(s.cfg.Auth.TwoFactorWindowMinutes) * time.Minute
+	for key, failures := range s.failures {
+		if time.Since(failures.windowStart) > window {
+			delete(s.failures, key)
+		}
+	}
+
+	failures, ok := s.failures[userID]
+	if !ok {
+		failures = &unlockFailures{windowStart: time.Now()}
+		s.failures[userID] = failures
+	}
+	if failures.count >= s.cfg.Auth.TwoFactorMaxAttempts {
+		return false
+	}
+	failures.count++
+	return true
+}
+
+func (s *TwoFactorService) resetFailures(userID uint) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.failures, userID)
+}
+
+// matchTOTP 在允许的时钟误差内查找与验证码匹配的时间步，只接受晚于 lastStep 的时间步
+func matchTOTP(secret, code string, lastStep int64) (int64, bool) {
+	if !isTOTPCode(code) {
+		return 0, false
+	}
+
+	now := time.Now()
+	current := now.Unix() / totpPeriod
+	for offset := -totpSkew; offset <= totpSkew; offset++ {
+		step := current + int64(offset)
+		if step <= lastStep {
+			continue
+		}
+		expected, err := totp.GenerateCodeCustom(secret, time.Unix(step*totpPeriod, 0), totpOpts)
+		if err != nil {
+			return 0, false
+		}
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+func isTOTPCode(code string) bool {
+	if len(code) != 6 {
+		return false
+	}
+	for _, r := range code {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// replaceRecoveryCodes 删除旧的恢复码并生成新的一组，返回明文
+func replaceRecoveryCodes(tx *gorm.DB, userID uint) ([]string, error) {
+	if err := tx.Where("user_id = ?", userID).Delete(&models.RecoveryCode{}).Error; err != nil {
+		return nil, err
+	}
+
+	codes := make([]string, 0, recoveryCodeCount)
+	records := make([]models.RecoveryCode, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		code, err := newRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+		codes = append(codes, code)
+		records = append(records, models.RecoveryCode{UserID: userID, CodeHash: hashRecoveryCode(code)})
+	}
+
+	if err := tx.Create(&records).Error; err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// newRecoveryCode 生成 80 位随机恢复码，格式为 xxxx-xxxx-xxxx-xxxx
+func newRecoveryCode() (string, error) {
+	raw := make([]byte, 10)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	encoded := strings.ToLower(base32.StdEncoding.EncodeToString(raw))
+	return encoded[0:4] + "-" + encoded[4:8] + "-" + encoded[8:12] + "-" + encoded[12:16], nil
+}
+
+// hashRecoveryCode 忽略大小写、空格和连字符后计算哈希
+func hashRecoveryCode(code string) string {
+	normalized := strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	sum := sha256.Sum256([]byte(normalized))
+	return hex.EncodeToString(sum[:])
+}
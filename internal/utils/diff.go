@@ -0,0 +1,246 @@
+package utils
+
+import (
+	"fmt"
+	"strings"
+)
+
+type DiffOp int
+
+const (
+	DiffEqual DiffOp = iota
+	DiffInsert
+	DiffDelete
+)
+
+type DiffLine struct {
+	Op   DiffOp
+	Text string
+}
+
+// DiffLines 使用 Myers 算法计算两组文本行的最短编辑序列。采用线性空间的分治版本：
+// 每次查找中间蛇形后递归处理两侧，内存占用为 O(N+M)
+func DiffLines(a, b []string) []DiffLine {
+	var lines []DiffLine
+	diffRange(a, b, &lines)
+	return lines
+}
+
+// diffRange 去掉公共前后缀后，按中间蛇形把问题拆成两半递归求解
+func diffRange(a, b []string, lines *[]DiffLine) {
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		*lines = append(*lines, DiffLine{Op: DiffEqual, Text: a[prefix]})
+		prefix++
+	}
+	a, b = a[prefix:], b[prefix:]
+
+	suffix := 0
+	for suffix < len(a) && suffix < len(b) && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+	common := a[len(a)-suffix:]
+	a, b = a[:len(a)-suffix], b[:len(b)-suffix]
+
+	switch {
+	case len(a) == 0:
+		for _, text := range b {
+			*lines = append(*lines, DiffLine{Op: DiffInsert, Text: text})
+		}
+	case len(b) == 0:
+		for _, text := range a {
+			*lines = append(*lines, DiffLine{Op: DiffDelete, Text: text})
+		}
+	default:
+		if x, y, ok := middleSnake(a, b); ok {
+			diffRange(a[:x], b[:y], lines)
+			diffRange(a[x:], b[y:], lines)
+		} else {
+			for _, text := range a {
+				*lines = append(*lines, DiffLine{Op: DiffDelete, Text: text})
+			}
+			for _, text := range b {
+				*lines = append(*lines, DiffLine{Op: DiffInsert, Text: text})
+			}
+		}
+	}
+
+	for _, text := range common {
+		*lines = append(*lines, DiffLine{Op: DiffEqual, Text: text})
+	}
+}
+
+// middleSnake 同时从两端搜索最短编辑路径，返回两条路径相遇的位置。
+// 两段文本没有公共行时返回 false
+func middleSnake(a, b []string) (int, int, bool) {
+	n, m := len(a), len(b)
+	maxD := (n + m + 1) / 2
+	offset := maxD
+	size := 2*maxD + 2
+
+	forward := make([]int, size)
+	backward := make([]int, size)
+	for i := range forward {
+		forward[i] = -1
+		backward[i] = -1
+	}
+	forward[offset+1] = 0
+	backward[offset+1] = 0
+
+	delta := n - m
+	// 差值为奇数时在正向搜索中检查相遇，否则在反向搜索中检查
+	front := delta%2 != 0
+	var kStart, kEnd, rStart, rEnd int
+
+	for d := 0; d < maxD; d++ {
+		for k := -d + kStart; k <= d-kEnd; k += 2 {
+			i := offset + k
+			var x int
+			if k == -d || (k != d && forward[i-1] < forward[i+1]) {
+				x = forward[i+1]
+			} else {
+				x = forward[i-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			forward[i] = x
+
+			switch {
+			case x > n:
+				kEnd += 2
+			case y > m:
+				kStart += 2
+			case front:
+				j := offset + delta - k
+				if j >= 0 && j < size && backward[j] != -1 && x >= n-backward[j] {
+					return x, y, true
+				}
+			}
+		}
+
+		for k := -d + rStart; k <= d-rEnd; k += 2 {
+			i := offset + k
+			var x int
+			if k == -d || (k != d && backward[i-1] < backward[i+1]) {
+				x = backward[i+1]
+			} else {
+				x = backward[i-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[n-x-1] == b[m-y-1] {
+				x++
+				y++
+			}
+			backward[i] = x
+
+			switch {
+			case x > n:
+				rEnd += 2
+			case y > m:
+				rStart += 2
+			case !front:
+				j := offset + delta - k
+				if j >= 0 && j < size && forward[j] != -1 {
+					fx := forward[j]
+					fy := fx - (j - offset)
+					if fx >= n-x {
+						return fx, fy, true
+					}
+				}
+			}
+		}
+	}
+
+	return 0, 0, false
+}
+
+// UnifiedDiff 生成行级的统一格式差异（与 diff -u 输出一致），内容相同时返回空字符串
+func UnifiedDiff(fromName, toName, a, b string, context int) string {
+	lines := DiffLines(splitLines(a), splitLines(b))
+
+	// 记录每一行在新旧文本中的起始位置
+	aPos := make([]int, len(lines)+1)
+	bPos := make([]int, len(lines)+1)
+	var changes []int
+	for i, line := range lines {
+		aPos[i+1], bPos[i+1] = aPos[i], bPos[i]
+		switch line.Op {
+		case DiffEqual:
+			aPos[i+1]++
+			bPos[i+1]++
+		case DiffDelete:
+			aPos[i+1]++
+			changes = append(changes, i)
+		case DiffInsert:
+			bPos[i+1]++
+			changes = append(changes, i)
+		}
+	}
+
+	if len(changes) == 0 {
+		return ""
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "--- %s\n+++ %s\n", fromName, toName)
+
+	for i := 0; i < len(changes); {
+		start := changes[i] - context
+		if start < 0 {
+			start = 0
+		}
+		end := changes[i]
+		j := i
+		for j+1 < len(changes) && changes[j+1]-end <= 2*context+1 {
+			j++
+			end = changes[j]
+		}
+		end += context + 1
+		if end > len(lines) {
+			end = len(lines)
+		}
+
+		aCount := aPos[end] - aPos[start]
+		bCount := bPos[end] - bPos[start]
+		fmt.Fprintf(&sb, "@@ -%s +%s @@\n", hunkRange(aPos[start], aCount), hunkRange(bPos[start], bCount))
+
+		for _, line := range lines[start:end] {
+			switch line.Op {
+			case DiffEqual:
+				sb.WriteString(" ")
+			case DiffDelete:
+				sb.WriteString("-")
+			case DiffInsert:
+				sb.WriteString("+")
+			}
+			sb.WriteString(line.Text)
+			sb.WriteString("\n")
+		}
+
+		i = j + 1
+	}
+
+	return sb.String()
+}
+
+func hunkRange(start, count int) string {
+	if count == 0 {
+		return fmt.Sprintf("%d,0", start)
+	}
+	if count == 1 {
+		return fmt.Sprintf("%d", start+1)
+	}
+	return fmt.Sprintf("%d,%d", start+1, count)
+}
+
+func splitLines(text string) []string {
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	text = strings.TrimSuffix(text, "\n")
+	if text == "" {
+		return nil
+	}
+	return strings.Split(text, "\n")
+}
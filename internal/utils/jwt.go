@@ -5,6 +5,7 @@ import (
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
 )
 
 type Claims struct {
@@ -12,26 +13,36 @@ type Claims struct {
 	Username string `json:"username"`
 	Email    string `json:"email"`
 	Role     string `json:"role"`
+	// 登录会话 ID，令牌 ID（jti）保存在 RegisteredClaims.ID 中，用于注销后吊销
+	SessionID string `json:"sid,omitempty"`
 	jwt.RegisteredClaims
 }
 
-func GenerateToken(userID int, username, email, role, secret string, expireHours int) (string, error) {
-	claims := Claims{
-		UserID:   uint(userID),
-		Username: username,
-		Email:    email,
-		Role:     role,
+// GenerateToken 签发短期访问令牌，返回的 Claims 中包含令牌 ID 和过期时间
+func GenerateToken(userID uint, username, email, role, sessionID, secret string, expire time.Duration) (string, *Claims, error) {
+	now := time.Now()
+	claims := &Claims{
+		UserID:    userID,
+		Username:  username,
+		Email:     email,
+		Role:      role,
+		SessionID: sessionID,
 		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Duration(expireHours) * time.Hour)),
-			IssuedAt:  jwt.NewNumericDate(time.Now()),
-			NotBefore: jwt.NewNumericDate(time.Now()),
+			ID:        uuid.NewString(),
+			ExpiresAt: jwt.NewNumericDate(now.Add(expire)),
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
 			Issuer:    "notes-backend",
 			Subject:   fmt.Sprintf("%d", userID),
 		},
 	}
 
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString([]byte(secret))
+	signed, err := token.SignedString([]byte(secret))
+	if err != nil {
+		return "", nil, err
+	}
+	return signed, claims, nil
 }
 
 func ParseToken(tokenString, secret string) (*Claims, error) {
@@ -46,7 +57,101 @@ func ParseToken(tokenString, secret string) (*Claims, error) {
 		return nil, err
 	}
 
-	if claims, ok := token.Claims.(*Claims); ok && token.Valid {
+	// 分享访问令牌等带 audience 的令牌不能作为登录令牌使用
+	if claims, ok := token.Claims.(*Claims); ok && token.Valid && len(claims.Audience) == 0 {
+		return claims, nil
+	}
+
+	return nil, fmt.Errorf("invalid token")
+}
+
+// ShareClaims 分享链接访问令牌，只对单个分享码有效。
+// PasswordTag 为签发时密码哈希的摘要，分享密码修改后旧令牌随之失效
+type ShareClaims struct {
+	ShareCode   string `json:"share_code"`
+	PasswordTag string `json:"pwd"`
+	jwt.RegisteredClaims
+}
+
+func GenerateShareToken(shareCode, passwordTag, secret string, expire time.Duration) (string, time.Time, error) {
+	expiresAt := time.Now().Add(expire)
+	claims := ShareClaims{
+		ShareCode:   shareCode,
+		PasswordTag: passwordTag,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			NotBefore: jwt.NewNumericDate(time.Now()),
+			Issuer:    "notes-backend",
+			Audience:  jwt.ClaimStrings{"share"},
+		},
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	signed, err := token.SignedString([]byte(secret))
+	return signed, expiresAt, err
+}
+
+func ParseShareToken(tokenString, secret string) (*ShareClaims, error) {
+	token, err := jwt.ParseWithClaims(tokenString, &ShareClaims{}, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		return []byte(secret), nil
+	}, jwt.WithAudience("share"))
+
+	if err != nil {
+		return nil, err
+	}
+
+	if claims, ok := token.Claims.(*ShareClaims); ok && token.Valid {
+		return claims, nil
+	}
+
+	return nil, fmt.Errorf("invalid token")
+}
+
+// ChallengeClaims 两步验证挑战令牌，密码验证通过后签发，只能用于换取登录令牌
+type ChallengeClaims struct {
+	UserID uint `json:"user_id"`
+	jwt.RegisteredClaims
+}
+
+func GenerateChallengeToken(userID uint, secret string, expire time.Duration) (string, *ChallengeClaims, error) {
+	now := time.Now()
+	claims := &ChallengeClaims{
+		UserID: userID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        uuid.NewString(),
+			ExpiresAt: jwt.NewNumericDate(now.Add(expire)),
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+			Issuer:    "notes-backend",
+			Audience:  jwt.ClaimStrings{"2fa"},
+		},
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	signed, err := token.SignedString([]byte(secret))
+	if err != nil {
+		return "", nil, err
+	}
+	return signed, claims, nil
+}
+
+func ParseChallengeToken(tokenString, secret string) (*ChallengeClaims, error) {
+	token, err := jwt.ParseWithClaims(tokenString, &ChallengeClaims{}, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		return []byte(secret), nil
+	}, jwt.WithAudience("2fa"))
+
+	if err != nil {
+		return nil, err
+	}
+
+	if claims, ok := token.Claims.(*ChallengeClaims); ok && token.Valid {
 		return claims, nil
 	}
 
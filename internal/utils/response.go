@@ -23,6 +23,15 @@ func SuccessWithMessage(c *gin.Context, message string, data interface{}) {
 	})
 }
 
+// Accepted 异步任务已受理
+func Accepted(c *gin.Context, message string, data interface{}) {
+	c.JSON(http.StatusAccepted, models.Response{
+		Code:    http.StatusAccepted,
+		Message: message,
+		Data:    data,
+	})
+}
+
 func Error(c *gin.Context, code int, message string) {
 	c.JSON(code, models.Response{
 		Code:    code,
@@ -46,6 +55,15 @@ func ValidationError(c *gin.Context, errors interface{}) {
 	})
 }
 
+// PreconditionFailed 返回 412，并附带服务端当前的数据供客户端合并
+func PreconditionFailed(c *gin.Context, message string, data interface{}) {
+	c.JSON(http.StatusPreconditionFailed, models.Response{
+		Code:    http.StatusPreconditionFailed,
+		Message: message,
+		Data:    data,
+	})
+}
+
 func InternalError(c *gin.Context) {
 	c.JSON(http.StatusInternalServerError, models.Response{
 		Code:    http.StatusInternalServerError,
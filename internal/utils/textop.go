@@ -0,0 +1,330 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// MaxTextComponentLength 解析客户端提交的操作时，单个保留或删除片段允许的最大长度。
+// 限制长度避免合并相邻片段时整数溢出
+const MaxTextComponentLength = 1 << 24
+
+// TextComponent 文本操作的一个片段：N 为正数表示保留 N 个字符，为负数表示删除 -N 个字符，
+// Insert 不为空表示插入文本
+type TextComponent struct {
+	N      int
+	Insert string
+}
+
+// TextOperation 基于操作变换（OT）的文本操作，JSON 格式与 ot.js 相同，如 [3, "abc", -2, 5]。
+// 长度和位置均按 Unicode 码点计算
+type TextOperation []TextComponent
+
+// BaseLength 操作作用的文本长度
+func (op TextOperation) BaseLength() int {
+	length := 0
+	for _, c := range op {
+		if c.Insert == "" {
+			length += abs(c.N)
+		}
+	}
+	return length
+}
+
+// TargetLength 操作应用后的文本长度
+func (op TextOperation) TargetLength() int {
+	length := 0
+	for _, c := range op {
+		switch {
+		case c.Insert != "":
+			length += utf8.RuneCountInString(c.Insert)
+		case c.N > 0:
+			length += c.N
+		}
+	}
+	return length
+}
+
+// IsNoop 操作不修改文本
+func (op TextOperation) IsNoop() bool {
+	for _, c := range op {
+		if c.Insert != "" || c.N < 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Retain、Insert、Delete 追加片段并与相邻的同类片段合并，删除和插入相邻时插入总在前面
+func (op TextOperation) Retain(n int) TextOperation {
+	if n <= 0 {
+		return op
+	}
+	if last := len(op) - 1; last >= 0 && op[last].Insert == "" && op[last].N > 0 {
+		op[last].N += n
+		return op
+	}
+	return append(op, TextComponent{N: n})
+}
+
+func (op TextOperation) Insert(text string) TextOperation {
+	if text == "" {
+		return op
+	}
+	last := len(op) - 1
+	if last >= 0 && op[last].Insert != "" {
+		op[last].Insert += text
+		return op
+	}
+	if last >= 0 && op[last].Insert == "" && op[last].N < 0 {
+		if last > 0 && op[last-1].Insert != "" {
+			op[last-1].Insert += text
+			return op
+		}
+		deleted := op[last]
+		op[last] = TextComponent{Insert: text}
+		return append(op, deleted)
+	}
+	return append(op, TextComponent{Insert: text})
+}
+
+func (op TextOperation) Delete(n int) TextOperation {
+	if n <= 0 {
+		return op
+	}
+	if last := len(op) - 1; last >= 0 && op[last].Insert == "" && op[last].N < 0 {
+		op[last].N -= n
+		return op
+	}
+	return append(op, TextComponent{N: -n})
+}
+
+// Apply 将操作应用到文本，操作的 BaseLength 必须等于文本长度。
+// 每个片段都单独检查是否超出文本，不依赖可能溢出的 BaseLength
+func (op TextOperation) Apply(text string) (string, error) {
+	runes := []rune(text)
+
+	var b strings.Builder
+	pos := 0
+	for _, c := range op {
+		if c.Insert != "" {
+			b.WriteString(c.Insert)
+			continue
+		}
+
+		n := c.N
+		if n < 0 {
+			n = -n
+		}
+		if n < 0 || n > len(runes)-pos {
+			return "", fmt.Errorf("操作超出文本长度 %d", len(runes))
+		}
+		if c.N > 0 {
+			b.WriteString(string(runes[pos : pos+n]))
+		}
+		pos += n
+	}
+	if pos != len(runes) {
+		return "", fmt.Errorf("操作长度 %d 与文本长度 %d 不一致", pos, len(runes))
+	}
+	return b.String(), nil
+}
+
+// TransformIndex 计算光标位置在操作应用后的新位置，插入在光标处时光标移到插入内容之后
+func (op TextOperation) TransformIndex(index int) int {
+	newIndex := index
+	for _, c := range op {
+		switch {
+		case c.Insert != "":
+			newIndex += utf8.RuneCountInString(c.Insert)
+		case c.N > 0:
+			index -= c.N
+		case c.N < 0:
+			newIndex -= min(index, -c.N)
+			index += c.N
+		}
+		if index < 0 {
+			break
+		}
+	}
+	return newIndex
+}
+
+// TransformText 对同一文本上并发的两个操作做变换，返回 a' 和 b'，
+// 满足 apply(apply(s, a), b') == apply(apply(s, b), a')。两者在同一位置插入时 a 的内容在前
+func TransformText(a, b TextOperation) (TextOperation, TextOperation, error) {
+	if a.BaseLength() != b.BaseLength() {
+		return nil, nil, fmt.Errorf("两个操作的长度不一致: %d, %d", a.BaseLength(), b.BaseLength())
+	}
+
+	var aPrime, bPrime TextOperation
+	i, j := 0, 0
+	var ca, cb *TextComponent
+	next := func(op TextOperation, k *int) *TextComponent {
+		if *k >= len(op) {
+			return nil
+		}
+		c := op[*k]
+		*k++
+		return &c
+	}
+	ca, cb = next(a, &i), next(b, &j)
+
+	for ca != nil || cb != nil {
+		if ca != nil && ca.Insert != "" {
+			aPrime = aPrime.Insert(ca.Insert)
+			bPrime = bPrime.Retain(utf8.RuneCountInString(ca.Insert))
+			ca = next(a, &i)
+			continue
+		}
+		if cb != nil && cb.Insert != "" {
+			aPrime = aPrime.Retain(utf8.RuneCountInString(cb.Insert))
+			bPrime = bPrime.Insert(cb.Insert)
+			cb = next(b, &j)
+			continue
+		}
+		if ca == nil || cb == nil {
+			return nil, nil, fmt.Errorf("操作长度不一致")
+		}
+
+		switch {
+		case ca.N > 0 && cb.N > 0:
+			n := min(ca.N, cb.N)
+			aPrime, bPrime = aPrime.Retain(n), bPrime.Retain(n)
+			ca.N, cb.N = ca.N-n, cb.N-n
+		case ca.N < 0 && cb.N < 0:
+			// 双方删除了相同的内容
+			n := min(-ca.N, -cb.N)
+			ca.N, cb.N = ca.N+n, cb.N+n
+		case ca.N < 0 && cb.N > 0:
+			n := min(-ca.N, cb.N)
+			aPrime = aPrime.Delete(n)
+			ca.N, cb.N = ca.N+n, cb.N-n
+		default:
+			n := min(ca.N, -cb.N)
+			bPrime = bPrime.Delete(n)
+			ca.N, cb.N = ca.N-n, cb.N+n
+		}
+		if ca.N == 0 {
+			ca = next(a, &i)
+		}
+		if cb.N == 0 {
+			cb = next(b, &j)
+		}
+	}
+
+	return aPrime, bPrime, nil
+}
+
+// maxDiffLines 逐行比较的最大行数，超过时将中间不同的部分整体替换
+const maxDiffLines = 4000
+
+// DiffText 生成把 from 改为 to 的操作。去掉相同的首尾行后按行比较，
+// 使不同位置的修改变换后可以分别保留
+func DiffText(from, to string) TextOperation {
+	a, b := splitLinesKeepEnds(from), splitLinesKeepEnds(to)
+
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	var op TextOperation
+	op = op.Retain(runeCount(a[:prefix]))
+
+	middleA, middleB := a[prefix:len(a)-suffix], b[prefix:len(b)-suffix]
+	if len(middleA)+len(middleB) > maxDiffLines {
+		op = op.Insert(strings.Join(middleB, ""))
+		op = op.Delete(runeCount(middleA))
+	} else {
+		for _, line := range DiffLines(middleA, middleB) {
+			switch line.Op {
+			case DiffEqual:
+				op = op.Retain(utf8.RuneCountInString(line.Text))
+			case DiffInsert:
+				op = op.Insert(line.Text)
+			case DiffDelete:
+				op = op.Delete(utf8.RuneCountInString(line.Text))
+			}
+		}
+	}
+
+	op = op.Retain(runeCount(a[len(a)-suffix:]))
+	return op
+}
+
+// splitLinesKeepEnds 按行切分文本，每行保留行尾的换行符
+func splitLinesKeepEnds(text string) []string {
+	lines := strings.SplitAfter(text, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+func runeCount(lines []string) int {
+	count := 0
+	for _, line := range lines {
+		count += utf8.RuneCountInString(line)
+	}
+	return count
+}
+
+func (op TextOperation) MarshalJSON() ([]byte, error) {
+	items := make([]interface{}, 0, len(op))
+	for _, c := range op {
+		if c.Insert != "" {
+			items = append(items, c.Insert)
+		} else {
+			items = append(items, c.N)
+		}
+	}
+	return json.Marshal(items)
+}
+
+func (op *TextOperation) UnmarshalJSON(data []byte) error {
+	var items []json.RawMessage
+	if err := json.Unmarshal(data, &items); err != nil {
+		return err
+	}
+
+	var result TextOperation
+	base := 0
+	for _, item := range items {
+		var text string
+		if err := json.Unmarshal(item, &text); err == nil {
+			result = result.Insert(text)
+			continue
+		}
+
+		var n int
+		if err := json.Unmarshal(item, &n); err != nil || n == 0 || n > MaxTextComponentLength || n < -MaxTextComponentLength {
+			return fmt.Errorf("无效的操作片段: %s", string(item))
+		}
+		// 每个片段不超过上限，但相邻片段合并后仍可能很长，累计长度同样受限
+		if base += abs(n); base > MaxTextComponentLength {
+			return fmt.Errorf("操作长度超出限制")
+		}
+		if n > 0 {
+			result = result.Retain(n)
+		} else {
+			result = result.Delete(-n)
+		}
+	}
+
+	*op = result
+	return nil
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
@@ -0,0 +1,151 @@
+package utils
+
+import (
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var (
+	wikiLinkPattern = regexp.MustCompile(`\[\[([^\[\]\n]+?)\]\]`)
+	noteIDPattern   = regexp.MustCompile(`^#(\d+)$`)
+)
+
+// WikiLink 正文中的笔记链接：[[标题]]、[[#123]] 或带显示文本的 [[标题|别名]]
+type WikiLink struct {
+	Title  string // 标题链接的目标标题，ID 链接时为空
+	NoteID uint   // ID 链接的目标笔记，标题链接时为 0
+	Alias  string
+	Start  int // 在正文中的字节偏移
+	End    int
+}
+
+// ParseWikiLinks 提取正文中的笔记链接，代码块和行内代码中的内容会被忽略
+func ParseWikiLinks(content string) []WikiLink {
+	var links []WikiLink
+	var code []codeRange
+	matches := wikiLinkPattern.FindAllStringSubmatchIndex(content, -1)
+	if len(matches) > 0 {
+		code = codeRanges(content)
+	}
+	for _, match := range matches {
+		if inCode(code, match[0]) {
+			continue
+		}
+
+		link := WikiLink{Start: match[0], End: match[1]}
+		target := content[match[2]:match[3]]
+		if i := strings.Index(target, "|"); i >= 0 {
+			link.Alias = strings.TrimSpace(target[i+1:])
+			target = target[:i]
+		}
+		target = strings.TrimSpace(target)
+
+		if m := noteIDPattern.FindStringSubmatch(target); m != nil {
+			id, err := strconv.ParseUint(m[1], 10, 32)
+			if err != nil || id == 0 {
+				continue
+			}
+			link.NoteID = uint(id)
+		} else {
+			if target == "" {
+				continue
+			}
+			link.Title = target
+		}
+
+		links = append(links, link)
+	}
+	return links
+}
+
+// RewriteWikiLinks 按 replace 的返回值替换正文中的链接，replace 返回 false 时保留原文
+func RewriteWikiLinks(content string, replace func(WikiLink) (string, bool)) string {
+	var sb strings.Builder
+	last := 0
+	for _, link := range ParseWikiLinks(content) {
+		text, ok := replace(link)
+		if !ok {
+			continue
+		}
+		sb.WriteString(content[last:link.Start])
+		sb.WriteString(text)
+		last = link.End
+	}
+	sb.WriteString(content[last:])
+	return sb.String()
+}
+
+// FormatWikiLink 生成链接文本，noteID 不为 0 时生成 ID 链接
+func FormatWikiLink(title string, noteID uint, alias string) string {
+	target := title
+	if noteID != 0 {
+		target = "#" + strconv.FormatUint(uint64(noteID), 10)
+	}
+	if alias != "" {
+		return "[[" + target + "|" + alias + "]]"
+	}
+	return "[[" + target + "]]"
+}
+
+// codeRange 正文中代码的字节区间 [start, end)
+type codeRange struct {
+	start, end int
+}
+
+// codeRanges 找出 ``` 代码块和行内代码的区间，按位置排序且互不重叠。
+// 行内代码按同一行内的反引号两两配对，未配对的反引号到行尾为止
+func codeRanges(content string) []codeRange {
+	var ranges []codeRange
+	fenceStart := -1
+	for lineStart := 0; lineStart <= len(content); {
+		lineEnd := strings.IndexByte(content[lineStart:], '\n')
+		if lineEnd < 0 {
+			lineEnd = len(content)
+		} else {
+			lineEnd += lineStart
+		}
+		line := content[lineStart:lineEnd]
+
+		if strings.HasPrefix(strings.TrimSpace(line), "```") {
+			if fenceStart < 0 {
+				fenceStart = lineStart
+			} else {
+				// 结束围栏所在行按行内代码处理
+				ranges = append(ranges, codeRange{fenceStart, lineStart})
+				fenceStart = -1
+			}
+		}
+
+		if fenceStart < 0 {
+			open := -1
+			for i := 0; i < len(line); i++ {
+				if line[i] != '`' {
+					continue
+				}
+				if open < 0 {
+					open = lineStart + i + 1
+				} else {
+					ranges = append(ranges, codeRange{open, lineStart + i})
+					open = -1
+				}
+			}
+			if open >= 0 {
+				ranges = append(ranges, codeRange{open, lineEnd + 1})
+			}
+		}
+
+		lineStart = lineEnd + 1
+	}
+	if fenceStart >= 0 {
+		ranges = append(ranges, codeRange{fenceStart, len(content) + 1})
+	}
+	return ranges
+}
+
+// inCode 判断偏移位置是否位于代码区间中，ranges 由 codeRanges 生成
+func inCode(ranges []codeRange, offset int) bool {
+	i := sort.Search(len(ranges), func(i int) bool { return ranges[i].end > offset })
+	return i < len(ranges) && ranges[i].start <= offset
+}
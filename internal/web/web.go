@@ -0,0 +1,28 @@
+// Package web 提供服务端渲染页面使用的 HTML 模板。
+// 默认模板内嵌在二进制中，配置 share.template_dir 后从该目录加载同名模板以自定义主题。
+package web
+
+import (
+	"embed"
+	"html/template"
+	"path/filepath"
+	"time"
+)
+
+//go:embed templates/*.html
+var templates embed.FS
+
+var funcs = template.FuncMap{
+	"formatDate": func(t time.Time) string {
+		return t.Format("2006-01-02 15:04")
+	},
+}
+
+// LoadTemplates 加载分享页面模板，dir 为空时使用内置模板
+func LoadTemplates(dir string) (*template.Template, error) {
+	tmpl := template.New("").Funcs(funcs)
+	if dir == "" {
+		return tmpl.ParseFS(templates, "templates/*.html")
+	}
+	return tmpl.ParseGlob(filepath.Join(dir, "*.html"))
+}